@@ -0,0 +1,105 @@
+// Package api is a versioned JSON API for managing channels, so
+// scripts and other services can drive them
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ystv/playout/channel"
+)
+
+// API manages the MCR's channels over HTTP
+type API struct {
+	mcr *channel.MCR
+}
+
+// Error is the body of every unsuccessful response
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"` // Machine readable, i.e. not_found
+	Message string `json:"message"`
+}
+
+// errBadRequest is when a request body can't be decoded
+var errBadRequest = errors.New("bad request")
+
+// New creates an API of the MCR
+func New(mcr *channel.MCR) *API {
+	return &API{mcr: mcr}
+}
+
+// Router provides the v1 endpoints, to be mounted under /api/v1
+func (a *API) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/channels", a.listChannels).Methods("GET")
+	r.HandleFunc("/channels", a.newChannel).Methods("POST")
+	r.HandleFunc("/channels/{channel}", a.getChannel).Methods("GET")
+	r.HandleFunc("/channels/{channel}", a.updateChannel).Methods("PUT")
+	r.HandleFunc("/channels/{channel}", a.deleteChannel).Methods("DELETE")
+	r.HandleFunc("/channels/{channel}/start", a.startChannel).Methods("POST")
+	r.HandleFunc("/channels/{channel}/stop", a.stopChannel).Methods("POST")
+	r.HandleFunc("/channels/{channel}/restart", a.restartChannel).Methods("POST")
+	r.HandleFunc("/channels/{channel}/status", a.channelStatus).Methods("GET")
+	r.HandleFunc("/channels/{channel}/input", a.pinInput).Methods("PUT")
+	r.HandleFunc("/channels/{channel}/input", a.unpinInput).Methods("DELETE")
+	r.HandleFunc("/channels/{channel}/outputs", a.listOutputs).Methods("GET")
+	r.HandleFunc("/channels/{channel}/outputs", a.newOutput).Methods("POST")
+	r.HandleFunc("/channels/{channel}/outputs/{output:[0-9]+}", a.updateOutput).Methods("PUT")
+	r.HandleFunc("/channels/{channel}/outputs/{output:[0-9]+}", a.deleteOutput).Methods("DELETE")
+	r.HandleFunc("/channels/{channel}/sources", a.listSourceChecks).Methods("GET")
+	r.HandleFunc("/channels/{channel}/sources/check", a.checkSources).Methods("POST")
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	})
+	return r
+}
+
+// decode reads a JSON request body into v, rejecting unknown fields
+func decode(r *http.Request, v interface{}) error {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	err := d.Decode(v)
+	if err != nil {
+		return fmt.Errorf("%w: invalid json: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// writeJSON responds with v
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with an error body
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, Error{Status: status, Code: code, Message: message})
+}
+
+// fail responds with the status code err corresponds to
+func fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBadRequest):
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+	case errors.Is(err, channel.ErrChannelNotFound), errors.Is(err, channel.ErrOutputNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, channel.ErrChannelExists):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, channel.ErrNoScheduler):
+		writeError(w, http.StatusConflict, "no_scheduler", err.Error())
+	case errors.Is(err, channel.ErrInvalidTransition):
+		writeError(w, http.StatusConflict, "invalid_state", err.Error())
+	case channel.IsInvalid(err):
+		writeError(w, http.StatusUnprocessableEntity, "invalid", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
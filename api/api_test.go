@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ystv/playout/channel"
+)
+
+// TestFail checks errors are mapped to their status and code
+func TestFail(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{err: fmt.Errorf("%w: invalid json: EOF", errBadRequest), status: http.StatusBadRequest, code: "bad_request"},
+		{err: fmt.Errorf("failed to get channel: %w", channel.ErrChannelNotFound), status: http.StatusNotFound, code: "not_found"},
+		{err: channel.ErrOutputNotFound, status: http.StatusNotFound, code: "not_found"},
+		{err: channel.ErrChannelExists, status: http.StatusConflict, code: "conflict"},
+		{err: channel.ErrNoScheduler, status: http.StatusConflict, code: "no_scheduler"},
+		{err: fmt.Errorf("%w: stopped to stopped", channel.ErrInvalidTransition), status: http.StatusConflict, code: "invalid_state"},
+		{err: fmt.Errorf("%w: latency must be positive", channel.ErrInvalidSRT), status: http.StatusUnprocessableEntity, code: "invalid"},
+		{err: channel.ErrUnknownOutputType, status: http.StatusUnprocessableEntity, code: "invalid"},
+		{err: errors.New("connection refused"), status: http.StatusInternalServerError, code: "internal"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			fail(w, test.err)
+			res := Error{}
+			err := json.NewDecoder(w.Body).Decode(&res)
+			if err != nil {
+				t.Fatalf("failed to decode error: %+v", err)
+			}
+			if w.Code != test.status || res.Status != test.status || res.Code != test.code {
+				t.Errorf("%q responded %d %+v, want %d %s", test.err, w.Code, res, test.status, test.code)
+			}
+			if res.Message != test.err.Error() {
+				t.Errorf("message is %q, want %q", res.Message, test.err)
+			}
+		})
+	}
+}
+
+// TestRouterErrors checks unknown endpoints and methods get an error body
+func TestRouterErrors(t *testing.T) {
+	r := New(nil).Router()
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{method: "GET", path: "/nowhere", status: http.StatusNotFound, code: "not_found"},
+		{method: "PATCH", path: "/channels", status: http.StatusMethodNotAllowed, code: "method_not_allowed"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		res := Error{}
+		err := json.NewDecoder(w.Body).Decode(&res)
+		if err != nil {
+			t.Fatalf("failed to decode error: %+v", err)
+		}
+		if w.Code != test.status || res.Code != test.code {
+			t.Errorf("%s %s responded %d %+v", test.method, test.path, w.Code, res)
+		}
+	}
+}
+
+func TestDecodeRejectsUnknownFields(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/channels/tv/input", strings.NewReader(`{"input": "slate", "pin": true}`))
+	v := struct {
+		Input string `json:"input"`
+	}{}
+	err := decode(req, &v)
+	if !errors.Is(err, errBadRequest) {
+		t.Errorf("decoded an unknown field, got %v", err)
+	}
+}
+
+// TestToOutputHidesSecrets checks responses never carry credentials
+func TestToOutputHidesSecrets(t *testing.T) {
+	o := toOutput(channel.Output{
+		Type:        "rtmp",
+		Destination: "rtmp://live.example.com/app",
+		StreamKey:   "key123",
+		SRTOptions:  channel.SRTOptions{SRTMode: "caller", SRTPassphrase: "passphrase1", SRTLatency: 200},
+	})
+	if o.StreamKey != "" || !o.HasStreamKey {
+		t.Errorf("stream key is %q, has key %t", o.StreamKey, o.HasStreamKey)
+	}
+	if o.SRT == nil || o.SRT.Passphrase != "" || o.SRT.Latency != 200 {
+		t.Errorf("srt is %+v", o.SRT)
+	}
+}
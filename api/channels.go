@@ -0,0 +1,584 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ystv/playout/channel"
+)
+
+type (
+	// Channel is a channel's config and status
+	Channel struct {
+		ShortName     string         `json:"shortName"`
+		Name          string         `json:"name"`
+		Description   string         `json:"description"`
+		Type          string         `json:"type"` // event / linear
+		IngestURL     string         `json:"ingestURL"`
+		IngestType    string         `json:"ingestType"` // rtp / rtmp / hls / srt
+		IngestSRT     *SRT           `json:"ingestSRT,omitempty"`
+		Loudness      Loudness       `json:"loudness"`
+		Logo          Logo           `json:"logo"`
+		Captions      Captions       `json:"captions"`
+		AudioTracks   []AudioTrack   `json:"audioTracks"`
+		SlateURL      string         `json:"slateURL"`
+		BackupIngests []Ingest       `json:"backupIngests"`
+		FailbackDelay int            `json:"failbackDelay"`
+		Input         Input          `json:"input"`  // Read only
+		Pinned        bool           `json:"pinned"` // Read only
+		Visibility    string         `json:"visibility"`
+		Archive       bool           `json:"archive"`
+		DVR           bool           `json:"dvr"`
+		HasScheduler  bool           `json:"hasScheduler"`
+		HasPiper      bool           `json:"hasPiper"`
+		Outputs       []Output       `json:"outputs"`
+		Status        channel.Status `json:"status"`
+		CreatedAt     time.Time      `json:"createdAt"`
+	}
+	// NewChannel is the body to create a channel
+	NewChannel struct {
+		ShortName     string       `json:"shortName"` // Generated if empty
+		Name          string       `json:"name"`
+		Description   string       `json:"description"`
+		Type          string       `json:"type"`
+		IngestURL     string       `json:"ingestURL"` // Can be set later
+		IngestType    string       `json:"ingestType"`
+		IngestSRT     *SRT         `json:"ingestSRT"`
+		Loudness      Loudness     `json:"loudness"`
+		Logo          Logo         `json:"logo"`
+		Captions      Captions     `json:"captions"`
+		AudioTracks   []AudioTrack `json:"audioTracks"` // The ingest's first audio stream if empty
+		SlateURL      string       `json:"slateURL"`
+		BackupIngests []Ingest     `json:"backupIngests"` // Failed over to in order before the slate
+		FailbackDelay int          `json:"failbackDelay"` // Seconds, 0 uses the MCR's
+		Visibility    string       `json:"visibility"`
+		Archive       bool         `json:"archive"`
+		DVR           bool         `json:"dvr"`
+		HasScheduler  bool         `json:"hasScheduler"`
+		HasPiper      bool         `json:"hasPiper"`
+		Outputs       []Output     `json:"outputs"`
+	}
+	// UpdateChannel is the body to replace a channel's config
+	UpdateChannel struct {
+		Name          string       `json:"name"` // Kept if empty
+		Description   string       `json:"description"`
+		IngestURL     string       `json:"ingestURL"`
+		IngestType    string       `json:"ingestType"`
+		IngestSRT     *SRT         `json:"ingestSRT"`
+		Loudness      Loudness     `json:"loudness"`
+		Logo          Logo         `json:"logo"`
+		Captions      Captions     `json:"captions"`
+		AudioTracks   []AudioTrack `json:"audioTracks"` // The ingest's first audio stream if empty
+		SlateURL      string       `json:"slateURL"`
+		BackupIngests []Ingest     `json:"backupIngests"` // Failed over to in order before the slate
+		FailbackDelay int          `json:"failbackDelay"` // Seconds, 0 uses the MCR's
+		Visibility    string       `json:"visibility"`
+		Archive       bool         `json:"archive"`
+		DVR           bool         `json:"dvr"`
+		HasScheduler  bool         `json:"hasScheduler"`
+		HasPiper      bool         `json:"hasPiper"`
+	}
+	// Output is one of a channel's outputs
+	Output struct {
+		ID              int         `json:"id"`
+		Name            string      `json:"name"`
+		Type            string      `json:"type"` // rtp / rtmp / srt / hls / dash / cmaf / restream
+		Passthrough     bool        `json:"passthrough"`
+		DVR             bool        `json:"dvr"`
+		DVRWindow       int         `json:"dvrWindow"`
+		SegmentDuration int         `json:"segmentDuration"`
+		Logo            bool        `json:"logo"`     // Overlay the channel's logo
+		Captions        bool        `json:"captions"` // Keep embedded captions and publish subtitles
+		Markers         bool        `json:"markers"`  // Signal programme changes and breaks in the manifests
+		Destination     string      `json:"destination"`
+		StreamKey       string      `json:"streamKey,omitempty"` // Write only, restream only, kept if empty
+		HasStreamKey    bool        `json:"hasStreamKey"`        // Read only
+		Disabled        bool        `json:"disabled"`            // Restream only
+		Scheduled       bool        `json:"scheduled"`           // Only restream while a playout is on air
+		Profile         string      `json:"profile"`
+		ProfileVersion  int         `json:"profileVersion"`
+		Renditions      []Rendition `json:"renditions"`
+		SRT             *SRT        `json:"srt,omitempty"`
+		Args            string      `json:"args"`
+		Status          string      `json:"status"` // Read only
+	}
+	// Rendition is a video stream of an output
+	Rendition struct {
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+		Bitrate int    `json:"bitrate"` // Kb/s
+		FPS     int    `json:"fps"`
+		Codec   string `json:"codec"`
+	}
+	// SRT are the options of an SRT connection, the passphrase is
+	// write only so has to be given again when replacing them
+	SRT struct {
+		Mode       string `json:"mode"` // caller / listener / rendezvous
+		Passphrase string `json:"passphrase,omitempty"`
+		Latency    int    `json:"latency"` // Milliseconds
+	}
+	// Ingest is a backup source of a channel
+	Ingest struct {
+		URL  string `json:"url"`
+		Type string `json:"type"` // rtp / rtmp / hls / srt
+		SRT  *SRT   `json:"srt,omitempty"`
+	}
+	// Input is what is feeding a channel's outputs
+	Input struct {
+		Source int  `json:"source"` // 0 is the channel's ingest, then its backups
+		Slate  bool `json:"slate"`
+	}
+	// Loudness is how a channel's audio is normalised
+	Loudness struct {
+		Mode     string  `json:"mode"`     // off / live / measured
+		Target   float64 `json:"target"`   // Integrated LUFS, 0 is -23
+		TruePeak float64 `json:"truePeak"` // Ceiling dBTP, 0 is -1
+	}
+	// Logo is the channel's station logo, placed on outputs which
+	// overlay it
+	Logo struct {
+		URL      string  `json:"url"`      // PNG or JPEG path or URL, empty for none
+		Position string  `json:"position"` // top-left / top-right / bottom-left / bottom-right
+		Scale    float64 `json:"scale"`    // Width as a fraction of the frame's, 0 is 0.1
+		Opacity  float64 `json:"opacity"`  // Fraction, 0 is opaque
+		Margin   float64 `json:"margin"`   // Safe area as a fraction of the frame, 0 is 0.05
+	}
+	// AudioTrack is an audio rendition of a channel's outputs, the
+	// first is the default
+	AudioTrack struct {
+		Track    int    `json:"track"`    // Index of the ingest's audio stream
+		Language string `json:"language"` // Language tag, empty if it has none
+		Name     string `json:"name"`     // Shown to viewers, i.e. commentary
+	}
+	// Captions are the captions a channel's outputs carry
+	Captions struct {
+		Subtitles      []string `json:"subtitles"`      // Languages of the WebVTT renditions filled by programmes' sidecars
+		ClosedCaptions string   `json:"closedCaptions"` // Language of captions embedded in the ingest, empty for none
+	}
+	// Status is a channel's state and the health of its outputs
+	Status struct {
+		Status  channel.Status         `json:"status"`
+		Outputs []channel.OutputHealth `json:"outputs"`
+	}
+)
+
+func (a *API) listChannels(w http.ResponseWriter, r *http.Request) {
+	chs, err := a.mcr.GetChannels()
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	res := []Channel{}
+	for _, ch := range chs {
+		res = append(res, toChannel(ch.Info()))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *API) getChannel(w http.ResponseWriter, r *http.Request) {
+	ch, err := a.mcr.GetChannel(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toChannel(ch.Info()))
+}
+
+func (a *API) newChannel(w http.ResponseWriter, r *http.Request) {
+	req := NewChannel{}
+	err := decode(r, &req)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	outputs := make([]channel.Output, 0, len(req.Outputs))
+	for _, o := range req.Outputs {
+		outputs = append(outputs, fromOutput(o))
+	}
+	ch, err := a.mcr.NewChannel(r.Context(), channel.NewChannelStruct{
+		ShortName:     req.ShortName,
+		Name:          req.Name,
+		Description:   req.Description,
+		ChannelType:   req.Type,
+		IngestURL:     req.IngestURL,
+		IngestType:    req.IngestType,
+		IngestSRT:     fromSRT(req.IngestSRT),
+		Loudness:      fromLoudness(req.Loudness),
+		Logo:          fromLogo(req.Logo),
+		Captions:      fromCaptions(req.Captions),
+		AudioTracks:   fromAudioTracks(req.AudioTracks),
+		SlateURL:      req.SlateURL,
+		BackupIngests: fromIngests(req.BackupIngests),
+		FailbackDelay: req.FailbackDelay,
+		Visible:       req.Visibility,
+		Archive:       req.Archive,
+		DVR:           req.DVR,
+		HasScheduler:  req.HasScheduler,
+		HasPiper:      req.HasPiper,
+		Outputs:       outputs,
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	info := ch.Info()
+	w.Header().Set("Location", "channels/"+info.ShortName)
+	writeJSON(w, http.StatusCreated, toChannel(info))
+}
+
+func (a *API) updateChannel(w http.ResponseWriter, r *http.Request) {
+	req := UpdateChannel{}
+	err := decode(r, &req)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	ch, err := a.mcr.UpdateChannel(r.Context(), mux.Vars(r)["channel"], channel.UpdateChannelStruct{
+		Name:          req.Name,
+		Description:   req.Description,
+		IngestURL:     req.IngestURL,
+		IngestType:    req.IngestType,
+		IngestSRT:     fromSRT(req.IngestSRT),
+		Loudness:      fromLoudness(req.Loudness),
+		Logo:          fromLogo(req.Logo),
+		Captions:      fromCaptions(req.Captions),
+		AudioTracks:   fromAudioTracks(req.AudioTracks),
+		SlateURL:      req.SlateURL,
+		BackupIngests: fromIngests(req.BackupIngests),
+		FailbackDelay: req.FailbackDelay,
+		Visible:       req.Visibility,
+		Archive:       req.Archive,
+		DVR:           req.DVR,
+		HasScheduler:  req.HasScheduler,
+		HasPiper:      req.HasPiper,
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toChannel(ch.Info()))
+}
+
+func (a *API) deleteChannel(w http.ResponseWriter, r *http.Request) {
+	err := a.mcr.DeleteChannel(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) startChannel(w http.ResponseWriter, r *http.Request) {
+	a.control(w, r, a.mcr.StartChannel)
+}
+
+func (a *API) stopChannel(w http.ResponseWriter, r *http.Request) {
+	a.control(w, r, a.mcr.StopChannel)
+}
+
+func (a *API) restartChannel(w http.ResponseWriter, r *http.Request) {
+	a.control(w, r, a.mcr.RestartChannel)
+}
+
+// control runs a lifecycle action on a channel, responding with its status
+func (a *API) control(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, shortName string) error) {
+	shortName := mux.Vars(r)["channel"]
+	err := action(r.Context(), shortName)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	a.channelStatus(w, r)
+}
+
+func (a *API) pinInput(w http.ResponseWriter, r *http.Request) {
+	req := Input{}
+	err := decode(r, &req)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	in := channel.Input(req)
+	a.input(w, r, &in)
+}
+
+func (a *API) unpinInput(w http.ResponseWriter, r *http.Request) {
+	a.input(w, r, nil)
+}
+
+// input pins or unpins a channel's input, responding with the channel
+func (a *API) input(w http.ResponseWriter, r *http.Request, in *channel.Input) {
+	shortName := mux.Vars(r)["channel"]
+	err := a.mcr.PinInput(r.Context(), shortName, in)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	a.getChannel(w, r)
+}
+
+func (a *API) channelStatus(w http.ResponseWriter, r *http.Request) {
+	ch, err := a.mcr.GetChannel(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	status, err := ch.Stat()
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Status{Status: status, Outputs: ch.Health()})
+}
+
+func (a *API) listOutputs(w http.ResponseWriter, r *http.Request) {
+	ch, err := a.mcr.GetChannel(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toChannel(ch.Info()).Outputs)
+}
+
+func (a *API) newOutput(w http.ResponseWriter, r *http.Request) {
+	req := Output{}
+	err := decode(r, &req)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	req.ID = 0
+	o, err := a.mcr.AddOutput(r.Context(), mux.Vars(r)["channel"], fromOutput(req))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.Header().Set("Location", "outputs/"+strconv.Itoa(o.ID))
+	writeJSON(w, http.StatusCreated, toOutput(*o))
+}
+
+func (a *API) updateOutput(w http.ResponseWriter, r *http.Request) {
+	req := Output{}
+	err := decode(r, &req)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	req.ID, _ = strconv.Atoi(mux.Vars(r)["output"])
+	o, err := a.mcr.UpdateOutput(r.Context(), mux.Vars(r)["channel"], fromOutput(req))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toOutput(*o))
+}
+
+func (a *API) deleteOutput(w http.ResponseWriter, r *http.Request) {
+	outputID, _ := strconv.Atoi(mux.Vars(r)["output"])
+	err := a.mcr.RemoveOutput(r.Context(), mux.Vars(r)["channel"], outputID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toChannel converts a channel for a response
+func toChannel(info channel.Info) Channel {
+	ch := Channel{
+		ShortName:     info.ShortName,
+		Name:          info.Name,
+		Description:   info.Description,
+		Type:          info.ChannelType,
+		IngestURL:     info.IngestURL,
+		IngestType:    info.IngestType,
+		IngestSRT:     toSRT(info.SRTOptions),
+		Loudness:      toLoudness(info.Loudness),
+		Logo:          toLogo(info.Logo),
+		Captions:      toCaptions(info.Captions),
+		AudioTracks:   []AudioTrack{},
+		SlateURL:      info.SlateURL,
+		BackupIngests: []Ingest{},
+		FailbackDelay: info.FailbackDelay,
+		Input:         Input(info.Input),
+		Pinned:        info.Pinned,
+		Visibility:    info.Visibility,
+		Archive:       info.Archive,
+		DVR:           info.DVR,
+		HasScheduler:  info.HasScheduler,
+		HasPiper:      info.HasPiper,
+		Outputs:       []Output{},
+		Status:        info.Status,
+		CreatedAt:     info.CreatedAt,
+	}
+	for _, o := range info.Outputs {
+		ch.Outputs = append(ch.Outputs, toOutput(o))
+	}
+	for _, t := range info.AudioTracks {
+		ch.AudioTracks = append(ch.AudioTracks, AudioTrack(t))
+	}
+	for _, src := range info.BackupIngests {
+		ch.BackupIngests = append(ch.BackupIngests, Ingest{URL: src.URL, Type: src.Type, SRT: toSRT(src.SRTOptions)})
+	}
+	return ch
+}
+
+// toOutput converts an output for a response, hiding its stream key
+func toOutput(o channel.Output) Output {
+	o = o.Redacted()
+	res := Output{
+		ID:              o.ID,
+		Name:            o.Name,
+		Type:            o.Type,
+		Passthrough:     o.Passthrough,
+		DVR:             o.DVR,
+		DVRWindow:       o.DVRWindow,
+		SegmentDuration: o.SegmentDuration,
+		Logo:            o.Logo,
+		Captions:        o.Captions,
+		Markers:         o.Markers,
+		Destination:     o.Destination,
+		HasStreamKey:    o.StreamKey != "",
+		Disabled:        o.Disabled,
+		Scheduled:       o.Scheduled,
+		Profile:         o.Profile,
+		ProfileVersion:  o.ProfileVersion,
+		Renditions:      []Rendition{},
+		SRT:             toSRT(o.SRTOptions),
+		Args:            o.Args,
+		Status:          o.Status,
+	}
+	for _, r := range o.Renditions {
+		res.Renditions = append(res.Renditions, Rendition(r))
+	}
+	return res
+}
+
+// fromOutput converts an output from a request
+func fromOutput(o Output) channel.Output {
+	res := channel.Output{
+		ID:              o.ID,
+		Name:            o.Name,
+		Type:            o.Type,
+		Passthrough:     o.Passthrough,
+		DVR:             o.DVR,
+		DVRWindow:       o.DVRWindow,
+		SegmentDuration: o.SegmentDuration,
+		Logo:            o.Logo,
+		Captions:        o.Captions,
+		Markers:         o.Markers,
+		Destination:     o.Destination,
+		StreamKey:       o.StreamKey,
+		Disabled:        o.Disabled,
+		Scheduled:       o.Scheduled,
+		Profile:         o.Profile,
+		ProfileVersion:  o.ProfileVersion,
+		SRTOptions:      fromSRT(o.SRT),
+		Args:            o.Args,
+	}
+	for _, r := range o.Renditions {
+		res.Renditions = append(res.Renditions, channel.Rendition(r))
+	}
+	return res
+}
+
+// toSRT converts SRT options for a response, leaving out the passphrase
+func toSRT(o channel.SRTOptions) *SRT {
+	if o == (channel.SRTOptions{}) {
+		return nil
+	}
+	return &SRT{Mode: o.SRTMode, Latency: o.SRTLatency}
+}
+
+// fromSRT converts SRT options from a request
+func fromSRT(o *SRT) channel.SRTOptions {
+	if o == nil {
+		return channel.SRTOptions{}
+	}
+	return channel.SRTOptions{
+		SRTMode:       o.Mode,
+		SRTPassphrase: o.Passphrase,
+		SRTLatency:    o.Latency,
+	}
+}
+
+// toLoudness converts a loudness policy for a response
+func toLoudness(p channel.LoudnessPolicy) Loudness {
+	return Loudness{
+		Mode:     p.LoudnessMode,
+		Target:   p.LoudnessTarget,
+		TruePeak: p.LoudnessTruePeak,
+	}
+}
+
+// fromLoudness converts a requested loudness policy
+func fromLoudness(l Loudness) channel.LoudnessPolicy {
+	return channel.LoudnessPolicy{
+		LoudnessMode:     l.Mode,
+		LoudnessTarget:   l.Target,
+		LoudnessTruePeak: l.TruePeak,
+	}
+}
+
+// toLogo converts a logo for a response
+func toLogo(l channel.LogoOptions) Logo {
+	return Logo{
+		URL:      l.LogoURL,
+		Position: l.LogoPosition,
+		Scale:    l.LogoScale,
+		Opacity:  l.LogoOpacity,
+		Margin:   l.LogoMargin,
+	}
+}
+
+// fromLogo converts a requested logo
+func fromLogo(l Logo) channel.LogoOptions {
+	return channel.LogoOptions{
+		LogoURL:      l.URL,
+		LogoPosition: l.Position,
+		LogoScale:    l.Scale,
+		LogoOpacity:  l.Opacity,
+		LogoMargin:   l.Margin,
+	}
+}
+
+// toCaptions converts caption options for a response
+func toCaptions(c channel.CaptionOptions) Captions {
+	res := Captions{Subtitles: []string{}, ClosedCaptions: c.ClosedCaptions}
+	for _, language := range strings.Split(c.SubtitleLanguages, ",") {
+		if language = strings.TrimSpace(language); language != "" {
+			res.Subtitles = append(res.Subtitles, language)
+		}
+	}
+	return res
+}
+
+// fromCaptions converts requested caption options
+func fromCaptions(c Captions) channel.CaptionOptions {
+	return channel.CaptionOptions{
+		SubtitleLanguages: strings.Join(c.Subtitles, ","),
+		ClosedCaptions:    c.ClosedCaptions,
+	}
+}
+
+// fromAudioTracks converts requested audio tracks
+func fromAudioTracks(tracks []AudioTrack) []channel.AudioTrack {
+	res := []channel.AudioTrack{}
+	for _, t := range tracks {
+		res = append(res, channel.AudioTrack(t))
+	}
+	return res
+}
+
+// fromIngests converts requested backup ingests
+func fromIngests(ingests []Ingest) []channel.IngestSource {
+	res := []channel.IngestSource{}
+	for _, i := range ingests {
+		res = append(res, channel.IngestSource{URL: i.URL, Type: i.Type, SRTOptions: fromSRT(i.SRT)})
+	}
+	return res
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (a *API) listSourceChecks(w http.ResponseWriter, r *http.Request) {
+	checks, err := a.mcr.GetSourceChecks(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checks)
+}
+
+func (a *API) checkSources(w http.ResponseWriter, r *http.Request) {
+	checks, err := a.mcr.CheckSources(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checks)
+}
@@ -0,0 +1,181 @@
+// Package captions reads sidecar subtitles and publishes them as live
+// WebVTT renditions alongside a channel's HLS outputs
+package captions
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCaptions is when a sidecar isn't SRT or WebVTT
+var ErrInvalidCaptions = errors.New("invalid captions")
+
+type (
+	// Cue is a caption shown between two points of a video
+	Cue struct {
+		Start time.Duration
+		End   time.Duration
+		Text  string
+	}
+	// Track is a programme's captions in one language, timed from the
+	// start of the programme
+	Track struct {
+		Language string
+		Name     string
+		Cues     []Cue
+	}
+)
+
+// Fetch reads a sidecar from a http(s) URL or a path
+func Fetch(ctx context.Context, url string) ([]Cue, error) {
+	if !isHTTP(url) {
+		f, err := os.Open(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open captions: %w", err)
+		}
+		defer f.Close()
+		return Parse(f)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create captions request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch captions: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch captions: %s", res.Status)
+	}
+	return Parse(res.Body)
+}
+
+// Parse reads SRT or WebVTT cues, sorted by when they start
+//
+// Both are blocks separated by blank lines with a timing line followed
+// by the cue's text, so they're read the same way. Blocks without a
+// timing line, i.e. headers and WebVTT's NOTE and STYLE, are skipped.
+func Parse(r io.Reader) ([]Cue, error) {
+	scanner := bufio.NewScanner(r)
+	cues := []Cue{}
+	block := []string{}
+	flush := func() error {
+		defer func() { block = block[:0] }()
+		for idx, line := range block {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			start, end, err := parseTiming(line)
+			if err != nil {
+				return err
+			}
+			text := strings.TrimSpace(strings.Join(block[idx+1:], "\n"))
+			if text != "" && end > start {
+				cues = append(cues, Cue{Start: start, End: end, Text: text})
+			}
+			return nil
+		}
+		return nil
+	}
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if strings.TrimSpace(line) == "" {
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read captions: %w", err)
+	}
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("%w: no cues", ErrInvalidCaptions)
+	}
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].Start < cues[j].Start
+	})
+	return cues, nil
+}
+
+// parseTiming reads a cue's "start --> end" line, ignoring any
+// WebVTT cue settings after it
+func parseTiming(line string) (time.Duration, time.Duration, error) {
+	parts := strings.SplitN(line, "-->", 2)
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("%w: timing \"%s\" has no end", ErrInvalidCaptions, line)
+	}
+	start, err := parseTimestamp(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimestamp(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseTimestamp reads "hh:mm:ss.mmm" or "mm:ss.mmm", SRT's
+// comma is accepted in place of the dot
+func parseTimestamp(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("%w: timestamp \"%s\"", ErrInvalidCaptions, s)
+	parts := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, invalid
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 {
+		return 0, invalid
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	for idx, unit := range []time.Duration{time.Minute, time.Hour}[:len(parts)-1] {
+		n, err := strconv.Atoi(parts[len(parts)-2-idx])
+		if err != nil || n < 0 {
+			return 0, invalid
+		}
+		d += time.Duration(n) * unit
+	}
+	return d.Round(time.Millisecond), nil
+}
+
+// Matches is when a caption's language tag is one a channel publishes,
+// either the same or a regional variant of it
+func Matches(tag, language string) bool {
+	tag, language = strings.ToLower(tag), strings.ToLower(language)
+	return tag == language || strings.HasPrefix(tag, language+"-")
+}
+
+// timestamp formats an offset as a WebVTT timestamp
+func timestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second, d%time.Second/time.Millisecond)
+}
+
+func isHTTP(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
@@ -0,0 +1,180 @@
+package captions
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Cue
+	}{
+		{
+			name: "srt",
+			in: "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\n\r\n" +
+				"2\r\n00:00:03,000 --> 00:00:04,000\r\nTwo\r\nlines\r\n",
+			want: []Cue{
+				{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello"},
+				{Start: 3 * time.Second, End: 4 * time.Second, Text: "Two\nlines"},
+			},
+		},
+		{
+			name: "webvtt",
+			in: "WEBVTT - programme\n\nNOTE made by hand\n\nSTYLE\n::cue { color: yellow }\n\n" +
+				"intro\n01:00:00.000 --> 01:00:01.000 align:start line:0\nLater\n\n" +
+				"00:05.250 --> 00:06.000\nShort form\n",
+			want: []Cue{
+				{Start: 5250 * time.Millisecond, End: 6 * time.Second, Text: "Short form"},
+				{Start: time.Hour, End: time.Hour + time.Second, Text: "Later"},
+			},
+		},
+		{
+			name: "empty and backwards cues",
+			in: "00:00:01.000 --> 00:00:02.000\n\n" +
+				"00:00:04.000 --> 00:00:03.000\nBackwards\n\n" +
+				"00:00:05.000 --> 00:00:06.000\nKept\n",
+			want: []Cue{
+				{Start: 5 * time.Second, End: 6 * time.Second, Text: "Kept"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cues, err := Parse(strings.NewReader(test.in))
+			if err != nil {
+				t.Fatalf("failed to parse: %+v", err)
+			}
+			if !reflect.DeepEqual(cues, test.want) {
+				t.Errorf("parsed %+v, want %+v", cues, test.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no cues":        "WEBVTT\n\nNOTE nothing here\n",
+		"no end":         "00:00:01.000 -->\nText\n",
+		"bad timestamp":  "00:00:aa.000 --> 00:00:02.000\nText\n",
+		"too many parts": "00:00:00:01.000 --> 00:00:02.000\nText\n",
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(in))
+			if !errors.Is(err, ErrInvalidCaptions) {
+				t.Errorf("parsed invalid captions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		tag, language string
+		want          bool
+	}{
+		{tag: "en", language: "en", want: true},
+		{tag: "en-GB", language: "en", want: true},
+		{tag: "EN", language: "en", want: true},
+		{tag: "eng", language: "en"},
+		{tag: "en", language: "en-GB"},
+	}
+	for _, test := range tests {
+		if got := Matches(test.tag, test.language); got != test.want {
+			t.Errorf("Matches(%q, %q) is %t", test.tag, test.language, got)
+		}
+	}
+}
+
+// TestTimelineCut checks a programme coming off air early loses the
+// rest of its cues, without touching the next programme's
+func TestTimelineCut(t *testing.T) {
+	at := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	tl := NewTimeline()
+	tl.Add("EN", 1, at, []Cue{
+		{Start: 0, End: 2 * time.Second, Text: "first"},
+		{Start: 3 * time.Second, End: 6 * time.Second, Text: "showing"},
+		{Start: 8 * time.Second, End: 9 * time.Second, Text: "never shown"},
+	})
+	tl.Add("en", 2, at.Add(10*time.Second), []Cue{
+		{Start: 0, End: time.Second, Text: "next"},
+	})
+	tl.Cut(1, at.Add(4*time.Second))
+
+	cues := tl.Between("en", at, at.Add(time.Minute))
+	texts := []string{}
+	for _, cue := range cues {
+		texts = append(texts, cue.Text)
+	}
+	if !reflect.DeepEqual(texts, []string{"first", "showing", "next"}) {
+		t.Fatalf("cues are %q", texts)
+	}
+	if !cues[1].End.Equal(at.Add(4 * time.Second)) {
+		t.Errorf("cut cue ends at %s", cues[1].End)
+	}
+
+	tl.Prune(at.Add(5 * time.Second))
+	if cues := tl.Between("en", at, at.Add(time.Minute)); len(cues) != 1 || cues[0].Text != "next" {
+		t.Errorf("pruned cues are %+v", cues)
+	}
+}
+
+// TestRenditionWrite checks segments are written with their cues
+// relative to the output's start, and drop out of the window
+func TestRenditionWrite(t *testing.T) {
+	dir := t.TempDir()
+	epoch := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := &Rendition{
+		Playlist: filepath.Join(dir, "subs.m3u8"),
+		Segment:  4 * time.Second,
+		Window:   2,
+		Epoch:    epoch,
+	}
+	cues := []Timed{
+		{Start: epoch.Add(time.Second), End: epoch.Add(5 * time.Second), Text: "spans two"},
+		{Start: epoch.Add(9 * time.Second), End: epoch.Add(10 * time.Second), Text: "third"},
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		err := r.Write(ctx, cues)
+		if err != nil {
+			t.Fatalf("failed to write segment: %+v", err)
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(dir, "subs_00000.vtt"))
+	if !os.IsNotExist(err) {
+		t.Errorf("segment outside the window is still there: %v", err)
+	}
+	segment, err := ioutil.ReadFile(filepath.Join(dir, "subs_00001.vtt"))
+	if err != nil {
+		t.Fatalf("failed to read segment: %+v", err)
+	}
+	want := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:05.000\nspans two\n"
+	if string(segment) != want {
+		t.Errorf("segment is %q, want %q", segment, want)
+	}
+
+	err = r.Close(ctx)
+	if err != nil {
+		t.Fatalf("failed to close rendition: %+v", err)
+	}
+	playlist, err := ioutil.ReadFile(r.Playlist)
+	if err != nil {
+		t.Fatalf("failed to read playlist: %+v", err)
+	}
+	want = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+		"#EXTINF:4.000,\nsubs_00001.vtt\n#EXTINF:4.000,\nsubs_00002.vtt\n#EXT-X-ENDLIST\n"
+	if string(playlist) != want {
+		t.Errorf("playlist is %q, want %q", playlist, want)
+	}
+}
@@ -0,0 +1,158 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mpegtsStart is where ffmpeg's MPEG-TS muxer starts an output's
+// timestamps, 1.4 seconds in at 90kHz. Segments map their cues to it.
+const mpegtsStart = 126000
+
+// Rendition is a live WebVTT subtitle rendition of a HLS output,
+// written as a media playlist of segments next to the output's own
+type Rendition struct {
+	Playlist string        // Path or http(s) URL, segments are written alongside
+	Segment  time.Duration // Length of each segment, the same as the output's
+	Window   int           // Segments kept in the playlist, 0 keeps every one
+	Epoch    time.Time     // When the output started, the start of its timeline
+
+	seq      int      // Next segment
+	segments []string // Names of the segments in the playlist
+}
+
+// Next is the span the next segment covers
+func (r *Rendition) Next() (from, to time.Time) {
+	from = r.Epoch.Add(time.Duration(r.seq) * r.Segment)
+	return from, from.Add(r.Segment)
+}
+
+// Write writes the next segment with the cues showing during it, then
+// the playlist including it. Segments which leave the window are
+// removed.
+func (r *Rendition) Write(ctx context.Context, cues []Timed) error {
+	from, to := r.Next()
+	vtt := bytes.Buffer{}
+	fmt.Fprintf(&vtt, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", mpegtsStart)
+	for _, cue := range cues {
+		if !cue.Start.Before(to) || !cue.End.After(from) {
+			continue
+		}
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s\n",
+			timestamp(cue.Start.Sub(r.Epoch)), timestamp(cue.End.Sub(r.Epoch)), cue.Text)
+	}
+	name := fmt.Sprintf("%s_%05d.vtt", strings.TrimSuffix(path.Base(r.Playlist), path.Ext(r.Playlist)), r.seq)
+	err := Put(ctx, r.sibling(name), vtt.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	r.seq++
+	r.segments = append(r.segments, name)
+	if r.Window > 0 && len(r.segments) > r.Window {
+		old := r.segments[0]
+		r.segments = r.segments[1:]
+		// Players behind the live edge might still want it, but it's
+		// gone from the playlist so isn't worth failing over
+		Remove(ctx, r.sibling(old))
+	}
+	err = Put(ctx, r.Playlist, r.playlist(false))
+	if err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
+
+// Close ends the playlist so players know no more segments are coming
+func (r *Rendition) Close(ctx context.Context) error {
+	if len(r.segments) == 0 {
+		return nil
+	}
+	err := Put(ctx, r.Playlist, r.playlist(true))
+	if err != nil {
+		return fmt.Errorf("failed to end playlist: %w", err)
+	}
+	return nil
+}
+
+// playlist is the media playlist of the segments in the window
+func (r *Rendition) playlist(ended bool) []byte {
+	m3u8 := bytes.Buffer{}
+	seconds := r.Segment.Seconds()
+	fmt.Fprintf(&m3u8, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n", int(seconds+0.5))
+	fmt.Fprintf(&m3u8, "#EXT-X-MEDIA-SEQUENCE:%d\n", r.seq-len(r.segments))
+	if r.Window == 0 {
+		m3u8.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	for _, name := range r.segments {
+		fmt.Fprintf(&m3u8, "#EXTINF:%.3f,\n%s\n", seconds, name)
+	}
+	if ended {
+		m3u8.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return m3u8.Bytes()
+}
+
+// sibling is a file next to the playlist
+func (r *Rendition) sibling(name string) string {
+	return strings.TrimSuffix(r.Playlist, path.Base(r.Playlist)) + name
+}
+
+// Put writes a file to a local path, or PUTs it to a http(s) URL like
+// ffmpeg does with the outputs' own segments
+func Put(ctx context.Context, dst string, body []byte) error {
+	if !isHTTP(dst) {
+		err := os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		// Renamed so players never read half a file
+		tmp := dst + ".part"
+		err = os.WriteFile(tmp, body, 0644)
+		if err != nil {
+			return err
+		}
+		return os.Rename(tmp, dst)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, dst, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("destination returned %s", res.Status)
+	}
+	return nil
+}
+
+// Remove deletes a file written by Put, it doesn't matter if it's
+// already gone
+func Remove(ctx context.Context, dst string) error {
+	if !isHTTP(dst) {
+		err := os.Remove(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, dst, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
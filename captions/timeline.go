@@ -0,0 +1,104 @@
+package captions
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timed is a cue placed on the wall clock
+type Timed struct {
+	Start   time.Time
+	End     time.Time
+	Text    string
+	Playout int // Playout the cue is part of
+}
+
+// Timeline is what a channel is captioning in each language, filled
+// as programmes go on air
+type Timeline struct {
+	lock sync.Mutex
+	cues map[string][]Timed
+}
+
+// NewTimeline creates an empty timeline
+func NewTimeline() *Timeline {
+	return &Timeline{cues: make(map[string][]Timed)}
+}
+
+// Add places a playout's cues timed from at, when its programme started
+func (t *Timeline) Add(language string, playoutID int, at time.Time, cues []Cue) {
+	language = strings.ToLower(language)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	timed := t.cues[language]
+	for _, cue := range cues {
+		timed = append(timed, Timed{
+			Start:   at.Add(cue.Start),
+			End:     at.Add(cue.End),
+			Text:    cue.Text,
+			Playout: playoutID,
+		})
+	}
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].Start.Before(timed[j].Start)
+	})
+	t.cues[language] = timed
+}
+
+// Cut drops a playout's cues from at onwards and ends the ones
+// showing, for when its programme comes off air early
+func (t *Timeline) Cut(playoutID int, at time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for language, timed := range t.cues {
+		kept := timed[:0]
+		for _, cue := range timed {
+			if cue.Playout != playoutID {
+				kept = append(kept, cue)
+				continue
+			}
+			if !cue.Start.Before(at) {
+				continue
+			}
+			if cue.End.After(at) {
+				cue.End = at
+			}
+			kept = append(kept, cue)
+		}
+		t.cues[language] = kept
+	}
+}
+
+// Between are the cues in a language showing at any point from
+// from until to
+func (t *Timeline) Between(language string, from, to time.Time) []Timed {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	cues := []Timed{}
+	for _, cue := range t.cues[strings.ToLower(language)] {
+		if !cue.Start.Before(to) {
+			break
+		}
+		if cue.End.After(from) {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+// Prune forgets cues which ended before a time
+func (t *Timeline) Prune(before time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for language, timed := range t.cues {
+		kept := timed[:0]
+		for _, cue := range timed {
+			if cue.End.After(before) {
+				kept = append(kept, cue)
+			}
+		}
+		t.cues[language] = kept
+	}
+}
@@ -0,0 +1,354 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/scheduler"
+)
+
+const (
+	// archiveOutput identifies the recording command on the transcoder
+	archiveOutput = "archive"
+	// recordingSegment is the length in seconds of each recorded file
+	recordingSegment = 60
+	// recordingLayout is the name format of recorded files, ffmpeg
+	// and Go's equivalent
+	recordingLayout     = "%Y%m%dT%H%M%S"
+	recordingLayoutTime = "20060102T150405"
+	// recordingPoll is how often a playout waits on its last file
+	recordingPoll = 5 * time.Second
+)
+
+var (
+	// ErrNoRecording is when there is nothing recorded covering a playout
+	ErrNoRecording = errors.New("no recording for playout")
+	// ErrArchiveUnavailable is when a channel is archived without
+	// anywhere to record it
+	ErrArchiveUnavailable = errors.New("archiving isn't available")
+)
+
+// ArchiveStore keeps archived recordings
+type ArchiveStore interface {
+	// Put stores a recording under name, returning where it can be found
+	Put(ctx context.Context, name string, r io.Reader) (string, error)
+}
+
+// LocalArchiveStore keeps recordings in a directory, which is
+// expected to be served at URL
+type LocalArchiveStore struct {
+	Dir string
+	URL string
+}
+
+var _ ArchiveStore = &LocalArchiveStore{}
+
+// Put writes the recording to the directory
+func (s *LocalArchiveStore) Put(ctx context.Context, name string, r io.Reader) (string, error) {
+	dst := filepath.Join(s.Dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("failed to make archive directory: %w", err)
+	}
+	// Write to a temporary file so a partial recording is never served
+	f, err := os.Create(dst + ".part")
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive file: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write archive file: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to close archive file: %w", err)
+	}
+	err = os.Rename(f.Name(), dst)
+	if err != nil {
+		return "", fmt.Errorf("failed to move archive file: %w", err)
+	}
+	if s.URL == "" {
+		return dst, nil
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse archive url: %w", err)
+	}
+	u.Path = path.Join(u.Path, name)
+	return u.String(), nil
+}
+
+// archiver records a channel's ingest and cuts it into playouts
+type archiver struct {
+	dir       string // Where the recording is kept
+	ffmpeg    string
+	retention time.Duration
+	store     ArchiveStore
+	po        *playout.Playouter
+}
+
+// recording is a single file of a channel's recording, there can be
+// gaps between them when the ingest dropped
+type recording struct {
+	path  string
+	start time.Time // From its name
+	end   time.Time // When it was last written
+}
+
+// validateArchive checks a channel can be archived, which needs
+// somewhere local to record it
+func (mcr *MCR) validateArchive(archive bool) error {
+	if archive && mcr.conf.ArchiveDir == "" {
+		return fmt.Errorf("%w: no archive directory is configured", ErrArchiveUnavailable)
+	}
+	return nil
+}
+
+// recordCommand records the channel's ingest into fixed length files
+// named by their start time
+func (ch *Channel) recordCommand() (Command, error) {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	input, err := ch.ingestArgs()
+	if err != nil {
+		return Command{}, err
+	}
+	dst := filepath.Join(ch.archive.dir, recordingLayout+".ts")
+	args := []string{"-hide_banner", "-nostdin"}
+	args = append(args, input...)
+	primary := len(args) - 1
+	args = append(args,
+		"-map", "0",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.Itoa(recordingSegment),
+		"-segment_format", "mpegts",
+		"-reset_timestamps", "1",
+		"-strftime", "1",
+		dst,
+	)
+	return Command{Output: archiveOutput, Destination: dst, Args: args, input: primary, secrets: ch.ingestSecrets()}, nil
+}
+
+// startRecording begins recording the ingest if the channel is archived
+func (ch *Channel) startRecording(ctx context.Context) error {
+	if !ch.Archive || ch.archive == nil || ch.tc == nil {
+		return nil
+	}
+	cmd, err := ch.recordCommand()
+	if err != nil {
+		return fmt.Errorf("failed to compile recording: %w", err)
+	}
+	err = ch.tc.Start(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start recording: %w", err)
+	}
+	ch.inputLock.Lock()
+	ch.recording = true
+	ch.inputLock.Unlock()
+	return nil
+}
+
+// stopRecording stops recording the ingest if it is being recorded
+func (ch *Channel) stopRecording(ctx context.Context) error {
+	ch.inputLock.Lock()
+	recording := ch.recording
+	ch.recording = false
+	ch.inputLock.Unlock()
+	if !recording {
+		return nil
+	}
+	return ch.tc.Stop(ctx, archiveOutput)
+}
+
+// handleScheduleEvent archives playouts once they have finished
+func (ch *Channel) handleScheduleEvent(e scheduler.Event) {
+	if e.Type != scheduler.EventPlayoutEnded || !ch.Archive || !e.Playout.Archive || ch.archive == nil {
+		return
+	}
+	go func() {
+		err := ch.ArchivePlayout(context.Background(), e.Playout)
+		if err != nil {
+			log.Printf("channel \"%s\": failed to archive playout %d: %+v", ch.ShortName, e.Playout.PlayoutID, err)
+		}
+	}()
+}
+
+// ArchivePlayout cuts a finished playout from the channel's recording,
+// storing it and setting the playout's VOD URL
+func (ch *Channel) ArchivePlayout(ctx context.Context, po playout.Playout) error {
+	if ch.archive == nil {
+		return errors.New("channel has no archiver")
+	}
+	if po.BroadcastStart.IsZero() || po.BroadcastEnd.IsZero() {
+		return errors.New("playout hasn't been broadcast")
+	}
+	a := ch.archive
+	err := ch.waitForRecording(ctx, po.BroadcastEnd)
+	if err != nil {
+		return err
+	}
+	recordings, err := a.between(po.BroadcastStart, po.BroadcastEnd)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "playout-archive")
+	if err != nil {
+		return fmt.Errorf("failed to make temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Each file is cut by its own start, so gaps between them are skipped
+	list := strings.Builder{}
+	list.WriteString("ffconcat version 1.0\n")
+	for _, rec := range recordings {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(rec.path, "'", `'\''`))
+		if po.BroadcastStart.After(rec.start) {
+			fmt.Fprintf(&list, "inpoint %.3f\n", po.BroadcastStart.Sub(rec.start).Seconds())
+		}
+		if po.BroadcastEnd.Before(rec.end) {
+			fmt.Fprintf(&list, "outpoint %.3f\n", po.BroadcastEnd.Sub(rec.start).Seconds())
+		}
+	}
+	listPath := filepath.Join(tmp, "list.txt")
+	err = os.WriteFile(listPath, []byte(list.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+
+	cut := filepath.Join(tmp, "cut.mp4")
+	out, err := exec.CommandContext(ctx, a.ffmpeg,
+		"-hide_banner", "-nostdin", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath,
+		"-map", "0", "-c", "copy",
+		"-movflags", "+faststart",
+		cut,
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to cut recording: %w: %s", err, out)
+	}
+
+	f, err := os.Open(cut)
+	if err != nil {
+		return fmt.Errorf("failed to open cut recording: %w", err)
+	}
+	defer f.Close()
+	name := fmt.Sprintf("%s/%d-%s.mp4", ch.ShortName, po.PlayoutID, po.BroadcastStart.Format(recordingLayoutTime))
+	vodURL, err := a.store.Put(ctx, name, f)
+	if err != nil {
+		return fmt.Errorf("failed to store recording: %w", err)
+	}
+
+	po.VODURL = vodURL
+	err = a.po.Update(ctx, po)
+	if err != nil {
+		return fmt.Errorf("failed to set vod url: %w", err)
+	}
+	a.prune()
+	return nil
+}
+
+// waitForRecording waits until the file being recorded at t has been
+// closed, which is when the next one starts or recording stops
+func (ch *Channel) waitForRecording(ctx context.Context, t time.Time) error {
+	// A file is never open for longer than a segment
+	deadline := t.Add(2 * recordingSegment * time.Second)
+	ticker := time.NewTicker(recordingPoll)
+	defer ticker.Stop()
+	for {
+		ch.inputLock.Lock()
+		recording := ch.recording
+		ch.inputLock.Unlock()
+		if !recording || time.Now().After(deadline) {
+			return nil
+		}
+		recs, err := ch.archive.recordings()
+		if err != nil {
+			return err
+		}
+		if len(recs) != 0 && !recs[len(recs)-1].start.Before(t) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordings lists the recorded files in start order
+func (a *archiver) recordings() ([]recording, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording directory: %w", err)
+	}
+	recs := []recording{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".ts" {
+			continue
+		}
+		start, err := time.ParseInLocation(recordingLayoutTime, strings.TrimSuffix(name, ".ts"), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recs = append(recs, recording{path: filepath.Join(a.dir, name), start: start, end: info.ModTime()})
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].start.Before(recs[j].start) })
+	return recs, nil
+}
+
+// between finds the recorded files covering a time range
+func (a *archiver) between(start, end time.Time) ([]recording, error) {
+	recs, err := a.recordings()
+	if err != nil {
+		return nil, err
+	}
+	covering := []recording{}
+	for _, rec := range recs {
+		if rec.start.Before(end) && rec.end.After(start) {
+			covering = append(covering, rec)
+		}
+	}
+	if len(covering) == 0 {
+		return nil, ErrNoRecording
+	}
+	return covering, nil
+}
+
+// prune removes recorded files older than the retention period
+func (a *archiver) prune() {
+	if a.retention <= 0 {
+		return
+	}
+	recs, err := a.recordings()
+	if err != nil {
+		return
+	}
+	for _, rec := range recs {
+		if time.Since(rec.end) > a.retention {
+			os.Remove(rec.path)
+		}
+	}
+}
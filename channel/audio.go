@@ -0,0 +1,153 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrInvalidAudioTracks is when a channel's audio tracks don't make sense
+	ErrInvalidAudioTracks = errors.New("invalid audio tracks")
+
+	// trackName is the format of an audio track's name, it's part of
+	// the names of its playlists and segments
+	trackName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+)
+
+// AudioTrack is an audio rendition of the channel's outputs, taken
+// from one of the ingest's audio streams. A channel's first track is
+// its default.
+type AudioTrack struct {
+	Track    int    `db:"track" json:"track"`       // Index of the ingest's audio stream
+	Language string `db:"language" json:"language"` // Language tag, empty if it has none
+	Name     string `db:"name" json:"name"`         // Shown to viewers, i.e. commentary
+}
+
+// defaultAudio is the rendition of channels which don't declare their
+// tracks, the ingest's first audio stream
+var defaultAudio = []AudioTrack{{Track: 0, Name: "audio"}}
+
+// validateAudioTracks checks a channel's tracks can be told apart
+func validateAudioTracks(tracks []AudioTrack) error {
+	names := make(map[string]bool)
+	for _, t := range tracks {
+		if t.Track < 0 {
+			return fmt.Errorf("%w: track %d doesn't exist", ErrInvalidAudioTracks, t.Track)
+		}
+		if !trackName.MatchString(t.Name) {
+			return fmt.Errorf("%w: name \"%s\" must be letters, numbers, dashes and underscores", ErrInvalidAudioTracks, t.Name)
+		}
+		if names[strings.ToLower(t.Name)] {
+			return fmt.Errorf("%w: name \"%s\" is repeated", ErrInvalidAudioTracks, t.Name)
+		}
+		names[strings.ToLower(t.Name)] = true
+		if t.Language != "" && !languageTag.MatchString(t.Language) {
+			return fmt.Errorf("%w: language \"%s\" isn't a language tag", ErrInvalidAudioTracks, t.Language)
+		}
+	}
+	return nil
+}
+
+// sameAudioTracks is when two channels' tracks are the same
+func sameAudioTracks(a, b []AudioTrack) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// audioTracks are the renditions an output carries, outputs which
+// can only carry one get the default
+func (ch *Channel) audioTracks(o Output) []AudioTrack {
+	tracks := ch.AudioTracks
+	if len(tracks) == 0 {
+		return defaultAudio
+	}
+	if strings.EqualFold(o.Type, "rtmp") {
+		// FLV only has room for one audio stream
+		return tracks[:1]
+	}
+	return tracks
+}
+
+// audioArgs map each track to an output audio stream, labelling them
+// for the manifests
+func audioArgs(tracks []AudioTrack) []string {
+	if len(tracks) == 1 && tracks[0] == defaultAudio[0] {
+		// The manifests always list an audio rendition, so sources
+		// without audio are failed by the ingest checks
+		return []string{"-map", "0:a:0"}
+	}
+	args := []string{}
+	for idx, t := range tracks {
+		stream := strconv.Itoa(idx)
+		args = append(args,
+			"-map", fmt.Sprintf("0:a:%d", t.Track),
+			"-metadata:s:a:"+stream, "title="+t.Name,
+		)
+		if t.Language != "" {
+			args = append(args, "-metadata:s:a:"+stream, "language="+t.Language)
+		}
+		disposition := "0"
+		if idx == 0 {
+			disposition = "default"
+		}
+		args = append(args, "-disposition:a:"+stream, disposition)
+	}
+	return args
+}
+
+// loadAudioTracks reads a channel's audio tracks in order
+func (mcr *MCR) loadAudioTracks(ctx context.Context, ch *Channel) error {
+	tracks := []AudioTrack{}
+	err := mcr.db.SelectContext(ctx, &tracks, `
+		SELECT track, language, name
+		FROM playout.channel_audio_tracks
+		WHERE channel_id = $1
+		ORDER BY position;`, ch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to select audio tracks: %w", err)
+	}
+	ch.AudioTracks = tracks
+	return nil
+}
+
+// replaceAudioTracks stores a channel's audio tracks in order, replacing
+// any it had
+func replaceAudioTracks(ctx context.Context, tx *sqlx.Tx, channelID int, tracks []AudioTrack) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM playout.channel_audio_tracks
+		WHERE channel_id = $1;`, channelID)
+	if err != nil {
+		return fmt.Errorf("failed to delete audio tracks: %w", err)
+	}
+	if len(tracks) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO playout.channel_audio_tracks(
+			channel_id, position, track, language, name)
+		VALUES ($1, $2, $3, $4, $5);`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare audio tracks: %w", err)
+	}
+	defer stmt.Close()
+	for idx, t := range tracks {
+		_, err = stmt.ExecContext(ctx, channelID, idx, t.Track, t.Language, t.Name)
+		if err != nil {
+			return fmt.Errorf("failed to insert audio track: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package channel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ystv/playout/scheduler"
+)
+
+// Topic is a kind of message published on the MCR's bus
+type Topic string
+
+// Bus topics
+const (
+	TopicChannelCreated Topic = "channel-created"
+	TopicChannelDeleted Topic = "channel-deleted"
+	TopicStateChanged   Topic = "state-changed"
+	TopicOutputFailed   Topic = "output-failed"
+	TopicPlayoutStarted Topic = "playout-started"
+)
+
+type (
+	// Message is something which happened to one of the MCR's channels
+	Message interface {
+		Topic() Topic
+		// ChannelName is the short name of the channel it happened to
+		ChannelName() string
+	}
+	// ChannelCreated is when a channel is added to the MCR
+	ChannelCreated struct {
+		Channel   string    `json:"channel"`
+		ChannelID int       `json:"channelID"`
+		At        time.Time `json:"at"`
+	}
+	// ChannelDeleted is when a channel is removed from the MCR
+	ChannelDeleted struct {
+		Channel   string    `json:"channel"`
+		ChannelID int       `json:"channelID"`
+		At        time.Time `json:"at"`
+	}
+	// StateChanged is when a channel moves through its lifecycle
+	StateChanged struct {
+		Transition
+	}
+	// OutputFailed is when one of a channel's outputs stops encoding
+	OutputFailed struct {
+		Channel  string    `json:"channel"`
+		OutputID int       `json:"outputID"`
+		Output   string    `json:"output"` // Output key
+		Reason   string    `json:"reason"`
+		At       time.Time `json:"at"`
+	}
+	// PlayoutStarted is when a channel's scheduler starts a playout
+	PlayoutStarted struct {
+		Channel     string    `json:"channel"`
+		PlayoutID   int       `json:"playoutID"`
+		ProgrammeID int       `json:"programmeID"`
+		At          time.Time `json:"at"`
+	}
+
+	// Bus passes messages to the subscribers of their topic
+	Bus struct {
+		lock        sync.RWMutex
+		subscribers map[int]subscription
+		next        int
+	}
+	subscription struct {
+		topics map[Topic]bool // Empty is every topic
+		fn     func(Message)
+	}
+)
+
+func (m ChannelCreated) Topic() Topic        { return TopicChannelCreated }
+func (m ChannelCreated) ChannelName() string { return m.Channel }
+func (m ChannelDeleted) Topic() Topic        { return TopicChannelDeleted }
+func (m ChannelDeleted) ChannelName() string { return m.Channel }
+func (m StateChanged) Topic() Topic          { return TopicStateChanged }
+func (m StateChanged) ChannelName() string   { return m.Channel }
+func (m OutputFailed) Topic() Topic          { return TopicOutputFailed }
+func (m OutputFailed) ChannelName() string   { return m.Channel }
+func (m PlayoutStarted) Topic() Topic        { return TopicPlayoutStarted }
+func (m PlayoutStarted) ChannelName() string { return m.Channel }
+
+// NewBus creates an empty bus
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[int]subscription)}
+}
+
+// Subscribe registers fn to be called with each message of the topics,
+// or every message if none are given. The returned function removes
+// the subscription.
+//
+// Subscribers are called synchronously and shouldn't block.
+func (b *Bus) Subscribe(fn func(Message), topics ...Topic) (unsubscribe func()) {
+	sub := subscription{topics: make(map[Topic]bool), fn: fn}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = sub
+	return func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish passes the message to its subscribers
+func (b *Bus) Publish(m Message) {
+	b.lock.RLock()
+	subscribers := make([]func(Message), 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		if len(sub.topics) == 0 || sub.topics[m.Topic()] {
+			subscribers = append(subscribers, sub.fn)
+		}
+	}
+	b.lock.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(m)
+	}
+}
+
+// Subscribe registers fn on the MCR's bus, see Bus.Subscribe
+func (mcr *MCR) Subscribe(fn func(Message), topics ...Topic) (unsubscribe func()) {
+	return mcr.bus.Subscribe(fn, topics...)
+}
+
+// notify publishes a message about the channel if it is attached
+// to a bus
+func (ch *Channel) notify(m Message) {
+	if ch.bus != nil {
+		ch.bus.Publish(m)
+	}
+}
+
+// notifyOutputFailed publishes that one of the channel's outputs failed
+func (ch *Channel) notifyOutputFailed(output, reason string) {
+	m := OutputFailed{
+		Channel: ch.ShortName,
+		Output:  output,
+		Reason:  reason,
+		At:      time.Now(),
+	}
+	for idx, o := range ch.outputs() {
+		if outputKey(idx, o) == output {
+			m.OutputID = o.ID
+			break
+		}
+	}
+	ch.notify(m)
+}
+
+// handlePlayoutFailed records the scheduler failing to start a playout
+func (ch *Channel) handlePlayoutFailed(e scheduler.Event) {
+	if e.Type != scheduler.EventPlayoutFailed {
+		return
+	}
+	if e.Retry.IsZero() {
+		ch.recordEvent(EventPlayoutFailed, "playout %d failed to start, giving up: %s", e.Playout.PlayoutID, e.Error)
+		return
+	}
+	ch.recordEvent(EventPlayoutFailed, "playout %d failed to start, retrying after %s: %s", e.Playout.PlayoutID,
+		e.Retry.Format(time.RFC3339), e.Error)
+}
+
+// handlePlayoutStarted publishes the scheduler starting a playout
+func (ch *Channel) handlePlayoutStarted(e scheduler.Event) {
+	if e.Type != scheduler.EventPlayoutStarted {
+		return
+	}
+	ch.notify(PlayoutStarted{
+		Channel:     ch.ShortName,
+		PlayoutID:   e.Playout.PlayoutID,
+		ProgrammeID: e.Playout.ProgrammeID,
+		At:          time.Now(),
+	})
+}
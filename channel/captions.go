@@ -0,0 +1,228 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/scheduler"
+)
+
+// captionRetention is how long cues are kept after they've ended, long
+// enough for every output to have written the segments showing them
+const captionRetention = time.Minute
+
+var (
+	// ErrInvalidCaptions is when a channel's captions or an output
+	// publishing them don't make sense
+	ErrInvalidCaptions = errors.New("invalid captions")
+
+	// languageTag roughly matches a BCP 47 language tag, i.e. "en" or "en-GB"
+	languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+)
+
+// CaptionOptions are the captions a channel's outputs carry
+type CaptionOptions struct {
+	SubtitleLanguages string `db:"subtitle_languages" json:"subtitleLanguages"` // Comma separated, each a WebVTT rendition filled by programmes' sidecars
+	ClosedCaptions    string `db:"closed_captions" json:"closedCaptions"`       // Language of CEA-608/708 captions embedded in the ingest, empty for none
+}
+
+// languages are the subtitle renditions published
+func (c CaptionOptions) languages() []string {
+	languages := []string{}
+	for _, language := range strings.Split(c.SubtitleLanguages, ",") {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if language != "" {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+// validate checks the languages are language tags
+func (c CaptionOptions) validate() error {
+	seen := make(map[string]bool)
+	for _, language := range c.languages() {
+		if !languageTag.MatchString(language) {
+			return fmt.Errorf("%w: subtitle language \"%s\" isn't a language tag", ErrInvalidCaptions, language)
+		}
+		if seen[language] {
+			return fmt.Errorf("%w: subtitle language \"%s\" is repeated", ErrInvalidCaptions, language)
+		}
+		seen[language] = true
+	}
+	if c.ClosedCaptions != "" && !languageTag.MatchString(c.ClosedCaptions) {
+		return fmt.Errorf("%w: closed caption language \"%s\" isn't a language tag", ErrInvalidCaptions, c.ClosedCaptions)
+	}
+	return nil
+}
+
+// validateOutput checks an output can publish captions
+func (c CaptionOptions) validateOutput(o Output) error {
+	if !o.Captions {
+		return nil
+	}
+	switch strings.ToLower(o.Type) {
+	case "hls", "dash", "cmaf":
+	default:
+		return fmt.Errorf("%w: only hls, dash and cmaf outputs publish them", ErrInvalidCaptions)
+	}
+	if o.Passthrough {
+		return fmt.Errorf("%w: passthrough outputs keep embedded captions as they are", ErrInvalidCaptions)
+	}
+	return c.validate()
+}
+
+// publishesSubtitles is when the channel writes an output's master
+// playlist and subtitle renditions, rather than ffmpeg
+func (o Output) publishesSubtitles() bool {
+	return o.Captions && !o.Passthrough && strings.EqualFold(o.Type, "hls")
+}
+
+// subtitlePlaylist is where an output's subtitle rendition is written
+func subtitlePlaylist(o Output, language string) string {
+	ext := path.Ext(o.Destination)
+	return strings.TrimSuffix(o.Destination, ext) + "_subs_" + language + ext
+}
+
+// captionPublisher writes an output's subtitle renditions
+type captionPublisher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// timeline is what the channel is captioning
+func (ch *Channel) timeline() *captions.Timeline {
+	ch.captionLock.Lock()
+	defer ch.captionLock.Unlock()
+	if ch.captionTimeline == nil {
+		ch.captionTimeline = captions.NewTimeline()
+	}
+	return ch.captionTimeline
+}
+
+// startCaptions publishes an output's master playlist and subtitle
+// renditions in the background, timed from now since the output has
+// just started
+func (ch *Channel) startCaptions(key string, o Output) {
+	ch.stopCaptions(key)
+	if !o.publishesSubtitles() {
+		return
+	}
+	master, err := ch.masterPlaylist(o)
+	if err != nil {
+		log.Printf("channel \"%s\": failed to build %s's master playlist: %+v", ch.ShortName, key, err)
+		return
+	}
+	epoch := time.Now()
+	renditions := make(map[string]*captions.Rendition)
+	for _, language := range ch.CaptionOptions.languages() {
+		renditions[language] = &captions.Rendition{
+			Playlist: subtitlePlaylist(o, language),
+			Segment:  time.Duration(segmentLength(o)) * time.Second,
+			Window:   windowSize(o),
+			Epoch:    epoch,
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ch.captionLock.Lock()
+	if ch.captionPublishers == nil {
+		ch.captionPublishers = make(map[string]*captionPublisher)
+	}
+	ch.captionPublishers[key] = &captionPublisher{cancel: cancel, done: done}
+	ch.captionLock.Unlock()
+	go func() {
+		defer close(done)
+		ch.publishCaptions(ctx, key, o, master, renditions)
+	}()
+}
+
+// stopCaptions stops publishing an output's subtitle renditions and
+// waits for it to finish
+func (ch *Channel) stopCaptions(key string) {
+	ch.captionLock.Lock()
+	p := ch.captionPublishers[key]
+	delete(ch.captionPublishers, key)
+	ch.captionLock.Unlock()
+	if p == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// stopAllCaptions stops publishing every output's subtitle renditions
+func (ch *Channel) stopAllCaptions() {
+	ch.captionLock.Lock()
+	keys := make([]string, 0, len(ch.captionPublishers))
+	for key := range ch.captionPublishers {
+		keys = append(keys, key)
+	}
+	ch.captionLock.Unlock()
+	for _, key := range keys {
+		ch.stopCaptions(key)
+	}
+}
+
+// publishCaptions writes the master playlist, then each rendition's
+// segments as they pass
+func (ch *Channel) publishCaptions(ctx context.Context, key string, o Output, master []byte, renditions map[string]*captions.Rendition) {
+	err := captions.Put(ctx, o.Destination, master)
+	if err != nil {
+		log.Printf("channel \"%s\": failed to write %s's master playlist: %+v", ch.ShortName, key, err)
+	}
+	ticker := time.NewTicker(time.Duration(segmentLength(o)) * time.Second)
+	defer ticker.Stop()
+	timeline := ch.timeline()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, r := range renditions {
+				// Not the stopping context, it's already cancelled
+				r.Close(context.Background())
+			}
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for language, r := range renditions {
+			for from, to := r.Next(); !to.After(now); from, to = r.Next() {
+				err = r.Write(ctx, timeline.Between(language, from, to))
+				if err != nil {
+					log.Printf("channel \"%s\": failed to write %s's %s subtitles: %+v", ch.ShortName, key, language, err)
+					break
+				}
+			}
+		}
+		timeline.Prune(now.Add(-captionRetention))
+	}
+}
+
+// handleCaptions places the sidecar captions of programmes going on
+// air in the languages the channel publishes, cutting them short if
+// a programme comes off air early
+func (ch *Channel) handleCaptions(e scheduler.Event) {
+	switch e.Type {
+	case scheduler.EventPlayoutStarted:
+		ch.confLock.RLock()
+		languages := ch.CaptionOptions.languages()
+		ch.confLock.RUnlock()
+		for _, language := range languages {
+			for _, track := range e.Captions {
+				if captions.Matches(track.Language, language) {
+					ch.timeline().Add(language, e.Playout.PlayoutID, e.Playout.BroadcastStart, track.Cues)
+					break
+				}
+			}
+		}
+	case scheduler.EventPlayoutEnded:
+		ch.timeline().Cut(e.Playout.PlayoutID, e.Playout.BroadcastEnd)
+	}
+}
@@ -0,0 +1,100 @@
+package channel
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/scheduler"
+)
+
+// testTracks are a programme's sidecars, one in a language the test
+// channel doesn't publish
+var testTracks = []captions.Track{
+	{Language: "fr", Cues: []captions.Cue{{Start: 0, End: 5 * time.Second, Text: "Bonjour"}}},
+	{Language: "en-GB", Cues: []captions.Cue{
+		{Start: 0, End: 5 * time.Second, Text: "Hello"},
+		{Start: 10 * time.Second, End: 15 * time.Second, Text: "Goodbye"},
+	}},
+}
+
+// eventually polls until cond is true or fails the test
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCaptionsPlacedAndCut(t *testing.T) {
+	ch := testChannel()
+	ch.SubtitleLanguages = "en"
+	start := time.Now()
+	po := playout.Playout{PlayoutID: 10, BroadcastStart: start}
+
+	ch.handleCaptions(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po, Captions: testTracks})
+	cues := ch.timeline().Between("en", start, start.Add(time.Minute))
+	if len(cues) != 2 || cues[0].Text != "Hello" || !cues[0].Start.Equal(start) || !cues[1].Start.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("timeline has %+v, want the english cues from the broadcast start", cues)
+	}
+	if cues := ch.timeline().Between("fr", start, start.Add(time.Minute)); len(cues) != 0 {
+		t.Errorf("timeline has %+v in a language the channel doesn't publish", cues)
+	}
+
+	// Off air early, part way through the first cue
+	po.BroadcastEnd = start.Add(2 * time.Second)
+	ch.handleCaptions(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: po})
+	cues = ch.timeline().Between("en", start, start.Add(time.Minute))
+	if len(cues) != 1 || !cues[0].End.Equal(po.BroadcastEnd) {
+		t.Errorf("timeline has %+v, want the first cue cut short at the broadcast end", cues)
+	}
+}
+
+func TestCaptionsPublished(t *testing.T) {
+	ch := testChannel()
+	ch.SubtitleLanguages = "en"
+	o := Output{
+		ID:              1,
+		Type:            "hls",
+		Destination:     filepath.Join(t.TempDir(), "index.m3u8"),
+		SegmentDuration: 1,
+		Captions:        true,
+		Renditions:      single,
+	}
+	ch.startCaptions("output-1", o)
+	defer ch.stopAllCaptions()
+	ch.handleCaptions(scheduler.Event{
+		Type:     scheduler.EventPlayoutStarted,
+		Playout:  playout.Playout{PlayoutID: 10, BroadcastStart: time.Now()},
+		Captions: testTracks,
+	})
+
+	playlist := subtitlePlaylist(o, "en")
+	segment := filepath.Join(filepath.Dir(playlist), "index_subs_en_00000.vtt")
+	eventually(t, "the first subtitle segment", func() bool {
+		b, err := ioutil.ReadFile(segment)
+		return err == nil && strings.Contains(string(b), "Hello")
+	})
+	b, err := ioutil.ReadFile(playlist)
+	if err != nil {
+		t.Fatalf("failed to read subtitle playlist: %+v", err)
+	}
+	if !strings.Contains(string(b), "index_subs_en_00000.vtt") {
+		t.Errorf("subtitle playlist doesn't list the segment:\n%s", b)
+	}
+	b, err = ioutil.ReadFile(o.Destination)
+	if err != nil {
+		t.Fatalf("failed to read master playlist: %+v", err)
+	}
+	if !strings.Contains(string(b), "TYPE=SUBTITLES") || !strings.Contains(string(b), "index_subs_en.m3u8") {
+		t.Errorf("master playlist doesn't have the subtitles:\n%s", b)
+	}
+}
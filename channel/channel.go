@@ -3,29 +3,40 @@
 package channel
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"image"
 	"log"
-	"regexp"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/markers"
 	"github.com/ystv/playout/piper"
 	"github.com/ystv/playout/scheduler"
 )
 
 type (
 	// Channel represents a video feed
+	//
+	// Its config is changed by the MCR, anything else reading it while
+	// the channel could be changing should use Info.
 	Channel struct {
 		// Core
-		ID          int      `db:"channel_id"`
-		ShortName   string   `db:"short_name"` // URL name
-		ChannelType string   `db:"type"`       // event / linear
-		IngestURL   string   `db:"ingest_url"`
-		IngestType  string   `db:"ingest_type"` // RTP / RTMP / HLS
-		SlateURL    string   `db:"slate_url"`   // Fallback video
-		Outputs     []Output // Configured outputs
-		Status      string   // The state of channel ready / running / starting / stopping / pending
+		ID             int            `db:"channel_id"`
+		ShortName      string         `db:"short_name"` // URL name
+		ChannelType    string         `db:"type"`       // event / linear
+		IngestURL      string         `db:"ingest_url"`
+		IngestType     string         `db:"ingest_type"` // RTP / RTMP / HLS / SRT
+		SlateURL       string         `db:"slate_url"`   // Fallback video
+		BackupIngests  []IngestSource // Failed over to in order when the ingest drops, before the slate
+		FailbackDelay  int            `db:"failback_delay"` // Seconds a preferred source has to be stable before going back to it, 0 uses the MCR's
+		Outputs        []Output       // Configured outputs
+		AudioTracks    []AudioTrack   // Audio renditions, the ingest's first stream if empty
+		SRTOptions                    // Ingest connection, SRT only
+		LoudnessPolicy                // Audio normalisation
+		LogoOptions                   // Station logo
+		CaptionOptions                // Subtitles and closed captions
 
 		// Options
 		Visibilty string `db:"visibility"`
@@ -39,29 +50,146 @@ type (
 		CreatedAt   time.Time `db:"created_at"`
 
 		// Modules
-		hasScheduler bool `db:"has_scheduler"`
+		HasScheduler bool `db:"has_scheduler"`
 		sch          *scheduler.Scheduler
-		hasPiper     bool `db:"has_piper"`
+		HasPiper     bool `db:"has_piper"`
 		piper        *piper.Piper
 
+		confLock sync.RWMutex // Guards the fields above while they change
+
+		// State
+		stateLock      sync.RWMutex
+		status         Status
+		subscribers    map[int]func(Transition)
+		nextSubscriber int
+
+		// Input
+		inputLock     sync.Mutex
+		current       Input  // Feeding the outputs
+		pin           *Input // Held by an operator, nil fails over automatically
+		recording     bool   // Ingest is being recorded to the archive
+		monitorCancel context.CancelFunc
+		monitorDone   chan struct{}
+
+		// Logo
+		logoLock   sync.Mutex
+		logoImage  image.Image // Decoded from LogoURL, nil until loaded
+		logoHidden bool        // Outputs are given a blank logo
+
+		// Captions
+		captionLock       sync.Mutex
+		captionTimeline   *captions.Timeline           // Cues of programmes which have gone on air
+		captionPublishers map[string]*captionPublisher // Writing subtitle renditions, by output
+
+		// Markers
+		markerLock       sync.Mutex
+		markerCues       *markers.Schedule           // Programmes and breaks which have gone on air
+		markerPublishers map[string]*markerPublisher // Marking manifests, by output
+
+		// Restreams
+		restreamLock sync.Mutex
+		restreaming  map[string]bool // Restreams which have been started, by output
+		onAir        int             // Playout on air, scheduled restreams only run during one
+
+		// Health
+		healthLock   sync.Mutex
+		healthCancel context.CancelFunc
+		healthDone   chan struct{}
+
+		// Preview
+		previewLock   sync.Mutex
+		previewAt     time.Time // When the latest frame was grabbed
+		previewCancel context.CancelFunc
+		previewDone   chan struct{}
+
+		// Events
+		eventLock  sync.Mutex
+		events     []Event
+		eventStore func(ctx context.Context, e Event) error
+		bus        *Bus
+
 		// Dependencies
-		conf *Config
+		conf    *Config
+		tc      Transcoder
+		checker IngestChecker
+		grabber FrameGrabber
+		archive *archiver
 	}
 
 	// NewChannelStruct represnets the required channel config
 	NewChannelStruct struct {
-		ShortName    string
-		Name         string
-		Description  string
-		ChannelType  string // event / linear
-		IngestType   string // RTSP / RTMP / HLS
-		SlateURL     string // fallback video
-		Visible      string // public / internal / private. TOOD: Will it stay?
-		Archive      bool   // Add to VOD after
-		DVR          bool   // Allow rewind on outputs, is a default value
-		HasScheduler bool
-		HasPiper     bool
-		Outputs      []Output
+		ShortName     string
+		Name          string
+		Description   string
+		ChannelType   string // event / linear
+		IngestURL     string // Can be left to be set later
+		IngestType    string // RTSP / RTMP / HLS / SRT
+		IngestSRT     SRTOptions
+		Loudness      LoudnessPolicy
+		Logo          LogoOptions
+		Captions      CaptionOptions
+		AudioTracks   []AudioTrack
+		SlateURL      string         // fallback video
+		BackupIngests []IngestSource // failed over to in order
+		FailbackDelay int            // seconds, 0 uses the MCR's
+		Visible       string         // public / internal / private. TOOD: Will it stay?
+		Archive       bool           // Add to VOD after
+		DVR           bool           // Allow rewind on outputs, is a default value
+		HasScheduler  bool
+		HasPiper      bool
+		Outputs       []Output
+	}
+
+	// UpdateChannelStruct represents the changeable channel config
+	UpdateChannelStruct struct {
+		Name          string
+		Description   string
+		IngestURL     string
+		IngestType    string // RTP / RTMP / HLS / SRT
+		IngestSRT     SRTOptions
+		Loudness      LoudnessPolicy
+		Logo          LogoOptions
+		Captions      CaptionOptions
+		AudioTracks   []AudioTrack
+		SlateURL      string         // fallback video
+		BackupIngests []IngestSource // failed over to in order
+		FailbackDelay int            // seconds, 0 uses the MCR's
+		Visible       string         // public / internal / private
+		Archive       bool           // Add to VOD after
+		DVR           bool           // Allow rewind on outputs, is a default value
+		HasScheduler  bool
+		HasPiper      bool
+	}
+
+	// Info is a copy of a channel's config and status
+	Info struct {
+		ID            int
+		ShortName     string
+		ChannelType   string
+		IngestURL     string
+		IngestType    string
+		SlateURL      string
+		BackupIngests []IngestSource
+		FailbackDelay int
+		Input         Input // What is feeding the outputs
+		Pinned        bool  // Input is held by an operator
+		Outputs       []Output
+		SRTOptions    SRTOptions
+		Loudness      LoudnessPolicy
+		Logo          LogoOptions
+		Captions      CaptionOptions
+		AudioTracks   []AudioTrack
+		Visibility    string
+		Archive       bool
+		DVR           bool
+		Name          string
+		Description   string
+		Thumbnail     string
+		CreatedAt     time.Time
+		HasScheduler  bool
+		HasPiper      bool
+		Status        Status
+		Preview       Preview
 	}
 
 	// Outputs
@@ -70,12 +198,26 @@ type (
 	//
 	// A channel can have multiple outputs
 	Output struct {
-		Name        string `db:"name"`        // Optional decorative name to help identify streams
-		Type        string `db:"type"`        // RTP / RTMP / HLS / DASH / CMAF
-		Passthrough bool   `db:"passthrough"` // To transcode or not
-		DVR         bool   `db:"dvr"`         // Can rewind
-		Destination string `db:"destination"` // URL endpoint
-		Renditions  []Rendition
+		ID              int    `db:"output_id"`
+		Name            string `db:"name"`             // Optional decorative name to help identify streams
+		Type            string `db:"type"`             // RTP / RTMP / SRT / HLS / DASH / CMAF / Restream
+		Passthrough     bool   `db:"passthrough"`      // To transcode or not
+		DVR             bool   `db:"dvr"`              // Can rewind
+		DVRWindow       int    `db:"dvr_window"`       // Seconds able to rewind, 0 keeps everything
+		SegmentDuration int    `db:"segment_duration"` // Seconds, segmented outputs only
+		Destination     string `db:"destination"`      // URL endpoint or local path
+		Profile         string `db:"profile"`          // Encoding profile, instead of renditions
+		ProfileVersion  int    `db:"profile_version"`  // Pinned profile version, 0 follows the latest
+		Logo            bool   `db:"logo"`             // Overlay the channel's logo
+		Captions        bool   `db:"captions"`         // Keep embedded captions and publish subtitles
+		Markers         bool   `db:"markers"`          // Signal programme changes and breaks in the manifests
+		StreamKey       string `db:"stream_key"`       // Appended to the destination, restream only
+		Disabled        bool   `db:"disabled"`         // Kept but not sent, restream only
+		Scheduled       bool   `db:"scheduled"`        // Only sent while a playout is on air, restream only
+		Renditions      []Rendition
+		SRTOptions      // Destination connection, SRT only
+
+		profile *Profile // Resolved encoding profile
 
 		Status string // Health of stream
 
@@ -84,14 +226,14 @@ type (
 
 	// Rendition represents a generic video stream information.
 	//
-	// This is generated, not stored, provides a read-only view
-	// to affect the video stream you will need to change the output.
+	// Stored as part of its output, to affect the video stream
+	// you will need to change the output.
 	Rendition struct {
-		Width   int
-		Height  int
-		Bitrate int // Kb/s
-		FPS     int
-		Codec   string // h264 / h265
+		Width   int    `db:"width"`
+		Height  int    `db:"height"`
+		Bitrate int    `db:"bitrate"` // Kb/s
+		FPS     int    `db:"fps"`
+		Codec   string `db:"codec"` // h264 / h265
 	}
 
 	// This implementation is just as a fancy frontend/abstraction from
@@ -103,133 +245,186 @@ type (
 
 // Start the channel
 //
-// Will create new tasks for VT, starting a new endpoint on the id/main.m3u8
+// Compiles an ffmpeg command for each of the channel's outputs
+// and hands them to the channel's transcoder
 func (ch *Channel) Start() error {
-	for _, output := range ch.Outputs {
-		if output.Passthrough {
-			// just copy
-
-			t := Task{
-				SrcURL:  ch.IngestURL,
-				DstURL:  output.Destination,
-				DstArgs: "",
-			}
-
-			switch output.Type {
-			case "rtmp":
-				t.DstArgs = `-c copy -f flv`
-			case "hls":
-				isDVR := ""
-				if output.DVR {
-					isDVR = "-hls_playlist_type event"
-				}
-				t.DstArgs = fmt.Sprintf(`-c copy -f hls -hls_time %d %s -hls_segment_type fmp4 -method PUT`, 4, isDVR)
-			default:
-				return errors.New("unknown output type")
-			}
-
-			log.Printf("%+v", t)
-			// err := ch.NewStream(context.Background(), t)
-			// if err != nil {
-			// 	return err
-			// }
-			return nil
-		}
-		inputArgs := ""
-		switch ch.IngestType {
-		case "rtmp":
-			inputArgs = fmt.Sprintf(`-re -stream_loop 1 -f flv -i "%s" `, ch.IngestURL)
-
-		default:
-			inputArgs = fmt.Sprintf(`-re -stream_loop 1 -f %s -i "%s" `, ch.IngestType, ch.IngestURL)
-		}
-		mapString := ""
-		if len(output.Renditions) == 0 {
-			mapString = "-map 0"
-		} else {
-			mapString = strings.Repeat("-map 0 ", len(output.Renditions)-1)
+	if ch.tc == nil {
+		return ErrNoTranscoder
+	}
+	err := ch.transition(StateStarting, "start requested")
+	if err != nil {
+		return fmt.Errorf("failed to start channel: %w", err)
+	}
+	ch.inputLock.Lock()
+	if ch.pin != nil {
+		ch.current = *ch.pin
+	}
+	ch.inputLock.Unlock()
+	cmds, err := ch.Compile()
+	if err != nil {
+		err = fmt.Errorf("failed to compile channel: %w", err)
+		ch.transition(StateFailed, err.Error())
+		return err
+	}
+	ctx := context.Background()
+	err = ch.ensureLogo(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to load logo: %w", err)
+		ch.transition(StateFailed, err.Error())
+		return err
+	}
+	outputs := ch.outputs()
+	started := 0
+	for idx, cmd := range cmds {
+		if !ch.runs(outputs[idx]) {
+			continue
 		}
-		inputArgs = fmt.Sprintf("%s %s", inputArgs, mapString)
-
-		// Audio mux
-		audioString := "-c:a aac -ar 48000"
-
-		// Video mux
-		encode := ""
-		for idx, rendition := range output.Renditions {
-			videoCodec := ""
-			switch rendition.Codec {
-			case "h264":
-				videoCodec = "libx264"
-			case "h265":
-				videoCodec = "libx265"
-				// TODO: nvenc codec's
-			default:
-				return errors.New("unknown codec")
-			}
-			encode = fmt.Sprintf(`
-					-vf "scale=w=%d:h=%d:force_original_aspect_ratio=decrease"
-					-b:v:%d %dk
-					-c:v:%d %s
-					-s:v:%d %dx%d 
-					-profile:v:%d main
-					-pix_fmt yuv420p`,
-				rendition.Width, rendition.Height, // scale resolution
-				idx, rendition.Bitrate, // bitrate
-				idx, videoCodec, // codec
-				idx, rendition.Width, rendition.Height, // resolution
-				idx, // profile
-			)
+		log.Printf("%s: %s", cmd.Output, cmd)
+		err = ch.tc.Start(ctx, cmd)
+		if err != nil {
+			ch.notifyOutputFailed(cmd.Output, err.Error())
+			ch.tc.StopAll(ctx)
+			ch.clearRestreaming()
+			ch.stopAllCaptions()
+			ch.stopAllMarkers()
+			err = fmt.Errorf("failed to start output \"%s\": %w", cmd.Output, err)
+			ch.transition(StateFailed, err.Error())
+			return err
 		}
-		outputString := ""
-		switch output.Type {
-		case "rtp":
-			log.Println("rtp out")
-			outputString = fmt.Sprintf(`-f rtp "%s"`, output.Destination)
-
-		case "rtmp":
-			log.Println("rtmp out")
-			outputString = fmt.Sprintf(`-f flv "%s"`, output.Destination)
-		case "hls":
-			log.Println("hls out")
-			// Output
-			outputString = fmt.Sprintf(`
-				-keyint_min 120 -g 120 -sc_threshold 0 -use_timeline 1
-				-use_template 1 -window_size 5
-				-adaptation_sets "id=0,streams=v id=1,streams=a"
-				-hls_playlist 1 -seg_duration 4 -streaming 1
-				-remove_at_exit 1 -method PUT -f hls "%s"`, output.Destination)
-
-		case "dash":
-			return errors.New("dash not implemented")
-
-		case "cmaf":
-			return errors.New("cmaf not implemented")
-
-		default:
-			return errors.New("unknown output type")
+		if outputs[idx].isRestream() {
+			ch.setRestreaming(cmd.Output, true)
 		}
-		// Combine to an executable string
-		removeInsideWhitespace := regexp.MustCompile(`[\s\p{Zs}]{2,}`)
-		cmd := strings.ReplaceAll(fmt.Sprintf("%s %s %s %s", inputArgs, audioString, encode, outputString), "\n", "")
-		cmd = removeInsideWhitespace.ReplaceAllString(cmd, " ")
-		log.Println(cmd)
-	}
-	ch.Status = "playing"
-	return nil
+		ch.startCaptions(cmd.Output, outputs[idx])
+		ch.startMarkers(cmd.Output, outputs[idx])
+		started++
+	}
+	err = ch.startRecording(ctx)
+	if err != nil {
+		// The outputs are still useful without an archive
+		log.Printf("channel \"%s\": failed to start recording: %+v", ch.ShortName, err)
+	}
+	ch.startIngestMonitor()
+	ch.startPreviews()
+	err = ch.transition(StateRunning, fmt.Sprintf("started %d outputs", started))
+	ch.startHealthChecks()
+	return err
 }
 
 // Stop the channel
 //
-// Will cancel VT jobs, triggering archiving if enabled
+// Will stop the channel's encoders, triggering archiving if enabled
 func (ch *Channel) Stop() error {
-	ch.Status = "stopping"
-	return nil
+	status, _ := ch.Stat()
+	switch status.State {
+	case StateStopped:
+		return nil
+	case StatePending:
+		return ch.transition(StateStopped, "stop requested")
+	}
+	err := ch.transition(StateStopping, "stop requested")
+	if err != nil {
+		return fmt.Errorf("failed to stop channel: %w", err)
+	}
+	ch.stopHealthChecks()
+	ch.stopIngestMonitor()
+	ch.stopPreviews()
+	ch.stopAllCaptions()
+	ch.inputLock.Lock()
+	ch.current = Input{}
+	ch.recording = false
+	ch.inputLock.Unlock()
+	if ch.tc != nil {
+		err = ch.tc.StopAll(context.Background())
+		ch.clearRestreaming()
+		// After the transcoder, so its last manifests are marked
+		ch.stopAllMarkers()
+		if err != nil {
+			err = fmt.Errorf("failed to stop transcoder: %w", err)
+			ch.transition(StateFailed, err.Error())
+			return err
+		}
+	}
+	return ch.transition(StateStopped, "stopped")
 }
 
-// Stat returns the current status of the channel
-//
-// Used by http api to allow VT to check if the stream still needs to be up
-func (ch *Channel) Stat() (string, error) {
-	return ch.Status, nil
+// Info copies the channel's config and status, safe to use while the
+// channel is being changed
+func (ch *Channel) Info() Info {
+	status, _ := ch.Stat()
+	input, pinned := ch.Input()
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	return Info{
+		ID:            ch.ID,
+		ShortName:     ch.ShortName,
+		ChannelType:   ch.ChannelType,
+		IngestURL:     ch.IngestURL,
+		IngestType:    ch.IngestType,
+		SlateURL:      ch.SlateURL,
+		BackupIngests: redactIngests(ch.BackupIngests),
+		FailbackDelay: ch.FailbackDelay,
+		Input:         input,
+		Pinned:        pinned,
+		Outputs:       redactOutputs(ch.Outputs, ch.ingestSecrets()),
+		SRTOptions:    ch.SRTOptions.Redacted(),
+		Loudness:      ch.LoudnessPolicy,
+		Logo:          ch.LogoOptions,
+		Captions:      ch.CaptionOptions,
+		AudioTracks:   append([]AudioTrack{}, ch.AudioTracks...),
+		Visibility:    ch.Visibilty,
+		Archive:       ch.Archive,
+		DVR:           ch.DVR,
+		Name:          ch.Name,
+		Description:   ch.Description,
+		Thumbnail:     ch.Thumbnail,
+		CreatedAt:     ch.CreatedAt,
+		HasScheduler:  ch.HasScheduler,
+		HasPiper:      ch.HasPiper,
+		Status:        status,
+		Preview:       ch.Preview(),
+	}
+}
+
+// outputs copies the channel's outputs, safe against them changing
+func (ch *Channel) outputs() []Output {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	return append([]Output{}, ch.Outputs...)
+}
+
+// settings copies the channel's changeable config
+func (ch *Channel) settings() UpdateChannelStruct {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	return UpdateChannelStruct{
+		Name:          ch.Name,
+		Description:   ch.Description,
+		IngestURL:     ch.IngestURL,
+		IngestType:    ch.IngestType,
+		IngestSRT:     ch.SRTOptions,
+		Loudness:      ch.LoudnessPolicy,
+		Logo:          ch.LogoOptions,
+		Captions:      ch.CaptionOptions,
+		AudioTracks:   append([]AudioTrack{}, ch.AudioTracks...),
+		SlateURL:      ch.SlateURL,
+		BackupIngests: append([]IngestSource{}, ch.BackupIngests...),
+		FailbackDelay: ch.FailbackDelay,
+		Visible:       ch.Visibilty,
+		Archive:       ch.Archive,
+		DVR:           ch.DVR,
+		HasScheduler:  ch.HasScheduler,
+		HasPiper:      ch.HasPiper,
+	}
+}
+
+// pauseMonitor stops the ingest monitor so the channel can be changed
+// without it switching inputs, the returned function resumes it if the
+// channel is live
+func (ch *Channel) pauseMonitor() (resume func()) {
+	ch.stopIngestMonitor()
+	return func() {
+		if ch.isLive() {
+			ch.startIngestMonitor()
+		}
+	}
 }
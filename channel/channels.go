@@ -6,23 +6,72 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/ystv/playout/piper"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/probe"
+	"github.com/ystv/playout/programming"
 	"github.com/ystv/playout/scheduler"
+	"github.com/ystv/playout/utils"
+	"github.com/ystv/playout/vt"
+)
+
+var (
+	// ErrChannelNotFound is when a channel doesn't exist
+	ErrChannelNotFound = errors.New("channel doesn't exist")
+	// ErrChannelExists is when a channel's short name is already taken
+	ErrChannelExists = errors.New("channel already exists")
 )
 
 type (
-	// MCR manages a group of channels
+	// MCR manages a group of channels, it is safe for concurrent use
 	MCR struct {
-		db       *sqlx.DB
-		conf     *Config
-		channels map[string]*Channel
+		db     *sqlx.DB
+		conf   *Config
+		vt     *vt.Tracker
+		bus    *Bus
+		prober *probe.Prober // Shared by the schedulers so sources are probed once
+
+		lock       sync.RWMutex // Guards channels
+		changeLock sync.Mutex   // Serialises changes to channels
+		channels   map[string]*Channel
 	}
 	// Config to specify available endpoints
 	Config struct {
 		VTEndpoint string
 		Endpoints  []Endpoint
+		Transcoder string // local / vt
+		FFmpegPath string // Binary used by the local transcoder
+
+		Piper          piper.Config      // Mixer used by channels with a piper
+		PiperEndpoints map[string]string // Mixer endpoint by channel short name, overriding Piper's
+
+		FFprobePath         string        // Binary used to check ingests
+		IngestCheckInterval time.Duration // How often ingests are checked
+		SlateRecovery       time.Duration // How long an ingest has to be stable before leaving slate
+
+		HealthThresholds HealthThresholds // Limits outputs are classified by
+
+		ArchiveDir       string        // Where ingests are recorded
+		ArchiveRetention time.Duration // How long recordings are kept, 0 keeps them
+		VODDir           string        // Where playouts cut from recordings are stored
+		VODURL           string        // Where VODDir is served
+
+		PreviewDir        string        // Where the latest frame of each channel is stored
+		PreviewInterval   time.Duration // How often a frame is grabbed
+		PreviewStaleAfter time.Duration // How old the latest frame can be before it is stale
+
+		LogoDir string // Where the logo each channel's outputs overlay is written
+
+		StreamKeySecret []byte // AES-256 key restream outputs' stream keys are encrypted with at rest
+
+		ProbeCacheTTL       time.Duration // How long a source's probe is reused
+		SourceCheckHorizon  time.Duration // How far ahead playouts' sources are checked
+		SourceCheckInterval time.Duration // How often playouts' sources are checked
 	}
 	// Endpoint a usable output by playout
 	Endpoint struct {
@@ -33,22 +82,16 @@ type (
 
 // NewMCR creates a new "Master Control Room"
 // effictively manages a group of channels
-func NewMCR(db *sqlx.DB) (*MCR, error) {
+func NewMCR(db *sqlx.DB, conf *Config) (*MCR, error) {
+	if conf == nil {
+		return nil, errors.New("mcr requires a config")
+	}
 	mcr := &MCR{
-		db: db,
-		conf: &Config{
-			VTEndpoint: "http://localhost:7071",
-			Endpoints: []Endpoint{
-				{
-					Type: "rtmp",
-					URL:  "rtmp://stream.ystv.co.uk/internal/",
-				},
-				{
-					Type: "hls",
-					URL:  "https://video-cdn.ystv.co.uk/",
-				},
-			},
-		},
+		db:       db,
+		conf:     conf,
+		vt:       vt.NewTracker(vt.New(conf.VTEndpoint), db),
+		bus:      NewBus(),
+		prober:   probe.New(conf.FFprobePath, conf.ProbeCacheTTL),
 		channels: make(map[string]*Channel),
 	}
 	err := mcr.Reload(context.Background())
@@ -58,155 +101,726 @@ func NewMCR(db *sqlx.DB) (*MCR, error) {
 	return mcr, nil
 }
 
+// Reload loads channels from the DB which aren't already in memory
 func (mcr *MCR) Reload(ctx context.Context) error {
-	chs := []Channel{}
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	chs := []*Channel{}
 	err := mcr.db.SelectContext(ctx, &chs,
-		`SELECT short_name, name, description, type, ingest_url, ingest_type,
-		slate_url, visibility, archive, dvr
+		`SELECT channel_id, short_name, name, description, type, ingest_url,
+		ingest_type, srt_mode, srt_passphrase, srt_latency, loudness_mode,
+		loudness_target, loudness_true_peak, logo_url, logo_position,
+		logo_scale, logo_opacity, logo_margin, subtitle_languages,
+		closed_captions, slate_url, failback_delay, visibility, archive, dvr,
+		has_scheduler, has_piper
 		FROM playout.channel;`)
 	if err != nil {
 		return fmt.Errorf("failed to get channels from db: %w", err)
 	}
+	loaded := 0
 	for _, ch := range chs {
-		ch.Status = "running"
+		if mcr.exists(ch.ShortName) {
+			continue
+		}
+		ch.SRTPassphrase, err = mcr.openStreamKey(ch.SRTPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt srt passphrase of \"%s\": %w", ch.ShortName, err)
+		}
+		err = mcr.loadOutputs(ctx, ch)
+		if err != nil {
+			return fmt.Errorf("failed to load outputs of \"%s\": %w", ch.ShortName, err)
+		}
+		err = mcr.loadAudioTracks(ctx, ch)
+		if err != nil {
+			return fmt.Errorf("failed to load audio tracks of \"%s\": %w", ch.ShortName, err)
+		}
+		err = mcr.loadIngestSources(ctx, ch)
+		if err != nil {
+			return fmt.Errorf("failed to load ingest sources of \"%s\": %w", ch.ShortName, err)
+		}
 		err = mcr.newChannel(ctx, ch, false)
 		if err != nil {
-			return fmt.Errorf("failed to add channel: %w", err)
+			// Don't let one channel's modules stop the others loading
+			log.Printf("failed to add channel \"%s\": %+v", ch.ShortName, err)
+			continue
 		}
+		loaded++
 	}
-	log.Printf("loaded %d channels", len(chs))
+	log.Printf("loaded %d channels", loaded)
 	return nil
 }
 
 // GetChannel retrieves a channel from playout
 func (mcr *MCR) GetChannel(ctx context.Context, shortName string) (*Channel, error) {
+	mcr.lock.RLock()
+	defer mcr.lock.RUnlock()
 	ch, ok := mcr.channels[shortName]
 	if !ok {
-		return nil, errors.New("channel doesn't exist")
+		return nil, fmt.Errorf("%w: \"%s\"", ErrChannelNotFound, shortName)
 	}
 	return ch, nil
 }
 
-// GetChannels retrieves all channels
+// GetChannels retrieves all channels, keyed by short name
 func (mcr *MCR) GetChannels() (map[string]*Channel, error) {
-	return mcr.channels, nil
+	mcr.lock.RLock()
+	defer mcr.lock.RUnlock()
+	chs := make(map[string]*Channel, len(mcr.channels))
+	for shortName, ch := range mcr.channels {
+		chs[shortName] = ch
+	}
+	return chs, nil
+}
+
+// list returns all channels
+func (mcr *MCR) list() []*Channel {
+	mcr.lock.RLock()
+	defer mcr.lock.RUnlock()
+	chs := make([]*Channel, 0, len(mcr.channels))
+	for _, ch := range mcr.channels {
+		chs = append(chs, ch)
+	}
+	return chs
+}
+
+// exists is when a channel already has the short name
+func (mcr *MCR) exists(shortName string) bool {
+	mcr.lock.RLock()
+	defer mcr.lock.RUnlock()
+	_, ok := mcr.channels[shortName]
+	return ok
 }
 
 // newChannel adds the channel to memory and adds the helper services
-func (mcr *MCR) newChannel(ctx context.Context, ch Channel, updateDB bool) error {
-	mcr.channels[ch.ShortName] = &ch
+//
+// The channel is only added once it is stored, a module failing to
+// start leaves it added but without the module.
+func (mcr *MCR) newChannel(ctx context.Context, ch *Channel, updateDB bool) error {
+	ch.status = Status{State: StatePending, Reason: "created", Since: time.Now()}
+	ch.conf = mcr.conf
+	ch.bus = mcr.bus
+
+	tc, err := mcr.newTranscoder(ch)
+	if err != nil {
+		return fmt.Errorf("failed to create transcoder: %w", err)
+	}
+	ch.tc = tc
+	ch.checker = &FFprobeChecker{Path: mcr.conf.FFprobePath}
+	ch.grabber = &FFmpegGrabber{Path: mcr.conf.FFmpegPath}
+	ch.eventStore = mcr.storeEvent
+	if mcr.conf.ArchiveDir != "" {
+		ch.archive = &archiver{
+			dir:       filepath.Join(mcr.conf.ArchiveDir, ch.ShortName),
+			ffmpeg:    mcr.conf.FFmpegPath,
+			retention: mcr.conf.ArchiveRetention,
+			store:     &LocalArchiveStore{Dir: mcr.conf.VODDir, URL: mcr.conf.VODURL},
+			po:        playout.New(programming.New(mcr.db), mcr.db),
+		}
+	}
 
 	if updateDB {
-		// TODO handle existing
 		err := mcr.addChannelToDB(ctx, ch)
 		if err != nil {
 			return fmt.Errorf("failed to add channel to DB: %w", err)
 		}
 	}
 
-	if ch.hasScheduler {
-		sch, err := scheduler.New(mcr.db, ch.ID)
+	ch.Subscribe(func(t Transition) {
+		mcr.bus.Publish(StateChanged{Transition: t})
+	})
+	mcr.lock.Lock()
+	mcr.channels[ch.ShortName] = ch
+	mcr.lock.Unlock()
+	mcr.bus.Publish(ChannelCreated{Channel: ch.ShortName, ChannelID: ch.ID, At: time.Now()})
+
+	if ch.HasScheduler {
+		err = mcr.startScheduler(ch)
 		if err != nil {
-			return fmt.Errorf("failed to start scheduler: %w", err)
+			return err
 		}
-		ch.sch = sch
 	}
 
-	if ch.hasPiper {
-		piper, err := piper.New(ctx, piper.Config{
-			Endpoint: "",
-			Width:    1920,
-			Height:   1080,
-			FPS:      50,
-		}, "brave")
+	if ch.HasPiper {
+		err = mcr.startPiper(ctx, ch)
 		if err != nil {
-			return fmt.Errorf("failed to start piper: %w", err)
+			return err
 		}
-		ch.piper = piper
 	}
 	return nil
 }
 
-// addChannelToDB will add a channel
-//
-// Will update channel ID to the new one
-func (mcr *MCR) addChannelToDB(ctx context.Context, ch Channel) error {
-	channelID := 0
-	err := mcr.db.GetContext(ctx, &channelID, `
-		INSERT INTO playout.channel(
-			channel_id,
-			short_name,
-			name,
-			description,
-			type,
-			ingest_url,
-			ingest_type,
-			slate_url,
-			visibility,
-			archive,
-			dvr)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING channel_id;`,
-		ch.ID, ch.ShortName, ch.Name, ch.Description, ch.ChannelType,
-		ch.IngestURL, ch.IngestURL, ch.SlateURL, ch.Visibilty,
-		ch.Archive, ch.DVR)
-	if err != nil {
-		return fmt.Errorf("failed to insert channel to DB: %w", err)
-	}
-	ch.ID = channelID
+// startScheduler attaches a scheduler to the channel
+func (mcr *MCR) startScheduler(ch *Channel) error {
+	sch, err := scheduler.New(mcr.db, scheduler.Config{
+		VTEndpoint:    mcr.conf.VTEndpoint,
+		FFmpegPath:    mcr.conf.FFmpegPath,
+		Prober:        mcr.prober,
+		CheckHorizon:  mcr.conf.SourceCheckHorizon,
+		CheckInterval: mcr.conf.SourceCheckInterval,
+	}, ch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to start scheduler: %w", err)
+	}
+	sch.Subscribe(ch.handleScheduleEvent)
+	sch.Subscribe(ch.handlePlayoutStarted)
+	sch.Subscribe(ch.handlePlayoutFailed)
+	sch.Subscribe(ch.handleSourceCheck)
+	sch.Subscribe(ch.handleLogoSuppression)
+	sch.Subscribe(ch.handleCaptions)
+	sch.Subscribe(ch.handleMarkers)
+	sch.Subscribe(ch.handleRestreams)
+	sch.SetLoudness(ch.LoudnessPolicy.measuredTarget())
+	ch.confLock.Lock()
+	ch.sch = sch
+	ch.confLock.Unlock()
+	// Subscribers are in place before the first playout can start
+	sch.Start()
+	return nil
+}
+
+// stopScheduler stops and detaches the channel's scheduler
+func (mcr *MCR) stopScheduler(ch *Channel) {
+	ch.confLock.Lock()
+	sch := ch.sch
+	ch.sch = nil
+	ch.confLock.Unlock()
+	if sch != nil {
+		sch.Stop()
+	}
+}
+
+// startPiper attaches a piper to the channel
+func (mcr *MCR) startPiper(ctx context.Context, ch *Channel) error {
+	conf := mcr.conf.Piper
+	if endpoint, ok := mcr.conf.PiperEndpoints[ch.ShortName]; ok {
+		conf.Endpoint = endpoint
+	}
+	piper, err := piper.New(ctx, conf, "brave")
+	if err != nil {
+		return fmt.Errorf("failed to start piper: %w", err)
+	}
+	ch.confLock.Lock()
+	ch.piper = piper
+	ch.confLock.Unlock()
 	return nil
 }
 
+// stopPiper detaches the channel's piper
+//
+// The mixer itself is left as is, since it could still
+// be feeding the ingest.
+func (mcr *MCR) stopPiper(ch *Channel) {
+	ch.confLock.Lock()
+	ch.piper = nil
+	ch.confLock.Unlock()
+}
+
+// addChannelToDB will add a channel and its outputs
+//
+// Will update channel ID and output IDs to the new ones
+func (mcr *MCR) addChannelToDB(ctx context.Context, ch *Channel) error {
+	passphrase, err := mcr.sealStreamKey(ch.SRTPassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt srt passphrase: %w", err)
+	}
+	return utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &ch.ID, `
+			INSERT INTO playout.channel(
+				short_name,
+				name,
+				description,
+				type,
+				ingest_url,
+				ingest_type,
+				srt_mode,
+				srt_passphrase,
+				srt_latency,
+				loudness_mode,
+				loudness_target,
+				loudness_true_peak,
+				logo_url,
+				logo_position,
+				logo_scale,
+				logo_opacity,
+				logo_margin,
+				subtitle_languages,
+				closed_captions,
+				slate_url,
+				failback_delay,
+				visibility,
+				archive,
+				dvr,
+				has_scheduler,
+				has_piper)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+				$19, $20, $21, $22, $23, $24, $25, $26)
+			RETURNING channel_id;`,
+			ch.ShortName, ch.Name, ch.Description, ch.ChannelType,
+			ch.IngestURL, ch.IngestType, ch.SRTMode, passphrase,
+			ch.SRTLatency, ch.LoudnessMode, ch.LoudnessTarget, ch.LoudnessTruePeak,
+			ch.LogoURL, ch.LogoPosition, ch.LogoScale, ch.LogoOpacity, ch.LogoMargin,
+			ch.SubtitleLanguages, ch.ClosedCaptions, ch.SlateURL, ch.FailbackDelay,
+			ch.Visibilty, ch.Archive, ch.DVR, ch.HasScheduler, ch.HasPiper)
+		if err != nil {
+			return fmt.Errorf("failed to insert channel to DB: %w", err)
+		}
+		err = replaceAudioTracks(ctx, tx, ch.ID, ch.AudioTracks)
+		if err != nil {
+			return err
+		}
+		err = mcr.replaceIngestSources(ctx, tx, ch.ID, ch.BackupIngests)
+		if err != nil {
+			return err
+		}
+		for idx := range ch.Outputs {
+			err = mcr.insertOutput(ctx, tx, ch.ID, &ch.Outputs[idx])
+			if err != nil {
+				return fmt.Errorf("failed to insert output \"%s\": %w", ch.Outputs[idx].Name, err)
+			}
+		}
+		return nil
+	})
+}
+
 // NewChannel creates a new channel to playout
 func (mcr *MCR) NewChannel(ctx context.Context, newCh NewChannelStruct) (*Channel, error) {
-	ch := Channel{
-		ShortName:   newCh.ShortName,
-		Name:        newCh.Name,
-		Description: newCh.Description,
-		ChannelType: newCh.ChannelType,
-		IngestType:  newCh.IngestType,
-		SlateURL:    newCh.SlateURL,
-		Outputs:     newCh.Outputs,
-		Archive:     newCh.Archive,
-	}
-	ch.Status = "pending"
+	ch := &Channel{
+		ShortName:      newCh.ShortName,
+		Name:           newCh.Name,
+		Description:    newCh.Description,
+		ChannelType:    newCh.ChannelType,
+		IngestURL:      newCh.IngestURL,
+		IngestType:     newCh.IngestType,
+		SRTOptions:     newCh.IngestSRT,
+		LoudnessPolicy: newCh.Loudness.withDefaults(),
+		LogoOptions:    newCh.Logo.withDefaults(),
+		CaptionOptions: newCh.Captions,
+		SlateURL:       newCh.SlateURL,
+		BackupIngests:  newCh.BackupIngests,
+		FailbackDelay:  newCh.FailbackDelay,
+		Outputs:        newCh.Outputs,
+		AudioTracks:    newCh.AudioTracks,
+		Visibilty:      newCh.Visible,
+		Archive:        newCh.Archive,
+		DVR:            newCh.DVR,
+		HasScheduler:   newCh.HasScheduler,
+		HasPiper:       newCh.HasPiper,
+	}
 
 	// Default values
 	if ch.Name == "" {
 		ch.Name = "A random livestream"
 	}
+	err := ch.LoudnessPolicy.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = ch.LogoOptions.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = ch.CaptionOptions.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = validateAudioTracks(ch.AudioTracks)
+	if err != nil {
+		return nil, err
+	}
+	primary := ch.ingestSources()[0]
+	if primary.URL != "" {
+		// The ingest can be set later, once it's known
+		err = validateIngest(primary, Input{}.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = validateFailover(primary, ch.BackupIngests, ch.FailbackDelay)
+	if err != nil {
+		return nil, err
+	}
+	err = mcr.validateArchive(ch.Archive)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	if ch.ShortName == "" {
 		// Generate a random short-name if one wasn't provided
-		if ch.ShortName == "" {
+		ch.ShortName = randString()
+		for mcr.exists(ch.ShortName) {
 			ch.ShortName = randString()
 		}
-		_, exists := mcr.channels[ch.ShortName]
-		if !exists {
-			break
+	} else if mcr.exists(ch.ShortName) {
+		return nil, fmt.Errorf("%w: \"%s\"", ErrChannelExists, ch.ShortName)
+	}
+
+	for idx := range ch.Outputs {
+		o := &ch.Outputs[idx]
+		err := mcr.resolveProfile(ctx, o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output \"%s\": %w", o.Name, err)
+		}
+		_, err = ch.compileOutput(*o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output \"%s\": %w", o.Name, err)
 		}
 	}
 
-	err := mcr.newChannel(ctx, ch, true)
+	err = mcr.newChannel(ctx, ch, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add channel to memory: %w", err)
 	}
 
-	return &ch, nil
+	return ch, nil
 }
 
-// DeleteChannel removes a channel from playout
-func (mcr *MCR) DeleteChannel(shortName string) error {
-	if _, ok := mcr.channels[shortName]; ok {
-		err := mcr.channels[shortName].Stop()
+// UpdateChannel changes a channel's config, applying it live
+//
+// A change which fails to apply is rolled back, so the channel keeps
+// running and storing its previous config.
+func (mcr *MCR) UpdateChannel(ctx context.Context, shortName string, upd UpdateChannelStruct) (*Channel, error) {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	if upd.Name == "" {
+		upd.Name = ch.Name
+	}
+	upd.Loudness = upd.Loudness.withDefaults()
+	err = upd.Loudness.validate()
+	if err != nil {
+		return nil, err
+	}
+	upd.Logo = upd.Logo.withDefaults()
+	err = upd.Logo.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = upd.Captions.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = validateAudioTracks(upd.AudioTracks)
+	if err != nil {
+		return nil, err
+	}
+	primary := IngestSource{URL: upd.IngestURL, Type: upd.IngestType, SRTOptions: upd.IngestSRT}
+	if primary.URL != "" {
+		err = validateIngest(primary, Input{}.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = validateFailover(primary, upd.BackupIngests, upd.FailbackDelay)
+	if err != nil {
+		return nil, err
+	}
+	err = mcr.validateArchive(upd.Archive)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the new ingest against the existing outputs
+	next := &Channel{
+		IngestURL:      upd.IngestURL,
+		IngestType:     upd.IngestType,
+		SRTOptions:     upd.IngestSRT,
+		LoudnessPolicy: upd.Loudness,
+		LogoOptions:    upd.Logo,
+		CaptionOptions: upd.Captions,
+		Archive:        upd.Archive,
+		Outputs:        ch.outputs(),
+		AudioTracks:    upd.AudioTracks,
+	}
+	_, err = next.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ingest: %w", err)
+	}
+
+	prev := ch.settings()
+	err = mcr.storeChannel(ctx, ch.ID, upd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update channel: %w", err)
+	}
+
+	// Slate fallback depends on the ingest, slate and piper, which
+	// the monitor picks up as it resumes
+	resume := ch.pauseMonitor()
+	defer resume()
+	err = mcr.applyChannel(ctx, ch, upd)
+	if err == nil {
+		return ch, nil
+	}
+	// Roll back, so the stored config is the one running
+	applyErr := mcr.applyChannel(ctx, ch, prev)
+	storeErr := mcr.storeChannel(ctx, ch.ID, prev)
+	if applyErr != nil || storeErr != nil {
+		return nil, fmt.Errorf("%w, and failed to roll back: %v, %v", err, applyErr, storeErr)
+	}
+	return nil, err
+}
+
+// storeChannel writes a channel's changeable config
+func (mcr *MCR) storeChannel(ctx context.Context, channelID int, upd UpdateChannelStruct) error {
+	passphrase, err := mcr.sealStreamKey(upd.IngestSRT.SRTPassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt srt passphrase: %w", err)
+	}
+	return utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE playout.channel SET
+				name = $1,
+				description = $2,
+				ingest_url = $3,
+				ingest_type = $4,
+				srt_mode = $5,
+				srt_passphrase = $6,
+				srt_latency = $7,
+				loudness_mode = $8,
+				loudness_target = $9,
+				loudness_true_peak = $10,
+				logo_url = $11,
+				logo_position = $12,
+				logo_scale = $13,
+				logo_opacity = $14,
+				logo_margin = $15,
+				subtitle_languages = $16,
+				closed_captions = $17,
+				slate_url = $18,
+				failback_delay = $19,
+				visibility = $20,
+				archive = $21,
+				dvr = $22,
+				has_scheduler = $23,
+				has_piper = $24
+			WHERE channel_id = $25;`,
+			upd.Name, upd.Description, upd.IngestURL, upd.IngestType,
+			upd.IngestSRT.SRTMode, passphrase, upd.IngestSRT.SRTLatency,
+			upd.Loudness.LoudnessMode, upd.Loudness.LoudnessTarget, upd.Loudness.LoudnessTruePeak,
+			upd.Logo.LogoURL, upd.Logo.LogoPosition, upd.Logo.LogoScale, upd.Logo.LogoOpacity, upd.Logo.LogoMargin,
+			upd.Captions.SubtitleLanguages, upd.Captions.ClosedCaptions, upd.SlateURL, upd.FailbackDelay,
+			upd.Visible, upd.Archive, upd.DVR, upd.HasScheduler, upd.HasPiper, channelID)
+		if err != nil {
+			return err
+		}
+		err = replaceAudioTracks(ctx, tx, channelID, upd.AudioTracks)
+		if err != nil {
+			return err
+		}
+		return mcr.replaceIngestSources(ctx, tx, channelID, upd.BackupIngests)
+	})
+}
+
+// applyChannel changes a channel's config to the update, only
+// touching what has changed. An ingest change restarts the outputs
+// of a live channel and modules are started or stopped when they
+// are toggled.
+func (mcr *MCR) applyChannel(ctx context.Context, ch *Channel, upd UpdateChannelStruct) error {
+	ingestChanged := ch.IngestURL != upd.IngestURL || ch.IngestType != upd.IngestType ||
+		ch.SRTOptions != upd.IngestSRT || !sameIngests(ch.BackupIngests, upd.BackupIngests)
+	loudnessChanged := ch.LoudnessPolicy != upd.Loudness
+	// A new image is swapped in live, placing it differently needs a restart
+	logoChanged := ch.LogoURL != upd.Logo.LogoURL
+	placement := upd.Logo
+	placement.LogoURL = ch.LogoURL
+	logoMoved := ch.LogoOptions != placement
+	captionsChanged := ch.CaptionOptions != upd.Captions
+	audioChanged := !sameAudioTracks(ch.AudioTracks, upd.AudioTracks)
+	archiveChanged := ch.Archive != upd.Archive
+	schedulerChanged := ch.HasScheduler != upd.HasScheduler
+	piperChanged := ch.HasPiper != upd.HasPiper
+
+	ch.confLock.Lock()
+	ch.Name = upd.Name
+	ch.Description = upd.Description
+	ch.IngestURL = upd.IngestURL
+	ch.IngestType = upd.IngestType
+	ch.SRTOptions = upd.IngestSRT
+	ch.LoudnessPolicy = upd.Loudness
+	ch.LogoOptions = upd.Logo
+	ch.CaptionOptions = upd.Captions
+	ch.AudioTracks = upd.AudioTracks
+	ch.SlateURL = upd.SlateURL
+	ch.BackupIngests = upd.BackupIngests
+	ch.FailbackDelay = upd.FailbackDelay
+	ch.Visibilty = upd.Visible
+	ch.Archive = upd.Archive
+	ch.DVR = upd.DVR
+	ch.HasScheduler = upd.HasScheduler
+	ch.HasPiper = upd.HasPiper
+	ch.confLock.Unlock()
+
+	// Inputs which no longer exist go back to the primary
+	sources := len(upd.BackupIngests) + 1
+	ch.inputLock.Lock()
+	unpinned := ch.pin != nil && (ch.pin.Slate && upd.SlateURL == "" || !ch.pin.Slate && ch.pin.Source >= sources)
+	if unpinned {
+		ch.pin = nil
+	}
+	if ch.current.Slate && upd.SlateURL == "" || !ch.current.Slate && ch.current.Source >= sources {
+		ch.current = Input{}
+	}
+	ch.inputLock.Unlock()
+	if unpinned {
+		ch.recordEvent(EventInputUnpinned, "input unpinned, its source was removed")
+	}
+
+	if loudnessChanged {
+		if sch, err := ch.scheduler(); err == nil {
+			sch.SetLoudness(upd.Loudness.measuredTarget())
+		}
+	}
+
+	var err error
+	if logoChanged && ch.usesLogo() {
+		err = ch.loadLogo(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to delete channel: %w", err)
+			return fmt.Errorf("failed to load logo: %w", err)
+		}
+	}
+
+	if (ingestChanged || loudnessChanged || logoMoved || captionsChanged || audioChanged) && ch.isLive() {
+		err = ch.restartOutputs(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (ingestChanged || archiveChanged) && ch.isLive() {
+		err = ch.stopRecording(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to stop recording: %w", err)
+		}
+		err = ch.startRecording(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if schedulerChanged {
+		if ch.HasScheduler {
+			err = mcr.startScheduler(ch)
+			if err != nil {
+				return err
+			}
+		} else {
+			mcr.stopScheduler(ch)
+		}
+	}
+
+	if piperChanged {
+		if ch.HasPiper {
+			err = mcr.startPiper(ctx, ch)
+			if err != nil {
+				return err
+			}
+		} else {
+			mcr.stopPiper(ch)
 		}
-		delete(mcr.channels, shortName)
 	}
 	return nil
 }
 
+// DeleteChannel stops a channel and removes it from playout, along with
+// its outputs, ingest sources, events and schedule
+func (mcr *MCR) DeleteChannel(ctx context.Context, shortName string) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	err = ch.Stop()
+	if err != nil {
+		return fmt.Errorf("failed to delete channel: %w", err)
+	}
+	mcr.stopScheduler(ch)
+	mcr.stopPiper(ch)
+	err = utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		for _, table := range []string{
+			"playout.outputs",
+			"playout.channel_audio_tracks",
+			"playout.channel_ingest_sources",
+			"playout.channel_events",
+			"playout.schedule_playouts",
+			"playout.channel",
+		} {
+			_, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE channel_id = $1;`, ch.ID)
+			if err != nil {
+				return fmt.Errorf("failed to delete from %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete channel: %w", err)
+	}
+	mcr.lock.Lock()
+	delete(mcr.channels, shortName)
+	mcr.lock.Unlock()
+	mcr.bus.Publish(ChannelDeleted{Channel: ch.ShortName, ChannelID: ch.ID, At: time.Now()})
+	return nil
+}
+
+// StartChannel starts a channel's outputs
+func (mcr *MCR) StartChannel(ctx context.Context, shortName string) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	return ch.Start()
+}
+
+// StopChannel stops a channel's outputs
+func (mcr *MCR) StopChannel(ctx context.Context, shortName string) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	return ch.Stop()
+}
+
+// RestartChannel stops then starts a channel's outputs
+func (mcr *MCR) RestartChannel(ctx context.Context, shortName string) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	err = ch.Stop()
+	if err != nil {
+		return err
+	}
+	return ch.Start()
+}
+
+// IsInvalid reports whether err is from a channel or output's config
+// being rejected, rather than something going wrong
+func IsInvalid(err error) bool {
+	for _, target := range []error{
+		ErrUnknownOutputType, ErrUnknownIngestType, ErrUnknownCodec,
+		ErrNoRenditions, ErrTooManyRenditions, ErrInvalidSegment,
+		ErrProfileConflict, ErrInvalidSRT, ErrSRTSharedIngest,
+		ErrProfileNotFound, ErrInvalidProfile, ErrInvalidLoudness, ErrInvalidLogo,
+		ErrInvalidCaptions, ErrInvalidAudioTracks, ErrInvalidMarkers,
+		ErrInvalidIngests, ErrInvalidInput, ErrInvalidRestream, ErrArchiveUnavailable,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func randString() string {
@@ -0,0 +1,111 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// recentEvents is the amount of events a channel keeps in memory
+const recentEvents = 50
+
+// Channel event types
+const (
+	EventSlateOn  = "slate-on"  // Ingest was lost, outputs swapped to the slate
+	EventSlateOff = "slate-off" // Ingest recovered, outputs swapped back
+
+	EventIngestSwitched = "ingest-switched" // Outputs moved between ingest sources
+	EventInputPinned    = "input-pinned"    // An operator held the outputs on an input
+	EventInputUnpinned  = "input-unpinned"  // Failing over automatically again
+
+	EventPreviewStale   = "preview-stale"   // Frames stopped being grabbed
+	EventPreviewResumed = "preview-resumed" // Frames are being grabbed again
+
+	EventSourceFlagged = "source-flagged" // An upcoming playout's content has problems
+	EventSourceCleared = "source-cleared" // An upcoming playout's content is fine again
+
+	EventLogoHidden = "logo-hidden" // A playout suppressing the logo went on air
+	EventLogoShown  = "logo-shown"  // The logo is back on
+
+	EventPlayoutFailed = "playout-failed" // The scheduler couldn't start a playout
+
+	EventRestreamStarted = "restream-started" // A scheduled restream went out with a playout
+	EventRestreamStopped = "restream-stopped" // A scheduled restream ended with its playout
+	EventRestreamFailed  = "restream-failed"  // A scheduled restream couldn't be started or stopped
+)
+
+// Event is something notable which happened to a channel
+type Event struct {
+	ID        int       `db:"event_id" json:"id"`
+	ChannelID int       `db:"channel_id" json:"channelID"`
+	Type      string    `db:"type" json:"type"`
+	Message   string    `db:"message" json:"message"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Events returns the channel's most recent events, newest last
+func (ch *Channel) Events() []Event {
+	ch.eventLock.Lock()
+	defer ch.eventLock.Unlock()
+	events := make([]Event, len(ch.events))
+	copy(events, ch.events)
+	return events
+}
+
+// recordEvent logs an event, keeping it in memory and
+// passing it to the channel's event store if it has one
+func (ch *Channel) recordEvent(eventType, format string, a ...interface{}) {
+	e := Event{
+		ChannelID: ch.ID,
+		Type:      eventType,
+		Message:   fmt.Sprintf(format, a...),
+		CreatedAt: time.Now(),
+	}
+	log.Printf("channel \"%s\": %s: %s", ch.ShortName, e.Type, e.Message)
+
+	ch.eventLock.Lock()
+	ch.events = append(ch.events, e)
+	if len(ch.events) > recentEvents {
+		ch.events = ch.events[len(ch.events)-recentEvents:]
+	}
+	store := ch.eventStore
+	ch.eventLock.Unlock()
+
+	if store != nil {
+		err := store(context.Background(), e)
+		if err != nil {
+			log.Printf("channel \"%s\": failed to store event: %+v", ch.ShortName, err)
+		}
+	}
+}
+
+// storeEvent saves a channel event to the DB
+func (mcr *MCR) storeEvent(ctx context.Context, e Event) error {
+	_, err := mcr.db.ExecContext(ctx, `
+		INSERT INTO playout.channel_events(channel_id, type, message, created_at)
+		VALUES ($1, $2, $3, $4);`, e.ChannelID, e.Type, e.Message, e.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+	return nil
+}
+
+// GetEvents retrieves a channel's stored events, newest first
+func (mcr *MCR) GetEvents(ctx context.Context, shortName string, amount int) ([]Event, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	err = mcr.db.SelectContext(ctx, &events, `
+		SELECT event_id, channel_id, type, message, created_at
+		FROM playout.channel_events
+		WHERE channel_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2;`, ch.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select events: %w", err)
+	}
+	return events, nil
+}
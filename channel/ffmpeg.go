@@ -0,0 +1,602 @@
+package channel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ystv/playout/utils"
+)
+
+var (
+	// ErrUnknownOutputType is when an output's type isn't supported
+	ErrUnknownOutputType = errors.New("unknown output type")
+	// ErrUnknownIngestType is when a channel's ingest type isn't supported
+	ErrUnknownIngestType = errors.New("unknown ingest type")
+	// ErrUnknownCodec is when a rendition's codec isn't supported
+	ErrUnknownCodec = errors.New("unknown codec")
+	// ErrNoRenditions is when a transcoded output has nothing to encode
+	ErrNoRenditions = errors.New("transcoded output has no renditions")
+	// ErrTooManyRenditions is when an output's container can only
+	// carry a single video stream
+	ErrTooManyRenditions = errors.New("output type only supports a single rendition")
+	// ErrInvalidSegment is when a segmented output's timings don't make sense
+	ErrInvalidSegment = errors.New("invalid segment duration or dvr window")
+	// ErrProfileConflict is when an output references a profile and
+	// defines its own renditions
+	ErrProfileConflict = errors.New("output has both a profile and renditions")
+)
+
+const (
+	// defaultSegmentDuration is the length in seconds of a segment on
+	// segmented outputs when one isn't set, keyframes are aligned to it
+	defaultSegmentDuration = 4
+	// liveWindow is the amount of segments kept in a non-DVR playlist
+	liveWindow = 5
+	// audioBitrate of the shared audio stream when the output
+	// doesn't use a profile, Kb/s
+	audioBitrate = 128
+	// audioSampleRate of the shared audio stream when the output
+	// doesn't use a profile, Hz
+	audioSampleRate = 48000
+)
+
+// Command is a compiled ffmpeg invocation which produces a single output
+type Command struct {
+	Output      string   // Identifies the output the command produces
+	Destination string   // Where the output is written to
+	Args        []string // Arguments to ffmpeg, excluding the binary
+
+	input   int      // Index of the primary input's URL in Args, others follow it
+	secrets []string // Hidden wherever the command is shown
+}
+
+// String returns the command as a shell-safe string, useful for logging
+func (c Command) String() string {
+	args := make([]string, len(c.Args))
+	for idx, arg := range c.Args {
+		args[idx] = redactSRT(c.redact(arg))
+	}
+	return "ffmpeg " + utils.QuoteArgs(args)
+}
+
+// Compile builds the ffmpeg command for each of the channel's outputs
+func (ch *Channel) Compile() ([]Command, error) {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	outputs := ch.Outputs
+	readers := len(outputs)
+	if ch.Archive {
+		readers++
+	}
+	if strings.EqualFold(ch.IngestType, "srt") && ch.SRTOptions.accepts() && readers > 1 {
+		return nil, ErrSRTSharedIngest
+	}
+	cmds := make([]Command, 0, len(outputs))
+	for idx, output := range outputs {
+		cmd, err := ch.compileOutput(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile output \"%s\": %w", outputKey(idx, output), err)
+		}
+		cmd.Output = outputKey(idx, output)
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// compile builds the command of a single output while the channel's
+// config can't change
+func (ch *Channel) compile(o Output) (Command, error) {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	return ch.compileOutput(o)
+}
+
+// compileOutput builds the argument list of a single output. Caller
+// holds confLock, unless nothing else can see the channel yet.
+//
+// A transcoded output splits the ingest's video once in a filter
+// graph, scaling a branch for each rendition which are then encoded
+// as separate video streams alongside one shared audio stream.
+func (ch *Channel) compileOutput(o Output) (Command, error) {
+	args := []string{"-hide_banner", "-nostdin"}
+
+	err := ch.LoudnessPolicy.validate()
+	if err != nil {
+		return Command{}, err
+	}
+	err = ch.LogoOptions.validateOutput(o)
+	if err != nil {
+		return Command{}, err
+	}
+	err = ch.CaptionOptions.validateOutput(o)
+	if err != nil {
+		return Command{}, err
+	}
+	err = validateMarkers(o)
+	if err != nil {
+		return Command{}, err
+	}
+	err = validateRestream(o)
+	if err != nil {
+		return Command{}, err
+	}
+	input, err := ch.inputArgs()
+	if err != nil {
+		return Command{}, err
+	}
+	args = append(args, input...)
+	primary := len(args) - 1
+	if o.Logo {
+		args = append(args, ch.logoInputArgs()...)
+	}
+
+	err = o.SRTOptions.validate(o.protocol())
+	if err != nil {
+		return Command{}, err
+	}
+	if o.SegmentDuration < 0 || o.DVRWindow < 0 {
+		return Command{}, ErrInvalidSegment
+	}
+
+	tracks := ch.audioTracks(o)
+	if o.Passthrough {
+		args = append(args, "-map", "0", "-c", "copy")
+	} else {
+		enc, err := o.encoding()
+		if err != nil {
+			return Command{}, err
+		}
+		// The container maps the ladder which is actually encoded
+		o.Renditions = enc.Renditions
+		f := filters{audio: ch.LoudnessPolicy.audioFilter()}
+		if o.Logo {
+			f.video = ch.LogoOptions.overlayGraph("main")
+			f.videoOut = "main"
+		}
+		encode, err := encodeArgs(enc, segmentLength(o), f, tracks)
+		if err != nil {
+			return Command{}, err
+		}
+		args = append(args, encode...)
+		if o.Captions {
+			// Carry the ingest's CEA-608/708 captions into the encode
+			args = append(args, "-a53cc", "1")
+		}
+	}
+
+	mux, err := muxArgs(o, tracks)
+	if err != nil {
+		return Command{}, err
+	}
+	args = append(args, mux...)
+	secrets := append(o.secrets(), ch.ingestSecrets()...)
+	return Command{Destination: o.Destination, Args: args, input: primary, secrets: secrets}, nil
+}
+
+// inputArgs are the arguments to read the channel's ingest, or
+// its slate on a loop when every source has dropped
+func (ch *Channel) inputArgs() ([]string, error) {
+	if ch.onSlate() {
+		return []string{"-re", "-stream_loop", "-1", "-i", ch.SlateURL}, nil
+	}
+	return ch.ingestArgs()
+}
+
+// ingestArgs are the arguments to read the ingest source feeding
+// the outputs
+func (ch *Channel) ingestArgs() ([]string, error) {
+	return ch.activeSource().args()
+}
+
+// encoding is the profile an output is encoded with, either the one
+// it references or one wrapping its own renditions
+func (o Output) encoding() (Profile, error) {
+	if o.Profile == "" {
+		return defaultProfile(o.Renditions), nil
+	}
+	if len(o.Renditions) > 0 {
+		return Profile{}, ErrProfileConflict
+	}
+	if o.profile == nil {
+		return Profile{}, fmt.Errorf("%w: \"%s\"", ErrProfileNotFound, o.Profile)
+	}
+	return *o.profile, nil
+}
+
+// filters are the channel's processing of the ingest before it is
+// encoded
+type filters struct {
+	video    string // Graph from the inputs' video, i.e. overlays
+	videoOut string // Label of video's output
+	audio    string // Filter of the ingest's audio
+}
+
+// encodeArgs are the filter graph, mapping and encoder arguments of
+// a profile's rendition ladder, with keyframes every segment seconds
+// unless the profile sets its own GOP. The ingest passes through the
+// filters first. Each audio track is encoded the same way.
+func encodeArgs(p Profile, segment int, f filters, tracks []AudioTrack) ([]string, error) {
+	renditions := p.Renditions
+	if len(renditions) == 0 {
+		return nil, ErrNoRenditions
+	}
+
+	// Video, split the ingest once then scale each branch
+	graph := strings.Builder{}
+	src := "[0:v]"
+	if f.video != "" {
+		graph.WriteString(f.video + ";")
+		src = "[" + f.videoOut + "]"
+	}
+	fmt.Fprintf(&graph, "%ssplit=%d", src, len(renditions))
+	for idx := range renditions {
+		fmt.Fprintf(&graph, "[s%d]", idx)
+	}
+	for idx, rendition := range renditions {
+		fmt.Fprintf(&graph, ";[s%d]scale=w=%d:h=%d:force_original_aspect_ratio=decrease:force_divisible_by=2",
+			idx, rendition.Width, rendition.Height)
+		if rendition.FPS > 0 {
+			fmt.Fprintf(&graph, ",fps=%d", rendition.FPS)
+		}
+		fmt.Fprintf(&graph, "[v%d]", idx)
+	}
+	args := []string{"-filter_complex", graph.String()}
+
+	videoProfile := p.VideoProfile
+	if videoProfile == "" {
+		videoProfile = "main"
+	}
+	for idx, rendition := range renditions {
+		codecName := rendition.Codec
+		if codecName == "" {
+			codecName = p.Codec
+		}
+		codec, err := videoCodec(codecName)
+		if err != nil {
+			return nil, err
+		}
+		stream := strconv.Itoa(idx)
+		args = append(args,
+			"-map", "[v"+stream+"]",
+			"-c:v:"+stream, codec,
+			"-b:v:"+stream, fmt.Sprintf("%dk", rendition.Bitrate),
+			"-maxrate:v:"+stream, fmt.Sprintf("%dk", rendition.Bitrate),
+			"-bufsize:v:"+stream, fmt.Sprintf("%dk", rendition.Bitrate*2),
+			"-profile:v:"+stream, videoProfile,
+		)
+		if p.Preset != "" {
+			args = append(args, "-preset:v:"+stream, p.Preset)
+		}
+		// Keyframes on segment boundaries so renditions can be switched between
+		gop := p.GOP
+		if gop == 0 {
+			gop = rendition.FPS * segment
+		}
+		if gop > 0 {
+			args = append(args, "-g:v:"+stream, strconv.Itoa(gop))
+			if p.AlignKeyframes {
+				args = append(args, "-keyint_min:v:"+stream, strconv.Itoa(gop))
+			}
+		}
+	}
+	args = append(args, "-pix_fmt", "yuv420p")
+	if p.AlignKeyframes {
+		args = append(args, "-sc_threshold", "0")
+	}
+
+	// Audio, streams shared by every rendition
+	codec, err := audioCodec(p.AudioCodec)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, audioArgs(tracks)...)
+	if f.audio != "" {
+		args = append(args, "-af", f.audio)
+	}
+	args = append(args,
+		"-c:a", codec,
+		"-b:a", fmt.Sprintf("%dk", p.AudioBitrate),
+		"-ar", strconv.Itoa(p.AudioSampleRate),
+	)
+	if p.AudioChannels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+	}
+	return args, nil
+}
+
+// muxArgs are the container and destination arguments of an output
+func muxArgs(o Output, tracks []AudioTrack) ([]string, error) {
+	switch strings.ToLower(o.Type) {
+	case "rtmp":
+		if len(o.Renditions) > 1 && !o.Passthrough {
+			return nil, ErrTooManyRenditions
+		}
+		return []string{"-f", "flv", o.Destination}, nil
+
+	case "rtp":
+		if len(o.Renditions) > 1 && !o.Passthrough {
+			return nil, ErrTooManyRenditions
+		}
+		// Plain RTP can only carry one stream, so wrap in MPEG-TS
+		return []string{"-f", "rtp_mpegts", o.Destination}, nil
+
+	case "srt":
+		if len(o.Renditions) > 1 && !o.Passthrough {
+			return nil, ErrTooManyRenditions
+		}
+		dst, err := o.SRTOptions.ffmpegURL(o.Destination)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"-f", "mpegts", dst}, nil
+
+	case "restream":
+		if len(o.Renditions) > 1 && !o.Passthrough {
+			return nil, ErrTooManyRenditions
+		}
+		dst, err := o.restreamURL()
+		if err != nil {
+			return nil, err
+		}
+		if o.protocol() == "srt" {
+			return []string{"-f", "mpegts", dst}, nil
+		}
+		return []string{"-f", "flv", dst}, nil
+
+	case "hls":
+		return hlsArgs(o, tracks), nil
+
+	case "dash":
+		return dashArgs(o, tracks, false), nil
+
+	case "cmaf":
+		return dashArgs(o, tracks, true), nil
+
+	default:
+		return nil, fmt.Errorf("%w: \"%s\"", ErrUnknownOutputType, o.Type)
+	}
+}
+
+// hlsArgs builds a HLS output
+//
+// A transcoded output writes a variant playlist per rendition referencing
+// one audio group of the tracks, with the master playlist at the output's
+// destination. The channel writes the master itself when it publishes
+// subtitles or marked variant playlists.
+func hlsArgs(o Output, tracks []AudioTrack) []string {
+	args := []string{
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentLength(o)),
+	}
+	flags := []string{}
+	if window := windowSize(o); window == 0 {
+		args = append(args, "-hls_playlist_type", "event", "-hls_list_size", "0")
+	} else {
+		args = append(args, "-hls_list_size", strconv.Itoa(window))
+		flags = append(flags, "delete_segments", "independent_segments")
+	}
+	if o.Markers {
+		// Markers are placed on segments by their times
+		flags = append(flags, "program_date_time")
+	}
+	if len(flags) > 0 {
+		args = append(args, "-hls_flags", strings.Join(flags, "+"))
+	}
+	args = append(args, putArgs(o.Destination)...)
+	dir, file := path.Split(o.Destination)
+	base := strings.TrimSuffix(file, path.Ext(file))
+	if o.Passthrough {
+		if !o.Markers {
+			return append(args, o.Destination)
+		}
+		// Named after the published playlist rather than the hidden one
+		return append(args, "-hls_segment_filename", dir+base+"%d.ts", unmarked(o.Destination))
+	}
+
+	streamMap := []string{}
+	for idx, t := range tracks {
+		stream := fmt.Sprintf("a:%d,agroup:audio,name:%s", idx, t.Name)
+		if t.Language != "" {
+			stream += ",language:" + t.Language
+		}
+		if len(tracks) > 1 && idx == 0 {
+			stream += ",default:yes"
+		}
+		streamMap = append(streamMap, stream)
+	}
+	for idx, rendition := range o.Renditions {
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,agroup:audio,name:%dp", idx, rendition.Height))
+	}
+	if !o.writesMaster() {
+		args = append(args, "-master_pl_name", file)
+	}
+	return append(args,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-hls_segment_filename", dir+base+"_%v_%05d.ts",
+		variantPlaylist(o),
+	)
+}
+
+// masterPlaylist is the master playlist of a HLS output the channel
+// writes the master of, the same as ffmpeg's with its subtitle
+// renditions and closed captions added
+func (ch *Channel) masterPlaylist(o Output) ([]byte, error) {
+	enc, err := o.encoding()
+	if err != nil {
+		return nil, err
+	}
+	_, file := path.Split(o.Destination)
+	base := strings.TrimSuffix(file, path.Ext(file))
+	m3u8 := bytes.Buffer{}
+	m3u8.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n")
+	for idx, t := range ch.audioTracks(o) {
+		def := "NO"
+		if idx == 0 {
+			def = "YES"
+		}
+		language := ""
+		if t.Language != "" {
+			language = fmt.Sprintf("LANGUAGE=\"%s\",", t.Language)
+		}
+		fmt.Fprintf(&m3u8, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",%sDEFAULT=%s,AUTOSELECT=YES,URI=\"%s_%s.m3u8\"\n",
+			t.Name, language, def, base, t.Name)
+	}
+	attrs := ",AUDIO=\"audio\""
+	languages := []string{}
+	if o.publishesSubtitles() {
+		languages = ch.CaptionOptions.languages()
+	}
+	for idx, language := range languages {
+		def := "NO"
+		if idx == 0 {
+			def = "YES"
+		}
+		fmt.Fprintf(&m3u8, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n",
+			language, language, def, path.Base(subtitlePlaylist(o, language)))
+	}
+	if len(languages) > 0 {
+		attrs += ",SUBTITLES=\"subs\""
+	}
+	if cc := ch.ClosedCaptions; cc != "" && o.Captions {
+		fmt.Fprintf(&m3u8, "#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"cc\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=YES,AUTOSELECT=YES,INSTREAM-ID=\"CC1\"\n",
+			cc, cc)
+		attrs += ",CLOSED-CAPTIONS=\"cc\""
+	} else {
+		attrs += ",CLOSED-CAPTIONS=NONE"
+	}
+	for _, rendition := range enc.Renditions {
+		bandwidth := (rendition.Bitrate + enc.AudioBitrate) * 1000
+		fmt.Fprintf(&m3u8, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d%s\n%s_%dp.m3u8\n",
+			bandwidth, rendition.Width, rendition.Height, attrs, base, rendition.Height)
+	}
+	return m3u8.Bytes(), nil
+}
+
+// dashArgs builds a DASH output
+//
+// Segments are fragmented MP4 with video and each audio track in separate
+// adaptation sets. When cmaf is set a HLS master playlist is written next
+// to the MPD referencing the same segments, so one encode serves both
+// manifests.
+func dashArgs(o Output, tracks []AudioTrack, cmaf bool) []string {
+	sets := []string{"id=0,streams=v"}
+	if o.Passthrough || len(tracks) == 1 {
+		sets = append(sets, "id=1,streams=a")
+	} else {
+		// Audio is mapped after the renditions, languages can't share a set
+		for idx := range tracks {
+			sets = append(sets, fmt.Sprintf("id=%d,streams=%d", idx+1, len(o.Renditions)+idx))
+		}
+	}
+	args := []string{
+		"-f", "dash",
+		"-dash_segment_type", "mp4",
+		"-seg_duration", strconv.Itoa(segmentLength(o)),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-streaming", "1",
+		"-window_size", strconv.Itoa(windowSize(o)),
+		"-adaptation_sets", strings.Join(sets, " "),
+	}
+	if windowSize(o) != 0 {
+		// Keep some segments around after they leave the manifest
+		// for players which are slightly behind
+		args = append(args, "-extra_window_size", strconv.Itoa(liveWindow), "-remove_at_exit", "1")
+	}
+	if cmaf {
+		_, file := path.Split(o.Destination)
+		base := strings.TrimSuffix(file, path.Ext(file))
+		args = append(args, "-hls_playlist", "1", "-hls_master_name", base+".m3u8")
+	}
+	args = append(args, putArgs(o.Destination)...)
+	if o.Markers {
+		return append(args, unmarked(o.Destination))
+	}
+	return append(args, o.Destination)
+}
+
+// variantPlaylist is where a transcoded HLS output's variant playlists
+// are written, hidden if the channel publishes marked copies
+func variantPlaylist(o Output) string {
+	dir, file := path.Split(o.Destination)
+	playlist := dir + strings.TrimSuffix(file, path.Ext(file)) + "_%v.m3u8"
+	if o.Markers {
+		return unmarked(playlist)
+	}
+	return playlist
+}
+
+// putArgs are the arguments to upload to a HTTP destination, local
+// destinations are written straight to disk
+func putArgs(dst string) []string {
+	if !isHTTP(dst) {
+		return nil
+	}
+	return []string{"-method", "PUT", "-http_persistent", "1"}
+}
+
+// segmentLength is the output's segment duration in seconds
+func segmentLength(o Output) int {
+	if o.SegmentDuration == 0 {
+		return defaultSegmentDuration
+	}
+	return o.SegmentDuration
+}
+
+// windowSize is the amount of segments an output's manifest keeps
+// available, 0 keeps every segment
+func windowSize(o Output) int {
+	if !o.DVR {
+		return liveWindow
+	}
+	if o.DVRWindow == 0 {
+		return 0
+	}
+	segment := segmentLength(o)
+	// Round up so the whole window is always available
+	return (o.DVRWindow + segment - 1) / segment
+}
+
+// videoCodec converts a rendition's codec to an ffmpeg encoder
+func videoCodec(codec string) (string, error) {
+	switch strings.ToLower(codec) {
+	case "h264":
+		return "libx264", nil
+	case "h265":
+		return "libx265", nil
+		// TODO: nvenc codec's
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", ErrUnknownCodec, codec)
+	}
+}
+
+// audioCodec converts a profile's audio codec to an ffmpeg encoder
+func audioCodec(codec string) (string, error) {
+	switch strings.ToLower(codec) {
+	case "aac":
+		return "aac", nil
+	case "opus":
+		return "libopus", nil
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", ErrUnknownCodec, codec)
+	}
+}
+
+// outputKey identifies an output within its channel
+func outputKey(idx int, o Output) string {
+	if o.ID != 0 {
+		return fmt.Sprintf("output-%d", o.ID)
+	}
+	if o.Name != "" {
+		return o.Name
+	}
+	return fmt.Sprintf("output-%d", idx)
+}
+
+func isHTTP(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
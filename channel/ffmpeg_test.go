@@ -0,0 +1,396 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// ladder is a typical adaptive rendition ladder
+var ladder = []Rendition{
+	{Width: 1920, Height: 1080, Bitrate: 6000, FPS: 25, Codec: "h264"},
+	{Width: 1280, Height: 720, Bitrate: 3000, FPS: 25, Codec: "h264"},
+	{Width: 854, Height: 480, Bitrate: 1200, FPS: 25, Codec: "h264"},
+}
+
+// single is a rendition for outputs which only carry one
+var single = []Rendition{{Width: 1280, Height: 720, Bitrate: 3000, FPS: 25, Codec: "h264"}}
+
+// testChannel is a channel fed by an RTMP ingest, which isn't stored
+// or started
+func testChannel() *Channel {
+	return &Channel{
+		ShortName:  "test",
+		IngestURL:  "rtmp://ingest.example.com/live/test",
+		IngestType: "rtmp",
+		conf:       &Config{LogoDir: "/var/lib/playout/logos"},
+	}
+}
+
+var compileTests = []struct {
+	name    string
+	channel func(ch *Channel)
+	output  Output
+}{
+	{
+		name:   "rtmp_passthrough",
+		output: Output{Type: "rtmp", Passthrough: true, Destination: "rtmp://live.example.com/app/stream"},
+	},
+	{
+		name:   "rtp",
+		output: Output{Type: "rtp", Destination: "rtp://239.0.0.1:5004", Renditions: single},
+	},
+	{
+		name: "srt",
+		output: Output{Type: "srt", Destination: "srt://distribution.example.com:9000", Renditions: single,
+			SRTOptions: SRTOptions{SRTPassphrase: "output-passphrase", SRTLatency: 200}},
+	},
+	{
+		name: "srt_ingest",
+		channel: func(ch *Channel) {
+			ch.IngestType = "srt"
+			ch.IngestURL = "srt://0.0.0.0:9000"
+			ch.SRTOptions = SRTOptions{SRTMode: SRTListener, SRTLatency: 120}
+		},
+		output: Output{Type: "rtmp", Passthrough: true, Destination: "rtmp://live.example.com/app/stream"},
+	},
+	{
+		name:   "hls_ladder",
+		output: Output{Type: "hls", Destination: "/srv/hls/test/index.m3u8", Renditions: ladder},
+	},
+	{
+		name:   "hls_passthrough",
+		output: Output{Type: "hls", Passthrough: true, DVR: true, DVRWindow: 3600, Destination: "/srv/hls/test/index.m3u8"},
+	},
+	{
+		name:   "dash_ladder",
+		output: Output{Type: "dash", Destination: "/srv/dash/test/manifest.mpd", Renditions: ladder},
+	},
+	{
+		name:   "cmaf_ladder",
+		output: Output{Type: "cmaf", SegmentDuration: 2, Destination: "https://origin.example.com/test/manifest.mpd", Renditions: ladder},
+	},
+	{
+		name: "loudness_live",
+		channel: func(ch *Channel) {
+			ch.LoudnessPolicy = LoudnessPolicy{LoudnessMode: LoudnessLive, LoudnessTarget: -16}
+		},
+		output: Output{Type: "rtmp", Destination: "rtmp://live.example.com/app/stream", Renditions: single},
+	},
+	{
+		name: "loudness_measured",
+		channel: func(ch *Channel) {
+			ch.LoudnessPolicy = LoudnessPolicy{LoudnessMode: LoudnessMeasured}
+		},
+		output: Output{Type: "rtmp", Destination: "rtmp://live.example.com/app/stream", Renditions: single},
+	},
+	{
+		name: "restream_rtmp",
+		output: Output{Type: "restream", Destination: "rtmp://a.rtmp.youtube.com/live2", StreamKey: "abcd-efgh-ijkl-mnop",
+			Renditions: single},
+	},
+	{
+		name: "restream_srt",
+		output: Output{Type: "restream", Destination: "srt://ingest.example.com:9999", StreamKey: "key with spaces",
+			Renditions: single},
+	},
+	{
+		name: "logo",
+		channel: func(ch *Channel) {
+			ch.LogoOptions = LogoOptions{LogoURL: "https://example.com/logo.png", LogoPosition: LogoBottomLeft}
+			ch.LoudnessPolicy = LoudnessPolicy{LoudnessMode: LoudnessLive}
+		},
+		output: Output{Type: "hls", Logo: true, Destination: "/srv/hls/test/index.m3u8", Renditions: ladder},
+	},
+	{
+		name: "hls_captions",
+		channel: func(ch *Channel) {
+			ch.CaptionOptions = CaptionOptions{SubtitleLanguages: "en,cy", ClosedCaptions: "en"}
+		},
+		output: Output{Type: "hls", Captions: true, Destination: "/srv/hls/test/index.m3u8", Renditions: ladder},
+	},
+	{
+		name: "audio_tracks",
+		channel: func(ch *Channel) {
+			ch.AudioTracks = []AudioTrack{
+				{Track: 0, Language: "en", Name: "english"},
+				{Track: 2, Language: "en", Name: "commentary"},
+			}
+		},
+		output: Output{Type: "dash", Destination: "/srv/dash/test/manifest.mpd", Renditions: ladder},
+	},
+	{
+		name: "hls_audio_tracks",
+		channel: func(ch *Channel) {
+			ch.AudioTracks = []AudioTrack{
+				{Track: 0, Language: "en", Name: "english"},
+				{Track: 1, Language: "cy", Name: "cymraeg"},
+			}
+		},
+		output: Output{Type: "hls", Destination: "/srv/hls/test/index.m3u8", Renditions: single},
+	},
+	{
+		name:   "hls_markers",
+		output: Output{Type: "hls", Markers: true, Destination: "/srv/hls/test/index.m3u8", Renditions: ladder},
+	},
+	{
+		name:   "dash_markers",
+		output: Output{Type: "dash", Passthrough: true, Markers: true, Destination: "/srv/dash/test/manifest.mpd"},
+	},
+	{
+		name: "hls_profile",
+		output: Output{Type: "hls", Destination: "/srv/hls/test/index.m3u8", Profile: "web-abr-720p50",
+			profile: &Profile{
+				Name:            "web-abr-720p50",
+				Codec:           "h265",
+				VideoProfile:    "main",
+				Preset:          "veryfast",
+				GOP:             100,
+				AudioCodec:      "aac",
+				AudioBitrate:    192,
+				AudioSampleRate: 44100,
+				AudioChannels:   2,
+				Renditions: []Rendition{
+					{Width: 1280, Height: 720, Bitrate: 3500, FPS: 50},
+					{Width: 640, Height: 360, Bitrate: 800, FPS: 25, Codec: "h264"},
+				},
+			}},
+	},
+}
+
+// TestCompileOutput compares each output's argv to its golden file,
+// one argument a line. Run with -update to rewrite them.
+func TestCompileOutput(t *testing.T) {
+	for _, test := range compileTests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := testChannel()
+			if test.channel != nil {
+				test.channel(ch)
+			}
+			cmd, err := ch.compileOutput(test.output)
+			if err != nil {
+				t.Fatalf("failed to compile: %+v", err)
+			}
+			got := strings.Join(cmd.Args, "\n") + "\n"
+			golden := filepath.Join("testdata", test.name+".golden")
+			if *update {
+				err = ioutil.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatalf("failed to update golden file: %+v", err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %+v", err)
+			}
+			if got != string(want) {
+				t.Errorf("args differ from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestCompileOutputInvalid checks outputs which can't be built are
+// rejected with the reason
+func TestCompileOutputInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		ingest string
+		output Output
+		err    error
+	}{
+		{name: "unknown type", output: Output{Type: "ndi", Renditions: single}, err: ErrUnknownOutputType},
+		{name: "unknown ingest", ingest: "ndi", output: Output{Type: "rtmp", Passthrough: true}, err: ErrUnknownIngestType},
+		{name: "unknown codec", output: Output{Type: "rtmp", Renditions: []Rendition{{Width: 1280, Height: 720, Codec: "vp9"}}}, err: ErrUnknownCodec},
+		{name: "no renditions", output: Output{Type: "hls"}, err: ErrNoRenditions},
+		{name: "rtmp ladder", output: Output{Type: "rtmp", Renditions: ladder}, err: ErrTooManyRenditions},
+		{name: "rtp ladder", output: Output{Type: "rtp", Renditions: ladder}, err: ErrTooManyRenditions},
+		{name: "negative segment", output: Output{Type: "dash", SegmentDuration: -1, Renditions: ladder}, err: ErrInvalidSegment},
+		{name: "profile and renditions", output: Output{Type: "hls", Profile: "web-abr-720p50", Renditions: ladder}, err: ErrProfileConflict},
+		{name: "unknown profile", output: Output{Type: "hls", Profile: "web-abr-720p50"}, err: ErrProfileNotFound},
+		{name: "srt options on rtmp", output: Output{Type: "rtmp", Destination: "rtmp://live.example.com/app/stream", Renditions: single,
+			SRTOptions: SRTOptions{SRTLatency: 200}}, err: ErrInvalidSRT},
+		{name: "negative dvr window", output: Output{Type: "hls", DVR: true, DVRWindow: -1, Renditions: ladder}, err: ErrInvalidSegment},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := testChannel()
+			if test.ingest != "" {
+				ch.IngestType = test.ingest
+			}
+			_, err := ch.compileOutput(test.output)
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestCommandStringRedacts checks stream keys and passphrases don't
+// appear when a command is shown, in any form they take in the args
+func TestCommandStringRedacts(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel func(ch *Channel)
+		output  Output
+		secrets []string
+	}{
+		{
+			name: "srt passphrase",
+			output: Output{Type: "srt", Destination: "srt://distribution.example.com:9000", Renditions: single,
+				SRTOptions: SRTOptions{SRTPassphrase: "output passphrase"}},
+			secrets: []string{"output passphrase", "output+passphrase"},
+		},
+		{
+			name: "rtmp stream key",
+			output: Output{Type: "restream", Destination: "rtmp://a.rtmp.youtube.com/live2", StreamKey: "abcd-efgh-ijkl-mnop",
+				Renditions: single},
+			secrets: []string{"abcd-efgh-ijkl-mnop"},
+		},
+		{
+			name: "srt stream key",
+			output: Output{Type: "restream", Destination: "srt://ingest.example.com:9999", StreamKey: "key with spaces",
+				Renditions: single},
+			secrets: []string{"key with spaces", "key+with+spaces"},
+		},
+		{
+			name: "srt stream key and passphrase",
+			output: Output{Type: "restream", Destination: "srt://ingest.example.com:9999", StreamKey: "stream/key",
+				Renditions: single, SRTOptions: SRTOptions{SRTPassphrase: "restream-passphrase"}},
+			secrets: []string{"stream/key", "stream%2Fkey", "restream-passphrase"},
+		},
+		{
+			name: "srt ingest passphrase",
+			channel: func(ch *Channel) {
+				ch.IngestURL, ch.IngestType = "srt://ingest.example.com:9000", "srt"
+				ch.SRTOptions = SRTOptions{SRTPassphrase: "ingest pass/phrase"}
+			},
+			output:  Output{Type: "rtmp", Destination: "rtmp://live.example.com/app/stream", Renditions: single},
+			secrets: []string{"ingest pass/phrase", "ingest+pass%2Fphrase"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := testChannel()
+			if test.channel != nil {
+				test.channel(ch)
+			}
+			cmd, err := ch.compileOutput(test.output)
+			if err != nil {
+				t.Fatalf("failed to compile: %+v", err)
+			}
+			// Make sure the secret is actually in the command
+			args := strings.Join(cmd.Args, " ")
+			found := false
+			for _, secret := range test.secrets {
+				found = found || strings.Contains(args, secret)
+			}
+			if !found {
+				t.Fatalf("none of %q are in the args %q", test.secrets, args)
+			}
+			s := cmd.String()
+			// ffmpeg's output is redacted without parsing URLs
+			logged := cmd.redact(args)
+			for _, secret := range test.secrets {
+				if strings.Contains(s, secret) {
+					t.Errorf("%q isn't redacted from %s", secret, s)
+				}
+				if strings.Contains(logged, secret) {
+					t.Errorf("%q isn't redacted from the log line %s", secret, logged)
+				}
+			}
+			if !strings.Contains(s, redacted) {
+				t.Errorf("nothing is redacted from %s", s)
+			}
+		})
+	}
+}
+
+// TestCompileWhileUpdating checks a channel can be compiled while its
+// config is changed, run with -race
+func TestCompileWhileUpdating(t *testing.T) {
+	mcr := testMCR(nil)
+	ch := addTestChannel(t, mcr)
+	upd := ch.settings()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, err := ch.Compile()
+			if err != nil {
+				t.Errorf("failed to compile: %+v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		upd.Loudness.LoudnessMode = []string{LoudnessOff, LoudnessLive}[i%2]
+		upd.Loudness = upd.Loudness.withDefaults()
+		err := mcr.applyChannel(context.Background(), ch, upd)
+		if err != nil {
+			t.Fatalf("failed to apply update: %+v", err)
+		}
+	}
+	<-done
+}
+
+// TestInfoRedactsIngests checks the passphrases of a channel's
+// ingests aren't shown
+func TestInfoRedactsIngests(t *testing.T) {
+	ch := testChannel()
+	ch.IngestURL, ch.IngestType = "srt://ingest.example.com:9000", "srt"
+	ch.SRTOptions = SRTOptions{SRTPassphrase: "ingest-passphrase"}
+	ch.BackupIngests = []IngestSource{
+		{URL: "srt://backup.example.com:9000", Type: "srt", SRTOptions: SRTOptions{SRTPassphrase: "backup-passphrase"}},
+		{URL: "rtmp://backup.example.com/live/test", Type: "rtmp"},
+	}
+	info := ch.Info()
+	if info.SRTOptions.SRTPassphrase != redacted {
+		t.Errorf("ingest passphrase is %q, want it redacted", info.SRTOptions.SRTPassphrase)
+	}
+	if info.BackupIngests[0].SRTPassphrase != redacted || info.BackupIngests[1].SRTPassphrase != "" {
+		t.Errorf("backup passphrases are %q and %q, want the first redacted",
+			info.BackupIngests[0].SRTPassphrase, info.BackupIngests[1].SRTPassphrase)
+	}
+	if ch.SRTPassphrase != "ingest-passphrase" || ch.BackupIngests[0].SRTPassphrase != "backup-passphrase" {
+		t.Error("redacting changed the channel's own passphrases")
+	}
+}
+
+// TestCommandTask checks a command is split around the primary input,
+// with the logo's input kept after it
+func TestCommandTask(t *testing.T) {
+	ch := testChannel()
+	ch.LogoOptions = LogoOptions{LogoURL: "https://example.com/logo.png"}
+	cmd, err := ch.compileOutput(Output{Type: "hls", Logo: true, Destination: "/srv/hls/test/index.m3u8", Renditions: ladder})
+	if err != nil {
+		t.Fatalf("failed to compile: %+v", err)
+	}
+	task, err := cmd.task()
+	if err != nil {
+		t.Fatalf("failed to convert to a task: %+v", err)
+	}
+	if task.SrcURL != ch.IngestURL {
+		t.Errorf("src url is %q, want %q", task.SrcURL, ch.IngestURL)
+	}
+	if task.SrcArgs != "-hide_banner -nostdin -f flv" {
+		t.Errorf("src args are %q", task.SrcArgs)
+	}
+	if !strings.HasPrefix(task.DstArgs, "-f image2 -loop 1 -framerate 1 -i /var/lib/playout/logos/test.png ") {
+		t.Errorf("dst args don't start with the logo's input: %q", task.DstArgs)
+	}
+	if task.DstURL != "/srv/hls/test/index_%v.m3u8" {
+		t.Errorf("dst url is %q", task.DstURL)
+	}
+
+	_, err = Command{Args: []string{"-hide_banner", "out.ts"}}.task()
+	if err == nil {
+		t.Error("command without an input converted to a task")
+	}
+}
@@ -0,0 +1,264 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Health is the classification of an output's encoder
+type Health string
+
+// Output health
+const (
+	HealthUnknown  Health = "unknown"  // Transcoder doesn't report progress
+	HealthHealthy  Health = "healthy"  // Encoding in real-time
+	HealthDegraded Health = "degraded" // Encoding, but slow or dropping frames
+	HealthFailed   Health = "failed"   // Not encoding
+)
+
+type (
+	// Progress is an encoder's most recent statistics
+	Progress struct {
+		Frame     int64     `json:"frame"`
+		FPS       float64   `json:"fps"`
+		Bitrate   float64   `json:"bitrate"` // Kb/s
+		Speed     float64   `json:"speed"`   // Multiple of real-time
+		Dropped   int64     `json:"dropped"`
+		Restarts  int       `json:"restarts"`
+		StartedAt time.Time `json:"startedAt"` // When the encoder last started, zero if unknown
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	// ProgressReporter is a transcoder which can report the
+	// progress of its commands
+	ProgressReporter interface {
+		// Progress returns the latest and the previous progress of an output
+		Progress(output string) (cur Progress, prev Progress, err error)
+	}
+	// HealthThresholds are the limits an output is classified by
+	HealthThresholds struct {
+		MinSpeed   float64       // Below this an output is degraded
+		MaxDropped float64       // Fraction of dropped frames between updates before an output is degraded
+		StaleAfter time.Duration // Without progress for this long an output has failed
+	}
+	// OutputHealth is the health of a single output
+	OutputHealth struct {
+		OutputID int      `json:"outputID"`
+		Name     string   `json:"name"`
+		Type     string   `json:"type"`
+		Health   Health   `json:"health"`
+		Reason   string   `json:"reason"`
+		Progress Progress `json:"progress"`
+	}
+)
+
+// defaultThresholds are used when the config doesn't set them
+var defaultThresholds = HealthThresholds{
+	MinSpeed:   0.95,
+	MaxDropped: 0.01,
+	StaleAfter: 15 * time.Second,
+}
+
+// statPattern matches the key=value pairs of ffmpeg's stats line,
+// the value can be padded with spaces.
+var statPattern = regexp.MustCompile(`(\w+)=\s*(\S+)`)
+
+// parseProgress reads an ffmpeg stats line, such as
+//
+//	frame= 1234 fps= 25 q=28.0 size= 12345kB time=00:00:49.36 bitrate=2048.0kbits/s drop=3 speed=1.00x
+func parseProgress(line string) (Progress, bool) {
+	if !strings.Contains(line, "time=") || !strings.Contains(line, "speed=") {
+		return Progress{}, false
+	}
+	p := Progress{}
+	for _, match := range statPattern.FindAllStringSubmatch(line, -1) {
+		value := match[2]
+		switch match[1] {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			p.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "drop":
+			p.Dropped, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return p, true
+}
+
+// classify decides an output's health from its latest two updates
+//
+// An encoder which has just started is given until StaleAfter to
+// report its first progress before it has failed.
+func classify(cur, prev Progress, t HealthThresholds, now time.Time) (Health, string) {
+	if cur.UpdatedAt.IsZero() {
+		if !cur.StartedAt.IsZero() && now.Sub(cur.StartedAt) <= t.StaleAfter {
+			return HealthUnknown, "starting"
+		}
+		return HealthFailed, "no progress reported"
+	}
+	if stale := now.Sub(cur.UpdatedAt); stale > t.StaleAfter {
+		return HealthFailed, fmt.Sprintf("no progress for %s", stale.Round(time.Second))
+	}
+	if cur.Speed < t.MinSpeed {
+		return HealthDegraded, fmt.Sprintf("encoding at %.2fx", cur.Speed)
+	}
+	if frames := cur.Frame - prev.Frame; frames > 0 && prev.Frame > 0 {
+		dropped := float64(cur.Dropped-prev.Dropped) / float64(frames)
+		if dropped > t.MaxDropped {
+			return HealthDegraded, fmt.Sprintf("dropping %.1f%% of frames", dropped*100)
+		}
+	}
+	return HealthHealthy, ""
+}
+
+// thresholds are what the channel's outputs are classified by
+func (ch *Channel) thresholds() HealthThresholds {
+	if ch.conf != nil && ch.conf.HealthThresholds != (HealthThresholds{}) {
+		return ch.conf.HealthThresholds
+	}
+	return defaultThresholds
+}
+
+// healthInterval is how often a live channel's outputs are classified
+const healthInterval = 5 * time.Second
+
+// Health classifies each of the channel's outputs
+func (ch *Channel) Health() []OutputHealth {
+	thresholds := ch.thresholds()
+	reporter, canReport := ch.tc.(ProgressReporter)
+	live := ch.isLive()
+	now := time.Now()
+
+	outputs := ch.outputs()
+	healths := make([]OutputHealth, 0, len(outputs))
+	for idx, o := range outputs {
+		h := OutputHealth{
+			OutputID: o.ID,
+			Name:     o.Name,
+			Type:     o.Type,
+			Health:   HealthUnknown,
+		}
+		switch {
+		case !live:
+			h.Reason = "channel isn't running"
+		case o.isRestream() && !ch.isRestreaming(outputKey(idx, o)):
+			h.Reason = o.idleReason()
+		case !canReport:
+			h.Reason = "transcoder doesn't report progress"
+		default:
+			cur, prev, err := reporter.Progress(outputKey(idx, o))
+			if err != nil {
+				h.Health, h.Reason = HealthFailed, err.Error()
+				break
+			}
+			h.Progress = cur
+			h.Health, h.Reason = classify(cur, prev, thresholds, now)
+		}
+		healths = append(healths, h)
+	}
+	return healths
+}
+
+// startHealthChecks classifies the outputs in the background while
+// the channel is live
+func (ch *Channel) startHealthChecks() {
+	ch.stopHealthChecks()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ch.healthLock.Lock()
+	ch.healthCancel = cancel
+	ch.healthDone = done
+	ch.healthLock.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ch.checkHealth()
+			}
+		}
+	}()
+}
+
+// stopHealthChecks stops classifying the outputs and waits for it to finish
+func (ch *Channel) stopHealthChecks() {
+	ch.healthLock.Lock()
+	cancel, done := ch.healthCancel, ch.healthDone
+	ch.healthCancel, ch.healthDone = nil, nil
+	ch.healthLock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// checkHealth records each output's health, publishing those which
+// have failed since the last check, then settles the channel's state
+func (ch *Channel) checkHealth() {
+	outputs := ch.outputs()
+	healths := ch.Health()
+
+	// The outputs could have changed meanwhile
+	failed := []int{}
+	ch.confLock.Lock()
+	for i, h := range healths {
+		if i >= len(ch.Outputs) || ch.Outputs[i].ID != h.OutputID {
+			continue
+		}
+		if h.Health == HealthFailed && ch.Outputs[i].Status != string(HealthFailed) {
+			failed = append(failed, i)
+		}
+		ch.Outputs[i].Status = string(h.Health)
+	}
+	ch.confLock.Unlock()
+
+	for _, i := range failed {
+		ch.notifyOutputFailed(outputKey(i, outputs[i]), healths[i].Reason)
+	}
+	ch.settle("outputs recovered")
+}
+
+// settle moves a live channel to running, or to degraded while it's
+// off its primary input or any of its outputs are unhealthy
+func (ch *Channel) settle(reason string) {
+	status, _ := ch.Stat()
+	if status.State != StateRunning && status.State != StateDegraded {
+		return
+	}
+	problems := []string{}
+	if in := ch.input(); in != (Input{}) {
+		problems = append(problems, "on "+in.String())
+	}
+	for _, o := range ch.outputs() {
+		switch Health(o.Status) {
+		case HealthFailed, HealthDegraded:
+			problems = append(problems, fmt.Sprintf("\"%s\" %s", o.Name, o.Status))
+		}
+	}
+	if len(problems) == 0 {
+		ch.transition(StateRunning, reason)
+		return
+	}
+	ch.transition(StateDegraded, strings.Join(problems, ", "))
+}
+
+// GetOutputHealth retrieves the health of a channel's outputs
+func (mcr *MCR) GetOutputHealth(ctx context.Context, shortName string) ([]OutputHealth, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	return ch.Health(), nil
+}
@@ -0,0 +1,68 @@
+package channel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		cur    Progress
+		prev   Progress
+		health Health
+		reason string
+	}{
+		{
+			name:   "healthy",
+			cur:    Progress{Frame: 500, Speed: 1, UpdatedAt: now},
+			prev:   Progress{Frame: 475, Speed: 1, UpdatedAt: now.Add(-time.Second)},
+			health: HealthHealthy,
+		},
+		{
+			name:   "starting",
+			cur:    Progress{StartedAt: now.Add(-5 * time.Second)},
+			health: HealthUnknown,
+			reason: "starting",
+		},
+		{
+			name:   "never started",
+			health: HealthFailed,
+			reason: "no progress reported",
+		},
+		{
+			name:   "no progress since starting",
+			cur:    Progress{StartedAt: now.Add(-20 * time.Second)},
+			health: HealthFailed,
+			reason: "no progress reported",
+		},
+		{
+			name:   "stale",
+			cur:    Progress{Frame: 500, Speed: 1, StartedAt: now.Add(-time.Minute), UpdatedAt: now.Add(-20 * time.Second)},
+			health: HealthFailed,
+			reason: "no progress for 20s",
+		},
+		{
+			name:   "slow",
+			cur:    Progress{Frame: 500, Speed: 0.8, UpdatedAt: now},
+			health: HealthDegraded,
+			reason: "encoding at 0.80x",
+		},
+		{
+			name:   "dropping",
+			cur:    Progress{Frame: 600, Speed: 1, Dropped: 10, UpdatedAt: now},
+			prev:   Progress{Frame: 500, Speed: 1, UpdatedAt: now.Add(-4 * time.Second)},
+			health: HealthDegraded,
+			reason: "dropping 10.0% of frames",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			health, reason := classify(test.cur, test.prev, defaultThresholds, now)
+			if health != test.health || reason != test.reason {
+				t.Errorf("got %s \"%s\", want %s \"%s\"", health, reason, test.health, test.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrInvalidIngests is when a channel's backup ingests don't make sense
+	ErrInvalidIngests = errors.New("invalid ingest sources")
+	// ErrInvalidInput is when an input can't be pinned
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// IngestSource is somewhere a channel can take its ingest from
+type IngestSource struct {
+	URL        string `db:"url" json:"url"`
+	Type       string `db:"type" json:"type"` // RTP / RTMP / HLS / SRT
+	SRTOptions        // Connection, SRT only
+}
+
+// Input is what is feeding a channel's outputs
+type Input struct {
+	Source int  `json:"source"` // Index of the ingest source, 0 is the primary
+	Slate  bool `json:"slate"`
+}
+
+// String names the input for events
+func (i Input) String() string {
+	switch {
+	case i.Slate:
+		return "slate"
+	case i.Source == 0:
+		return "primary"
+	default:
+		return fmt.Sprintf("backup %d", i.Source)
+	}
+}
+
+// ingestSources are the channel's sources in the order they're
+// preferred, the primary first
+func (ch *Channel) ingestSources() []IngestSource {
+	primary := IngestSource{URL: ch.IngestURL, Type: ch.IngestType, SRTOptions: ch.SRTOptions}
+	return append([]IngestSource{primary}, ch.BackupIngests...)
+}
+
+// validateFailover checks backups can be failed over to
+//
+// SRT sources which accept the connection can't be checked, so can't
+// be part of a channel with backups.
+func validateFailover(primary IngestSource, backups []IngestSource, failbackDelay int) error {
+	if failbackDelay < 0 {
+		return fmt.Errorf("%w: failback delay can't be negative", ErrInvalidIngests)
+	}
+	if len(backups) == 0 {
+		return nil
+	}
+	for idx, src := range append([]IngestSource{primary}, backups...) {
+		name := Input{Source: idx}.String()
+		if idx > 0 {
+			err := validateIngest(src, name)
+			if err != nil {
+				return err
+			}
+		}
+		if strings.EqualFold(src.Type, "srt") && src.SRTOptions.accepts() {
+			return fmt.Errorf("%w: %s is an srt %s which can't be checked", ErrInvalidIngests, name, src.SRTOptions.mode())
+		}
+	}
+	return nil
+}
+
+// portBound is when reading the source listens on a port, so only one
+// reader can have it at a time
+func (s IngestSource) portBound() bool {
+	return strings.EqualFold(s.Type, "rtp") || (strings.EqualFold(s.Type, "srt") && s.SRTOptions.accepts())
+}
+
+// validateIngest checks a source can be read
+func validateIngest(src IngestSource, name string) error {
+	if src.URL == "" {
+		return fmt.Errorf("%w: %s has no url", ErrInvalidIngests, name)
+	}
+	u, err := url.Parse(src.URL)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("%w: %s \"%s\" isn't a url", ErrInvalidIngests, name, src.URL)
+	}
+	switch strings.ToLower(src.Type) {
+	case "rtmp", "rtp", "hls", "srt":
+	default:
+		return fmt.Errorf("%w: \"%s\"", ErrUnknownIngestType, src.Type)
+	}
+	err = src.SRTOptions.validate(src.Type)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	_, err = src.ffmpegURL()
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// ingestSecrets are the passphrases of the channel's ingests, which
+// can't be shown in the commands reading them
+func (ch *Channel) ingestSecrets() []string {
+	secrets := []string{}
+	for _, src := range ch.ingestSources() {
+		if src.SRTPassphrase != "" {
+			secrets = append(secrets, src.SRTPassphrase, url.QueryEscape(src.SRTPassphrase))
+		}
+	}
+	return secrets
+}
+
+// redactIngests copies sources to be shown
+func redactIngests(sources []IngestSource) []IngestSource {
+	res := make([]IngestSource, 0, len(sources))
+	for _, src := range sources {
+		src.SRTOptions = src.SRTOptions.Redacted()
+		res = append(res, src)
+	}
+	return res
+}
+
+// sameIngests is when two channels' backups are the same
+func sameIngests(a, b []IngestSource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// ffmpegURL is the source with any connection options applied
+func (s IngestSource) ffmpegURL() (string, error) {
+	err := s.SRTOptions.validate(s.Type)
+	if err != nil {
+		return "", fmt.Errorf("invalid ingest: %w", err)
+	}
+	if !strings.EqualFold(s.Type, "srt") {
+		return s.URL, nil
+	}
+	return s.SRTOptions.ffmpegURL(s.URL)
+}
+
+// args are the arguments to read the source
+func (s IngestSource) args() ([]string, error) {
+	url, err := s.ffmpegURL()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(s.Type) {
+	case "rtmp":
+		return []string{"-f", "flv", "-i", url}, nil
+	case "rtp":
+		return []string{"-f", "rtp", "-i", url}, nil
+	case "hls":
+		return []string{"-f", "hls", "-i", url}, nil
+	case "srt":
+		return []string{"-f", "mpegts", "-i", url}, nil
+	default:
+		return nil, fmt.Errorf("%w: \"%s\"", ErrUnknownIngestType, s.Type)
+	}
+}
+
+// input is what is feeding the outputs
+func (ch *Channel) input() Input {
+	ch.inputLock.Lock()
+	defer ch.inputLock.Unlock()
+	return ch.current
+}
+
+// Input is what is feeding the channel's outputs, and whether an
+// operator has pinned it
+func (ch *Channel) Input() (Input, bool) {
+	ch.inputLock.Lock()
+	defer ch.inputLock.Unlock()
+	return ch.current, ch.pin != nil
+}
+
+// activeSource is the ingest source feeding the outputs, or the
+// primary when the slate is
+func (ch *Channel) activeSource() IngestSource {
+	sources := ch.ingestSources()
+	in := ch.input()
+	if in.Slate || in.Source >= len(sources) {
+		return sources[0]
+	}
+	return sources[in.Source]
+}
+
+// pinInput holds the outputs on an input until it is unpinned, nil
+// goes back to failing over automatically
+func (ch *Channel) pinInput(ctx context.Context, in *Input) error {
+	if in != nil {
+		ch.confLock.RLock()
+		sources, slate, failsOver := len(ch.ingestSources()), ch.SlateURL, ch.failsOver()
+		ch.confLock.RUnlock()
+		if !failsOver {
+			return fmt.Errorf("%w: the channel doesn't fail over", ErrInvalidInput)
+		}
+		if in.Slate && slate == "" {
+			return fmt.Errorf("%w: the channel has no slate", ErrInvalidInput)
+		}
+		if !in.Slate && (in.Source < 0 || in.Source >= sources) {
+			return fmt.Errorf("%w: source %d doesn't exist", ErrInvalidInput, in.Source)
+		}
+		if in.Slate {
+			in = &Input{Slate: true}
+		}
+	}
+
+	resume := ch.pauseMonitor()
+	defer resume()
+
+	ch.inputLock.Lock()
+	ch.pin = in
+	ch.inputLock.Unlock()
+	if in == nil {
+		ch.recordEvent(EventInputUnpinned, "input unpinned, failing over automatically")
+		return nil
+	}
+	ch.recordEvent(EventInputPinned, "input pinned to %s", in)
+	if ch.isLive() && *in != ch.input() {
+		ch.switchInput(ctx, *in, "pinned by operator")
+	}
+	return nil
+}
+
+// PinInput holds a channel's outputs on an input, nil unpins it
+func (mcr *MCR) PinInput(ctx context.Context, shortName string, in *Input) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	return ch.pinInput(ctx, in)
+}
+
+// loadIngestSources reads a channel's backups in order
+func (mcr *MCR) loadIngestSources(ctx context.Context, ch *Channel) error {
+	sources := []IngestSource{}
+	err := mcr.db.SelectContext(ctx, &sources, `
+		SELECT url, type, srt_mode, srt_passphrase, srt_latency
+		FROM playout.channel_ingest_sources
+		WHERE channel_id = $1
+		ORDER BY position;`, ch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to select ingest sources: %w", err)
+	}
+	for idx := range sources {
+		sources[idx].SRTPassphrase, err = mcr.openStreamKey(sources[idx].SRTPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt srt passphrase of backup %d: %w", idx+1, err)
+		}
+	}
+	ch.BackupIngests = sources
+	return nil
+}
+
+// replaceIngestSources stores a channel's backups in order, replacing
+// any it had, with their passphrases encrypted
+func (mcr *MCR) replaceIngestSources(ctx context.Context, tx *sqlx.Tx, channelID int, sources []IngestSource) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM playout.channel_ingest_sources
+		WHERE channel_id = $1;`, channelID)
+	if err != nil {
+		return fmt.Errorf("failed to delete ingest sources: %w", err)
+	}
+	for idx, src := range sources {
+		passphrase, err := mcr.sealStreamKey(src.SRTPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt srt passphrase: %w", err)
+		}
+		// Positions start after the primary
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO playout.channel_ingest_sources(
+				channel_id, position, url, type, srt_mode, srt_passphrase, srt_latency)
+			VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+			channelID, idx+1, src.URL, src.Type, src.SRTMode, passphrase, src.SRTLatency)
+		if err != nil {
+			return fmt.Errorf("failed to insert ingest source: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package channel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// logLines is the amount of stderr lines kept for each process
+	logLines = 100
+	// stopTimeout is how long ffmpeg is given to finish writing
+	// its output before being killed
+	stopTimeout = 10 * time.Second
+	// minBackoff is the first delay before restarting a crashed encoder
+	minBackoff = time.Second
+	// maxBackoff is the longest delay between restarting a crashed encoder
+	maxBackoff = time.Minute
+	// stableAfter is how long an encoder has to run before it is
+	// considered healthy and its backoff is reset
+	stableAfter = 30 * time.Second
+)
+
+// LocalTranscoder runs a channel's commands as ffmpeg processes on
+// this machine, restarting them with an exponential backoff if they exit.
+type LocalTranscoder struct {
+	path  string // ffmpeg binary
+	log   *log.Logger
+	lock  sync.Mutex
+	procs map[string]*process
+
+	// Timings, the constants of the same names unless in tests
+	stopTimeout time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+// process is a supervised ffmpeg process
+type process struct {
+	cmd    Command
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock     sync.Mutex
+	lines    []string // Most recent stderr, excluding stats
+	restarts int
+	started  time.Time
+	progress Progress
+	previous Progress
+}
+
+var (
+	_ Transcoder       = &LocalTranscoder{}
+	_ ProgressReporter = &LocalTranscoder{}
+)
+
+// NewLocalTranscoder creates a new local transcoder, name
+// is used to prefix its logs
+func NewLocalTranscoder(ffmpegPath, name string) *LocalTranscoder {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	return &LocalTranscoder{
+		path:  ffmpegPath,
+		log:   log.New(os.Stderr, fmt.Sprintf("[%s] ", name), log.LstdFlags),
+		procs: make(map[string]*process),
+
+		stopTimeout: stopTimeout,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+	}
+}
+
+// Start spawns a supervised ffmpeg process for the command
+//
+// The context only applies to starting, the process will
+// run until it is stopped.
+func (t *LocalTranscoder) Start(ctx context.Context, cmd Command) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.procs[cmd.Output]; ok {
+		return fmt.Errorf("output \"%s\" is already running", cmd.Output)
+	}
+	err := prepareDestination(cmd.Destination)
+	if err != nil {
+		return fmt.Errorf("failed to prepare destination: %w", err)
+	}
+	supervisorCtx, cancel := context.WithCancel(context.Background())
+	p := &process{
+		cmd:    cmd,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	t.procs[cmd.Output] = p
+	go t.supervise(supervisorCtx, p)
+	return nil
+}
+
+// Stop ends the output's process, waiting for it to exit
+func (t *LocalTranscoder) Stop(ctx context.Context, output string) error {
+	t.lock.Lock()
+	p, ok := t.procs[output]
+	delete(t.procs, output)
+	t.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("output \"%s\" isn't running", output)
+	}
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for output \"%s\" to stop: %w", output, ctx.Err())
+	}
+}
+
+// StopAll ends every process
+func (t *LocalTranscoder) StopAll(ctx context.Context) error {
+	t.lock.Lock()
+	outputs := make([]string, 0, len(t.procs))
+	for output := range t.procs {
+		outputs = append(outputs, output)
+	}
+	t.lock.Unlock()
+	for _, output := range outputs {
+		err := t.Stop(ctx, output)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logs returns the most recent stderr lines of an output's process
+func (t *LocalTranscoder) Logs(output string) ([]string, error) {
+	t.lock.Lock()
+	p, ok := t.procs[output]
+	t.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("output \"%s\" isn't running", output)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	lines := make([]string, len(p.lines))
+	copy(lines, p.lines)
+	return lines, nil
+}
+
+// Progress returns the latest and previous encoding statistics of an
+// output's process
+func (t *LocalTranscoder) Progress(output string) (Progress, Progress, error) {
+	t.lock.Lock()
+	p, ok := t.procs[output]
+	t.lock.Unlock()
+	if !ok {
+		return Progress{}, Progress{}, fmt.Errorf("output \"%s\" isn't running", output)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	cur := p.progress
+	cur.Restarts = p.restarts
+	cur.StartedAt = p.started
+	return cur, p.previous, nil
+}
+
+// supervise keeps the process running until its context is cancelled
+func (t *LocalTranscoder) supervise(ctx context.Context, p *process) {
+	defer close(p.done)
+	backoff := t.minBackoff
+	for {
+		started := time.Now()
+		err := t.run(ctx, p)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > stableAfter {
+			backoff = t.minBackoff
+		}
+		p.lock.Lock()
+		p.restarts++
+		tail := ""
+		if len(p.lines) > 0 {
+			tail = p.lines[len(p.lines)-1]
+		}
+		p.lock.Unlock()
+		t.log.Printf("output \"%s\" exited: %v (%s), restarting in %s", p.cmd.Output, err, tail, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+// run executes ffmpeg once, returning when it exits or once it has
+// been stopped by the context
+func (t *LocalTranscoder) run(ctx context.Context, p *process) error {
+	cmd := exec.Command(t.path, p.cmd.Args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	p.lock.Lock()
+	// Progress from before a restart says nothing about this run
+	p.started = time.Now()
+	p.progress, p.previous = Progress{}, Progress{}
+	p.lock.Unlock()
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		p.capture(stderr)
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		<-read
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+		if err == nil {
+			return errors.New("ffmpeg exited")
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	// Ask ffmpeg to finish nicely so manifests and segments are
+	// finalised, then kill it if it takes too long.
+	err = cmd.Process.Signal(os.Interrupt)
+	if err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(t.stopTimeout):
+		t.log.Printf("output \"%s\" didn't stop in time, killing", p.cmd.Output)
+		cmd.Process.Kill()
+		<-exited
+	}
+	return ctx.Err()
+}
+
+// capture stores ffmpeg's stderr, which uses carriage returns
+// between progress updates as well as newlines
+func (p *process) capture(r io.Reader) {
+	s := bufio.NewScanner(r)
+	s.Split(scanLines)
+	for s.Scan() {
+		// ffmpeg's errors can echo the destination
+		line := p.cmd.redact(strings.TrimSpace(s.Text()))
+		if line == "" {
+			continue
+		}
+		progress, isProgress := parseProgress(line)
+		p.lock.Lock()
+		if isProgress {
+			// Stats are frequent so are kept separate from the log
+			progress.UpdatedAt = time.Now()
+			p.previous, p.progress = p.progress, progress
+			p.lock.Unlock()
+			continue
+		}
+		p.lines = append(p.lines, line)
+		if len(p.lines) > logLines {
+			p.lines = p.lines[len(p.lines)-logLines:]
+		}
+		p.lock.Unlock()
+	}
+}
+
+// scanLines is a bufio.SplitFunc splitting on either \r or \n
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// prepareDestination creates the directory of a local destination
+func prepareDestination(dst string) error {
+	if dst == "" || strings.Contains(dst, "://") {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(dst), 0o755)
+}
@@ -0,0 +1,249 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg is a local transcoder running a shell script instead of
+// ffmpeg, with timings short enough to test
+func fakeFFmpeg(t *testing.T, script string) *LocalTranscoder {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatalf("failed to write fake ffmpeg: %+v", err)
+	}
+	tc := NewLocalTranscoder(path, t.Name())
+	tc.stopTimeout = 5 * time.Second
+	tc.minBackoff = 50 * time.Millisecond
+	tc.maxBackoff = 100 * time.Millisecond
+	return tc
+}
+
+// fakeCommand has a stream key in its destination, which is its third
+// argument
+var fakeCommand = Command{
+	Output:  "output-1",
+	Args:    []string{"-i", "rtmp://ingest.example.com/live/test", "rtmp://live.example.com/app/secret-key"},
+	secrets: []string{"secret-key"},
+}
+
+// logged is when an output's logs have a line containing s
+func logged(tc *LocalTranscoder, output, s string) bool {
+	lines, err := tc.Logs(output)
+	if err != nil {
+		return false
+	}
+	for _, line := range lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestLocalTranscoderRestarts checks a crashing encoder is restarted,
+// backing off further each time, with its errors redacted
+func TestLocalTranscoderRestarts(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	tc := fakeFFmpeg(t, fmt.Sprintf(`date +%%s%%N >> %s
+echo "[flv @ 0x1] Error opening output $3: I/O error" >&2
+exit 1
+`, runs))
+	err := tc.Start(context.Background(), fakeCommand)
+	if err != nil {
+		t.Fatalf("failed to start: %+v", err)
+	}
+	defer tc.StopAll(context.Background())
+
+	eventually(t, "restarts", func() bool {
+		p, _, err := tc.Progress(fakeCommand.Output)
+		return err == nil && p.Restarts >= 4
+	})
+	b, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatalf("failed to read runs: %+v", err)
+	}
+	starts := []int64{}
+	for _, line := range strings.Fields(string(b)) {
+		ns, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			t.Fatalf("failed to parse run time %q: %+v", line, err)
+		}
+		starts = append(starts, ns)
+	}
+	// Doubling from the minimum, then held at the maximum
+	for idx, backoff := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond} {
+		gap := time.Duration(starts[idx+1] - starts[idx])
+		if gap < backoff {
+			t.Errorf("restart %d came after %s, want at least %s", idx+1, gap, backoff)
+		}
+	}
+
+	lines, err := tc.Logs(fakeCommand.Output)
+	if err != nil {
+		t.Fatalf("failed to get logs: %+v", err)
+	}
+	want := "[flv @ 0x1] Error opening output rtmp://live.example.com/app/REDACTED: I/O error"
+	if len(lines) == 0 || lines[0] != want {
+		t.Errorf("logs are %q, want %q first", lines, want)
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "secret-key") {
+			t.Errorf("stream key isn't redacted from %q", line)
+		}
+	}
+}
+
+// TestLocalTranscoderProgress checks stats lines are parsed rather
+// than logged
+func TestLocalTranscoderProgress(t *testing.T) {
+	tc := fakeFFmpeg(t, `echo "Press [q] to stop" >&2
+printf 'frame=  100 fps= 25 q=28.0 size=    1024kB time=00:00:04.00 bitrate=2097.2kbits/s speed=   1x\r' >&2
+printf 'frame=  150 fps= 25 q=28.0 size=    1536kB time=00:00:06.00 bitrate=2097.2kbits/s drop=2 speed=0.98x\r' >&2
+while :; do sleep 0.05; done
+`)
+	err := tc.Start(context.Background(), fakeCommand)
+	if err != nil {
+		t.Fatalf("failed to start: %+v", err)
+	}
+	defer tc.StopAll(context.Background())
+
+	eventually(t, "progress", func() bool {
+		cur, _, err := tc.Progress(fakeCommand.Output)
+		return err == nil && cur.Frame == 150
+	})
+	cur, prev, _ := tc.Progress(fakeCommand.Output)
+	if cur.Speed != 0.98 || cur.Dropped != 2 || cur.Bitrate != 2097.2 || cur.UpdatedAt.IsZero() {
+		t.Errorf("latest progress is %+v", cur)
+	}
+	if cur.StartedAt.IsZero() || cur.StartedAt.After(cur.UpdatedAt) {
+		t.Errorf("process started at %s, want before its progress at %s", cur.StartedAt, cur.UpdatedAt)
+	}
+	if prev.Frame != 100 || prev.Speed != 1 {
+		t.Errorf("previous progress is %+v", prev)
+	}
+	lines, _ := tc.Logs(fakeCommand.Output)
+	if len(lines) != 1 || lines[0] != "Press [q] to stop" {
+		t.Errorf("logs are %q, want only the first line", lines)
+	}
+}
+
+// TestLocalTranscoderProgressResets checks progress from before a
+// restart isn't reported as the restarted encoder's
+func TestLocalTranscoderProgressResets(t *testing.T) {
+	dir := t.TempDir()
+	ran, restarted := filepath.Join(dir, "ran"), filepath.Join(dir, "restarted")
+	tc := fakeFFmpeg(t, fmt.Sprintf(`if [ ! -f %s ]; then
+	touch %[1]s
+	printf 'frame=  100 fps= 25 q=28.0 size=    1024kB time=00:00:04.00 bitrate=2097.2kbits/s speed=   1x\r' >&2
+	exit 1
+fi
+touch %s
+while :; do sleep 0.05; done
+`, ran, restarted))
+	err := tc.Start(context.Background(), fakeCommand)
+	if err != nil {
+		t.Fatalf("failed to start: %+v", err)
+	}
+	defer tc.StopAll(context.Background())
+
+	eventually(t, "restart", func() bool {
+		_, err := os.Stat(restarted)
+		return err == nil
+	})
+	cur, prev, _ := tc.Progress(fakeCommand.Output)
+	if !cur.UpdatedAt.IsZero() || cur.Frame != 0 || prev != (Progress{}) {
+		t.Errorf("restarted encoder has progress %+v, previous %+v", cur, prev)
+	}
+	if h, _ := classify(cur, prev, defaultThresholds, time.Now()); h != HealthUnknown {
+		t.Errorf("restarted encoder is %s, want unknown until it reports progress", h)
+	}
+}
+
+// TestLocalTranscoderStop checks stopping interrupts ffmpeg so it can
+// finish its output
+func TestLocalTranscoderStop(t *testing.T) {
+	stopped := filepath.Join(t.TempDir(), "stopped")
+	tc := fakeFFmpeg(t, fmt.Sprintf(`trap 'echo interrupted > %s; exit 0' INT
+echo ready >&2
+while :; do sleep 0.05; done
+`, stopped))
+	err := tc.Start(context.Background(), fakeCommand)
+	if err != nil {
+		t.Fatalf("failed to start: %+v", err)
+	}
+	eventually(t, "ready", func() bool {
+		return logged(tc, fakeCommand.Output, "ready")
+	})
+
+	start := time.Now()
+	err = tc.Stop(context.Background(), fakeCommand.Output)
+	if err != nil {
+		t.Fatalf("failed to stop: %+v", err)
+	}
+	if took := time.Since(start); took >= tc.stopTimeout {
+		t.Errorf("stop took %s, it should have been interrupted", took)
+	}
+	_, err = os.Stat(stopped)
+	if err != nil {
+		t.Errorf("ffmpeg wasn't interrupted: %+v", err)
+	}
+	_, err = tc.Logs(fakeCommand.Output)
+	if err == nil {
+		t.Error("output is still running after being stopped")
+	}
+	err = tc.Stop(context.Background(), fakeCommand.Output)
+	if err == nil {
+		t.Error("stopped an output twice")
+	}
+}
+
+// TestLocalTranscoderStopKills checks ffmpeg is killed if it doesn't
+// exit after being interrupted
+func TestLocalTranscoderStopKills(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	tc := fakeFFmpeg(t, fmt.Sprintf(`trap '' INT
+echo $$ > %s
+echo ready >&2
+while :; do sleep 0.05; done
+`, pidFile))
+	tc.stopTimeout = 200 * time.Millisecond
+	err := tc.Start(context.Background(), fakeCommand)
+	if err != nil {
+		t.Fatalf("failed to start: %+v", err)
+	}
+	eventually(t, "ready", func() bool {
+		return logged(tc, fakeCommand.Output, "ready")
+	})
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("failed to read pid: %+v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatalf("failed to parse pid: %+v", err)
+	}
+
+	start := time.Now()
+	err = tc.Stop(context.Background(), fakeCommand.Output)
+	if err != nil {
+		t.Fatalf("failed to stop: %+v", err)
+	}
+	if took := time.Since(start); took < tc.stopTimeout {
+		t.Errorf("stop took %s, it should have waited %s before killing", took, tc.stopTimeout)
+	}
+	err = syscall.Kill(pid, 0)
+	if err != syscall.ESRCH {
+		t.Errorf("ffmpeg is still running: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Logos can be JPEGs
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ystv/playout/scheduler"
+)
+
+// Logo positions
+const (
+	LogoTopLeft     = "top-left"
+	LogoTopRight    = "top-right"
+	LogoBottomLeft  = "bottom-left"
+	LogoBottomRight = "bottom-right"
+)
+
+const (
+	// defaultLogoScale is the logo's width as a fraction of the frame's
+	defaultLogoScale = 0.1
+	// defaultLogoMargin is the safe area between the logo and the
+	// frame's edges as a fraction of the frame
+	defaultLogoMargin = 0.05
+)
+
+// ErrInvalidLogo is when a logo or an output overlaying it doesn't
+// make sense
+var ErrInvalidLogo = errors.New("invalid logo")
+
+// LogoOptions are the channel's station logo (DOG), burnt into outputs
+// which enable it
+type LogoOptions struct {
+	LogoURL      string  `db:"logo_url" json:"logoURL"`           // PNG or JPEG path or URL, empty for none
+	LogoPosition string  `db:"logo_position" json:"logoPosition"` // top-left / top-right / bottom-left / bottom-right, empty is top-right
+	LogoScale    float64 `db:"logo_scale" json:"logoScale"`       // Width as a fraction of the frame's, 0 is 0.1
+	LogoOpacity  float64 `db:"logo_opacity" json:"logoOpacity"`   // Fraction, 0 is opaque
+	LogoMargin   float64 `db:"logo_margin" json:"logoMargin"`     // Safe area as a fraction of the frame, 0 is 0.05
+}
+
+// withDefaults fills in unset options
+func (l LogoOptions) withDefaults() LogoOptions {
+	if l.LogoPosition == "" {
+		l.LogoPosition = LogoTopRight
+	}
+	l.LogoPosition = strings.ToLower(l.LogoPosition)
+	if l.LogoScale == 0 {
+		l.LogoScale = defaultLogoScale
+	}
+	if l.LogoOpacity == 0 {
+		l.LogoOpacity = 1
+	}
+	if l.LogoMargin == 0 {
+		l.LogoMargin = defaultLogoMargin
+	}
+	return l
+}
+
+// validate checks the logo can be placed
+func (l LogoOptions) validate() error {
+	l = l.withDefaults()
+	switch l.LogoPosition {
+	case LogoTopLeft, LogoTopRight, LogoBottomLeft, LogoBottomRight:
+	default:
+		return fmt.Errorf("%w: unknown position \"%s\"", ErrInvalidLogo, l.LogoPosition)
+	}
+	if l.LogoScale < 0 || l.LogoScale > 1 {
+		return fmt.Errorf("%w: scale must be a fraction of the frame", ErrInvalidLogo)
+	}
+	if l.LogoOpacity < 0 || l.LogoOpacity > 1 {
+		return fmt.Errorf("%w: opacity must be between 0 and 1", ErrInvalidLogo)
+	}
+	if l.LogoMargin < 0 || l.LogoMargin >= 0.5 {
+		return fmt.Errorf("%w: margin must be less than half the frame", ErrInvalidLogo)
+	}
+	return nil
+}
+
+// validateOutput checks an output can overlay the logo
+func (l LogoOptions) validateOutput(o Output) error {
+	if !o.Logo {
+		return nil
+	}
+	if o.Passthrough {
+		return fmt.Errorf("%w: passthrough outputs can't overlay it", ErrInvalidLogo)
+	}
+	if l.LogoURL == "" {
+		return fmt.Errorf("%w: channel doesn't have one", ErrInvalidLogo)
+	}
+	return l.validate()
+}
+
+// overlayGraph is the filter graph overlaying the logo, input 1, on
+// the ingest's video, labelled out
+func (l LogoOptions) overlayGraph(out string) string {
+	l = l.withDefaults()
+	margin := strconv.FormatFloat(l.LogoMargin, 'f', -1, 64)
+	x, y := "main_w*"+margin, "main_h*"+margin
+	if strings.HasSuffix(l.LogoPosition, "right") {
+		x = "main_w-overlay_w-" + x
+	}
+	if strings.HasPrefix(l.LogoPosition, "bottom") {
+		y = "main_h-overlay_h-" + y
+	}
+	return fmt.Sprintf("[1:v]format=rgba,colorchannelmixer=aa=%s[logo];"+
+		"[logo][0:v]scale2ref=w=main_w*%s:h=ow/a[logo][base];"+
+		"[base][logo]overlay=x=%s:y=%s[%s]",
+		strconv.FormatFloat(l.LogoOpacity, 'f', -1, 64),
+		strconv.FormatFloat(l.LogoScale, 'f', -1, 64),
+		x, y, out)
+}
+
+// logoInputArgs are the arguments to read the channel's logo
+//
+// image2 opens the file for each frame, so swapping it takes effect
+// without restarting the outputs.
+func (ch *Channel) logoInputArgs() []string {
+	return []string{"-f", "image2", "-loop", "1", "-framerate", "1", "-i", ch.logoPath()}
+}
+
+// logoPath is the logo outputs read, either the channel's logo or
+// a blank one while it is hidden
+func (ch *Channel) logoPath() string {
+	dir := ""
+	if ch.conf != nil {
+		dir = ch.conf.LogoDir
+	}
+	return filepath.Join(dir, ch.ShortName+".png")
+}
+
+// usesLogo is when any of the channel's outputs overlay the logo
+func (ch *Channel) usesLogo() bool {
+	for _, o := range ch.outputs() {
+		if o.Logo {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureLogo loads the logo if any output overlays it and it hasn't
+// been already. A logo left from before a restart is used if it can't
+// be fetched.
+func (ch *Channel) ensureLogo(ctx context.Context) error {
+	if !ch.usesLogo() {
+		return nil
+	}
+	ch.logoLock.Lock()
+	loaded := ch.logoImage != nil
+	ch.logoLock.Unlock()
+	if loaded {
+		return nil
+	}
+	err := ch.loadLogo(ctx)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Stat(ch.logoPath()); statErr == nil {
+		log.Printf("channel \"%s\": using previous logo: %+v", ch.ShortName, err)
+		return nil
+	}
+	return err
+}
+
+// loadLogo fetches the channel's logo and writes it for the outputs
+func (ch *Channel) loadLogo(ctx context.Context) error {
+	ch.confLock.RLock()
+	url := ch.LogoURL
+	ch.confLock.RUnlock()
+	var img image.Image
+	if url != "" {
+		rc, err := openLogo(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		img, _, err = image.Decode(rc)
+		if err != nil {
+			return fmt.Errorf("failed to decode logo: %w", err)
+		}
+	}
+	ch.logoLock.Lock()
+	defer ch.logoLock.Unlock()
+	ch.logoImage = img
+	return ch.writeLogo()
+}
+
+// openLogo opens a logo from a http(s) URL or a path
+func openLogo(ctx context.Context, url string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		f, err := os.Open(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open logo: %w", err)
+		}
+		return f, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logo request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch logo: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to fetch logo: %s", res.Status)
+	}
+	return res.Body, nil
+}
+
+// writeLogo replaces the logo outputs read, a transparent one of the
+// same size while it is hidden. logoLock must be held.
+func (ch *Channel) writeLogo() error {
+	if ch.logoImage == nil {
+		return nil
+	}
+	img := ch.logoImage
+	if ch.logoHidden {
+		img = image.NewNRGBA(img.Bounds())
+	}
+	dst := ch.logoPath()
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create logo dir: %w", err)
+	}
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create logo: %w", err)
+	}
+	err = png.Encode(f, img)
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to encode logo: %w", err)
+	}
+	// Renamed so outputs never read half a file
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return fmt.Errorf("failed to store logo: %w", err)
+	}
+	return nil
+}
+
+// SetLogoHidden takes the logo off or puts it back on the outputs
+// which overlay it
+func (ch *Channel) SetLogoHidden(hidden bool) error {
+	ch.logoLock.Lock()
+	defer ch.logoLock.Unlock()
+	if ch.logoHidden == hidden {
+		return nil
+	}
+	ch.logoHidden = hidden
+	return ch.writeLogo()
+}
+
+// handleLogoSuppression hides the logo while playouts which suppress
+// it are on air
+func (ch *Channel) handleLogoSuppression(e scheduler.Event) {
+	if !e.Playout.HideLogo {
+		return
+	}
+	switch e.Type {
+	case scheduler.EventPlayoutStarted:
+		err := ch.SetLogoHidden(true)
+		if err != nil {
+			ch.recordEvent(EventLogoHidden, "failed to hide logo for playout %d: %s", e.Playout.PlayoutID, err)
+			return
+		}
+		ch.recordEvent(EventLogoHidden, "playout %d", e.Playout.PlayoutID)
+	case scheduler.EventPlayoutEnded:
+		err := ch.SetLogoHidden(false)
+		if err != nil {
+			ch.recordEvent(EventLogoShown, "failed to show logo after playout %d: %s", e.Playout.PlayoutID, err)
+			return
+		}
+		ch.recordEvent(EventLogoShown, "playout %d ended", e.Playout.PlayoutID)
+	}
+}
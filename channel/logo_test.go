@@ -0,0 +1,110 @@
+package channel
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/scheduler"
+)
+
+// logoChannel is a test channel with an opaque red logo loaded into
+// a temporary logo dir
+func logoChannel(t *testing.T) *Channel {
+	t.Helper()
+	dir := t.TempDir()
+	logo := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			logo.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	src := filepath.Join(dir, "logo.png")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("failed to create logo: %+v", err)
+	}
+	err = png.Encode(f, logo)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to encode logo: %+v", err)
+	}
+
+	ch := testChannel()
+	ch.conf = &Config{LogoDir: filepath.Join(dir, "logos")}
+	ch.LogoURL = src
+	err = ch.loadLogo(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load logo: %+v", err)
+	}
+	return ch
+}
+
+// writtenLogo decodes the logo the outputs read
+func writtenLogo(t *testing.T, ch *Channel) image.Image {
+	t.Helper()
+	f, err := os.Open(ch.logoPath())
+	if err != nil {
+		t.Fatalf("failed to open written logo: %+v", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("failed to decode written logo: %+v", err)
+	}
+	return img
+}
+
+// eventTypes are the types of the channel's recorded events
+func eventTypes(ch *Channel) []string {
+	types := []string{}
+	for _, e := range ch.Events() {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestLogoSuppression(t *testing.T) {
+	ch := logoChannel(t)
+	po := playout.Playout{PlayoutID: 10, HideLogo: true}
+
+	ch.handleLogoSuppression(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po})
+	img := writtenLogo(t, ch)
+	if img.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Errorf("hidden logo is %s, want the same size as the logo", img.Bounds())
+	}
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				t.Fatalf("hidden logo isn't transparent at %d,%d", x, y)
+			}
+		}
+	}
+
+	ch.handleLogoSuppression(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: po})
+	img = writtenLogo(t, ch)
+	if r, _, _, a := img.At(1, 1).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("logo wasn't restored, it is %v at 1,1", img.At(1, 1))
+	}
+	types := eventTypes(ch)
+	if len(types) != 2 || types[0] != EventLogoHidden || types[1] != EventLogoShown {
+		t.Errorf("recorded %q, want the logo hidden then shown", types)
+	}
+}
+
+func TestLogoSuppressionIgnoresOtherPlayouts(t *testing.T) {
+	ch := logoChannel(t)
+
+	ch.handleLogoSuppression(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: playout.Playout{PlayoutID: 10}})
+	if _, _, _, a := writtenLogo(t, ch).At(1, 1).RGBA(); a != 0xffff {
+		t.Error("logo was hidden for a playout which doesn't suppress it")
+	}
+	if types := eventTypes(ch); len(types) != 0 {
+		t.Errorf("recorded %q", types)
+	}
+}
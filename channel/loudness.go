@@ -0,0 +1,94 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ystv/playout/loudness"
+)
+
+// Loudness normalisation modes
+const (
+	LoudnessOff      = "off"      // Audio is left as is
+	LoudnessLive     = "live"     // Normalised as the ingest is encoded
+	LoudnessMeasured = "measured" // Programme videos are measured ahead of air, the ingest is only limited
+)
+
+// ErrInvalidLoudness is when a loudness policy doesn't make sense
+var ErrInvalidLoudness = errors.New("invalid loudness policy")
+
+// LoudnessPolicy is how a channel's audio is normalised
+type LoudnessPolicy struct {
+	LoudnessMode     string  `db:"loudness_mode" json:"loudnessMode"`          // off / live / measured, empty is off
+	LoudnessTarget   float64 `db:"loudness_target" json:"loudnessTarget"`      // Integrated LUFS, 0 is -23
+	LoudnessTruePeak float64 `db:"loudness_true_peak" json:"loudnessTruePeak"` // Ceiling dBTP, 0 is -1
+}
+
+// mode is the normalisation mode, defaulting to off
+func (p LoudnessPolicy) mode() string {
+	if p.LoudnessMode == "" {
+		return LoudnessOff
+	}
+	return strings.ToLower(p.LoudnessMode)
+}
+
+// withDefaults fills in unset levels
+func (p LoudnessPolicy) withDefaults() LoudnessPolicy {
+	p.LoudnessMode = p.mode()
+	if p.LoudnessTarget == 0 {
+		p.LoudnessTarget = loudness.DefaultTarget
+	}
+	if p.LoudnessTruePeak == 0 {
+		p.LoudnessTruePeak = loudness.DefaultTruePeak
+	}
+	return p
+}
+
+// validate checks the levels are ones loudnorm accepts
+func (p LoudnessPolicy) validate() error {
+	p = p.withDefaults()
+	switch p.LoudnessMode {
+	case LoudnessOff, LoudnessLive, LoudnessMeasured:
+	default:
+		return fmt.Errorf("%w: unknown mode \"%s\"", ErrInvalidLoudness, p.LoudnessMode)
+	}
+	if p.LoudnessTarget < -70 || p.LoudnessTarget > -5 {
+		return fmt.Errorf("%w: target must be between -70 and -5 LUFS", ErrInvalidLoudness)
+	}
+	if p.LoudnessTruePeak < -9 || p.LoudnessTruePeak > 0 {
+		return fmt.Errorf("%w: true peak must be between -9 and 0 dBTP", ErrInvalidLoudness)
+	}
+	return nil
+}
+
+// target is what the policy normalises to
+func (p LoudnessPolicy) target() loudness.Target {
+	p = p.withDefaults()
+	return loudness.Target{Integrated: p.LoudnessTarget, TruePeak: p.LoudnessTruePeak}
+}
+
+// audioFilter is the filter in the channel's audio chain, empty when
+// the audio is left as is
+func (p LoudnessPolicy) audioFilter() string {
+	switch p.mode() {
+	case LoudnessLive:
+		return p.target().Live()
+	case LoudnessMeasured:
+		// The player applies the measured gains, anything it couldn't
+		// has its peaks kept down
+		return p.target().Limiter()
+	default:
+		return ""
+	}
+}
+
+// measuredTarget is what the scheduler normalises programmes to, nil
+// unless the channel uses measured gains
+func (p LoudnessPolicy) measuredTarget() *loudness.Target {
+	if p.mode() != LoudnessMeasured {
+		return nil
+	}
+	t := p.target()
+	return &t
+}
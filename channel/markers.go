@@ -0,0 +1,223 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/markers"
+	"github.com/ystv/playout/scheduler"
+)
+
+const (
+	// markerRetention is how long cues are kept after they've ended, a
+	// DVR window longer than it loses the older cues
+	markerRetention = time.Hour
+	// markerInterval is how often the transcoder's manifests are checked
+	// for changes
+	markerInterval = 500 * time.Millisecond
+)
+
+// ErrInvalidMarkers is when an output can't carry markers
+var ErrInvalidMarkers = errors.New("invalid markers")
+
+// validateMarkers checks an output's manifests can be marked
+func validateMarkers(o Output) error {
+	if !o.Markers {
+		return nil
+	}
+	switch strings.ToLower(o.Type) {
+	case "hls", "dash", "cmaf":
+	default:
+		return fmt.Errorf("%w: only hls, dash and cmaf outputs carry them", ErrInvalidMarkers)
+	}
+	if isHTTP(o.Destination) {
+		return fmt.Errorf("%w: the manifests are rewritten so have to be written locally", ErrInvalidMarkers)
+	}
+	return nil
+}
+
+// writesMaster is when the channel writes a HLS output's master
+// playlist, rather than ffmpeg
+func (o Output) writesMaster() bool {
+	if o.Passthrough || !strings.EqualFold(o.Type, "hls") {
+		return false
+	}
+	return o.Markers || o.publishesSubtitles()
+}
+
+// unmarked is where the transcoder writes a manifest of an output
+// with markers, hidden next to where the marked one is published
+func unmarked(dst string) string {
+	dir, file := path.Split(dst)
+	return dir + "." + file
+}
+
+// markerPublisher rewrites an output's manifests with markers
+type markerPublisher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// markerSchedule is what the channel is marking
+func (ch *Channel) markerSchedule() *markers.Schedule {
+	ch.markerLock.Lock()
+	defer ch.markerLock.Unlock()
+	if ch.markerCues == nil {
+		ch.markerCues = &markers.Schedule{}
+	}
+	return ch.markerCues
+}
+
+// startMarkers marks an output's manifests in the background as the
+// transcoder writes them
+func (ch *Channel) startMarkers(key string, o Output) {
+	ch.stopMarkers(key)
+	if !o.Markers {
+		return
+	}
+	if o.writesMaster() && !o.publishesSubtitles() {
+		// Otherwise it's written with the subtitles
+		master, err := ch.masterPlaylist(o)
+		if err != nil {
+			log.Printf("channel \"%s\": failed to build %s's master playlist: %+v", ch.ShortName, key, err)
+			return
+		}
+		err = captions.Put(context.Background(), o.Destination, master)
+		if err != nil {
+			log.Printf("channel \"%s\": failed to write %s's master playlist: %+v", ch.ShortName, key, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ch.markerLock.Lock()
+	if ch.markerPublishers == nil {
+		ch.markerPublishers = make(map[string]*markerPublisher)
+	}
+	ch.markerPublishers[key] = &markerPublisher{cancel: cancel, done: done}
+	ch.markerLock.Unlock()
+	go func() {
+		defer close(done)
+		ch.publishMarkers(ctx, key, o)
+	}()
+}
+
+// stopMarkers stops marking an output's manifests, once the latest
+// ones are marked
+func (ch *Channel) stopMarkers(key string) {
+	ch.markerLock.Lock()
+	p := ch.markerPublishers[key]
+	delete(ch.markerPublishers, key)
+	ch.markerLock.Unlock()
+	if p == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// stopAllMarkers stops marking every output's manifests
+func (ch *Channel) stopAllMarkers() {
+	ch.markerLock.Lock()
+	keys := make([]string, 0, len(ch.markerPublishers))
+	for key := range ch.markerPublishers {
+		keys = append(keys, key)
+	}
+	ch.markerLock.Unlock()
+	for _, key := range keys {
+		ch.stopMarkers(key)
+	}
+}
+
+// publishMarkers checks the transcoder's manifests each interval,
+// publishing a marked copy of the ones which have changed
+func (ch *Channel) publishMarkers(ctx context.Context, key string, o Output) {
+	mark := markers.HLS
+	if !strings.EqualFold(o.Type, "hls") {
+		mark = markers.DASH
+	}
+	pattern := unmarked(o.Destination)
+	if !o.Passthrough && strings.EqualFold(o.Type, "hls") {
+		// Each variant playlist is marked, the master has nothing to mark
+		pattern = strings.Replace(variantPlaylist(o), "%v", "*", 1)
+	}
+	written := make(map[string]time.Time)
+	publish := func() {
+		sources, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Printf("channel \"%s\": failed to find %s's manifests: %+v", ch.ShortName, key, err)
+			return
+		}
+		cues := ch.markerSchedule().Cues()
+		for _, src := range sources {
+			info, err := os.Stat(src)
+			if err != nil || info.ModTime().Equal(written[src]) {
+				continue
+			}
+			manifest, err := os.ReadFile(src)
+			if err != nil {
+				log.Printf("channel \"%s\": failed to read %s's manifest: %+v", ch.ShortName, key, err)
+				continue
+			}
+			dir, file := filepath.Split(src)
+			err = captions.Put(ctx, dir+strings.TrimPrefix(file, "."), mark(manifest, cues))
+			if err != nil {
+				log.Printf("channel \"%s\": failed to write %s's manifest: %+v", ch.ShortName, key, err)
+				continue
+			}
+			written[src] = info.ModTime()
+		}
+	}
+	ticker := time.NewTicker(markerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// The transcoder has stopped, so the manifests are final
+			ctx = context.Background()
+			publish()
+			return
+		case <-ticker.C:
+			publish()
+		}
+	}
+}
+
+// handleMarkers places programmes going on and off air and the breaks
+// in them on the channel's marker schedule
+func (ch *Channel) handleMarkers(e scheduler.Event) {
+	s := ch.markerSchedule()
+	switch e.Type {
+	case scheduler.EventPlayoutStarted:
+		s.Add(markers.Cue{
+			Kind:      markers.KindProgramme,
+			ID:        e.Playout.PlayoutID,
+			Start:     e.Playout.BroadcastStart,
+			Playout:   e.Playout.PlayoutID,
+			Programme: e.Playout.ProgrammeID,
+		})
+	case scheduler.EventPlayoutEnded:
+		s.EndPlayout(e.Playout.PlayoutID, e.Playout.BroadcastEnd)
+	case scheduler.EventBreakStarted:
+		s.Add(markers.Cue{
+			Kind:      markers.KindBreak,
+			ID:        e.Break.ID,
+			Start:     time.Now(),
+			Planned:   e.Break.Length(),
+			Playout:   e.Playout.PlayoutID,
+			Programme: e.Playout.ProgrammeID,
+		})
+	case scheduler.EventBreakEnded:
+		s.End(markers.KindBreak, e.Break.ID, time.Now())
+	default:
+		return
+	}
+	s.Prune(time.Now().Add(-markerRetention))
+}
@@ -0,0 +1,118 @@
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ystv/playout/markers"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/scheduler"
+)
+
+// markerPlayout is on air with a 30 second break
+var (
+	markerPlayout = playout.Playout{PlayoutID: 10, ProgrammeID: 20}
+	markerBreak   = playout.Break{ID: 3, Start: 60, Duration: 30}
+)
+
+func TestMarkersScheduled(t *testing.T) {
+	ch := testChannel()
+	po := markerPlayout
+	po.BroadcastStart = time.Now()
+
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po})
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventBreakStarted, Playout: po, Break: markerBreak})
+	cues := ch.markerSchedule().Cues()
+	if len(cues) != 2 {
+		t.Fatalf("schedule has %+v, want the programme and its break", cues)
+	}
+	prog, brk := cues[0], cues[1]
+	if prog.Kind != markers.KindProgramme || prog.ID != 10 || prog.Programme != 20 || !prog.Start.Equal(po.BroadcastStart) || !prog.End.IsZero() {
+		t.Errorf("programme cue is %+v", prog)
+	}
+	if brk.Kind != markers.KindBreak || brk.ID != 3 || brk.Playout != 10 || brk.Planned != 30*time.Second || !brk.End.IsZero() {
+		t.Errorf("break cue is %+v", brk)
+	}
+
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventBreakEnded, Playout: po, Break: markerBreak})
+	if cues := ch.markerSchedule().Cues(); cues[1].End.IsZero() || !cues[0].End.IsZero() {
+		t.Errorf("schedule has %+v, want only the break ended", cues)
+	}
+
+	po.BroadcastEnd = time.Now()
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: po})
+	if cues := ch.markerSchedule().Cues(); !cues[0].End.Equal(po.BroadcastEnd) {
+		t.Errorf("programme cue is %+v, want it ended at the broadcast end", cues[0])
+	}
+}
+
+func TestMarkersEndBreakWithPlayout(t *testing.T) {
+	ch := testChannel()
+	po := markerPlayout
+	po.BroadcastStart = time.Now()
+
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po})
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventBreakStarted, Playout: po, Break: markerBreak})
+	po.BroadcastEnd = time.Now()
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: po})
+	for _, c := range ch.markerSchedule().Cues() {
+		if !c.End.Equal(po.BroadcastEnd) {
+			t.Errorf("cue %+v wasn't ended with its playout", c)
+		}
+	}
+}
+
+func TestMarkersPublished(t *testing.T) {
+	ch := testChannel()
+	o := Output{
+		ID:          1,
+		Type:        "hls",
+		Passthrough: true,
+		Markers:     true,
+		Destination: filepath.Join(t.TempDir(), "index.m3u8"),
+	}
+	// Segments either side of now, the programme started with the first
+	first := time.Now().Add(-8 * time.Second).UTC()
+	playlist := strings.Builder{}
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:0\n")
+	for idx := 0; idx < 4; idx++ {
+		fmt.Fprintf(&playlist, "#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:4.000,\nindex%d.ts\n",
+			first.Add(time.Duration(idx)*4*time.Second).Format("2006-01-02T15:04:05.000Z"), idx)
+	}
+	err := ioutil.WriteFile(unmarked(o.Destination), []byte(playlist.String()), 0644)
+	if err != nil {
+		t.Fatalf("failed to write playlist: %+v", err)
+	}
+
+	po := markerPlayout
+	po.BroadcastStart = first
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po})
+	ch.handleMarkers(scheduler.Event{Type: scheduler.EventBreakStarted, Playout: po, Break: markerBreak})
+	ch.startMarkers("output-1", o)
+	defer ch.stopAllMarkers()
+
+	var marked string
+	eventually(t, "the marked playlist", func() bool {
+		b, err := ioutil.ReadFile(o.Destination)
+		marked = string(b)
+		return err == nil
+	})
+	for _, want := range []string{
+		`ID="programme-10"`,
+		`ID="break-3"`,
+		"SCTE35-OUT=0x",
+		"#EXT-X-CUE-OUT:DURATION=30.000",
+		"X-PROGRAMME-ID=20",
+	} {
+		if !strings.Contains(marked, want) {
+			t.Errorf("marked playlist doesn't have %s:\n%s", want, marked)
+		}
+	}
+	if !strings.HasPrefix(marked, "#EXTM3U\n") || strings.Count(marked, "#EXTINF") != 4 {
+		t.Errorf("marked playlist lost the original:\n%s", marked)
+	}
+}
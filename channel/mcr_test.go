@@ -0,0 +1,264 @@
+package channel
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ystv/playout/piper/brave/bravetest"
+	"github.com/ystv/playout/vt"
+	"github.com/ystv/playout/vt/vttest"
+)
+
+// testMCR is an MCR transcoding through a fake VT, without a DB
+func testMCR(tracker *vt.Tracker) *MCR {
+	return &MCR{
+		conf:     &Config{Transcoder: "vt"},
+		vt:       tracker,
+		bus:      NewBus(),
+		channels: make(map[string]*Channel),
+	}
+}
+
+// addTestChannel adds a channel with a passthrough and a transcoded
+// output to the MCR, without storing it
+func addTestChannel(t *testing.T, mcr *MCR) *Channel {
+	t.Helper()
+	ch := testChannel()
+	ch.ID = 1
+	ch.Outputs = []Output{
+		{ID: 1, Type: "rtmp", Passthrough: true, Destination: "rtmp://live.example.com/app/stream"},
+		{ID: 2, Type: "hls", Destination: "/srv/hls/test/index.m3u8", Renditions: ladder},
+	}
+	err := mcr.newChannel(context.Background(), ch, false)
+	if err != nil {
+		t.Fatalf("failed to add channel: %+v", err)
+	}
+	ch.eventStore = nil
+	return ch
+}
+
+// messages collects what is published on the MCR's bus
+type messages struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+func (m *messages) add(msg Message) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = append(m.messages, msg)
+}
+
+func (m *messages) get() []Message {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Message{}, m.messages...)
+}
+
+// state is the channel's current state
+func state(ch *Channel) State {
+	status, _ := ch.Stat()
+	return status.State
+}
+
+func TestMCRStartStopVT(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+	ctx := context.Background()
+
+	err := ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	if state(ch) != StateRunning {
+		t.Errorf("channel is %s, want running", state(ch))
+	}
+	running := srv.Running()
+	if len(running) != 2 {
+		t.Fatalf("vt is running %d tasks, want one for each output", len(running))
+	}
+	for _, task := range running {
+		if task.Kind != vttest.KindLive || task.Live.SrcURL != ch.IngestURL {
+			t.Errorf("task %+v isn't a live task from the ingest", task)
+		}
+	}
+	if dst := running[0].Live.DstURL; dst != "rtmp://live.example.com/app/stream" {
+		t.Errorf("first task is sent to %q", dst)
+	}
+	tracked, err := mcr.GetVTTasks(ctx, ch.ShortName)
+	if err != nil {
+		t.Fatalf("failed to get vt tasks: %+v", err)
+	}
+	if len(tracked) != 2 || tracked[0].Output != "output-1" || tracked[1].Output != "output-2" {
+		t.Errorf("tracked %+v, want a task for each output", tracked)
+	}
+
+	err = ch.Stop()
+	if err != nil {
+		t.Fatalf("failed to stop channel: %+v", err)
+	}
+	if state(ch) != StateStopped {
+		t.Errorf("channel is %s, want stopped", state(ch))
+	}
+	if running := srv.Running(); len(running) != 0 {
+		t.Errorf("vt is still running %+v", running)
+	}
+}
+
+func TestMCRStartVTFails(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+	msgs := &messages{}
+	mcr.Subscribe(msgs.add, TopicOutputFailed)
+
+	srv.Fail(vttest.RouteLive, http.StatusInternalServerError)
+	err := ch.Start()
+	if err == nil {
+		t.Fatal("started a channel vt failed to run")
+	}
+	if state(ch) != StateFailed {
+		t.Errorf("channel is %s, want failed", state(ch))
+	}
+	got := msgs.get()
+	if len(got) != 1 || got[0].(OutputFailed).Output != "output-1" {
+		t.Errorf("published %+v, want the first output failing", got)
+	}
+	if running := srv.Running(); len(running) != 0 {
+		t.Errorf("vt is running %+v", running)
+	}
+
+	// The channel can be started again once VT has recovered
+	srv.Fail(vttest.RouteLive, 0)
+	err = ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel once vt recovered: %+v", err)
+	}
+	if running := srv.Running(); len(running) != 2 {
+		t.Errorf("vt is running %d tasks, want 2", len(running))
+	}
+	ch.Stop()
+}
+
+func TestMCRPublishesStateChanges(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	msgs := &messages{}
+	mcr.Subscribe(msgs.add, TopicChannelCreated, TopicStateChanged)
+	ch := addTestChannel(t, mcr)
+
+	err := ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	got := msgs.get()
+	if len(got) != 3 {
+		t.Fatalf("published %+v, want the channel created then starting and running", got)
+	}
+	if created := got[0].(ChannelCreated); created.Channel != ch.ShortName {
+		t.Errorf("published %+v first, want the channel created", created)
+	}
+	if changed := got[2].(StateChanged); changed.From != StateStarting || changed.To != StateRunning {
+		t.Errorf("published %+v last, want the channel running", changed)
+	}
+	ch.Stop()
+}
+
+func TestMCRStopVTFails(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+
+	err := ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	srv.Fail(vttest.RouteCancel, http.StatusInternalServerError)
+	err = ch.Stop()
+	if err == nil {
+		t.Fatal("stopped a channel vt failed to cancel")
+	}
+	if status, _ := ch.Stat(); status.State != StateFailed || !strings.Contains(status.Reason, "failed to stop transcoder") {
+		t.Errorf("channel is %+v, want failed to stop", status)
+	}
+	if running := srv.Running(); len(running) != 2 {
+		t.Errorf("vt is running %d tasks, they couldn't be cancelled", len(running))
+	}
+}
+
+func TestMCRAdoptsVTTasks(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	// The store outlives the MCR, like the DB across a restart
+	store := vttest.NewStore()
+	mcr := testMCR(vt.NewStoreTracker(vt.New(srv.URL), store))
+	ch := addTestChannel(t, mcr)
+	err := ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+
+	restarted := testMCR(vt.NewStoreTracker(vt.New(srv.URL), store))
+	ch = addTestChannel(t, restarted)
+	err = ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel after restarting: %+v", err)
+	}
+	if tasks := srv.Tasks(); len(tasks) != 2 {
+		t.Errorf("vt has %d tasks, the running ones should have been adopted", len(tasks))
+	}
+
+	// A task which ended while the MCR was down is replaced
+	ch.Stop()
+	err = ch.Start()
+	if err != nil {
+		t.Fatalf("failed to start channel again: %+v", err)
+	}
+	if running := srv.Running(); len(running) != 2 {
+		t.Errorf("vt is running %d tasks, want 2", len(running))
+	}
+	if tasks := srv.Tasks(); len(tasks) != 4 {
+		t.Errorf("vt has %d tasks, want the cancelled ones replaced", len(tasks))
+	}
+}
+
+func TestMCRStartPiper(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(nil)
+	mcr.conf.PiperEndpoints = map[string]string{"test": srv.URL}
+	ch := testChannel()
+
+	srv.Fail(bravetest.RouteMixers, http.StatusInternalServerError)
+	err := mcr.startPiper(context.Background(), ch)
+	if err == nil {
+		t.Fatal("started a piper brave failed to create a mixer for")
+	}
+	if ch.piper != nil {
+		t.Error("channel has a piper which failed to start")
+	}
+
+	srv.Fail(bravetest.RouteMixers, 0)
+	err = mcr.startPiper(context.Background(), ch)
+	if err != nil {
+		t.Fatalf("failed to start piper: %+v", err)
+	}
+	if ch.piper == nil {
+		t.Fatal("channel doesn't have its piper")
+	}
+	if mixers := srv.State().Mixers; len(mixers) != 2 {
+		t.Errorf("brave has %d mixers, want one created", len(mixers))
+	}
+	mcr.stopPiper(ch)
+	if ch.piper != nil {
+		t.Error("channel still has its piper")
+	}
+}
@@ -0,0 +1,402 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ystv/playout/utils"
+)
+
+// ErrOutputNotFound is when an output doesn't exist on a channel
+var ErrOutputNotFound = errors.New("output doesn't exist")
+
+// AddOutput validates and stores a new output on a channel, starting
+// it if the channel is live. An output which fails to start isn't kept.
+func (mcr *MCR) AddOutput(ctx context.Context, shortName string, o Output) (*Output, error) {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	err = mcr.resolveProfile(ctx, &o)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	_, err = ch.compile(o)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	err = utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		return mcr.insertOutput(ctx, tx, ch.ID, &o)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add output: %w", err)
+	}
+	resume := ch.pauseMonitor()
+	defer resume()
+	ch.confLock.Lock()
+	ch.Outputs = append(ch.Outputs, o)
+	idx := len(ch.Outputs) - 1
+	ch.confLock.Unlock()
+	if !ch.isLive() {
+		return &o, nil
+	}
+	err = ch.startOutput(ctx, idx)
+	if err == nil {
+		return &o, nil
+	}
+	// Roll back, so an output which can't start isn't left behind
+	ch.confLock.Lock()
+	ch.Outputs = ch.Outputs[:idx]
+	ch.confLock.Unlock()
+	_, delErr := mcr.db.ExecContext(ctx, `
+		DELETE FROM playout.outputs
+		WHERE output_id = $1;`, o.ID)
+	if delErr != nil {
+		return nil, fmt.Errorf("failed to start output: %w, and failed to remove it: %s", err, delErr)
+	}
+	return nil, fmt.Errorf("failed to start output: %w", err)
+}
+
+// UpdateOutput replaces the output with the same ID on a channel,
+// restarting it if the channel is live. A restream's stream key is
+// kept if it isn't given. An output which fails to restart is rolled
+// back to its previous config.
+func (mcr *MCR) UpdateOutput(ctx context.Context, shortName string, o Output) (*Output, error) {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	idx := ch.outputIndex(o.ID)
+	if idx == -1 {
+		return nil, ErrOutputNotFound
+	}
+	if o.isRestream() && o.StreamKey == "" {
+		o.StreamKey = ch.outputs()[idx].StreamKey
+	}
+	err = mcr.resolveProfile(ctx, &o)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	_, err = ch.compile(o)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	prev := ch.outputs()[idx]
+	err = mcr.storeOutput(ctx, o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update output: %w", err)
+	}
+
+	resume := ch.pauseMonitor()
+	defer resume()
+	if !ch.isLive() {
+		ch.confLock.Lock()
+		ch.Outputs[idx] = o
+		ch.confLock.Unlock()
+		return &o, nil
+	}
+	err = ch.stopOutput(ctx, idx)
+	if err != nil {
+		// The previous output is still running, so keep it stored
+		storeErr := mcr.storeOutput(ctx, prev)
+		if storeErr != nil {
+			return nil, fmt.Errorf("failed to stop output: %w, and failed to restore it: %s", err, storeErr)
+		}
+		return nil, fmt.Errorf("failed to stop output: %w", err)
+	}
+	ch.confLock.Lock()
+	ch.Outputs[idx] = o
+	ch.confLock.Unlock()
+	err = ch.startOutput(ctx, idx)
+	if err == nil {
+		return &o, nil
+	}
+	// Roll back, so an output which can't start doesn't take the
+	// previous one down with it
+	ch.confLock.Lock()
+	ch.Outputs[idx] = prev
+	ch.confLock.Unlock()
+	startErr := ch.startOutput(ctx, idx)
+	storeErr := mcr.storeOutput(ctx, prev)
+	if startErr != nil || storeErr != nil {
+		return nil, fmt.Errorf("failed to restart output: %w, and failed to restore it: %v, %v", err, startErr, storeErr)
+	}
+	return nil, fmt.Errorf("failed to restart output: %w", err)
+}
+
+// storeOutput writes an existing output and its renditions
+func (mcr *MCR) storeOutput(ctx context.Context, o Output) error {
+	return utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		streamKey, err := mcr.sealStreamKey(o.StreamKey)
+		if err != nil {
+			return err
+		}
+		passphrase, err := mcr.sealStreamKey(o.SRTPassphrase)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE playout.outputs SET
+				name = $1,
+				type = $2,
+				passthrough = $3,
+				dvr = $4,
+				dvr_window = $5,
+				segment_duration = $6,
+				destination = $7,
+				profile = $8,
+				profile_version = $9,
+				srt_mode = $10,
+				srt_passphrase = $11,
+				srt_latency = $12,
+				logo = $13,
+				captions = $14,
+				markers = $15,
+				stream_key = $16,
+				disabled = $17,
+				scheduled = $18,
+				args = $19
+			WHERE output_id = $20;`,
+			o.Name, o.Type, o.Passthrough, o.DVR, o.DVRWindow,
+			o.SegmentDuration, o.Destination, o.Profile, o.ProfileVersion,
+			o.SRTMode, passphrase, o.SRTLatency, o.Logo, o.Captions, o.Markers,
+			streamKey, o.Disabled, o.Scheduled, o.Args, o.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update output: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM playout.output_renditions
+			WHERE output_id = $1;`, o.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete old renditions: %w", err)
+		}
+		return insertRenditions(ctx, tx, o)
+	})
+}
+
+// RemoveOutput stops and deletes an output from a channel
+func (mcr *MCR) RemoveOutput(ctx context.Context, shortName string, outputID int) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return err
+	}
+	idx := ch.outputIndex(outputID)
+	if idx == -1 {
+		return ErrOutputNotFound
+	}
+	resume := ch.pauseMonitor()
+	defer resume()
+	if ch.isLive() {
+		err = ch.stopOutput(ctx, idx)
+		if err != nil {
+			return fmt.Errorf("failed to stop output: %w", err)
+		}
+	}
+	_, err = mcr.db.ExecContext(ctx, `
+		DELETE FROM playout.outputs
+		WHERE output_id = $1;`, outputID)
+	if err != nil {
+		return fmt.Errorf("failed to delete output: %w", err)
+	}
+	ch.confLock.Lock()
+	ch.Outputs = append(ch.Outputs[:idx], ch.Outputs[idx+1:]...)
+	ch.confLock.Unlock()
+	return nil
+}
+
+// loadOutputs retrieves a channel's outputs, their renditions and profiles
+func (mcr *MCR) loadOutputs(ctx context.Context, ch *Channel) error {
+	outputs := []Output{}
+	err := mcr.db.SelectContext(ctx, &outputs, `
+		SELECT output_id, name, type, passthrough, dvr, dvr_window,
+			segment_duration, destination, profile, profile_version,
+			srt_mode, srt_passphrase, srt_latency, logo, captions, markers,
+			stream_key, disabled, scheduled, args
+		FROM playout.outputs
+		WHERE channel_id = $1
+		ORDER BY output_id;`, ch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to select outputs: %w", err)
+	}
+	renditions := []struct {
+		OutputID int `db:"output_id"`
+		Rendition
+	}{}
+	err = mcr.db.SelectContext(ctx, &renditions, `
+		SELECT r.output_id, r.width, r.height, r.bitrate, r.fps, r.codec
+		FROM playout.output_renditions r
+		INNER JOIN playout.outputs o ON r.output_id = o.output_id
+		WHERE o.channel_id = $1
+		ORDER BY r.output_id, r.position;`, ch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to select renditions: %w", err)
+	}
+	for idx := range outputs {
+		outputs[idx].StreamKey, err = mcr.openStreamKey(outputs[idx].StreamKey)
+		if err != nil {
+			return fmt.Errorf("failed to open stream key of output %d: %w", outputs[idx].ID, err)
+		}
+		outputs[idx].SRTPassphrase, err = mcr.openStreamKey(outputs[idx].SRTPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to open srt passphrase of output %d: %w", outputs[idx].ID, err)
+		}
+		for _, r := range renditions {
+			if r.OutputID == outputs[idx].ID {
+				outputs[idx].Renditions = append(outputs[idx].Renditions, r.Rendition)
+			}
+		}
+	}
+	ch.Outputs = outputs
+	mcr.resolveProfiles(ctx, ch)
+	return nil
+}
+
+// insertOutput stores an output and its renditions, setting
+// the output's ID to the new one
+func (mcr *MCR) insertOutput(ctx context.Context, tx *sqlx.Tx, channelID int, o *Output) error {
+	streamKey, err := mcr.sealStreamKey(o.StreamKey)
+	if err != nil {
+		return err
+	}
+	passphrase, err := mcr.sealStreamKey(o.SRTPassphrase)
+	if err != nil {
+		return err
+	}
+	err = tx.GetContext(ctx, &o.ID, `
+		INSERT INTO playout.outputs(
+			channel_id,
+			name,
+			type,
+			passthrough,
+			dvr,
+			dvr_window,
+			segment_duration,
+			destination,
+			profile,
+			profile_version,
+			srt_mode,
+			srt_passphrase,
+			srt_latency,
+			logo,
+			captions,
+			markers,
+			stream_key,
+			disabled,
+			scheduled,
+			args)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		RETURNING output_id;`,
+		channelID, o.Name, o.Type, o.Passthrough, o.DVR, o.DVRWindow,
+		o.SegmentDuration, o.Destination, o.Profile, o.ProfileVersion,
+		o.SRTMode, passphrase, o.SRTLatency, o.Logo, o.Captions, o.Markers,
+		streamKey, o.Disabled, o.Scheduled, o.Args)
+	if err != nil {
+		return fmt.Errorf("failed to insert output: %w", err)
+	}
+	return insertRenditions(ctx, tx, *o)
+}
+
+// insertRenditions stores an output's renditions in ladder order
+func insertRenditions(ctx context.Context, tx *sqlx.Tx, o Output) error {
+	if len(o.Renditions) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO playout.output_renditions(
+			output_id, position, width, height, bitrate, fps, codec)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare renditions: %w", err)
+	}
+	defer stmt.Close()
+	for idx, r := range o.Renditions {
+		_, err = stmt.ExecContext(ctx, o.ID, idx, r.Width, r.Height, r.Bitrate, r.FPS, r.Codec)
+		if err != nil {
+			return fmt.Errorf("failed to insert rendition: %w", err)
+		}
+	}
+	return nil
+}
+
+// outputIndex finds the position of an output by its ID, -1 if
+// it doesn't exist
+func (ch *Channel) outputIndex(outputID int) int {
+	for idx := range ch.Outputs {
+		if ch.Outputs[idx].ID == outputID {
+			return idx
+		}
+	}
+	return -1
+}
+
+// startOutput compiles and starts a single output, restreams which
+// shouldn't run are left stopped
+func (ch *Channel) startOutput(ctx context.Context, idx int) error {
+	if ch.tc == nil {
+		return ErrNoTranscoder
+	}
+	o := ch.outputs()[idx]
+	if !ch.runs(o) {
+		return nil
+	}
+	cmd, err := ch.compile(o)
+	if err != nil {
+		return fmt.Errorf("failed to compile output: %w", err)
+	}
+	if o.Logo {
+		err = ch.ensureLogo(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load logo: %w", err)
+		}
+	}
+	cmd.Output = outputKey(idx, o)
+	err = ch.tc.Start(ctx, cmd)
+	if err != nil {
+		ch.notifyOutputFailed(cmd.Output, err.Error())
+		return err
+	}
+	if o.isRestream() {
+		ch.setRestreaming(cmd.Output, true)
+	}
+	ch.startCaptions(cmd.Output, o)
+	ch.startMarkers(cmd.Output, o)
+	return nil
+}
+
+// stopOutput stops a single output
+func (ch *Channel) stopOutput(ctx context.Context, idx int) error {
+	if ch.tc == nil {
+		return ErrNoTranscoder
+	}
+	o := ch.outputs()[idx]
+	key := outputKey(idx, o)
+	ch.stopCaptions(key)
+	if o.isRestream() {
+		if !ch.isRestreaming(key) {
+			return nil
+		}
+		ch.setRestreaming(key, false)
+	}
+	err := ch.tc.Stop(ctx, key)
+	// After the transcoder, so its last manifests are marked
+	ch.stopMarkers(key)
+	return err
+}
+
+// isLive is when the channel's outputs should be running
+func (ch *Channel) isLive() bool {
+	status, _ := ch.Stat()
+	switch status.State {
+	case StateStarting, StateRunning, StateDegraded:
+		return true
+	}
+	return false
+}
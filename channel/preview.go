@@ -0,0 +1,235 @@
+package channel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// grabTimeout is how long extracting a single frame can take
+	grabTimeout = 15 * time.Second
+	// previewWidth of grabbed frames, the height keeps the aspect ratio
+	previewWidth = 640
+)
+
+type (
+	// FrameGrabber extracts a single frame from an input to a JPEG
+	FrameGrabber interface {
+		Grab(ctx context.Context, input []string, dst string) error
+	}
+	// FFmpegGrabber grabs frames with ffmpeg
+	FFmpegGrabber struct {
+		Path string // ffmpeg binary
+	}
+	// Preview is a channel's most recently grabbed frame
+	Preview struct {
+		Path       string    `json:"-"` // JPEG on disk
+		CapturedAt time.Time `json:"capturedAt"`
+		Stale      bool      `json:"stale"` // Frames have stopped updating
+	}
+)
+
+var _ FrameGrabber = &FFmpegGrabber{}
+
+// Grab writes the input's first video frame to dst, scaled down
+func (g *FFmpegGrabber) Grab(ctx context.Context, input []string, dst string) error {
+	ctx, cancel := context.WithTimeout(ctx, grabTimeout)
+	defer cancel()
+	path := g.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	args = append(args, input...)
+	args = append(args,
+		"-frames:v", "1",
+		"-vf", "scale="+strconv.Itoa(previewWidth)+":-2",
+		"-q:v", "5",
+		"-f", "mjpeg", dst)
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("frame grab stalled: %w", ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to grab frame: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Preview returns the channel's latest frame, CapturedAt is zero
+// if there isn't one
+func (ch *Channel) Preview() Preview {
+	p := Preview{Path: ch.previewPath()}
+	ch.previewLock.Lock()
+	p.CapturedAt = ch.previewAt
+	ch.previewLock.Unlock()
+	if p.CapturedAt.IsZero() {
+		// Left over from before a restart
+		if fi, err := os.Stat(p.Path); err == nil {
+			p.CapturedAt = fi.ModTime()
+		}
+	}
+	p.Stale = p.CapturedAt.IsZero() || time.Since(p.CapturedAt) > ch.previewStaleAfter()
+	return p
+}
+
+// GetPreview retrieves a channel's latest frame
+func (mcr *MCR) GetPreview(ctx context.Context, shortName string) (Preview, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return Preview{}, err
+	}
+	return ch.Preview(), nil
+}
+
+// previewPath is where the channel's frames are written
+func (ch *Channel) previewPath() string {
+	dir := ""
+	if ch.conf != nil {
+		dir = ch.conf.PreviewDir
+	}
+	return filepath.Join(dir, ch.ShortName+".jpg")
+}
+
+// previewInterval is how often a frame is grabbed
+func (ch *Channel) previewInterval() time.Duration {
+	if ch.conf != nil && ch.conf.PreviewInterval > 0 {
+		return ch.conf.PreviewInterval
+	}
+	return 10 * time.Second
+}
+
+// previewStaleAfter is how old the latest frame can be before the
+// preview is stale
+func (ch *Channel) previewStaleAfter() time.Duration {
+	if ch.conf != nil && ch.conf.PreviewStaleAfter > 0 {
+		return ch.conf.PreviewStaleAfter
+	}
+	return 3 * ch.previewInterval()
+}
+
+// previewInput are the arguments to read the channel for a frame
+//
+// SRT ingests which accept the connection can't be read twice, so a
+// segmented output is used instead.
+func (ch *Channel) previewInput() ([]string, error) {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	if ch.onSlate() || !strings.EqualFold(ch.IngestType, "srt") || !ch.SRTOptions.accepts() {
+		return ch.inputArgs()
+	}
+	for _, o := range ch.Outputs {
+		switch strings.ToLower(o.Type) {
+		case "hls", "dash", "cmaf":
+			return []string{"-i", o.Destination}, nil
+		}
+	}
+	return nil, fmt.Errorf("srt %s ingest has no segmented output to preview", ch.SRTOptions.mode())
+}
+
+// startPreviews grabs frames in the background if the channel has
+// somewhere to store them
+func (ch *Channel) startPreviews() {
+	ch.stopPreviews()
+	if ch.grabber == nil || ch.conf == nil || ch.conf.PreviewDir == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ch.previewLock.Lock()
+	ch.previewCancel = cancel
+	ch.previewDone = done
+	ch.previewLock.Unlock()
+	go func() {
+		defer close(done)
+		ch.grabPreviews(ctx)
+	}()
+}
+
+// stopPreviews stops grabbing frames and waits for it to finish
+func (ch *Channel) stopPreviews() {
+	ch.previewLock.Lock()
+	cancel, done := ch.previewCancel, ch.previewDone
+	ch.previewCancel, ch.previewDone = nil, nil
+	ch.previewLock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// grabPreviews grabs a frame each interval, recording an event when
+// frames stop updating and when they resume
+func (ch *Channel) grabPreviews(ctx context.Context) {
+	ticker := time.NewTicker(ch.previewInterval())
+	defer ticker.Stop()
+	dst := ch.previewPath()
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		log.Printf("channel \"%s\": failed to create preview dir: %+v", ch.ShortName, err)
+		return
+	}
+	stale := false
+	for {
+		err = ch.grabPreview(ctx, dst)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == nil && stale:
+			stale = false
+			ch.recordEvent(EventPreviewResumed, "frames updating again")
+		case err != nil && !stale:
+			p := ch.Preview()
+			if !p.Stale {
+				break
+			}
+			stale = true
+			if p.CapturedAt.IsZero() {
+				ch.recordEvent(EventPreviewStale, "no frame grabbed: %s", err)
+				break
+			}
+			ch.recordEvent(EventPreviewStale, "no frame since %s: %s",
+				p.CapturedAt.Format(time.RFC3339), err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// grabPreview replaces the channel's frame with a new one
+func (ch *Channel) grabPreview(ctx context.Context, dst string) error {
+	input, err := ch.previewInput()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	err = ch.grabber.Grab(ctx, input, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return fmt.Errorf("failed to store frame: %w", err)
+	}
+	ch.previewLock.Lock()
+	ch.previewAt = time.Now()
+	ch.previewLock.Unlock()
+	return nil
+}
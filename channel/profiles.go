@@ -0,0 +1,358 @@
+package channel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ystv/playout/utils"
+)
+
+var (
+	// ErrProfileNotFound is when an encoding profile or version doesn't exist
+	ErrProfileNotFound = errors.New("encoding profile doesn't exist")
+	// ErrInvalidProfile is when an encoding profile can't be compiled
+	ErrInvalidProfile = errors.New("invalid encoding profile")
+)
+
+// Profile is a named and versioned encoding, outputs reference it by
+// name instead of defining their own renditions.
+//
+// Saving a profile creates a new version, outputs follow the latest
+// version unless they pin one.
+type Profile struct {
+	ID              int         `db:"profile_id" json:"id"`
+	Name            string      `db:"name" json:"name"` // i.e. web-abr-1080p50
+	Version         int         `db:"version" json:"version"`
+	Description     string      `db:"description" json:"description"`
+	Codec           string      `db:"codec" json:"codec"`                       // h264 / h265, used by renditions without one
+	VideoProfile    string      `db:"video_profile" json:"videoProfile"`        // i.e. main / high
+	Preset          string      `db:"preset" json:"preset"`                     // Encoder preset, empty uses the encoder's default
+	GOP             int         `db:"gop" json:"gop"`                           // Frames between keyframes, 0 aligns them to segments
+	AlignKeyframes  bool        `db:"align_keyframes" json:"alignKeyframes"`    // Fixed keyframes so renditions can be switched between
+	AudioCodec      string      `db:"audio_codec" json:"audioCodec"`            // aac / opus
+	AudioBitrate    int         `db:"audio_bitrate" json:"audioBitrate"`        // Kb/s
+	AudioSampleRate int         `db:"audio_sample_rate" json:"audioSampleRate"` // Hz
+	AudioChannels   int         `db:"audio_channels" json:"audioChannels"`      // 0 keeps the ingest's
+	Renditions      []Rendition `json:"renditions"`
+	CreatedAt       time.Time   `db:"created_at" json:"createdAt"`
+}
+
+// profileName is the format of a profile's name
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// defaultProfile is the encoding of outputs which define their own renditions
+func defaultProfile(renditions []Rendition) Profile {
+	return Profile{
+		Codec:           "h264",
+		VideoProfile:    "main",
+		AlignKeyframes:  true,
+		AudioCodec:      "aac",
+		AudioBitrate:    audioBitrate,
+		AudioSampleRate: audioSampleRate,
+		Renditions:      renditions,
+	}
+}
+
+// Validate checks the profile can be compiled
+func (p Profile) Validate() error {
+	if !profileName.MatchString(p.Name) {
+		return fmt.Errorf("%w: name \"%s\" must be lowercase letters, numbers and dashes", ErrInvalidProfile, p.Name)
+	}
+	_, err := videoCodec(p.Codec)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidProfile, err)
+	}
+	err = validVideoProfile(p.Codec, p.VideoProfile)
+	if err != nil {
+		return err
+	}
+	if p.GOP < 0 {
+		return fmt.Errorf("%w: gop can't be negative", ErrInvalidProfile)
+	}
+	_, err = audioCodec(p.AudioCodec)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidProfile, err)
+	}
+	if p.AudioBitrate <= 0 {
+		return fmt.Errorf("%w: audio bitrate must be positive", ErrInvalidProfile)
+	}
+	switch p.AudioSampleRate {
+	case 44100, 48000:
+	default:
+		return fmt.Errorf("%w: unsupported audio sample rate %d", ErrInvalidProfile, p.AudioSampleRate)
+	}
+	switch p.AudioChannels {
+	case 0, 1, 2, 6:
+	default:
+		return fmt.Errorf("%w: unsupported audio channel count %d", ErrInvalidProfile, p.AudioChannels)
+	}
+	if len(p.Renditions) == 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidProfile, ErrNoRenditions)
+	}
+	for idx, r := range p.Renditions {
+		if r.Width <= 0 || r.Height <= 0 || r.Width%2 != 0 || r.Height%2 != 0 {
+			return fmt.Errorf("%w: rendition %d must have an even, positive size", ErrInvalidProfile, idx)
+		}
+		if r.Bitrate <= 0 {
+			return fmt.Errorf("%w: rendition %d must have a positive bitrate", ErrInvalidProfile, idx)
+		}
+		if r.FPS < 0 {
+			return fmt.Errorf("%w: rendition %d can't have a negative fps", ErrInvalidProfile, idx)
+		}
+		if r.Codec == "" {
+			continue
+		}
+		_, err = videoCodec(r.Codec)
+		if err != nil {
+			return fmt.Errorf("%w: rendition %d: %s", ErrInvalidProfile, idx, err)
+		}
+	}
+	return nil
+}
+
+// validVideoProfile checks the codec supports the video profile
+func validVideoProfile(codec, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	supported := map[string][]string{
+		"h264": {"baseline", "main", "high"},
+		"h265": {"main", "main10"},
+	}
+	for _, p := range supported[strings.ToLower(codec)] {
+		if p == profile {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s doesn't support the \"%s\" profile", ErrInvalidProfile, codec, profile)
+}
+
+// ListProfiles retrieves the latest version of each encoding profile
+func (mcr *MCR) ListProfiles(ctx context.Context) ([]Profile, error) {
+	profiles := []Profile{}
+	err := mcr.db.SelectContext(ctx, &profiles, `
+		SELECT DISTINCT ON (name) profile_id, name, version, description,
+			codec, video_profile, preset, gop, align_keyframes, audio_codec,
+			audio_bitrate, audio_sample_rate, audio_channels, created_at
+		FROM playout.encoding_profiles
+		ORDER BY name, version DESC;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select profiles: %w", err)
+	}
+	for idx := range profiles {
+		err = mcr.loadProfileRenditions(ctx, &profiles[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// GetProfile retrieves a version of an encoding profile, version 0
+// being the latest
+func (mcr *MCR) GetProfile(ctx context.Context, name string, version int) (*Profile, error) {
+	p := Profile{}
+	err := mcr.db.GetContext(ctx, &p, `
+		SELECT profile_id, name, version, description, codec, video_profile,
+			preset, gop, align_keyframes, audio_codec, audio_bitrate,
+			audio_sample_rate, audio_channels, created_at
+		FROM playout.encoding_profiles
+		WHERE name = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC
+		LIMIT 1;`, name, version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: \"%s\" version %d", ErrProfileNotFound, name, version)
+		}
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	err = mcr.loadProfileRenditions(ctx, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SaveProfile validates and stores a profile as the next version of
+// its name.
+//
+// Running channels keep their current encoding until the profile is
+// applied with ApplyProfile.
+func (mcr *MCR) SaveProfile(ctx context.Context, p Profile) (*Profile, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = utils.Transact(mcr.db, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &p.Version, `
+			SELECT COALESCE(MAX(version), 0) + 1
+			FROM playout.encoding_profiles
+			WHERE name = $1;`, p.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get next version: %w", err)
+		}
+		err = tx.QueryRowxContext(ctx, `
+			INSERT INTO playout.encoding_profiles(
+				name, version, description, codec, video_profile, preset,
+				gop, align_keyframes, audio_codec, audio_bitrate,
+				audio_sample_rate, audio_channels)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING profile_id, created_at;`,
+			p.Name, p.Version, p.Description, p.Codec, p.VideoProfile, p.Preset,
+			p.GOP, p.AlignKeyframes, p.AudioCodec, p.AudioBitrate,
+			p.AudioSampleRate, p.AudioChannels).Scan(&p.ID, &p.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert profile: %w", err)
+		}
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO playout.profile_renditions(
+				profile_id, position, width, height, bitrate, fps, codec)
+			VALUES ($1, $2, $3, $4, $5, $6, $7);`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare renditions: %w", err)
+		}
+		defer stmt.Close()
+		for idx, r := range p.Renditions {
+			_, err = stmt.ExecContext(ctx, p.ID, idx, r.Width, r.Height, r.Bitrate, r.FPS, r.Codec)
+			if err != nil {
+				return fmt.Errorf("failed to insert rendition: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save profile: %w", err)
+	}
+	return &p, nil
+}
+
+// PreviewProfile compiles every output following the profile as if it
+// had been applied, keyed by channel short name. Nothing is stored or
+// restarted.
+func (mcr *MCR) PreviewProfile(ctx context.Context, p Profile) (map[string][]Command, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	preview := make(map[string][]Command)
+	for _, ch := range mcr.list() {
+		for idx, o := range ch.outputs() {
+			if !o.follows(p.Name) {
+				continue
+			}
+			o.profile = &p
+			cmd, err := ch.compile(o)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile output \"%s\" on \"%s\": %w", outputKey(idx, o), ch.ShortName, err)
+			}
+			cmd.Output = outputKey(idx, o)
+			preview[ch.ShortName] = append(preview[ch.ShortName], cmd)
+		}
+	}
+	return preview, nil
+}
+
+// ApplyProfile moves every output following the profile onto its
+// latest version, restarting those on live channels
+func (mcr *MCR) ApplyProfile(ctx context.Context, name string) error {
+	mcr.changeLock.Lock()
+	defer mcr.changeLock.Unlock()
+	p, err := mcr.GetProfile(ctx, name, 0)
+	if err != nil {
+		return err
+	}
+	// Compile everything first so a bad profile doesn't take
+	// down half of the outputs
+	_, err = mcr.PreviewProfile(ctx, *p)
+	if err != nil {
+		return err
+	}
+	for _, ch := range mcr.list() {
+		err = ch.applyProfile(ctx, p)
+		if err != nil {
+			return fmt.Errorf("failed to apply profile to \"%s\": %w", ch.ShortName, err)
+		}
+	}
+	return nil
+}
+
+// applyProfile moves the channel's outputs following the profile onto
+// it, restarting them if the channel is live
+func (ch *Channel) applyProfile(ctx context.Context, p *Profile) error {
+	resume := ch.pauseMonitor()
+	defer resume()
+	live := ch.isLive()
+	for idx, o := range ch.outputs() {
+		if !o.follows(p.Name) {
+			continue
+		}
+		if live {
+			err := ch.stopOutput(ctx, idx)
+			if err != nil {
+				return fmt.Errorf("failed to stop output: %w", err)
+			}
+		}
+		ch.confLock.Lock()
+		ch.Outputs[idx].profile = p
+		ch.confLock.Unlock()
+		if live {
+			err := ch.startOutput(ctx, idx)
+			if err != nil {
+				return fmt.Errorf("failed to restart output: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveProfile loads the profile an output references
+func (mcr *MCR) resolveProfile(ctx context.Context, o *Output) error {
+	o.profile = nil
+	if o.Profile == "" {
+		return nil
+	}
+	p, err := mcr.GetProfile(ctx, o.Profile, o.ProfileVersion)
+	if err != nil {
+		return err
+	}
+	o.profile = p
+	return nil
+}
+
+// resolveProfiles loads the profiles of each of the channel's outputs,
+// outputs which fail are left to error when compiled
+func (mcr *MCR) resolveProfiles(ctx context.Context, ch *Channel) {
+	for idx := range ch.Outputs {
+		err := mcr.resolveProfile(ctx, &ch.Outputs[idx])
+		if err != nil {
+			log.Printf("channel \"%s\": failed to resolve profile of output \"%s\": %+v",
+				ch.ShortName, outputKey(idx, ch.Outputs[idx]), err)
+		}
+	}
+}
+
+// loadProfileRenditions retrieves a profile's rendition ladder
+func (mcr *MCR) loadProfileRenditions(ctx context.Context, p *Profile) error {
+	p.Renditions = []Rendition{}
+	err := mcr.db.SelectContext(ctx, &p.Renditions, `
+		SELECT width, height, bitrate, fps, codec
+		FROM playout.profile_renditions
+		WHERE profile_id = $1
+		ORDER BY position;`, p.ID)
+	if err != nil {
+		return fmt.Errorf("failed to select profile renditions: %w", err)
+	}
+	return nil
+}
+
+// follows is when the output uses the latest version of the profile
+func (o Output) follows(profile string) bool {
+	return o.Profile == profile && o.ProfileVersion == 0
+}
@@ -0,0 +1,300 @@
+package channel
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ystv/playout/scheduler"
+)
+
+// redacted replaces secrets wherever they would be shown
+const redacted = "REDACTED"
+
+// ErrInvalidRestream is when a restream output doesn't make sense
+var ErrInvalidRestream = errors.New("invalid restream")
+
+// isRestream is when the output simulcasts to a platform
+func (o Output) isRestream() bool {
+	return strings.EqualFold(o.Type, "restream")
+}
+
+// protocol is how the output is sent, a restream's is decided by
+// its destination
+func (o Output) protocol() string {
+	if !o.isRestream() {
+		return o.Type
+	}
+	u, err := url.Parse(o.Destination)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp", "rtmps":
+		return "rtmp"
+	case "srt":
+		return "srt"
+	default:
+		return ""
+	}
+}
+
+// validateRestream checks a restream can be sent, and that only
+// restreams have their options
+func validateRestream(o Output) error {
+	if !o.isRestream() {
+		if o.StreamKey != "" || o.Disabled || o.Scheduled {
+			return fmt.Errorf("%w: only restream outputs have stream keys or are disabled or scheduled", ErrInvalidRestream)
+		}
+		return nil
+	}
+	switch o.protocol() {
+	case "rtmp":
+	case "srt":
+		if o.SRTOptions.accepts() {
+			return fmt.Errorf("%w: srt destinations have to be called", ErrInvalidRestream)
+		}
+	default:
+		return fmt.Errorf("%w: \"%s\" must be a rtmp(s):// or srt:// url", ErrInvalidRestream, o.Destination)
+	}
+	return nil
+}
+
+// restreamURL is the destination with the stream key applied, as the
+// last part of the path of RTMP or the stream ID of SRT
+func (o Output) restreamURL() (string, error) {
+	if o.protocol() != "srt" {
+		if o.StreamKey == "" {
+			return o.Destination, nil
+		}
+		return strings.TrimSuffix(o.Destination, "/") + "/" + o.StreamKey, nil
+	}
+	dst, err := o.SRTOptions.ffmpegURL(o.Destination)
+	if err != nil || o.StreamKey == "" {
+		return dst, err
+	}
+	u, err := url.Parse(dst)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse srt url: %w", err)
+	}
+	q := u.Query()
+	q.Set("streamid", o.StreamKey)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// secrets are the parts of an output's command which can't be shown
+func (o Output) secrets() []string {
+	secrets := []string{}
+	for _, secret := range []string{o.StreamKey, o.SRTPassphrase} {
+		if secret != "" {
+			secrets = append(secrets, secret, url.QueryEscape(secret))
+		}
+	}
+	return secrets
+}
+
+// Redacted is a copy of the output which is safe to show, its stream
+// key and SRT passphrase are hidden wherever they appear
+func (o Output) Redacted() Output {
+	for _, secret := range o.secrets() {
+		o.Destination = strings.ReplaceAll(o.Destination, secret, redacted)
+		o.Args = strings.ReplaceAll(o.Args, secret, redacted)
+	}
+	if o.StreamKey != "" {
+		o.StreamKey = redacted
+	}
+	if o.SRTPassphrase != "" {
+		o.SRTPassphrase = redacted
+	}
+	return o
+}
+
+// redactOutputs copies outputs to be shown, also hiding the channel's
+// secrets
+func redactOutputs(outputs []Output, secrets []string) []Output {
+	res := make([]Output, 0, len(outputs))
+	for _, o := range outputs {
+		o = o.Redacted()
+		for _, secret := range secrets {
+			o.Destination = strings.ReplaceAll(o.Destination, secret, redacted)
+			o.Args = strings.ReplaceAll(o.Args, secret, redacted)
+		}
+		res = append(res, o)
+	}
+	return res
+}
+
+// redact hides the command's secrets in s
+func (c Command) redact(s string) string {
+	for _, secret := range c.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// streamKeyCipher encrypts outputs' stream keys and SRT passphrases at
+// rest with AES-GCM under the MCR's secret
+func (mcr *MCR) streamKeyCipher() (cipher.AEAD, error) {
+	if len(mcr.conf.StreamKeySecret) == 0 {
+		return nil, fmt.Errorf("%w: no secret is configured to encrypt stream keys and passphrases", ErrInvalidRestream)
+	}
+	block, err := aes.NewCipher(mcr.conf.StreamKeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream key cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealStreamKey encrypts a stream key to be stored, prefixed by its nonce
+func (mcr *MCR) sealStreamKey(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	aead, err := mcr.streamKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(key), nil)), nil
+}
+
+// openStreamKey decrypts a stored stream key
+func (mcr *MCR) openStreamKey(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	aead, err := mcr.streamKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode stream key: %w", err)
+	}
+	if len(b) < aead.NonceSize() {
+		return "", errors.New("stream key is too short")
+	}
+	key, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt stream key: %w", err)
+	}
+	return string(key), nil
+}
+
+// runs is when an output should be running while the channel is live,
+// restreams can be disabled or only run while a playout is on air
+func (ch *Channel) runs(o Output) bool {
+	if !o.isRestream() {
+		return true
+	}
+	if o.Disabled {
+		return false
+	}
+	if !o.Scheduled {
+		return true
+	}
+	ch.restreamLock.Lock()
+	defer ch.restreamLock.Unlock()
+	return ch.onAir != 0
+}
+
+// idleReason is why a restream isn't running
+func (o Output) idleReason() string {
+	if o.Disabled {
+		return "restream is disabled"
+	}
+	return "waiting for a playout to go on air"
+}
+
+// isRestreaming is when a restream output's process has been started
+func (ch *Channel) isRestreaming(key string) bool {
+	ch.restreamLock.Lock()
+	defer ch.restreamLock.Unlock()
+	return ch.restreaming[key]
+}
+
+// setRestreaming records a restream output's process starting or stopping
+func (ch *Channel) setRestreaming(key string, on bool) {
+	ch.restreamLock.Lock()
+	defer ch.restreamLock.Unlock()
+	if !on {
+		delete(ch.restreaming, key)
+		return
+	}
+	if ch.restreaming == nil {
+		ch.restreaming = make(map[string]bool)
+	}
+	ch.restreaming[key] = true
+}
+
+// clearRestreaming forgets every restream, once the transcoder has
+// stopped them
+func (ch *Channel) clearRestreaming() {
+	ch.restreamLock.Lock()
+	defer ch.restreamLock.Unlock()
+	ch.restreaming = nil
+}
+
+// handleRestreams starts scheduled restreams as playouts go on air and
+// stops them as they come off
+func (ch *Channel) handleRestreams(e scheduler.Event) {
+	ch.restreamLock.Lock()
+	switch e.Type {
+	case scheduler.EventPlayoutStarted:
+		ch.onAir = e.Playout.PlayoutID
+	case scheduler.EventPlayoutEnded:
+		if ch.onAir != e.Playout.PlayoutID {
+			// The next playout has already started
+			ch.restreamLock.Unlock()
+			return
+		}
+		ch.onAir = 0
+	default:
+		ch.restreamLock.Unlock()
+		return
+	}
+	ch.restreamLock.Unlock()
+	ch.syncRestreams(context.Background(), e.Playout.PlayoutID)
+}
+
+// syncRestreams starts the scheduled restreams which should be running
+// and stops the ones which shouldn't
+func (ch *Channel) syncRestreams(ctx context.Context, playoutID int) {
+	if !ch.isLive() {
+		return
+	}
+	for idx, o := range ch.outputs() {
+		if !o.isRestream() || !o.Scheduled {
+			continue
+		}
+		key := outputKey(idx, o)
+		want, running := ch.runs(o), ch.isRestreaming(key)
+		switch {
+		case want && !running:
+			err := ch.startOutput(ctx, idx)
+			if err != nil {
+				ch.recordEvent(EventRestreamFailed, "failed to start \"%s\" for playout %d: %s", key, playoutID, err)
+				continue
+			}
+			ch.recordEvent(EventRestreamStarted, "\"%s\" for playout %d", key, playoutID)
+		case !want && running:
+			err := ch.stopOutput(ctx, idx)
+			if err != nil {
+				ch.recordEvent(EventRestreamFailed, "failed to stop \"%s\" after playout %d: %s", key, playoutID, err)
+				continue
+			}
+			ch.recordEvent(EventRestreamStopped, "\"%s\", playout %d ended", key, playoutID)
+		}
+	}
+}
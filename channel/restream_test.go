@@ -0,0 +1,122 @@
+package channel
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/scheduler"
+	"github.com/ystv/playout/vt/vttest"
+)
+
+func TestScheduledRestream(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+	ch.Outputs = append(ch.Outputs, Output{
+		ID:          3,
+		Type:        "restream",
+		Destination: "rtmp://a.rtmp.youtube.com/live2",
+		StreamKey:   "secret-key",
+		Passthrough: true,
+		Scheduled:   true,
+	})
+	ctx := context.Background()
+	err := mcr.StartChannel(ctx, ch.ShortName)
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	defer mcr.StopChannel(ctx, ch.ShortName)
+	if running := srv.Running(); len(running) != 2 || ch.isRestreaming("output-3") {
+		t.Fatalf("vt is running %d tasks, the restream should wait for a playout", len(running))
+	}
+
+	po := playout.Playout{PlayoutID: 10}
+	ch.handleRestreams(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: po})
+	running := srv.Running()
+	if len(running) != 3 || !ch.isRestreaming("output-3") {
+		t.Fatalf("vt is running %d tasks, want the restream started", len(running))
+	}
+	if dst := running[2].Live.DstURL; !strings.HasSuffix(dst, "/live2/secret-key") {
+		t.Errorf("restream is sent to %q", dst)
+	}
+
+	// An earlier playout ending after the next started leaves it running
+	ch.handleRestreams(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: playout.Playout{PlayoutID: 9}})
+	if !ch.isRestreaming("output-3") {
+		t.Error("restream stopped when another playout ended")
+	}
+
+	ch.handleRestreams(scheduler.Event{Type: scheduler.EventPlayoutEnded, Playout: po})
+	if running := srv.Running(); len(running) != 2 || ch.isRestreaming("output-3") {
+		t.Errorf("vt is running %d tasks, want the restream stopped", len(running))
+	}
+	types := eventTypes(ch)
+	if len(types) != 2 || types[0] != EventRestreamStarted || types[1] != EventRestreamStopped {
+		t.Errorf("recorded %q, want the restream started then stopped", types)
+	}
+}
+
+func TestScheduledRestreamFails(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+	ch.Outputs = append(ch.Outputs, Output{
+		ID:          3,
+		Type:        "restream",
+		Destination: "rtmp://a.rtmp.youtube.com/live2",
+		StreamKey:   "secret-key",
+		Passthrough: true,
+		Scheduled:   true,
+	})
+	ctx := context.Background()
+	err := mcr.StartChannel(ctx, ch.ShortName)
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	defer mcr.StopChannel(ctx, ch.ShortName)
+
+	srv.Fail(vttest.RouteLive, http.StatusInternalServerError)
+	ch.handleRestreams(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: playout.Playout{PlayoutID: 10}})
+	if ch.isRestreaming("output-3") {
+		t.Fatal("restream is running though vt failed to start it")
+	}
+	types := eventTypes(ch)
+	if len(types) != 1 || types[0] != EventRestreamFailed {
+		t.Errorf("recorded %q, want the restream failing", types)
+	}
+}
+
+func TestScheduledRestreamWaitsForChannel(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	mcr := testMCR(srv.Tracker())
+	ch := addTestChannel(t, mcr)
+	ch.Outputs = append(ch.Outputs, Output{
+		ID:          3,
+		Type:        "restream",
+		Destination: "rtmp://a.rtmp.youtube.com/live2",
+		StreamKey:   "secret-key",
+		Passthrough: true,
+		Scheduled:   true,
+	})
+
+	ch.handleRestreams(scheduler.Event{Type: scheduler.EventPlayoutStarted, Playout: playout.Playout{PlayoutID: 10}})
+	if running := srv.Running(); len(running) != 0 {
+		t.Fatalf("vt is running %+v while the channel is stopped", running)
+	}
+
+	// It goes out with the channel, the playout being on air
+	err := mcr.StartChannel(context.Background(), ch.ShortName)
+	if err != nil {
+		t.Fatalf("failed to start channel: %+v", err)
+	}
+	defer mcr.StopChannel(context.Background(), ch.ShortName)
+	if running := srv.Running(); len(running) != 3 || !ch.isRestreaming("output-3") {
+		t.Errorf("vt is running %d tasks, want the restream with the others", len(running))
+	}
+}
@@ -0,0 +1,363 @@
+package channel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ingestFailures is the amount of consecutive failed checks
+	// before an ingest is considered dropped
+	ingestFailures = 2
+	// checkTimeout is how long a single ingest check can take
+	checkTimeout = 10 * time.Second
+)
+
+// IngestChecker reports whether an ingest is currently providing video
+// and audio
+type IngestChecker interface {
+	Check(ctx context.Context, url, ingestType string) error
+}
+
+// FFprobeChecker checks an ingest by probing it for video and audio
+// streams
+type FFprobeChecker struct {
+	Path string // ffprobe binary
+}
+
+var _ IngestChecker = &FFprobeChecker{}
+
+// Check probes the ingest, failing if there isn't a video or an
+// audio stream
+func (c *FFprobeChecker) Check(ctx context.Context, url, ingestType string) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	path := c.Path
+	if path == "" {
+		path = "ffprobe"
+	}
+	args := []string{"-v", "error", "-show_entries", "stream=codec_type", "-of", "csv=p=0"}
+	switch strings.ToLower(ingestType) {
+	case "rtmp":
+		args = append(args, "-f", "flv")
+	case "srt":
+		args = append(args, "-f", "mpegts")
+	}
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, path, append(args, url)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return fmt.Errorf("ingest stalled: %w", ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to probe ingest: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if !strings.Contains(string(out), "video") {
+		return errors.New("ingest has no video")
+	}
+	if !strings.Contains(string(out), "audio") {
+		return errors.New("ingest has no audio")
+	}
+	return nil
+}
+
+// failsOver is when the channel watches its ingest sources to fail
+// over between them and its slate, channels with a piper rely on it
+// instead.
+//
+// SRT ingests which accept the connection can't be checked since
+// probing would take the connection from the outputs.
+func (ch *Channel) failsOver() bool {
+	if strings.EqualFold(ch.IngestType, "srt") && ch.SRTOptions.accepts() {
+		return false
+	}
+	return !ch.HasPiper && (ch.SlateURL != "" || len(ch.BackupIngests) > 0) && ch.checker != nil
+}
+
+// onSlate reports whether the outputs are fed by the slate
+func (ch *Channel) onSlate() bool {
+	return ch.input().Slate
+}
+
+// startIngestMonitor watches the ingest sources in the background if
+// the channel fails over
+func (ch *Channel) startIngestMonitor() {
+	ch.stopIngestMonitor()
+	if !ch.failsOver() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ch.inputLock.Lock()
+	ch.monitorCancel = cancel
+	ch.monitorDone = done
+	ch.inputLock.Unlock()
+	go func() {
+		defer close(done)
+		ch.monitorIngest(ctx)
+	}()
+}
+
+// stopIngestMonitor stops watching the ingest and waits for it to finish
+func (ch *Channel) stopIngestMonitor() {
+	ch.inputLock.Lock()
+	cancel, done := ch.monitorCancel, ch.monitorDone
+	ch.monitorCancel, ch.monitorDone = nil, nil
+	ch.inputLock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// sourceHealth is what the monitor knows of an ingest source
+type sourceHealth struct {
+	failures     int       // Consecutive failed checks
+	healthySince time.Time // Zero while failing
+	err          error     // Of the latest check
+}
+
+// up is when the source can feed the outputs
+func (h sourceHealth) up() bool {
+	return h.failures < ingestFailures
+}
+
+// stable is when the source has been healthy long enough to go back to
+func (h sourceHealth) stable(recovery time.Duration) bool {
+	return h.failures == 0 && !h.healthySince.IsZero() && time.Since(h.healthySince) >= recovery
+}
+
+// monitorIngest checks every ingest source each interval, failing over
+// down the sources then to the slate when the one feeding the outputs
+// drops, and back to a preferred one once it has been stable for the
+// recovery period. Nothing is switched while the input is pinned.
+func (ch *Channel) monitorIngest(ctx context.Context) {
+	interval, recovery := 5*time.Second, 30*time.Second
+	if ch.conf != nil {
+		if ch.conf.IngestCheckInterval > 0 {
+			interval = ch.conf.IngestCheckInterval
+		}
+		if ch.conf.SlateRecovery > 0 {
+			recovery = ch.conf.SlateRecovery
+		}
+	}
+	ch.confLock.RLock()
+	sources := ch.ingestSources()
+	hasSlate := ch.SlateURL != ""
+	if ch.FailbackDelay > 0 {
+		recovery = time.Duration(ch.FailbackDelay) * time.Second
+	}
+	ch.confLock.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health := make([]sourceHealth, len(sources))
+	switched := time.Now() // The encoders are given time to start reading
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		errs := ch.checkSources(ctx, sources, switched)
+		if ctx.Err() != nil {
+			return
+		}
+		for idx, err := range errs {
+			h := &health[idx]
+			h.err = err
+			if err != nil {
+				h.failures++
+				h.healthySince = time.Time{}
+				continue
+			}
+			h.failures = 0
+			if h.healthySince.IsZero() {
+				h.healthySince = time.Now()
+			}
+		}
+
+		ch.inputLock.Lock()
+		current, pinned := ch.current, ch.pin != nil
+		ch.inputLock.Unlock()
+		if pinned {
+			continue
+		}
+		next, reason := nextInput(current, health, hasSlate, recovery)
+		if next != current {
+			ch.switchInput(ctx, next, reason)
+			switched = time.Now()
+		}
+	}
+}
+
+// nextInput is the input the outputs should move to from current,
+// which is current if they should stay
+func nextInput(current Input, health []sourceHealth, hasSlate bool, recovery time.Duration) (Input, string) {
+	stableFor := fmt.Sprintf("stable for %s", recovery)
+	if current.Slate {
+		for idx, h := range health {
+			if h.stable(recovery) {
+				return Input{Source: idx}, stableFor
+			}
+		}
+		return current, ""
+	}
+	if current.Source >= len(health) {
+		// The sources have changed under it
+		return Input{}, "source removed"
+	}
+	// Preferred sources have to be stable to go back to them
+	for idx := 0; idx < current.Source; idx++ {
+		if health[idx].stable(recovery) {
+			return Input{Source: idx}, stableFor
+		}
+	}
+	h := health[current.Source]
+	if h.up() {
+		return current, ""
+	}
+	lost := fmt.Sprintf("%s lost: %v", current, h.err)
+	for idx := range health {
+		if idx != current.Source && health[idx].up() {
+			return Input{Source: idx}, lost
+		}
+	}
+	if hasSlate {
+		return Input{Slate: true}, lost
+	}
+	return current, ""
+}
+
+// checkSources checks each source at once, so a stalled one doesn't
+// hold up the others
+func (ch *Channel) checkSources(ctx context.Context, sources []IngestSource, switched time.Time) []error {
+	active := ch.input()
+	errs := make([]error, len(sources))
+	wg := sync.WaitGroup{}
+	for idx, src := range sources {
+		if !active.Slate && idx == active.Source {
+			errs[idx] = ch.checkActive(ctx, src, switched)
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, src IngestSource) {
+			defer wg.Done()
+			errs[idx] = ch.probeSource(ctx, src)
+		}(idx, src)
+	}
+	wg.Wait()
+	return errs
+}
+
+// probeSource checks a source with the ingest checker
+func (ch *Channel) probeSource(ctx context.Context, src IngestSource) error {
+	url, err := src.ffmpegURL()
+	if err != nil {
+		return err
+	}
+	return ch.checker.Check(ctx, url, src.Type)
+}
+
+// checkActive checks the source feeding the outputs by whether their
+// encoders are making progress, since probing an RTP source would
+// fight them for its port. Without progress, only sources which
+// aren't bound to a port are probed.
+func (ch *Channel) checkActive(ctx context.Context, src IngestSource, switched time.Time) error {
+	reporter, ok := ch.tc.(ProgressReporter)
+	if !ok {
+		if src.portBound() {
+			return nil
+		}
+		return ch.probeSource(ctx, src)
+	}
+	stale := ch.thresholds().StaleAfter
+	if time.Since(switched) < stale {
+		return nil
+	}
+	running := 0
+	for idx, o := range ch.outputs() {
+		if !ch.runs(o) {
+			continue
+		}
+		running++
+		cur, _, err := reporter.Progress(outputKey(idx, o))
+		if err != nil {
+			continue
+		}
+		if time.Since(cur.UpdatedAt) <= stale || cur.UpdatedAt.IsZero() && time.Since(cur.StartedAt) <= stale {
+			// Progressing, or only just restarted
+			return nil
+		}
+	}
+	if running == 0 {
+		return nil
+	}
+	return fmt.Errorf("no output has made progress for %s", stale)
+}
+
+// switchInput moves the outputs to another ingest source or the slate,
+// the recording follows the ingest between sources
+func (ch *Channel) switchInput(ctx context.Context, next Input, reason string) {
+	ch.inputLock.Lock()
+	prev := ch.current
+	ch.current = next
+	ch.inputLock.Unlock()
+
+	err := ch.restartOutputs(ctx)
+	if err != nil {
+		log.Printf("channel \"%s\": failed to switch input: %+v", ch.ShortName, err)
+	}
+	if !next.Slate && !prev.Slate && next.Source != prev.Source {
+		err = ch.stopRecording(ctx)
+		if err == nil {
+			err = ch.startRecording(ctx)
+		}
+		if err != nil {
+			log.Printf("channel \"%s\": failed to move recording to %s: %+v", ch.ShortName, next, err)
+		}
+	}
+
+	switch {
+	case next.Slate:
+		ch.recordEvent(EventSlateOn, "playing slate: %s", reason)
+	case prev.Slate:
+		ch.recordEvent(EventSlateOff, "ingest restored from %s: %s", next, reason)
+	default:
+		ch.recordEvent(EventIngestSwitched, "switched from %s to %s: %s", prev, next, reason)
+	}
+	switch {
+	case next.Slate:
+		ch.transition(StateDegraded, "on slate: "+reason)
+	case next.Source == 0:
+		ch.settle("on primary: " + reason)
+	default:
+		ch.transition(StateDegraded, fmt.Sprintf("on %s: %s", next, reason))
+	}
+}
+
+// restartOutputs stops then starts each output, picking up any
+// change in input
+func (ch *Channel) restartOutputs(ctx context.Context) error {
+	for idx := range ch.outputs() {
+		err := ch.stopOutput(ctx, idx)
+		if err != nil {
+			return fmt.Errorf("failed to stop output: %w", err)
+		}
+		err = ch.startOutput(ctx, idx)
+		if err != nil {
+			return fmt.Errorf("failed to restart output: %w", err)
+		}
+	}
+	return nil
+}
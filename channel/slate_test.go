@@ -0,0 +1,38 @@
+package channel
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestFFprobeChecker checks an ingest needs video and audio to be up
+func TestFFprobeChecker(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams string
+		err     string
+	}{
+		{name: "video and audio", streams: `video\naudio\n`},
+		{name: "no audio", streams: `video\n`, err: "ingest has no audio"},
+		{name: "no video", streams: `audio\n`, err: "ingest has no video"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ffprobe")
+			err := ioutil.WriteFile(path, []byte("#!/bin/sh\nprintf '"+test.streams+"'\n"), 0755)
+			if err != nil {
+				t.Fatalf("failed to write fake ffprobe: %+v", err)
+			}
+			c := &FFprobeChecker{Path: path}
+			err = c.Check(context.Background(), "rtmp://ingest.example.com/live/test", "rtmp")
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("ingest is down: %+v", err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Errorf("check failed with %v, want %q", err, test.err)
+			}
+		})
+	}
+}
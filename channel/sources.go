@@ -0,0 +1,63 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/scheduler"
+)
+
+// ErrNoScheduler is when a channel doesn't have a scheduler
+var ErrNoScheduler = errors.New("channel has no scheduler")
+
+// handleSourceCheck records upcoming playouts being flagged and cleared
+func (ch *Channel) handleSourceCheck(e scheduler.Event) {
+	switch e.Type {
+	case scheduler.EventPlayoutFlagged:
+		ch.recordEvent(EventSourceFlagged, "playout %d at %s: %s", e.Playout.PlayoutID,
+			e.Playout.ScheduledStart.Format(time.RFC3339), strings.Join(e.Problems, "; "))
+	case scheduler.EventPlayoutCleared:
+		ch.recordEvent(EventSourceCleared, "playout %d at %s", e.Playout.PlayoutID,
+			e.Playout.ScheduledStart.Format(time.RFC3339))
+	}
+}
+
+// scheduler returns the channel's scheduler
+func (ch *Channel) scheduler() (*scheduler.Scheduler, error) {
+	ch.confLock.RLock()
+	defer ch.confLock.RUnlock()
+	if ch.sch == nil {
+		return nil, ErrNoScheduler
+	}
+	return ch.sch, nil
+}
+
+// GetSourceChecks retrieves the latest checks of a channel's upcoming
+// playouts
+func (mcr *MCR) GetSourceChecks(ctx context.Context, shortName string) ([]scheduler.SourceCheck, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	sch, err := ch.scheduler()
+	if err != nil {
+		return nil, err
+	}
+	return sch.SourceChecks(), nil
+}
+
+// CheckSources checks a channel's upcoming playouts now rather than
+// waiting for the next interval
+func (mcr *MCR) CheckSources(ctx context.Context, shortName string) ([]scheduler.SourceCheck, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	sch, err := ch.scheduler()
+	if err != nil {
+		return nil, err
+	}
+	return sch.CheckUpcoming(ctx)
+}
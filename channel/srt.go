@@ -0,0 +1,130 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SRT connection modes
+const (
+	SRTCaller     = "caller"     // Connect to a listener
+	SRTListener   = "listener"   // Wait for a caller
+	SRTRendezvous = "rendezvous" // Both ends connect to each other
+)
+
+const (
+	// srtMinPassphrase and srtMaxPassphrase are the lengths SRT
+	// accepts for a passphrase
+	srtMinPassphrase = 10
+	srtMaxPassphrase = 79
+)
+
+var (
+	// ErrInvalidSRT is when SRT options don't make sense
+	ErrInvalidSRT = errors.New("invalid srt options")
+	// ErrSRTSharedIngest is when an SRT ingest which accepts the
+	// connection would be read by more than one process
+	ErrSRTSharedIngest = errors.New("srt listener and rendezvous ingests can only feed a single output")
+)
+
+// SRTOptions configure an SRT connection, only valid on srt ingests
+// and outputs
+type SRTOptions struct {
+	SRTMode       string `db:"srt_mode" json:"srtMode"`             // caller / listener / rendezvous, empty is caller
+	SRTPassphrase string `db:"srt_passphrase" json:"srtPassphrase"` // Encrypts the stream, empty disables encryption
+	SRTLatency    int    `db:"srt_latency" json:"srtLatency"`       // Milliseconds, 0 uses SRT's default
+}
+
+// Redacted is a copy of the options which is safe to show, with the
+// passphrase hidden
+func (o SRTOptions) Redacted() SRTOptions {
+	if o.SRTPassphrase != "" {
+		o.SRTPassphrase = redacted
+	}
+	return o
+}
+
+// isSet is when any SRT option has been given
+func (o SRTOptions) isSet() bool {
+	return o != (SRTOptions{})
+}
+
+// mode is the connection mode, defaulting to caller
+func (o SRTOptions) mode() string {
+	if o.SRTMode == "" {
+		return SRTCaller
+	}
+	return strings.ToLower(o.SRTMode)
+}
+
+// accepts is when the connection is made to us rather than by us
+func (o SRTOptions) accepts() bool {
+	return o.mode() == SRTListener || o.mode() == SRTRendezvous
+}
+
+// validate checks the options are valid for a connection type, SRT
+// options can't be given to other types
+func (o SRTOptions) validate(connType string) error {
+	if !strings.EqualFold(connType, "srt") {
+		if o.isSet() {
+			return fmt.Errorf("%w: \"%s\" doesn't use srt options", ErrInvalidSRT, connType)
+		}
+		return nil
+	}
+	switch o.mode() {
+	case SRTCaller, SRTListener, SRTRendezvous:
+	default:
+		return fmt.Errorf("%w: unknown mode \"%s\"", ErrInvalidSRT, o.SRTMode)
+	}
+	if o.SRTPassphrase != "" && (len(o.SRTPassphrase) < srtMinPassphrase || len(o.SRTPassphrase) > srtMaxPassphrase) {
+		return fmt.Errorf("%w: passphrase must be %d to %d characters", ErrInvalidSRT, srtMinPassphrase, srtMaxPassphrase)
+	}
+	if o.SRTLatency < 0 {
+		return fmt.Errorf("%w: latency can't be negative", ErrInvalidSRT)
+	}
+	return nil
+}
+
+// ffmpegURL sets the options as query parameters of an srt:// URL
+// for ffmpeg's libsrt, keeping any others
+func (o SRTOptions) ffmpegURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse srt url: %w", err)
+	}
+	if u.Scheme != "srt" {
+		return "", fmt.Errorf("%w: \"%s\" isn't an srt:// url", ErrInvalidSRT, base)
+	}
+	q := u.Query()
+	q.Set("mode", o.mode())
+	if o.SRTPassphrase != "" {
+		q.Set("passphrase", o.SRTPassphrase)
+	}
+	if o.SRTLatency > 0 {
+		// libsrt takes microseconds
+		q.Set("latency", strconv.Itoa(o.SRTLatency*1000))
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// redactSRT hides the passphrase of an srt:// URL so it can be logged
+func redactSRT(arg string) string {
+	if !strings.HasPrefix(arg, "srt://") {
+		return arg
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return arg
+	}
+	q := u.Query()
+	if q.Get("passphrase") == "" {
+		return arg
+	}
+	q.Set("passphrase", "REDACTED")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,141 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTransition is when a channel is asked to move to a
+// state it can't reach from its current state
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// State is a stage in a channel's lifecycle
+type State string
+
+// Channel states
+//
+// pending → starting → running → stopping → stopped, with running and
+// degraded swapping as outputs fail and recover.
+const (
+	StatePending  State = "pending"  // Created but never started
+	StateStarting State = "starting" // Outputs are being started
+	StateRunning  State = "running"  // All outputs are healthy
+	StateDegraded State = "degraded" // Running, but some outputs are unhealthy
+	StateStopping State = "stopping" // Outputs are being stopped
+	StateStopped  State = "stopped"  // All outputs have stopped
+	StateFailed   State = "failed"   // Couldn't start or crashed out
+)
+
+// transitions are the states reachable from each state
+var transitions = map[State][]State{
+	StatePending:  {StateStarting, StateStopped},
+	StateStarting: {StateRunning, StateDegraded, StateStopping, StateFailed},
+	StateRunning:  {StateDegraded, StateStopping, StateFailed},
+	StateDegraded: {StateRunning, StateStopping, StateFailed},
+	StateStopping: {StateStopped, StateFailed},
+	StateStopped:  {StateStarting},
+	StateFailed:   {StateStarting, StateStopping, StateStopped},
+}
+
+type (
+	// Status is a channel's position in its lifecycle
+	Status struct {
+		State    State     `json:"state"`
+		Previous State     `json:"previous"`
+		Reason   string    `json:"reason"` // Why the last transition happened
+		Since    time.Time `json:"since"`
+	}
+	// Transition is a change in a channel's state, given to subscribers
+	Transition struct {
+		Channel string    `json:"channel"` // Short name
+		From    State     `json:"from"`
+		To      State     `json:"to"`
+		Reason  string    `json:"reason"`
+		At      time.Time `json:"at"`
+	}
+)
+
+// CanTransition reports whether to is reachable from the state
+func (s State) CanTransition(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Stat returns the current status of the channel
+//
+// Used by http api to allow VT to check if the stream still needs to be up
+func (ch *Channel) Stat() (Status, error) {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	if ch.status.State == "" {
+		return Status{State: StatePending}, nil
+	}
+	return ch.status, nil
+}
+
+// Subscribe registers fn to be called after each of the channel's
+// transitions, the returned function removes the subscription.
+//
+// Subscribers are called synchronously and shouldn't block.
+func (ch *Channel) Subscribe(fn func(Transition)) (unsubscribe func()) {
+	ch.stateLock.Lock()
+	defer ch.stateLock.Unlock()
+	if ch.subscribers == nil {
+		ch.subscribers = make(map[int]func(Transition))
+	}
+	id := ch.nextSubscriber
+	ch.nextSubscriber++
+	ch.subscribers[id] = fn
+	return func() {
+		ch.stateLock.Lock()
+		defer ch.stateLock.Unlock()
+		delete(ch.subscribers, id)
+	}
+}
+
+// transition moves the channel to a new state, notifying subscribers
+//
+// Moving to the current state is a no-op.
+func (ch *Channel) transition(to State, reason string) error {
+	ch.stateLock.Lock()
+	from := ch.status.State
+	if from == "" {
+		from = StatePending
+	}
+	if from == to {
+		ch.stateLock.Unlock()
+		return nil
+	}
+	if !from.CanTransition(to) {
+		ch.stateLock.Unlock()
+		return fmt.Errorf("%w: %s → %s", ErrInvalidTransition, from, to)
+	}
+	t := Transition{
+		Channel: ch.ShortName,
+		From:    from,
+		To:      to,
+		Reason:  reason,
+		At:      time.Now(),
+	}
+	ch.status = Status{
+		State:    to,
+		Previous: from,
+		Reason:   reason,
+		Since:    t.At,
+	}
+	subscribers := make([]func(Transition), 0, len(ch.subscribers))
+	for _, fn := range ch.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	ch.stateLock.Unlock()
+
+	for _, fn := range subscribers {
+		fn(t)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ystv/playout/utils"
+	"github.com/ystv/playout/vt"
+)
+
+var (
+	// ErrUnknownTranscoder is when an unsupported transcoder is
+	// attempted to be used
+	ErrUnknownTranscoder = errors.New("unknown transcoder")
+	// ErrNoTranscoder is when a channel is started without one
+	ErrNoTranscoder = errors.New("channel has no transcoder")
+)
+
+// Transcoder runs a channel's compiled commands, each command
+// is identified by the output it produces
+type Transcoder interface {
+	// Start runs the command until it is stopped
+	Start(ctx context.Context, cmd Command) error
+	// Stop ends the command producing the output
+	Stop(ctx context.Context, output string) error
+	// StopAll ends every command
+	StopAll(ctx context.Context) error
+}
+
+// newTranscoder creates the transcoder backend chosen in the config
+func (mcr *MCR) newTranscoder(ch *Channel) (Transcoder, error) {
+	switch mcr.conf.Transcoder {
+	case "local":
+		return NewLocalTranscoder(mcr.conf.FFmpegPath, ch.ShortName), nil
+	case "vt":
+		return &vtTranscoder{ch: ch, tracker: mcr.vt}, nil
+	default:
+		return nil, fmt.Errorf("%w: \"%s\"", ErrUnknownTranscoder, mcr.conf.Transcoder)
+	}
+}
+
+// vtTranscoder hands a channel's commands to VT as live tasks,
+// tracking them against the channel's outputs
+type vtTranscoder struct {
+	ch      *Channel
+	tracker *vt.Tracker
+}
+
+var _ Transcoder = &vtTranscoder{}
+
+// Start submits the command as a VT live task, adopting the output's
+// task instead if it is still running from before a restart
+func (t *vtTranscoder) Start(ctx context.Context, cmd Command) error {
+	owner := vt.Owner{ChannelID: t.ch.ID, Output: cmd.Output}
+	taskID, running, err := t.tracker.Adopt(ctx, owner)
+	if err != nil {
+		return fmt.Errorf("failed to check for running task: %w", err)
+	}
+	if running {
+		log.Printf("channel \"%s\": adopted vt task \"%s\" for \"%s\"", t.ch.ShortName, taskID, cmd.Output)
+		return nil
+	}
+	task, err := cmd.task()
+	if err != nil {
+		return err
+	}
+	taskID, err = t.tracker.Client().NewLive(ctx, task)
+	if err != nil {
+		return err
+	}
+	return t.tracker.Track(ctx, taskID, owner)
+}
+
+// Stop cancels the output's VT task
+func (t *vtTranscoder) Stop(ctx context.Context, output string) error {
+	return t.tracker.Cancel(ctx, vt.Owner{ChannelID: t.ch.ID, Output: output})
+}
+
+// StopAll cancels the VT tasks of all of the channel's outputs,
+// leaving its scheduler's playouts alone
+func (t *vtTranscoder) StopAll(ctx context.Context) error {
+	return t.tracker.Cancel(ctx, vt.Owner{ChannelID: t.ch.ID, Outputs: true})
+}
+
+// GetVTTasks retrieves the channel's running VT tasks and their status
+func (mcr *MCR) GetVTTasks(ctx context.Context, shortName string) ([]vt.Tracked, error) {
+	ch, err := mcr.GetChannel(ctx, shortName)
+	if err != nil {
+		return nil, err
+	}
+	return mcr.vt.Active(ctx, vt.Owner{ChannelID: ch.ID})
+}
+
+// task converts a command into VT's task format, which is
+// everything before the primary input, the input, then everything
+// after it with the destination last. Other inputs, such as a logo,
+// come after the primary so keep their place.
+func (c Command) task() (vt.LiveTask, error) {
+	if c.input < 1 || c.Args[c.input-1] != "-i" || c.input+1 >= len(c.Args)-1 {
+		return vt.LiveTask{}, errors.New("command has no input or destination")
+	}
+	return vt.LiveTask{
+		SrcArgs: utils.QuoteArgs(c.Args[:c.input-1]),
+		SrcURL:  c.Args[c.input],
+		DstArgs: utils.QuoteArgs(c.Args[c.input+1 : len(c.Args)-1]),
+		DstURL:  c.Args[len(c.Args)-1],
+	}, nil
+}
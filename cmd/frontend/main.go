@@ -2,10 +2,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	// PostgreSQL driver
@@ -14,6 +14,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	"github.com/ystv/playout/channel"
+	"github.com/ystv/playout/config"
 	"github.com/ystv/playout/playout"
 	"github.com/ystv/playout/programming"
 	"github.com/ystv/playout/public"
@@ -21,11 +22,18 @@ import (
 )
 
 func main() {
-	db, err := newDatabase()
+	configPath := flag.String("config", "", "path to a JSON config file, PLAYOUT_ environment variables override it")
+	flag.Parse()
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %+v", err)
+	}
+	db, err := newDatabase(conf.DB)
 	if err != nil {
 		log.Fatalf("failed to start db: %+v", err)
 	}
-	mcr, err := channel.NewMCR(db)
+	mcr, err := channel.NewMCR(db, conf.MCR())
 	if err != nil {
 		log.Fatalf("failed to create mcr: %+v", err)
 	}
@@ -36,7 +44,7 @@ func main() {
 	mount(r, "/playout", web.New(mcr).Router())
 	mount(r, "/public", public.New(mcr, prog, po).Router())
 
-	log.Fatal(http.ListenAndServe("0.0.0.0:7070", r))
+	log.Fatal(http.ListenAndServe(conf.Addr, r))
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -55,16 +63,8 @@ func mount(r *mux.Router, path string, handler http.Handler) {
 }
 
 // newDatabase creates a new database connection
-func newDatabase() (*sqlx.DB, error) {
-	username := os.Getenv("PLAYOUT_DB_USER")
-	password := os.Getenv("PLAYOUT_DB_PASS")
-	dbName := os.Getenv("PLAYOUT_DB_NAME")
-	dbHost := os.Getenv("PLAYOUT_DB_HOST")
-	dbPort := os.Getenv("PLAYOUT_DB_PORT")
-
-	dbURI := fmt.Sprintf("dbname=%s host=%s user=%s password=%s port=%s sslmode=disable", dbName, dbHost, username, password, dbPort) // Build connection string
-
-	db, err := sqlx.Open("postgres", dbURI)
+func newDatabase(conf config.DB) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", conf.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to DB: %w", err)
 	}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	// PostgreSQL driver
@@ -11,34 +12,47 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
+	"github.com/ystv/playout/api"
+	"github.com/ystv/playout/channel"
+	"github.com/ystv/playout/config"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/programming"
+	"github.com/ystv/playout/public"
+	"github.com/ystv/playout/web"
 )
 
-/*
 func main() {
-	log.Println("playout (v0.0.2) by Rhys Milling")
-	db, err := newDatabase()
+	configPath := flag.String("config", "", "path to a JSON config file, PLAYOUT_ environment variables override it")
+	flag.Parse()
+
+	log.Println("playout (v0.0.3) by Rhys Milling")
+	conf, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("failed to start DB: %+v", err)
+		log.Fatalf("failed to load config: %+v", err)
 	}
-	s, err := scheduler.New(db)
+	db, err := newDatabase(conf.DB)
 	if err != nil {
-		log.Fatalf("failed to start scheduler: %+v", err)
+		log.Fatalf("failed to start DB: %+v", err)
 	}
-
-	err = s.MainLoop(context.Background())
+	mcr, err := channel.NewMCR(db, conf.MCR())
 	if err != nil {
-		log.Fatalf("scheduler failed: %+v", err)
+		log.Fatalf("failed to create mcr: %+v", err)
 	}
+	prog := programming.New(db)
+	po := playout.New(prog, db)
+
 	r := mux.NewRouter()
 	r.HandleFunc("/", handleIndex).Methods("GET")
-	mount(r, "/schedule", s.Router())
+	mount(r, "/playout", web.New(mcr).Router())
+	mount(r, "/public", public.New(mcr, prog, po).Router())
+	mount(r, "/api/v1", api.New(mcr).Router())
 
-	log.Fatal(http.ListenAndServe("0.0.0.0:7070", r))
+	log.Printf("listening on %s", conf.Addr)
+	log.Fatal(http.ListenAndServe(conf.Addr, r))
 }
-*/
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("playout (v0.0.2)"))
+	w.Write([]byte("playout (v0.0.3)"))
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -53,16 +67,8 @@ func mount(r *mux.Router, path string, handler http.Handler) {
 }
 
 // newDatabase creates a new database connection
-func newDatabase() (*sqlx.DB, error) {
-	username := os.Getenv("PLAYOUT_DB_USER")
-	password := os.Getenv("PLAYOUT_DB_PASS")
-	dbName := os.Getenv("PLAYOUT_DB_NAME")
-	dbHost := os.Getenv("PLAYOUT_DB_HOST")
-	dbPort := os.Getenv("PLAYOUT_DB_PORT")
-
-	dbURI := fmt.Sprintf("dbname=%s host=%s user=%s password=%s port=%s sslmode=disable", dbName, dbHost, username, password, dbPort) // Build connection string
-
-	db, err := sqlx.Open("postgres", dbURI)
+func newDatabase(conf config.DB) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", conf.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to DB: %w", err)
 	}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"os"
@@ -12,8 +11,7 @@ import (
 )
 
 func main() {
-	mcr := channel.NewMCR()
-	ch, err := mcr.NewChannel(context.Background(), channel.NewChannelStruct{
+	ch := &channel.Channel{
 		Name:        "Cooking time",
 		Description: "Very cool cooking show",
 		ChannelType: "linear",
@@ -43,6 +41,29 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:            "web player",
+				Type:            "cmaf",
+				DVR:             true,
+				DVRWindow:       7200,
+				SegmentDuration: 2,
+				Destination:     "/srv/live/test123/manifest.mpd",
+				Renditions: []channel.Rendition{
+					{
+						Width:   1920,
+						Height:  1080,
+						Bitrate: 8000,
+						FPS:     50,
+						Codec:   "h264",
+					}, {
+						Width:   1280,
+						Height:  720,
+						Bitrate: 4000,
+						FPS:     50,
+						Codec:   "h264",
+					},
+				},
+			},
 			{
 				Name:        "signage stream",
 				Type:        "rtmp",
@@ -53,9 +74,6 @@ func main() {
 			},
 		},
 		Archive: true,
-	})
-	if err != nil {
-		log.Fatalf("failed to create new channel: %+v", err)
 	}
 
 	// db, err := newDatabase()
@@ -70,9 +88,12 @@ func main() {
 	// 	log.Fatalf("scheduling failed: %+v", err)
 	// }
 
-	err = ch.Start()
+	cmds, err := ch.Compile()
 	if err != nil {
-		log.Fatalf("%+v", err)
+		log.Fatalf("failed to compile channel: %+v", err)
+	}
+	for _, cmd := range cmds {
+		fmt.Printf("%s:\n%s\n\n", cmd.Output, cmd)
 	}
 }
 
@@ -0,0 +1,451 @@
+// Package config loads playout's configuration from a JSON file with
+// environment overrides, so the same binary can run in any environment.
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/channel"
+	"github.com/ystv/playout/piper"
+)
+
+// ErrInvalidConfig is when the loaded config fails validation
+var ErrInvalidConfig = errors.New("invalid config")
+
+type (
+	// Config is everything playout needs to run
+	Config struct {
+		Addr      string     `json:"addr"` // Listen address, i.e. 0.0.0.0:7070
+		DB        DB         `json:"db"`
+		VT        VT         `json:"vt"`
+		Brave     Brave      `json:"brave"`
+		Endpoints []Endpoint `json:"endpoints"` // Where outputs can be sent
+		Channel   Channel    `json:"channel"`   // Defaults for every channel
+	}
+	// DB is the Postgres connection
+	DB struct {
+		Host    string `json:"host"`
+		Port    int    `json:"port"`
+		User    string `json:"user"`
+		Pass    string `json:"pass"`
+		Name    string `json:"name"`
+		SSLMode string `json:"sslMode"`
+	}
+	// VT is the video tape service playing out programmes
+	VT struct {
+		Endpoint string `json:"endpoint"`
+	}
+	// Brave is the mixer used by channels with a piper
+	Brave struct {
+		Endpoint string            `json:"endpoint"`
+		Width    int               `json:"width"`
+		Height   int               `json:"height"`
+		FPS      int               `json:"fps"`
+		Channels map[string]string `json:"channels"` // Endpoint by channel short name, overriding the default
+	}
+	// Endpoint is a usable output base URL
+	Endpoint struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+	// Channel are the defaults of the technical side of channels
+	Channel struct {
+		Transcoder          string   `json:"transcoder"` // local / vt
+		FFmpegPath          string   `json:"ffmpegPath"`
+		FFprobePath         string   `json:"ffprobePath"`
+		IngestCheckInterval Duration `json:"ingestCheckInterval"`
+		SlateRecovery       Duration `json:"slateRecovery"`
+		HealthMinSpeed      float64  `json:"healthMinSpeed"`
+		HealthMaxDropped    float64  `json:"healthMaxDropped"`
+		HealthStaleAfter    Duration `json:"healthStaleAfter"`
+		ArchiveDir          string   `json:"archiveDir"`
+		ArchiveRetention    Duration `json:"archiveRetention"` // 0 keeps recordings
+		VODDir              string   `json:"vodDir"`
+		VODURL              string   `json:"vodURL"`
+		PreviewDir          string   `json:"previewDir"`
+		PreviewInterval     Duration `json:"previewInterval"`
+		PreviewStaleAfter   Duration `json:"previewStaleAfter"`
+		LogoDir             string   `json:"logoDir"`
+		StreamKeySecret     string   `json:"streamKeySecret"` // Base64 32 byte key, required to store stream keys
+		ProbeCacheTTL       Duration `json:"probeCacheTTL"`
+		SourceCheckHorizon  Duration `json:"sourceCheckHorizon"`
+		SourceCheckInterval Duration `json:"sourceCheckInterval"`
+	}
+)
+
+// Duration is a time.Duration written as a string, i.e. "30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	s := ""
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default is the config used for anything a file or the
+// environment doesn't set
+func Default() *Config {
+	return &Config{
+		Addr: "0.0.0.0:7070",
+		DB: DB{
+			Host:    "localhost",
+			Port:    5432,
+			Name:    "playout",
+			SSLMode: "disable",
+		},
+		VT: VT{
+			Endpoint: "http://localhost:7071",
+		},
+		Brave: Brave{
+			Endpoint: "http://localhost:5000",
+			Width:    1920,
+			Height:   1080,
+			FPS:      50,
+		},
+		Endpoints: []Endpoint{
+			{
+				Type: "rtmp",
+				URL:  "rtmp://stream.ystv.co.uk/internal/",
+			},
+			{
+				Type: "hls",
+				URL:  "https://video-cdn.ystv.co.uk/",
+			},
+		},
+		Channel: Channel{
+			Transcoder:          "local",
+			FFmpegPath:          "ffmpeg",
+			FFprobePath:         "ffprobe",
+			IngestCheckInterval: Duration(5 * time.Second),
+			SlateRecovery:       Duration(30 * time.Second),
+			HealthMinSpeed:      0.95,
+			HealthMaxDropped:    0.01,
+			HealthStaleAfter:    Duration(15 * time.Second),
+			ArchiveDir:          "/var/lib/playout/archive",
+			ArchiveRetention:    Duration(7 * 24 * time.Hour),
+			VODDir:              "/var/lib/playout/vod",
+			VODURL:              "https://vod.ystv.co.uk/",
+			PreviewDir:          "/var/lib/playout/previews",
+			PreviewInterval:     Duration(10 * time.Second),
+			PreviewStaleAfter:   Duration(30 * time.Second),
+			LogoDir:             "/var/lib/playout/logos",
+			ProbeCacheTTL:       Duration(time.Hour),
+			SourceCheckHorizon:  Duration(24 * time.Hour),
+			SourceCheckInterval: Duration(15 * time.Minute),
+		},
+	}
+}
+
+// Load reads the config file on top of the defaults, then applies the
+// environment and validates the result. An empty path only uses the
+// defaults and environment.
+func Load(path string) (*Config, error) {
+	conf := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open config: %w", err)
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(conf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config \"%s\": %w", path, err)
+		}
+	}
+	err := conf.applyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// applyEnv overrides the config with any PLAYOUT_ variables which are set
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"PLAYOUT_ADDR":              &c.Addr,
+		"PLAYOUT_DB_HOST":           &c.DB.Host,
+		"PLAYOUT_DB_USER":           &c.DB.User,
+		"PLAYOUT_DB_PASS":           &c.DB.Pass,
+		"PLAYOUT_DB_NAME":           &c.DB.Name,
+		"PLAYOUT_DB_SSLMODE":        &c.DB.SSLMode,
+		"PLAYOUT_VT_ENDPOINT":       &c.VT.Endpoint,
+		"PLAYOUT_BRAVE_ENDPOINT":    &c.Brave.Endpoint,
+		"PLAYOUT_TRANSCODER":        &c.Channel.Transcoder,
+		"PLAYOUT_FFMPEG_PATH":       &c.Channel.FFmpegPath,
+		"PLAYOUT_FFPROBE_PATH":      &c.Channel.FFprobePath,
+		"PLAYOUT_ARCHIVE_DIR":       &c.Channel.ArchiveDir,
+		"PLAYOUT_VOD_DIR":           &c.Channel.VODDir,
+		"PLAYOUT_VOD_URL":           &c.Channel.VODURL,
+		"PLAYOUT_PREVIEW_DIR":       &c.Channel.PreviewDir,
+		"PLAYOUT_LOGO_DIR":          &c.Channel.LogoDir,
+		"PLAYOUT_STREAM_KEY_SECRET": &c.Channel.StreamKeySecret,
+	}
+	for key, field := range strs {
+		if value, ok := lookup(key); ok {
+			*field = value
+		}
+	}
+	ints := map[string]*int{
+		"PLAYOUT_DB_PORT": &c.DB.Port,
+	}
+	for key, field := range ints {
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a number: \"%s\"", ErrInvalidConfig, key, value)
+		}
+		*field = n
+	}
+	durations := map[string]*Duration{
+		"PLAYOUT_INGEST_CHECK_INTERVAL": &c.Channel.IngestCheckInterval,
+		"PLAYOUT_SLATE_RECOVERY":        &c.Channel.SlateRecovery,
+		"PLAYOUT_ARCHIVE_RETENTION":     &c.Channel.ArchiveRetention,
+		"PLAYOUT_PREVIEW_INTERVAL":      &c.Channel.PreviewInterval,
+		"PLAYOUT_PREVIEW_STALE_AFTER":   &c.Channel.PreviewStaleAfter,
+		"PLAYOUT_PROBE_CACHE_TTL":       &c.Channel.ProbeCacheTTL,
+		"PLAYOUT_SOURCE_CHECK_HORIZON":  &c.Channel.SourceCheckHorizon,
+		"PLAYOUT_SOURCE_CHECK_INTERVAL": &c.Channel.SourceCheckInterval,
+	}
+	for key, field := range durations {
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a duration: \"%s\"", ErrInvalidConfig, key, value)
+		}
+		*field = Duration(d)
+	}
+	return nil
+}
+
+// Validate checks every section, reporting all of the problems at once
+func (c *Config) Validate() error {
+	problems := []string{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	_, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		add("addr \"%s\" must be host:port", c.Addr)
+	}
+
+	if c.DB.Host == "" {
+		add("db.host is required")
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		add("db.port %d is out of range", c.DB.Port)
+	}
+	if c.DB.Name == "" {
+		add("db.name is required")
+	}
+	if c.DB.User == "" {
+		add("db.user is required")
+	}
+
+	if !validURL(c.VT.Endpoint, "http", "https") {
+		add("vt.endpoint \"%s\" must be a http(s) URL", c.VT.Endpoint)
+	}
+	if !validURL(c.Brave.Endpoint, "http", "https") {
+		add("brave.endpoint \"%s\" must be a http(s) URL", c.Brave.Endpoint)
+	}
+	for shortName, endpoint := range c.Brave.Channels {
+		if !validURL(endpoint, "http", "https") {
+			add("brave.channels.%s \"%s\" must be a http(s) URL", shortName, endpoint)
+		}
+	}
+	if c.Brave.Width <= 0 || c.Brave.Height <= 0 || c.Brave.FPS <= 0 {
+		add("brave width, height and fps must be positive")
+	}
+
+	for idx, e := range c.Endpoints {
+		if e.Type == "" {
+			add("endpoints[%d].type is required", idx)
+		}
+		if !validURL(e.URL) {
+			add("endpoints[%d].url \"%s\" must be a URL", idx, e.URL)
+		}
+	}
+
+	switch c.Channel.Transcoder {
+	case "local", "vt":
+	default:
+		add("channel.transcoder \"%s\" must be local or vt", c.Channel.Transcoder)
+	}
+	if c.Channel.Transcoder == "local" && c.Channel.FFmpegPath == "" {
+		add("channel.ffmpegPath is required by the local transcoder")
+	}
+	if c.Channel.FFprobePath == "" {
+		add("channel.ffprobePath is required")
+	}
+	if c.Channel.IngestCheckInterval <= 0 {
+		add("channel.ingestCheckInterval must be positive")
+	}
+	if c.Channel.SlateRecovery < 0 {
+		add("channel.slateRecovery can't be negative")
+	}
+	if c.Channel.HealthMinSpeed <= 0 {
+		add("channel.healthMinSpeed must be positive")
+	}
+	if c.Channel.HealthMaxDropped < 0 || c.Channel.HealthMaxDropped > 1 {
+		add("channel.healthMaxDropped must be a fraction between 0 and 1")
+	}
+	if c.Channel.HealthStaleAfter <= 0 {
+		add("channel.healthStaleAfter must be positive")
+	}
+	// VT records wherever it runs, so playouts can't be cut from it
+	if c.Channel.Transcoder == "vt" && c.Channel.ArchiveDir != "" {
+		add("channel.archiveDir can't be used with the vt transcoder, leave it empty")
+	}
+	if c.Channel.ArchiveRetention < 0 {
+		add("channel.archiveRetention can't be negative")
+	}
+	if c.Channel.VODDir == "" {
+		add("channel.vodDir is required")
+	}
+	if c.Channel.VODURL != "" && !validURL(c.Channel.VODURL, "http", "https") {
+		add("channel.vodURL \"%s\" must be a http(s) URL", c.Channel.VODURL)
+	}
+	if c.Channel.LogoDir == "" {
+		add("channel.logoDir is required")
+	}
+	if c.Channel.StreamKeySecret != "" {
+		secret, err := base64.StdEncoding.DecodeString(c.Channel.StreamKeySecret)
+		if err != nil || len(secret) != 32 {
+			add("channel.streamKeySecret must be 32 bytes of base64")
+		}
+	}
+	if c.Channel.PreviewInterval <= 0 {
+		add("channel.previewInterval must be positive")
+	}
+	if c.Channel.PreviewStaleAfter < c.Channel.PreviewInterval {
+		add("channel.previewStaleAfter must be at least channel.previewInterval")
+	}
+	if c.Channel.ProbeCacheTTL <= 0 {
+		add("channel.probeCacheTTL must be positive")
+	}
+	if c.Channel.SourceCheckHorizon <= 0 {
+		add("channel.sourceCheckHorizon must be positive")
+	}
+	if c.Channel.SourceCheckInterval <= 0 {
+		add("channel.sourceCheckInterval must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n\t%s", ErrInvalidConfig, strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// DSN is the Postgres connection string
+func (db DB) DSN() string {
+	return fmt.Sprintf("dbname=%s host=%s user=%s password=%s port=%d sslmode=%s",
+		db.Name, db.Host, db.User, db.Pass, db.Port, db.SSLMode)
+}
+
+// MCR is the config given to the channel package
+func (c *Config) MCR() *channel.Config {
+	endpoints := make([]channel.Endpoint, 0, len(c.Endpoints))
+	for _, e := range c.Endpoints {
+		endpoints = append(endpoints, channel.Endpoint{Type: e.Type, URL: e.URL})
+	}
+	// Checked by Validate
+	streamKeySecret, _ := base64.StdEncoding.DecodeString(c.Channel.StreamKeySecret)
+	braveChannels := make(map[string]string, len(c.Brave.Channels))
+	for shortName, endpoint := range c.Brave.Channels {
+		braveChannels[shortName] = endpoint
+	}
+	return &channel.Config{
+		VTEndpoint: c.VT.Endpoint,
+		Endpoints:  endpoints,
+		Transcoder: c.Channel.Transcoder,
+		FFmpegPath: c.Channel.FFmpegPath,
+
+		Piper: piper.Config{
+			Endpoint: c.Brave.Endpoint,
+			Width:    c.Brave.Width,
+			Height:   c.Brave.Height,
+			FPS:      c.Brave.FPS,
+		},
+		PiperEndpoints: braveChannels,
+
+		FFprobePath:         c.Channel.FFprobePath,
+		IngestCheckInterval: time.Duration(c.Channel.IngestCheckInterval),
+		SlateRecovery:       time.Duration(c.Channel.SlateRecovery),
+
+		HealthThresholds: channel.HealthThresholds{
+			MinSpeed:   c.Channel.HealthMinSpeed,
+			MaxDropped: c.Channel.HealthMaxDropped,
+			StaleAfter: time.Duration(c.Channel.HealthStaleAfter),
+		},
+
+		ArchiveDir:       c.Channel.ArchiveDir,
+		ArchiveRetention: time.Duration(c.Channel.ArchiveRetention),
+		VODDir:           c.Channel.VODDir,
+		VODURL:           c.Channel.VODURL,
+
+		PreviewDir:        c.Channel.PreviewDir,
+		PreviewInterval:   time.Duration(c.Channel.PreviewInterval),
+		PreviewStaleAfter: time.Duration(c.Channel.PreviewStaleAfter),
+
+		LogoDir: c.Channel.LogoDir,
+
+		StreamKeySecret: streamKeySecret,
+
+		ProbeCacheTTL:       time.Duration(c.Channel.ProbeCacheTTL),
+		SourceCheckHorizon:  time.Duration(c.Channel.SourceCheckHorizon),
+		SourceCheckInterval: time.Duration(c.Channel.SourceCheckInterval),
+	}
+}
+
+// validURL is when s parses as an absolute URL, with one of the
+// schemes if any are given
+func validURL(s string, schemes ...string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if len(schemes) == 0 {
+		return true
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// valid is the default config with what it's missing filled in
+func valid() *Config {
+	c := Default()
+	c.DB.User = "playout"
+	return c
+}
+
+// env looks variables up from a map instead of the environment
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	c := valid()
+	err := c.applyEnv(env(map[string]string{
+		"PLAYOUT_DB_HOST":           "db.example.com",
+		"PLAYOUT_DB_PORT":           "6543",
+		"PLAYOUT_TRANSCODER":        "vt",
+		"PLAYOUT_ARCHIVE_DIR":       "",
+		"PLAYOUT_SLATE_RECOVERY":    "1m30s",
+		"PLAYOUT_STREAM_KEY_SECRET": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+	}))
+	if err != nil {
+		t.Fatalf("failed to apply env: %+v", err)
+	}
+	switch {
+	case c.DB.Host != "db.example.com":
+		t.Errorf("db host is %q", c.DB.Host)
+	case c.DB.Port != 6543:
+		t.Errorf("db port is %d", c.DB.Port)
+	case c.Channel.Transcoder != "vt":
+		t.Errorf("transcoder is %q", c.Channel.Transcoder)
+	case c.Channel.ArchiveDir != "":
+		t.Errorf("archive dir is %q, an empty variable should clear it", c.Channel.ArchiveDir)
+	case time.Duration(c.Channel.SlateRecovery) != 90*time.Second:
+		t.Errorf("slate recovery is %s", time.Duration(c.Channel.SlateRecovery))
+	}
+	// Variables which aren't set leave the defaults
+	if c.DB.Name != "playout" || c.Addr != "0.0.0.0:7070" {
+		t.Errorf("unset variables changed the config: %+v", c)
+	}
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("overridden config is invalid: %+v", err)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		msg  string
+	}{
+		{
+			name: "port",
+			vars: map[string]string{"PLAYOUT_DB_PORT": "postgres"},
+			msg:  `PLAYOUT_DB_PORT must be a number: "postgres"`,
+		},
+		{
+			name: "duration",
+			vars: map[string]string{"PLAYOUT_PROBE_CACHE_TTL": "an hour"},
+			msg:  `PLAYOUT_PROBE_CACHE_TTL must be a duration: "an hour"`,
+		},
+		{
+			name: "duration without a unit",
+			vars: map[string]string{"PLAYOUT_SLATE_RECOVERY": "30"},
+			msg:  `PLAYOUT_SLATE_RECOVERY must be a duration: "30"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := valid().applyEnv(env(test.vars))
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("applied invalid env, got %v", err)
+			}
+			if !strings.Contains(err.Error(), test.msg) {
+				t.Errorf("error is %q, want it to contain %q", err, test.msg)
+			}
+		})
+	}
+}
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want time.Duration
+		err  bool
+	}{
+		{json: `"30s"`, want: 30 * time.Second},
+		{json: `"1h15m"`, want: 75 * time.Minute},
+		{json: `"0s"`},
+		{json: `"soon"`, err: true},
+		{json: `30`, err: true},
+		{json: `null`, err: true},
+	}
+	for _, test := range tests {
+		d := Duration(-1)
+		err := json.Unmarshal([]byte(test.json), &d)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("%s parsed as %s", test.json, time.Duration(d))
+		case !test.err && err != nil:
+			t.Errorf("failed to parse %s: %+v", test.json, err)
+		case !test.err && time.Duration(d) != test.want:
+			t.Errorf("%s parsed as %s, want %s", test.json, time.Duration(d), test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		msg    string
+	}{
+		{
+			name:   "addr",
+			change: func(c *Config) { c.Addr = "7070" },
+			msg:    `addr "7070" must be host:port`,
+		},
+		{
+			name:   "db port",
+			change: func(c *Config) { c.DB.Port = 70000 },
+			msg:    "db.port 70000 is out of range",
+		},
+		{
+			name:   "db user",
+			change: func(c *Config) { c.DB.User = "" },
+			msg:    "db.user is required",
+		},
+		{
+			name:   "vt endpoint",
+			change: func(c *Config) { c.VT.Endpoint = "localhost:7071" },
+			msg:    `vt.endpoint "localhost:7071" must be a http(s) URL`,
+		},
+		{
+			name:   "brave channel endpoint",
+			change: func(c *Config) { c.Brave.Channels = map[string]string{"ystv": "ftp://brave.example.com"} },
+			msg:    `brave.channels.ystv "ftp://brave.example.com" must be a http(s) URL`,
+		},
+		{
+			name:   "transcoder",
+			change: func(c *Config) { c.Channel.Transcoder = "gpu" },
+			msg:    `channel.transcoder "gpu" must be local or vt`,
+		},
+		{
+			name:   "vt archive",
+			change: func(c *Config) { c.Channel.Transcoder = "vt" },
+			msg:    "channel.archiveDir can't be used with the vt transcoder, leave it empty",
+		},
+		{
+			name:   "dropped frames",
+			change: func(c *Config) { c.Channel.HealthMaxDropped = 1.5 },
+			msg:    "channel.healthMaxDropped must be a fraction between 0 and 1",
+		},
+		{
+			name:   "stream key secret",
+			change: func(c *Config) { c.Channel.StreamKeySecret = "c2hvcnQ=" },
+			msg:    "channel.streamKeySecret must be 32 bytes of base64",
+		},
+		{
+			name:   "preview staleness",
+			change: func(c *Config) { c.Channel.PreviewStaleAfter = Duration(time.Second) },
+			msg:    "channel.previewStaleAfter must be at least channel.previewInterval",
+		},
+	}
+	err := valid().Validate()
+	if err != nil {
+		t.Fatalf("default config is invalid: %+v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := valid()
+			test.change(c)
+			err := c.Validate()
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("validated, got %v", err)
+			}
+			if !strings.Contains(err.Error(), test.msg) {
+				t.Errorf("error is %q, want it to contain %q", err, test.msg)
+			}
+		})
+	}
+}
+
+// TestValidateReportsAll checks every problem is reported at once
+func TestValidateReportsAll(t *testing.T) {
+	c := valid()
+	c.DB.Host = ""
+	c.Channel.VODDir = ""
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("validated a config without a db host or vod dir")
+	}
+	for _, msg := range []string{"db.host is required", "channel.vodDir is required"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error is %q, want it to contain %q", err, msg)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"db": {"user": "playout", "host": "db.example.com"},
+		"channel": {"slateRecovery": "1m"}
+	}`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %+v", err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %+v", err)
+	}
+	if c.DB.Host != "db.example.com" || c.DB.Port != 5432 || time.Duration(c.Channel.SlateRecovery) != time.Minute {
+		t.Errorf("loaded %+v, want the file over the defaults", c)
+	}
+
+	unknown := filepath.Join(dir, "unknown.json")
+	err = ioutil.WriteFile(unknown, []byte(`{"db": {"user": "playout"}, "dbHost": "db.example.com"}`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %+v", err)
+	}
+	_, err = Load(unknown)
+	if err == nil {
+		t.Error("loaded a config with an unknown field")
+	}
+}
@@ -0,0 +1,202 @@
+// Package loudness measures programme audio and builds the filters
+// which normalise it to EBU R128 style targets
+package loudness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTarget is EBU R128's integrated loudness, LUFS
+	DefaultTarget = -23.0
+	// DefaultTruePeak is EBU R128's maximum true peak, dBTP
+	DefaultTruePeak = -1.0
+	// loudnessRange given to loudnorm, LU. Wide enough that music
+	// keeps its dynamics rather than being squashed.
+	loudnessRange = 11.0
+	// maxGain is the most a quiet video is boosted by, dB, so near
+	// silence isn't lifted into noise
+	maxGain = 20.0
+	// silence is what the integrated loudness of a video without any
+	// gated audio is stored as, LUFS
+	silence = -70.0
+	// measureTimeout is how long measuring a single video can take
+	measureTimeout = 2 * time.Hour
+)
+
+// ErrNoMeasurement is when ffmpeg didn't report a measurement
+var ErrNoMeasurement = errors.New("no loudness measurement")
+
+type (
+	// Target is the loudness audio is normalised to
+	Target struct {
+		Integrated float64 // LUFS
+		TruePeak   float64 // dBTP
+	}
+	// Measurement is a first pass analysis of a video's audio
+	Measurement struct {
+		VideoID    int       `db:"programme_video_id" json:"videoID"`
+		Integrated float64   `db:"integrated" json:"integrated"` // LUFS
+		TruePeak   float64   `db:"true_peak" json:"truePeak"`    // dBTP
+		Range      float64   `db:"lra" json:"range"`             // LU
+		Threshold  float64   `db:"threshold" json:"threshold"`   // LUFS
+		Duration   float64   `db:"duration" json:"duration"`     // Seconds
+		MeasuredAt time.Time `db:"measured_at" json:"measuredAt"`
+	}
+	// Meter measures videos with ffmpeg's loudnorm
+	Meter struct {
+		Path string // ffmpeg binary
+	}
+)
+
+// Measure runs a first loudnorm pass over a video's audio, the
+// duration isn't known to ffmpeg's null output so is left to the caller
+func (m *Meter) Measure(ctx context.Context, url string) (Measurement, error) {
+	ctx, cancel := context.WithTimeout(ctx, measureTimeout)
+	defer cancel()
+	path := m.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, path, "-hide_banner", "-nostats", "-nostdin",
+		"-i", url, "-vn",
+		"-af", fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s:print_format=json",
+			num(DefaultTarget), num(DefaultTruePeak), num(loudnessRange)),
+		"-f", "null", "-")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return Measurement{}, fmt.Errorf("measuring stalled: %w", ctx.Err())
+	}
+	if err != nil {
+		return Measurement{}, fmt.Errorf("failed to measure loudness: %w: %s", err, lastLine(stderr.String()))
+	}
+	return parse(stderr.String())
+}
+
+// Silence is the measurement of a video without audio
+func Silence() Measurement {
+	return Measurement{
+		Integrated: silence,
+		TruePeak:   silence,
+		Threshold:  silence,
+		MeasuredAt: time.Now(),
+	}
+}
+
+// parse reads the JSON loudnorm prints at the end of its pass
+func parse(out string) (Measurement, error) {
+	start, end := strings.LastIndex(out, "{"), strings.LastIndex(out, "}")
+	if start == -1 || end < start {
+		return Measurement{}, ErrNoMeasurement
+	}
+	report := struct {
+		InputI      string `json:"input_i"`
+		InputTP     string `json:"input_tp"`
+		InputLRA    string `json:"input_lra"`
+		InputThresh string `json:"input_thresh"`
+	}{}
+	err := json.Unmarshal([]byte(out[start:end+1]), &report)
+	if err != nil {
+		return Measurement{}, fmt.Errorf("%w: %v", ErrNoMeasurement, err)
+	}
+	m := Measurement{
+		Integrated: level(report.InputI),
+		TruePeak:   level(report.InputTP),
+		Range:      level(report.InputLRA),
+		Threshold:  level(report.InputThresh),
+		MeasuredAt: time.Now(),
+	}
+	if m.Range < 0 {
+		m.Range = 0
+	}
+	return m, nil
+}
+
+// level parses a loudnorm value, silence reports -inf which is
+// floored so it can be stored
+func level(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || v < silence {
+		return silence
+	}
+	if math.IsInf(v, 1) {
+		return 0
+	}
+	return v
+}
+
+// Gain is the linear gain which brings a measured video to the target
+func (t Target) Gain(m Measurement) float64 {
+	db := t.Integrated - m.Integrated
+	if m.Integrated <= silence {
+		// Nothing to normalise
+		db = 0
+	}
+	if db > maxGain {
+		db = maxGain
+	}
+	return math.Pow(10, db/20)
+}
+
+// Live is a single pass filter normalising audio as it is played
+func (t Target) Live() string {
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s",
+		num(t.Integrated), num(t.TruePeak), num(loudnessRange))
+}
+
+// Limiter is a filter keeping peaks under the ceiling
+func (t Target) Limiter() string {
+	limit := math.Pow(10, t.TruePeak/20)
+	return fmt.Sprintf("alimiter=limit=%s:level=false", strconv.FormatFloat(limit, 'f', 4, 64))
+}
+
+// Measured is a filter applying each video's gain in turn, followed by
+// the limiter, for videos played back to back from zero. ok is false
+// if a video hasn't been measured, since the gains after it can't be
+// placed.
+func (t Target) Measured(ms []*Measurement) (filter string, ok bool) {
+	if len(ms) == 0 {
+		return "", false
+	}
+	// Nested ifs choosing the gain of the video playing at t, commas
+	// are escaped since the expression is a filter option
+	expr := strings.Builder{}
+	at := 0.0
+	for idx, m := range ms {
+		if m == nil || m.Duration <= 0 {
+			return "", false
+		}
+		gain := strconv.FormatFloat(t.Gain(*m), 'f', 4, 64)
+		if idx == len(ms)-1 {
+			expr.WriteString(gain)
+			break
+		}
+		at += m.Duration
+		fmt.Fprintf(&expr, `if(lt(t\,%s)\,%s\,`, num(at), gain)
+	}
+	expr.WriteString(strings.Repeat(")", len(ms)-1))
+	return fmt.Sprintf("volume=eval=frame:volume=%s,%s", expr.String(), t.Limiter()), true
+}
+
+// num formats a level for a filter option
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// lastLine is the last non-empty line of ffmpeg's output, where
+// it reports why it failed
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
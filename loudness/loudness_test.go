@@ -0,0 +1,138 @@
+package loudness
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loudnormOutput is the end of ffmpeg's output after a loudnorm pass
+const loudnormOutput = `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'programme.mp4':
+  Duration: 00:30:00.04, start: 0.000000, bitrate: 5000 kb/s
+[Parsed_loudnorm_0 @ 0x55d5c6f0c4c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-23.00",
+	"output_tp" : "-1.00",
+	"output_lra" : "11.00",
+	"output_thresh" : "-34.46",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.00"
+}
+`
+
+func TestParse(t *testing.T) {
+	m, err := parse(loudnormOutput)
+	if err != nil {
+		t.Fatalf("failed to parse: %+v", err)
+	}
+	if m.Integrated != -27.61 || m.TruePeak != -4.47 || m.Range != 18.06 || m.Threshold != -39.2 || m.MeasuredAt.IsZero() {
+		t.Errorf("parsed %+v", m)
+	}
+
+	// Silence is reported as -inf
+	silent := strings.NewReplacer(`"-27.61"`, `"-inf"`, `"-4.47"`, `"-inf"`, `"18.06"`, `"-0.00"`, `"-39.20"`, `"-inf"`).Replace(loudnormOutput)
+	m, err = parse(silent)
+	if err != nil {
+		t.Fatalf("failed to parse: %+v", err)
+	}
+	if m.Integrated != silence || m.TruePeak != silence || m.Range != 0 || m.Threshold != silence {
+		t.Errorf("parsed silence as %+v", m)
+	}
+
+	for _, out := range []string{"programme.mp4: No such file or directory\n", "{\"input_i\": -27.61}"} {
+		_, err = parse(out)
+		if !errors.Is(err, ErrNoMeasurement) {
+			t.Errorf("parsed %q, got %v", out, err)
+		}
+	}
+}
+
+func TestGain(t *testing.T) {
+	target := Target{Integrated: DefaultTarget, TruePeak: DefaultTruePeak}
+	tests := []struct {
+		name       string
+		integrated float64
+		db         float64
+	}{
+		{name: "quiet", integrated: -29, db: 6},
+		{name: "loud", integrated: -17, db: -6},
+		{name: "near silence", integrated: -60, db: maxGain},
+		{name: "silence", integrated: silence, db: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gain := target.Gain(Measurement{Integrated: test.integrated})
+			if db := 20 * math.Log10(gain); math.Abs(db-test.db) > 1e-9 {
+				t.Errorf("gain is %fdB, want %fdB", db, test.db)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	target := Target{Integrated: -24, TruePeak: -2}
+	if live := target.Live(); live != "loudnorm=I=-24:TP=-2:LRA=11" {
+		t.Errorf("live filter is %s", live)
+	}
+	if limiter := target.Limiter(); limiter != "alimiter=limit=0.7943:level=false" {
+		t.Errorf("limiter is %s", limiter)
+	}
+
+	filter, ok := target.Measured([]*Measurement{
+		{Integrated: -30, Duration: 60},
+		{Integrated: -24, Duration: 30.5},
+		{Integrated: -18, Duration: 10},
+	})
+	want := `volume=eval=frame:volume=if(lt(t\,60)\,1.9953\,if(lt(t\,90.5)\,1.0000\,0.5012)),alimiter=limit=0.7943:level=false`
+	if !ok || filter != want {
+		t.Errorf("measured filter is %s (%t), want %s", filter, ok, want)
+	}
+
+	// A video which hasn't been measured can't be placed
+	_, ok = target.Measured([]*Measurement{{Integrated: -30, Duration: 60}, nil})
+	if ok {
+		t.Error("built a filter with an unmeasured video")
+	}
+	_, ok = target.Measured(nil)
+	if ok {
+		t.Error("built a filter without any videos")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ffmpeg")
+	out := filepath.Join(dir, "out")
+	err := ioutil.WriteFile(out, []byte(loudnormOutput), 0644)
+	if err != nil {
+		t.Fatalf("failed to write output: %+v", err)
+	}
+	err = ioutil.WriteFile(path, []byte("#!/bin/sh\ncat "+out+" >&2\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write fake ffmpeg: %+v", err)
+	}
+	m, err := (&Meter{Path: path}).Measure(context.Background(), "programme.mp4")
+	if err != nil {
+		t.Fatalf("failed to measure: %+v", err)
+	}
+	if m.Integrated != -27.61 {
+		t.Errorf("measured %+v", m)
+	}
+
+	err = ioutil.WriteFile(path, []byte("#!/bin/sh\necho 'programme.mp4: No such file or directory' >&2\nexit 1\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write fake ffmpeg: %+v", err)
+	}
+	_, err = (&Meter{Path: path}).Measure(context.Background(), "programme.mp4")
+	if err == nil || !strings.HasSuffix(err.Error(), "programme.mp4: No such file or directory") {
+		t.Errorf("measured a missing video, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package loudness
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Store keeps measurements so videos are only measured once
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates a store of measurements
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{db: db}
+}
+
+// Get retrieves the measurements of videos by their programme video ID,
+// videos which haven't been measured are missing from the map
+func (s *Store) Get(ctx context.Context, videoIDs []int) (map[int]Measurement, error) {
+	ms := []Measurement{}
+	err := s.db.SelectContext(ctx, &ms, `
+		SELECT programme_video_id, integrated, true_peak, lra, threshold,
+			duration, measured_at
+		FROM playout.programme_video_loudness
+		WHERE programme_video_id = ANY($1);`, pq.Array(videoIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to select measurements: %w", err)
+	}
+	byVideo := make(map[int]Measurement, len(ms))
+	for _, m := range ms {
+		byVideo[m.VideoID] = m
+	}
+	return byVideo, nil
+}
+
+// Put stores a video's measurement, replacing any previous one
+func (s *Store) Put(ctx context.Context, m Measurement) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO playout.programme_video_loudness(programme_video_id,
+			integrated, true_peak, lra, threshold, duration, measured_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (programme_video_id) DO UPDATE SET
+			integrated = EXCLUDED.integrated,
+			true_peak = EXCLUDED.true_peak,
+			lra = EXCLUDED.lra,
+			threshold = EXCLUDED.threshold,
+			duration = EXCLUDED.duration,
+			measured_at = EXCLUDED.measured_at;`,
+		m.VideoID, m.Integrated, m.TruePeak, m.Range, m.Threshold, m.Duration, m.MeasuredAt)
+	if err != nil {
+		return fmt.Errorf("failed to store measurement: %w", err)
+	}
+	return nil
+}
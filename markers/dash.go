@@ -0,0 +1,73 @@
+package markers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// availabilityStart is when a live MPD's timeline starts
+var availabilityStart = regexp.MustCompile(`availabilityStartTime="([^"]+)"`)
+
+// DASH adds the cues to a live MPD as event streams of its first period,
+// timed from the MPD's availability start. Breaks are SCTE-35 splices,
+// programmes events of their own scheme with the programme's ID as
+// their message. MPDs which aren't live are left as they are.
+func DASH(mpd []byte, cues []Cue) []byte {
+	match := availabilityStart.FindSubmatch(mpd)
+	if match == nil {
+		return mpd
+	}
+	start, err := time.Parse(time.RFC3339Nano, string(match[1]))
+	if err != nil {
+		return mpd
+	}
+	period := bytes.Index(mpd, []byte("<Period"))
+	if period == -1 {
+		return mpd
+	}
+	open := bytes.IndexByte(mpd[period:], '>')
+	if open == -1 {
+		return mpd
+	}
+	at := period + open + 1
+
+	programmes, breaks := bytes.Buffer{}, bytes.Buffer{}
+	for _, c := range cues {
+		if c.Ended(start) {
+			continue
+		}
+		from := c.Start.Sub(start)
+		if from < 0 {
+			from = 0
+		}
+		duration := c.Planned
+		if !c.End.IsZero() {
+			duration = c.End.Sub(start) - from
+		}
+		attrs := fmt.Sprintf("presentationTime=\"%d\" id=\"%d\"", from.Milliseconds(), c.ID)
+		if duration > 0 {
+			attrs += fmt.Sprintf(" duration=\"%d\"", duration.Milliseconds())
+		}
+		switch c.Kind {
+		case KindBreak:
+			fmt.Fprintf(&breaks, "\t\t\t<Event %s><Signal xmlns=\"http://www.scte.org/schemas/35/2016\"><Binary>%s</Binary></Signal></Event>\n",
+				attrs, Base64(SpliceInsert(uint32(c.ID), true, c.Planned)))
+		default:
+			fmt.Fprintf(&programmes, "\t\t\t<Event %s messageData=\"%d\"/>\n", attrs, c.Programme)
+		}
+	}
+
+	streams := bytes.Buffer{}
+	if programmes.Len() > 0 {
+		fmt.Fprintf(&streams, "\n\t\t<EventStream schemeIdUri=\"%s\" timescale=\"1000\">\n%s\t\t</EventStream>", SchemeProgramme, programmes.String())
+	}
+	if breaks.Len() > 0 {
+		fmt.Fprintf(&streams, "\n\t\t<EventStream schemeIdUri=\"%s\" timescale=\"1000\">\n%s\t\t</EventStream>", SchemeSCTE35, breaks.String())
+	}
+	out := make([]byte, 0, len(mpd)+streams.Len())
+	out = append(out, mpd[:at]...)
+	out = append(out, streams.Bytes()...)
+	return append(out, mpd[at:]...)
+}
@@ -0,0 +1,165 @@
+package markers
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFormat is how HLS tags write dates
+const dateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// segment is a media segment of a playlist and the lines describing it
+type segment struct {
+	lines []string
+	start time.Time
+	end   time.Time
+}
+
+// HLS adds the cues to a media playlist, placing them on the segments
+// they start and end in by the segments' program date times. Playlists
+// without program date times are left as they are.
+//
+// Each cue is a date range, breaks are also cue out and in tags for
+// platforms which splice on those.
+func HLS(playlist []byte, cues []Cue) []byte {
+	header, segments, trailer, ok := parsePlaylist(playlist)
+	if !ok || len(segments) == 0 {
+		return playlist
+	}
+	out := bytes.Buffer{}
+	for _, line := range header {
+		out.WriteString(line + "\n")
+	}
+	for idx, seg := range segments {
+		for _, c := range cues {
+			starts := !c.Start.Before(seg.start) && c.Start.Before(seg.end)
+			if starts || idx == 0 && c.Start.Before(seg.start) && !c.Ended(seg.start) {
+				// Players joining late still see what they're watching
+				out.WriteString(dateRange(c) + "\n")
+			}
+			if c.Kind != KindBreak {
+				continue
+			}
+			switch {
+			case starts:
+				fmt.Fprintf(&out, "#EXT-X-CUE-OUT:DURATION=%.3f\n", c.Planned.Seconds())
+			case c.Start.Before(seg.start) && !c.Ended(seg.start):
+				fmt.Fprintf(&out, "#EXT-X-CUE-OUT-CONT:ElapsedTime=%.3f,Duration=%.3f\n",
+					seg.start.Sub(c.Start).Seconds(), c.Planned.Seconds())
+			}
+			if !c.End.IsZero() && !c.End.Before(seg.start) && c.End.Before(seg.end) {
+				out.WriteString("#EXT-X-CUE-IN\n")
+			}
+		}
+		for _, line := range seg.lines {
+			out.WriteString(line + "\n")
+		}
+	}
+	for _, line := range trailer {
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// parsePlaylist splits a media playlist into its header, segments and
+// anything after the last segment, timing each segment from the
+// latest program date time
+func parsePlaylist(playlist []byte) (header []string, segments []segment, trailer []string, ok bool) {
+	lines := strings.Split(strings.TrimRight(string(playlist), "\n"), "\n")
+	var (
+		pending  []string
+		at       time.Time
+		duration time.Duration
+		inHeader = true
+	)
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		uri := line != "" && !strings.HasPrefix(line, "#")
+		if inHeader && !uri && !isSegmentTag(line) {
+			header = append(header, line)
+			continue
+		}
+		inHeader = false
+		pending = append(pending, line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			t, err := parseDate(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+			if err != nil {
+				return nil, nil, nil, false
+			}
+			at = t
+		case strings.HasPrefix(line, "#EXTINF:"):
+			seconds, err := strconv.ParseFloat(strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0], 64)
+			if err != nil {
+				return nil, nil, nil, false
+			}
+			duration = time.Duration(seconds * float64(time.Second))
+		case uri:
+			if at.IsZero() {
+				return nil, nil, nil, false
+			}
+			segments = append(segments, segment{lines: pending, start: at, end: at.Add(duration)})
+			pending = nil
+			at = at.Add(duration)
+		}
+	}
+	return header, segments, pending, true
+}
+
+// isSegmentTag is when a tag describes the segment after it rather
+// than the playlist
+func isSegmentTag(line string) bool {
+	for _, tag := range []string{"#EXTINF:", "#EXT-X-PROGRAM-DATE-TIME:", "#EXT-X-DISCONTINUITY", "#EXT-X-BYTERANGE:"} {
+		if strings.HasPrefix(line, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDate reads a program date time, ffmpeg leaves the colon out of
+// the zone offset
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02T15:04:05.000-0700", s)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// dateRange is a cue's EXT-X-DATERANGE tag
+func dateRange(c Cue) string {
+	attrs := []string{}
+	switch c.Kind {
+	case KindBreak:
+		attrs = append(attrs,
+			fmt.Sprintf("ID=\"break-%d\"", c.ID),
+			fmt.Sprintf("CLASS=\"%s\"", ClassBreak),
+			fmt.Sprintf("START-DATE=\"%s\"", c.Start.Format(dateFormat)),
+			fmt.Sprintf("PLANNED-DURATION=%.3f", c.Planned.Seconds()),
+		)
+	default:
+		attrs = append(attrs,
+			fmt.Sprintf("ID=\"programme-%d\"", c.ID),
+			fmt.Sprintf("CLASS=\"%s\"", ClassProgramme),
+			fmt.Sprintf("START-DATE=\"%s\"", c.Start.Format(dateFormat)),
+		)
+	}
+	if !c.End.IsZero() {
+		attrs = append(attrs, fmt.Sprintf("END-DATE=\"%s\"", c.End.Format(dateFormat)))
+	}
+	if c.Kind == KindBreak {
+		attrs = append(attrs, "SCTE35-OUT="+Hex(SpliceInsert(uint32(c.ID), true, c.Planned)))
+		if !c.End.IsZero() {
+			attrs = append(attrs, "SCTE35-IN="+Hex(SpliceInsert(uint32(c.ID), false, 0)))
+		}
+	}
+	attrs = append(attrs,
+		fmt.Sprintf("X-PLAYOUT-ID=%d", c.Playout),
+		fmt.Sprintf("X-PROGRAMME-ID=%d", c.Programme),
+	)
+	return "#EXT-X-DATERANGE:" + strings.Join(attrs, ",")
+}
@@ -0,0 +1,109 @@
+// Package markers signals programme changes and ad breaks in a
+// channel's manifests, as HLS date ranges and cue tags and DASH event
+// streams carrying SCTE-35
+package markers
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is what a cue marks
+type Kind string
+
+// Cue kinds
+const (
+	KindProgramme Kind = "programme"
+	KindBreak     Kind = "break"
+)
+
+// Date range classes and DASH schemes of the cues
+const (
+	ClassProgramme  = "uk.co.ystv.playout.programme"
+	ClassBreak      = "uk.co.ystv.playout.break"
+	SchemeProgramme = "urn:uk:co:ystv:playout:programme"
+	SchemeSCTE35    = "urn:scte:scte35:2014:xml+bin"
+)
+
+// Cue is a programme or break placed on the wall clock
+type Cue struct {
+	Kind      Kind
+	ID        int // Playout ID of a programme, break ID of a break, also its splice event ID
+	Start     time.Time
+	End       time.Time     // Zero until it's over
+	Planned   time.Duration // How long a break is meant to be
+	Playout   int
+	Programme int
+}
+
+// Ended is when the cue is over by a time
+func (c Cue) Ended(at time.Time) bool {
+	return !c.End.IsZero() && !c.End.After(at)
+}
+
+// Schedule is what's been on a channel, filled as the scheduler
+// starts and ends playouts and breaks
+type Schedule struct {
+	lock sync.Mutex
+	cues []Cue
+}
+
+// Add places a cue, replacing one of the same kind and ID
+func (s *Schedule) Add(c Cue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for idx := range s.cues {
+		if s.cues[idx].Kind == c.Kind && s.cues[idx].ID == c.ID {
+			s.cues[idx] = c
+			return
+		}
+	}
+	s.cues = append(s.cues, c)
+}
+
+// End marks a cue as over, if it isn't already
+func (s *Schedule) End(kind Kind, id int, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for idx := range s.cues {
+		c := &s.cues[idx]
+		if c.Kind == kind && c.ID == id && c.End.IsZero() {
+			c.End = at
+		}
+	}
+}
+
+// EndPlayout marks a playout and any break in it as over, for when
+// it comes off air before its break has finished
+func (s *Schedule) EndPlayout(playoutID int, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for idx := range s.cues {
+		c := &s.cues[idx]
+		if c.Playout == playoutID && c.End.IsZero() {
+			c.End = at
+		}
+	}
+}
+
+// Cues are the cues placed, oldest first
+func (s *Schedule) Cues() []Cue {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cues := make([]Cue, len(s.cues))
+	copy(cues, s.cues)
+	return cues
+}
+
+// Prune forgets cues which ended before a time
+func (s *Schedule) Prune(before time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := s.cues[:0]
+	for _, c := range s.cues {
+		if !c.Ended(before) {
+			kept = append(kept, c)
+		}
+	}
+	s.cues = kept
+}
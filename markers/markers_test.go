@@ -0,0 +1,168 @@
+package markers
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC32MPEG2(t *testing.T) {
+	// The CRC-32/MPEG-2 check value
+	if crc := crc32MPEG2([]byte("123456789")); crc != 0x0376e6e7 {
+		t.Errorf("crc is %#x", crc)
+	}
+}
+
+func TestSpliceInsert(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      bool
+		duration time.Duration
+		flags    byte   // out_of_network, program_splice, duration and immediate flags
+		break90k uint64 // break_duration at 90kHz, if it's there
+	}{
+		{name: "out with duration", out: true, duration: 30 * time.Second, flags: 0xf, break90k: 30 * 90000},
+		{name: "out without duration", out: true, flags: 0xd},
+		{name: "in", out: false, duration: 30 * time.Second, flags: 0x5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := SpliceInsert(1234, test.out, test.duration)
+			if s[0] != 0xfc {
+				t.Fatalf("table id is %#x", s[0])
+			}
+			if length := int(binary.BigEndian.Uint16(s[1:3]) & 0xfff); length != len(s)-3 {
+				t.Errorf("section length is %d, section is %d bytes", length, len(s))
+			}
+			if crc := crc32MPEG2(s); crc != 0 {
+				t.Errorf("section doesn't match its crc, crc over it is %#x", crc)
+			}
+			cmdLength := int(binary.BigEndian.Uint16(s[11:13]) & 0xfff)
+			if s[13] != spliceInsert {
+				t.Fatalf("command type is %#x", s[13])
+			}
+			cmd := s[14 : 14+cmdLength]
+			if id := binary.BigEndian.Uint32(cmd[0:4]); id != 1234 {
+				t.Errorf("event id is %d", id)
+			}
+			if cmd[4]&0x80 != 0 {
+				t.Error("event is cancelled")
+			}
+			if flags := cmd[5] >> 4; flags != test.flags {
+				t.Errorf("flags are %04b, want %04b", flags, test.flags)
+			}
+			rest := cmd[6:]
+			if test.break90k != 0 {
+				if rest[0]&0x80 == 0 {
+					t.Error("break doesn't return automatically")
+				}
+				duration := uint64(rest[0]&1)<<32 | uint64(binary.BigEndian.Uint32(rest[1:5]))
+				if duration != test.break90k {
+					t.Errorf("break duration is %d, want %d", duration, test.break90k)
+				}
+				rest = rest[5:]
+			}
+			// unique_program_id, avail_num and avails_expected
+			if len(rest) != 4 {
+				t.Errorf("%d bytes after the splice flags, want 4", len(rest))
+			}
+			if descriptors := s[14+cmdLength : 16+cmdLength]; descriptors[0] != 0 || descriptors[1] != 0 {
+				t.Errorf("descriptor loop length is %x", descriptors)
+			}
+		})
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	s := []byte{0xfc, 0x30, 0x0a}
+	if hex := Hex(s); hex != "0xFC300A" {
+		t.Errorf("hex is %s", hex)
+	}
+	if b64 := Base64(s); b64 != "/DAK" {
+		t.Errorf("base64 is %s", b64)
+	}
+}
+
+func TestHLS(t *testing.T) {
+	at := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	playlist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2021-01-01T12:00:00.000+0000\n#EXTINF:4.000,\nseg10.ts\n" +
+		"#EXTINF:4.000,\nseg11.ts\n" +
+		"#EXTINF:4.000,\nseg12.ts\n"
+	programme := Cue{Kind: KindProgramme, ID: 7, Start: at.Add(-time.Minute), Playout: 7, Programme: 3}
+	brk := Cue{Kind: KindBreak, ID: 2, Start: at.Add(5 * time.Second), End: at.Add(9 * time.Second), Planned: 4 * time.Second, Playout: 7, Programme: 3}
+	got := string(HLS([]byte(playlist), []Cue{programme, brk}))
+
+	programmeRange := `#EXT-X-DATERANGE:ID="programme-7",CLASS="uk.co.ystv.playout.programme",START-DATE="2021-01-01T11:59:00.000Z",X-PLAYOUT-ID=7,X-PROGRAMME-ID=3`
+	breakRange := `#EXT-X-DATERANGE:ID="break-2",CLASS="uk.co.ystv.playout.break",START-DATE="2021-01-01T12:00:05.000Z",` +
+		`PLANNED-DURATION=4.000,END-DATE="2021-01-01T12:00:09.000Z",` +
+		"SCTE35-OUT=" + Hex(SpliceInsert(2, true, 4*time.Second)) + ",SCTE35-IN=" + Hex(SpliceInsert(2, false, 0)) +
+		",X-PLAYOUT-ID=7,X-PROGRAMME-ID=3"
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n" +
+		programmeRange + "\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2021-01-01T12:00:00.000+0000\n#EXTINF:4.000,\nseg10.ts\n" +
+		breakRange + "\n#EXT-X-CUE-OUT:DURATION=4.000\n" +
+		"#EXTINF:4.000,\nseg11.ts\n" +
+		"#EXT-X-CUE-OUT-CONT:ElapsedTime=3.000,Duration=4.000\n#EXT-X-CUE-IN\n" +
+		"#EXTINF:4.000,\nseg12.ts\n"
+	if got != want {
+		t.Errorf("playlist is\n%s\nwant\n%s", got, want)
+	}
+
+	// Without program date times the cues can't be placed
+	untimed := "#EXTM3U\n#EXTINF:4.000,\nseg10.ts\n"
+	if got := string(HLS([]byte(untimed), []Cue{brk})); got != untimed {
+		t.Errorf("untimed playlist changed to\n%s", got)
+	}
+}
+
+func TestDASH(t *testing.T) {
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	mpd := `<MPD type="dynamic" availabilityStartTime="2021-01-01T12:00:00Z">` + "\n\t<Period id=\"0\" start=\"PT0S\">\n\t\t<AdaptationSet/>\n\t</Period>\n</MPD>\n"
+	cues := []Cue{
+		{Kind: KindProgramme, ID: 6, Start: start.Add(-time.Hour), End: start.Add(-time.Minute), Programme: 2},
+		{Kind: KindProgramme, ID: 7, Start: start.Add(-time.Minute), Programme: 3},
+		{Kind: KindBreak, ID: 2, Start: start.Add(90 * time.Second), Planned: 30 * time.Second},
+	}
+	got := string(DASH([]byte(mpd), cues))
+	want := `<MPD type="dynamic" availabilityStartTime="2021-01-01T12:00:00Z">` + "\n\t<Period id=\"0\" start=\"PT0S\">" +
+		"\n\t\t<EventStream schemeIdUri=\"urn:uk:co:ystv:playout:programme\" timescale=\"1000\">\n" +
+		"\t\t\t<Event presentationTime=\"0\" id=\"7\" messageData=\"3\"/>\n\t\t</EventStream>" +
+		"\n\t\t<EventStream schemeIdUri=\"urn:scte:scte35:2014:xml+bin\" timescale=\"1000\">\n" +
+		"\t\t\t<Event presentationTime=\"90000\" id=\"2\" duration=\"30000\"><Signal xmlns=\"http://www.scte.org/schemas/35/2016\"><Binary>" +
+		Base64(SpliceInsert(2, true, 30*time.Second)) + "</Binary></Signal></Event>\n\t\t</EventStream>" +
+		"\n\t\t<AdaptationSet/>\n\t</Period>\n</MPD>\n"
+	if got != want {
+		t.Errorf("mpd is\n%s\nwant\n%s", got, want)
+	}
+
+	static := strings.Replace(mpd, ` availabilityStartTime="2021-01-01T12:00:00Z"`, "", 1)
+	if got := string(DASH([]byte(static), cues)); got != static {
+		t.Errorf("static mpd changed to\n%s", got)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	at := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := Schedule{}
+	s.Add(Cue{Kind: KindProgramme, ID: 7, Start: at, Playout: 7})
+	s.Add(Cue{Kind: KindBreak, ID: 2, Start: at.Add(time.Minute), Playout: 7})
+	s.Add(Cue{Kind: KindProgramme, ID: 2, Start: at.Add(time.Hour), Playout: 2})
+	s.End(KindBreak, 2, at.Add(2*time.Minute))
+	// Already over, so the break keeps its end
+	s.EndPlayout(7, at.Add(3*time.Minute))
+
+	cues := s.Cues()
+	if len(cues) != 3 {
+		t.Fatalf("%d cues, want 3, the break shouldn't replace the programme of the same ID", len(cues))
+	}
+	if !cues[0].End.Equal(at.Add(3*time.Minute)) || !cues[1].End.Equal(at.Add(2*time.Minute)) || !cues[2].End.IsZero() {
+		t.Errorf("cues are %+v", cues)
+	}
+
+	s.Prune(at.Add(3 * time.Minute))
+	if cues := s.Cues(); len(cues) != 1 || cues[0].ID != 2 || cues[0].Kind != KindProgramme {
+		t.Errorf("pruned cues are %+v", cues)
+	}
+}
@@ -0,0 +1,104 @@
+package markers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// spliceInsert is SCTE-35's splice_insert command type
+const spliceInsert = 0x05
+
+// bits writes big-endian fields of any width
+type bits struct {
+	buf []byte
+	n   uint // Bits written
+}
+
+func (b *bits) put(v uint64, width uint) {
+	for i := width; i > 0; i-- {
+		if b.n%8 == 0 {
+			b.buf = append(b.buf, 0)
+		}
+		if v>>(i-1)&1 == 1 {
+			b.buf[len(b.buf)-1] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+// SpliceInsert encodes a SCTE-35 splice_info_section of an immediate
+// splice_insert. Out of network splices carry the break's planned
+// duration and return automatically.
+func SpliceInsert(eventID uint32, out bool, duration time.Duration) []byte {
+	cmd := bits{}
+	cmd.put(uint64(eventID), 32)
+	cmd.put(0, 1)    // splice_event_cancel_indicator
+	cmd.put(0x7f, 7) // reserved
+	cmd.put(boolBit(out), 1)
+	cmd.put(1, 1) // program_splice_flag
+	hasDuration := out && duration > 0
+	cmd.put(boolBit(hasDuration), 1)
+	cmd.put(1, 1)   // splice_immediate_flag
+	cmd.put(0xf, 4) // reserved
+	if hasDuration {
+		cmd.put(1, 1)    // auto_return
+		cmd.put(0x3f, 6) // reserved
+		cmd.put(uint64(duration*90000/time.Second), 33)
+	}
+	cmd.put(0, 16) // unique_program_id
+	cmd.put(0, 8)  // avail_num
+	cmd.put(0, 8)  // avails_expected
+
+	s := bits{}
+	s.put(0xfc, 8) // table_id
+	s.put(0, 1)    // section_syntax_indicator
+	s.put(0, 1)    // private_indicator
+	s.put(3, 2)    // sap_type, not specified
+	s.put(uint64(17+len(cmd.buf)), 12)
+	s.put(0, 8)      // protocol_version
+	s.put(0, 1)      // encrypted_packet
+	s.put(0, 6)      // encryption_algorithm
+	s.put(0, 33)     // pts_adjustment
+	s.put(0, 8)      // cw_index
+	s.put(0xfff, 12) // tier
+	s.put(uint64(len(cmd.buf)), 12)
+	s.put(spliceInsert, 8)
+	s.buf = append(s.buf, cmd.buf...)
+	s.buf = append(s.buf, 0, 0) // descriptor_loop_length
+	crc := crc32MPEG2(s.buf)
+	return append(s.buf, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// Hex is a section as HLS date ranges carry it
+func Hex(section []byte) string {
+	return fmt.Sprintf("0x%X", section)
+}
+
+// Base64 is a section as DASH events carry it
+func Base64(section []byte) string {
+	return base64.StdEncoding.EncodeToString(section)
+}
+
+// crc32MPEG2 is the CRC of MPEG-2 sections, unreflected from all ones
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func boolBit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -109,14 +109,18 @@ func New(ctx context.Context, endpoint string, width, height int) (*Brave, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal new pipe json: %w", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "/mixers", bytes.NewReader(reqJSON))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.endpoint+"/api/mixers", bytes.NewReader(reqJSON))
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
-	_, err = b.c.Do(req)
+	mixRes, err := b.c.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to do request: %w", err)
 	}
+	defer mixRes.Body.Close()
+	if mixRes.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("failed to create mixer: %s", mixRes.Status)
+	}
 	return b, nil
 }
 
@@ -134,10 +138,14 @@ func (b *Brave) Restart() error {
 	if err != nil {
 		return fmt.Errorf("failed to marhsal restart json: %w", err)
 	}
-	_, err = b.c.Post("/api/restart", "application/json", bytes.NewReader(reqJSON))
+	res, err := b.c.Post(b.endpoint+"/api/restart", "application/json", bytes.NewReader(reqJSON))
 	if err != nil {
 		return fmt.Errorf("failed to restart Brave: %w", err)
 	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("failed to restart Brave: %s", res.Status)
+	}
 	return nil
 }
 
@@ -147,6 +155,10 @@ func (b *Brave) GetState() (*State, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to request state: %w", err)
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to request state: %s", res.Status)
+	}
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
@@ -0,0 +1,64 @@
+package brave_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ystv/playout/piper/brave"
+	"github.com/ystv/playout/piper/brave/bravetest"
+)
+
+func TestRestart(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	b, err := brave.New(context.Background(), srv.URL, 1920, 1080)
+	if err != nil {
+		t.Fatalf("failed to connect to brave: %+v", err)
+	}
+
+	err = b.Restart()
+	if err != nil {
+		t.Fatalf("failed to restart: %+v", err)
+	}
+	if srv.Restarts() != 1 {
+		t.Errorf("brave restarted %d times, want 1", srv.Restarts())
+	}
+
+	srv.Fail(bravetest.RouteRestart, http.StatusServiceUnavailable)
+	err = b.Restart()
+	if err == nil {
+		t.Error("restarted while brave was failing")
+	}
+	if srv.Restarts() != 1 {
+		t.Errorf("brave restarted %d times, want 1", srv.Restarts())
+	}
+}
+
+func TestGetState(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	b, err := brave.New(context.Background(), srv.URL, 1920, 1080)
+	if err != nil {
+		t.Fatalf("failed to connect to brave: %+v", err)
+	}
+	srv.SetState(brave.State{
+		MainMixID: 1,
+		Inputs:    []brave.Input{{ID: 4, URI: "rtmp://camera.example.com/live/cam1", State: "PLAYING"}},
+		Mixers:    []brave.Mixer{{ID: 1, State: "PLAYING", Sources: []brave.MixSource{{ID: 4, InMix: true}}}},
+	})
+
+	state, err := b.GetState()
+	if err != nil {
+		t.Fatalf("failed to get state: %+v", err)
+	}
+	if len(state.Inputs) != 1 || state.Inputs[0].ID != 4 || len(state.Mixers) != 1 || state.Mixers[0].Sources[0].ID != 4 {
+		t.Errorf("state is %+v, want the scripted one", state)
+	}
+
+	srv.Fail(bravetest.RouteAll, http.StatusInternalServerError)
+	_, err = b.GetState()
+	if err == nil {
+		t.Error("got state while brave was failing")
+	}
+}
@@ -0,0 +1,217 @@
+// Package bravetest provides an in-process fake of Brave for testing.
+//
+// The fake's state can be scripted and read back, and any endpoint can
+// be made to fail to exercise error handling.
+package bravetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/ystv/playout/piper/brave"
+)
+
+// Routes of the fake, used to inject failures
+const (
+	RouteAll         = "/api/all"
+	RouteNewInput    = "/api/inputs"
+	RouteDeleteInput = "/api/inputs/{id}"
+	RouteMixers      = "/api/mixers"
+	RouteRestart     = "/api/restart"
+)
+
+// Server is a fake Brave instance
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	state    brave.State
+	nextID   int
+	restarts int
+	fail     map[string]int // Status code by route
+}
+
+// NewServer starts a fake Brave with a single empty mixer, it should
+// be closed when done with
+func NewServer() *Server {
+	s := &Server{
+		state: brave.State{
+			MainMixID: 1,
+			Inputs:    []brave.Input{},
+			Overlays:  []brave.Overlay{},
+			Outputs:   []brave.Output{},
+			Mixers:    []brave.Mixer{{ID: 1, UID: "mixer1", State: "PLAYING"}},
+		},
+		nextID: 1,
+		fail:   make(map[string]int),
+	}
+	r := mux.NewRouter()
+	r.HandleFunc(RouteAll, s.guard(RouteAll, s.all)).Methods(http.MethodGet)
+	r.HandleFunc(RouteNewInput, s.guard(RouteNewInput, s.newInput)).Methods(http.MethodPut)
+	r.HandleFunc(RouteDeleteInput, s.guard(RouteDeleteInput, s.deleteInput)).Methods(http.MethodDelete)
+	r.HandleFunc(RouteMixers, s.guard(RouteMixers, s.newMixer)).Methods(http.MethodPut)
+	// Older clients created mixers without the API prefix
+	r.HandleFunc("/mixers", s.guard(RouteMixers, s.newMixer)).Methods(http.MethodPut)
+	r.HandleFunc(RouteRestart, s.guard(RouteRestart, s.restart)).Methods(http.MethodPost)
+	s.Server = httptest.NewServer(r)
+	return s
+}
+
+// Fail makes a route respond with the status code, 0 restores it
+func (s *Server) Fail(route string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == 0 {
+		delete(s.fail, route)
+		return
+	}
+	s.fail[route] = status
+}
+
+// State returns a copy of the fake's state
+func (s *Server) State() brave.State {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state := s.state
+	state.Inputs = append([]brave.Input{}, s.state.Inputs...)
+	state.Overlays = append([]brave.Overlay{}, s.state.Overlays...)
+	state.Outputs = append([]brave.Output{}, s.state.Outputs...)
+	state.Mixers = append([]brave.Mixer{}, s.state.Mixers...)
+	return state
+}
+
+// SetState scripts the fake's state
+func (s *Server) SetState(state brave.State) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state = state
+	for _, i := range state.Inputs {
+		if i.ID >= s.nextID {
+			s.nextID = i.ID + 1
+		}
+	}
+	for _, m := range state.Mixers {
+		if m.ID >= s.nextID {
+			s.nextID = m.ID + 1
+		}
+	}
+}
+
+// SetInputState scripts an input's state, i.e. PLAYING or NULL
+func (s *Server) SetInputState(id int, state string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for idx := range s.state.Inputs {
+		if s.state.Inputs[idx].ID == id {
+			s.state.Inputs[idx].State = state
+			return nil
+		}
+	}
+	return fmt.Errorf("input %d doesn't exist", id)
+}
+
+// Restarts is how many times the fake has been restarted
+func (s *Server) Restarts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.restarts
+}
+
+// guard responds with an injected failure instead of the handler
+func (s *Server) guard(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		status, failing := s.fail[route]
+		s.lock.Unlock()
+		if failing {
+			http.Error(w, "injected failure", status)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) all(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.State())
+}
+
+func (s *Server) newInput(w http.ResponseWriter, r *http.Request) {
+	i := brave.NewInput{}
+	err := json.NewDecoder(r.Body).Decode(&i)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	input := brave.Input{
+		ID:       s.nextID,
+		UID:      fmt.Sprintf("input%d", s.nextID),
+		URI:      i.URI,
+		Type:     i.Type,
+		HasAudio: i.HasAudio,
+		HasVideo: i.HasVideo,
+		Volume:   i.Volume,
+		Position: i.Position,
+		State:    "PLAYING",
+		Width:    i.Width,
+		Height:   i.Height,
+	}
+	s.nextID++
+	s.state.Inputs = append(s.state.Inputs, input)
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, brave.NewInputResponse{InputID: input.ID, UniversalID: input.UID})
+}
+
+func (s *Server) deleteInput(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid input id", http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for idx, i := range s.state.Inputs {
+		if i.ID == id {
+			s.state.Inputs = append(s.state.Inputs[:idx], s.state.Inputs[idx+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "input not found", http.StatusNotFound)
+}
+
+func (s *Server) newMixer(w http.ResponseWriter, r *http.Request) {
+	m := brave.Mixer{}
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	m.ID = s.nextID
+	m.UID = fmt.Sprintf("mixer%d", m.ID)
+	m.State = "PLAYING"
+	s.nextID++
+	s.state.Mixers = append(s.state.Mixers, m)
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]int{"id": m.ID})
+}
+
+func (s *Server) restart(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.restarts++
+	s.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON responds with v
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -44,6 +44,9 @@ func (b *Brave) New(ctx context.Context, i NewInput) (NewInputResponse, error) {
 		return NewInputResponse{}, fmt.Errorf("failed to do request: %w", err)
 	}
 	defer httpRes.Body.Close()
+	if httpRes.StatusCode >= http.StatusBadRequest {
+		return NewInputResponse{}, fmt.Errorf("failed to create input: %s", httpRes.Status)
+	}
 
 	body, err := io.ReadAll(httpRes.Body)
 	if err != nil {
@@ -64,9 +67,13 @@ func (b *Brave) Delete(ctx context.Context, inputID int) error {
 	if err != nil {
 		return fmt.Errorf("failed to make delete input request: %w", err)
 	}
-	_, err = b.c.Do(req)
+	res, err := b.c.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to do delete input request: %w", err)
 	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("failed to delete input: %s", res.Status)
+	}
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/ystv/playout/piper/brave"
@@ -14,6 +16,9 @@ var (
 	// ErrUnknownMixer is when an unsupported mixer is
 	// attempted to be used
 	ErrUnknownMixer = errors.New("unknown mixer")
+	// ErrUnsupportedInput is when an input's URL can't be
+	// ingested by the mixer
+	ErrUnsupportedInput = errors.New("unsupported input url")
 )
 
 type (
@@ -182,3 +187,53 @@ func (p *Piper) UpdateState(ctx context.Context) error {
 	}
 	return nil
 }
+
+var _ InputStore = &Piper{}
+
+// New adds an input to the mixer
+//
+// SRT inputs take their connection options as query parameters,
+// i.e. srt://host:port?mode=listener&passphrase=...&latency=120
+// with latency in milliseconds.
+func (p *Piper) New(ctx context.Context, i NewInput) error {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse input url: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp", "rtsp", "srt", "http", "https", "file":
+	default:
+		return fmt.Errorf("%w: \"%s\"", ErrUnsupportedInput, u.Scheme)
+	}
+	switch p.mixer {
+	case "brave":
+		_, err = p.brave.New(ctx, brave.NewInput{
+			URI:      i.URL,
+			Type:     "uri",
+			HasAudio: true,
+			HasVideo: true,
+			Width:    i.Width,
+			Height:   i.Height,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add brave input: %w", err)
+		}
+	default:
+		return ErrUnknownMixer
+	}
+	return p.UpdateState(ctx)
+}
+
+// Delete removes an input from the mixer
+func (p *Piper) Delete(ctx context.Context, sourceID int) error {
+	switch p.mixer {
+	case "brave":
+		err := p.brave.Delete(ctx, sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to delete brave input: %w", err)
+		}
+	default:
+		return ErrUnknownMixer
+	}
+	return p.UpdateState(ctx)
+}
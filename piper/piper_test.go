@@ -0,0 +1,145 @@
+package piper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ystv/playout/piper/brave/bravetest"
+)
+
+// testPiper is a piper mixing with a fake Brave
+func testPiper(t *testing.T, srv *bravetest.Server) *Piper {
+	t.Helper()
+	p, err := New(context.Background(), Config{Endpoint: srv.URL, Width: 1920, Height: 1080}, "brave")
+	if err != nil {
+		t.Fatalf("failed to create piper: %+v", err)
+	}
+	return p
+}
+
+func TestNewCreatesMixer(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	p := testPiper(t, srv)
+
+	mixers := srv.State().Mixers
+	if len(mixers) != 2 || mixers[1].Width != 1920 || mixers[1].Height != 1080 {
+		t.Errorf("brave has mixers %+v, want a 1920x1080 one created", mixers)
+	}
+	if p.Composition.State != "PLAYING" {
+		t.Errorf("composition is %+v, want the main mix playing", p.Composition)
+	}
+}
+
+func TestNewFails(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		mixer string
+	}{
+		{name: "mixer", route: bravetest.RouteMixers, mixer: "brave"},
+		{name: "state", route: bravetest.RouteAll, mixer: "brave"},
+		{name: "unknown mixer", mixer: "obs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := bravetest.NewServer()
+			defer srv.Close()
+			if test.route != "" {
+				srv.Fail(test.route, http.StatusInternalServerError)
+			}
+			_, err := New(context.Background(), Config{Endpoint: srv.URL}, test.mixer)
+			if err == nil {
+				t.Error("created a piper while brave was failing")
+			}
+		})
+	}
+}
+
+func TestInputs(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	p := testPiper(t, srv)
+	ctx := context.Background()
+
+	err := p.New(ctx, NewInput{Input{URL: "srt://camera.example.com:9000?mode=caller", Width: 1280, Height: 720}})
+	if err != nil {
+		t.Fatalf("failed to add input: %+v", err)
+	}
+	inputs := srv.State().Inputs
+	if len(inputs) != 1 || inputs[0].URI != "srt://camera.example.com:9000?mode=caller" || !inputs[0].HasVideo || !inputs[0].HasAudio {
+		t.Fatalf("brave has inputs %+v, want the camera", inputs)
+	}
+	if len(p.Inputs) != 1 || p.Inputs[0].State != "PLAYING" {
+		t.Errorf("piper has inputs %+v, want the camera playing", p.Inputs)
+	}
+
+	// Brave's state is picked up on the next update
+	err = srv.SetInputState(inputs[0].ID, "NULL")
+	if err != nil {
+		t.Fatalf("failed to script input: %+v", err)
+	}
+	err = p.UpdateState(ctx)
+	if err != nil {
+		t.Fatalf("failed to update state: %+v", err)
+	}
+	if p.Inputs[0].State != "NULL" {
+		t.Errorf("input is %s, want NULL", p.Inputs[0].State)
+	}
+
+	err = p.Delete(ctx, inputs[0].ID)
+	if err != nil {
+		t.Fatalf("failed to delete input: %+v", err)
+	}
+	if inputs := srv.State().Inputs; len(inputs) != 0 {
+		t.Errorf("brave still has inputs %+v", inputs)
+	}
+	if len(p.Inputs) != 0 {
+		t.Errorf("piper still has inputs %+v", p.Inputs)
+	}
+}
+
+func TestInputsFail(t *testing.T) {
+	srv := bravetest.NewServer()
+	defer srv.Close()
+	p := testPiper(t, srv)
+	ctx := context.Background()
+
+	err := p.New(ctx, NewInput{Input{URL: "udp://239.0.0.1:5000"}})
+	if !errors.Is(err, ErrUnsupportedInput) {
+		t.Errorf("added an unsupported input: %+v", err)
+	}
+
+	srv.Fail(bravetest.RouteNewInput, http.StatusInternalServerError)
+	err = p.New(ctx, NewInput{Input{URL: "rtmp://camera.example.com/live/cam1"}})
+	if err == nil {
+		t.Error("added an input brave failed to create")
+	}
+	srv.Fail(bravetest.RouteNewInput, 0)
+	if inputs := srv.State().Inputs; len(inputs) != 0 {
+		t.Fatalf("brave has inputs %+v", inputs)
+	}
+
+	err = p.New(ctx, NewInput{Input{URL: "rtmp://camera.example.com/live/cam1"}})
+	if err != nil {
+		t.Fatalf("failed to add input: %+v", err)
+	}
+	id := srv.State().Inputs[0].ID
+	srv.Fail(bravetest.RouteDeleteInput, http.StatusInternalServerError)
+	err = p.Delete(ctx, id)
+	if err == nil {
+		t.Error("deleted an input brave failed to delete")
+	}
+	if len(p.Inputs) != 1 {
+		t.Errorf("piper has inputs %+v, the failed delete should have kept it", p.Inputs)
+	}
+
+	srv.Fail(bravetest.RouteDeleteInput, 0)
+	srv.Fail(bravetest.RouteAll, http.StatusInternalServerError)
+	err = p.Delete(ctx, id)
+	if err == nil {
+		t.Error("deleted an input without getting brave's state after")
+	}
+}
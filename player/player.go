@@ -7,13 +7,20 @@ import (
 // Player will create a stream to playout a programme
 type Player interface {
 	Play(ctx context.Context, c Config) error
+	// Stop ends a playout which is being played
+	Stop(ctx context.Context, playoutID int) error
 }
 
 // Config is the required video information for processing
 type Config struct {
+	ChannelID int // Channel and playout the stream is for
+	PlayoutID int
 	DstURL    string
 	Width     int
 	Height    int
 	Bitrate   int
 	VideoURLs []string
+	// AudioFilter is applied to the programme's audio, which is the
+	// videos played back to back. Empty leaves the audio as is.
+	AudioFilter string
 }
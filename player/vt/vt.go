@@ -1,71 +1,67 @@
 package vt
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"log"
 
 	"github.com/ystv/playout/player"
+	"github.com/ystv/playout/utils"
+	"github.com/ystv/playout/vt"
 )
 
 // Player encapsulates VT's dependencies
 type Player struct {
-	endpoint string
-	c        http.Client
+	tracker *vt.Tracker
 }
 
 var _ player.Player = &Player{}
 
-// EncodeArgs are the FFmpeg arguements on the playout encode
-type EncodeArgs struct {
-	Args    string `json:"args"`    // Global arguments
-	DstArgs string `json:"dstArgs"` // Output file options
-	DstURL  string `json:"dstURL"`  // Destination
-}
-
 // Play will create a VT Task to play the programme
+//
+// If the playout's task is still running, i.e. after a restart,
+// it is adopted instead of being played again.
 func (p *Player) Play(ctx context.Context, c player.Config) error {
-	reqBody := struct {
-		EncodeArgs EncodeArgs
-		Videos     []string
-	}{
-		EncodeArgs: EncodeArgs{
+	owner := vt.Owner{ChannelID: c.ChannelID, PlayoutID: c.PlayoutID}
+	if c.PlayoutID != 0 {
+		taskID, running, err := p.tracker.Adopt(ctx, owner)
+		if err != nil {
+			return fmt.Errorf("failed to check for running task: %w", err)
+		}
+		if running {
+			log.Printf("adopted vt task \"%s\" for playout %d", taskID, c.PlayoutID)
+			return nil
+		}
+	}
+	dstArgs := []string{"-c:v", "libx264", "-bitrate", "10M"}
+	if c.AudioFilter != "" {
+		dstArgs = append(dstArgs, "-af", c.AudioFilter)
+	}
+	dstArgs = append(dstArgs, "-f", "flv")
+	taskID, err := p.tracker.Client().Play(ctx, vt.PlayTask{
+		EncodeArgs: vt.EncodeArgs{
 			Args:    "-re",
-			DstArgs: "-c:v libx264 -bitrate 10M -f flv",
+			DstArgs: utils.QuoteArgs(dstArgs),
 			DstURL:  c.DstURL,
 		},
 		Videos: c.VideoURLs,
-	}
-	reqJSON, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal VT task: %w", err)
-	}
-	res, err := p.c.Post(p.endpoint+"/task/play", "application/json", bytes.NewReader(reqJSON))
+	})
 	if err != nil {
-		return fmt.Errorf("failed to submit VT task: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusCreated {
-		return fmt.Errorf("VT failed to create task: %v", res.Body)
+		return err
 	}
-	return nil
+	return p.tracker.Track(ctx, taskID, owner)
+}
+
+// Stop cancels the playout's VT task
+func (p *Player) Stop(ctx context.Context, playoutID int) error {
+	return p.tracker.Cancel(ctx, vt.Owner{PlayoutID: playoutID})
 }
 
 // New creates a new VT-based player
-func New(endpoint string) (*Player, error) {
-	p := Player{
-		endpoint: endpoint,
-		c:        http.Client{},
-	}
-	res, err := p.c.Get(endpoint + "/ok")
+func New(ctx context.Context, tracker *vt.Tracker) (*Player, error) {
+	err := tracker.Client().Ping(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to VT: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("VT not OK: %v", res.Body)
+		return nil, err
 	}
-	return &p, nil
+	return &Player{tracker: tracker}, nil
 }
@@ -0,0 +1,83 @@
+package playout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidBreak is when a playout's break doesn't fit in it
+var ErrInvalidBreak = errors.New("invalid break")
+
+// Break is an ad break during a playout, signalled to downstream
+// platforms so they can splice in their own content
+type Break struct {
+	ID       int `db:"break_id" json:"breakID"`
+	Start    int `db:"start_offset" json:"start"` // Seconds after the playout's broadcast start
+	Duration int `db:"duration" json:"duration"`  // Seconds
+}
+
+// Offset is when the break starts after the playout's broadcast start
+func (b Break) Offset() time.Duration {
+	return time.Duration(b.Start) * time.Second
+}
+
+// Length is how long the break is planned to last
+func (b Break) Length() time.Duration {
+	return time.Duration(b.Duration) * time.Second
+}
+
+// validateBreaks checks breaks are within the playout and don't overlap
+func validateBreaks(po NewPlayout) error {
+	breaks := make([]Break, len(po.Breaks))
+	copy(breaks, po.Breaks)
+	sort.Slice(breaks, func(i, j int) bool {
+		return breaks[i].Start < breaks[j].Start
+	})
+	length := po.End.Sub(po.Start)
+	end := 0
+	for _, b := range breaks {
+		if b.Start < 0 || b.Duration <= 0 {
+			return fmt.Errorf("%w: breaks need a start and a duration", ErrInvalidBreak)
+		}
+		if b.Offset()+b.Length() > length {
+			return fmt.Errorf("%w: break at %ds runs past the end of the playout", ErrInvalidBreak, b.Start)
+		}
+		if b.Start < end {
+			return fmt.Errorf("%w: break at %ds overlaps the one before", ErrInvalidBreak, b.Start)
+		}
+		end = b.Start + b.Duration
+	}
+	return nil
+}
+
+// insertBreaks stores a new playout's breaks
+func insertBreaks(ctx context.Context, tx *sqlx.Tx, playoutID int, breaks []Break) error {
+	for _, b := range breaks {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO playout.schedule_playout_breaks(playout_id, start_offset, duration)
+			VALUES ($1, $2, $3);`, playoutID, b.Start, b.Duration)
+		if err != nil {
+			return fmt.Errorf("failed to insert break: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetBreaks retrieves a playout's breaks, soonest first
+func (p *Playouter) GetBreaks(ctx context.Context, playoutID int) ([]Break, error) {
+	breaks := []Break{}
+	err := p.db.SelectContext(ctx, &breaks, `
+		SELECT break_id, start_offset, duration
+		FROM playout.schedule_playout_breaks
+		WHERE playout_id = $1
+		ORDER BY start_offset;`, playoutID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select breaks: %w", err)
+	}
+	return breaks, nil
+}
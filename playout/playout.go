@@ -7,10 +7,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/ystv/playout/programming"
+	"github.com/ystv/playout/utils"
 )
 
 type (
@@ -18,10 +21,15 @@ type (
 	Repo interface {
 		New(ctx context.Context, po NewPlayout) (int, error)
 		Update(ctx context.Context, b Playout) error
+		Get(ctx context.Context, playoutID int) (*Playout, error)
 		// Our gets are always arrays since it isn't channel specific
 		GetCurrent(ctx context.Context) ([]Playout, error)
 		GetRange(ctx context.Context, start time.Time, end time.Time) ([]Playout, error)
 		GetAmount(ctx context.Context, amount int) ([]Playout, error)
+		GetUpcoming(ctx context.Context, channelID int, before time.Time) ([]Playout, error)
+		GetOnAir(ctx context.Context, channelID int) ([]Playout, error)
+		GetBreaks(ctx context.Context, playoutID int) ([]Break, error)
+		Delete(ctx context.Context, playoutID int) error
 	}
 	// Playouter handles the videostreams
 	Playouter struct {
@@ -36,9 +44,11 @@ type (
 		ChannelID   int       `db:"channel_id" json:"channelID"`
 		ProgrammeID int       `db:"programme_id" json:"programmeID"`
 		IngestURL   string    `db:"ingest_url" json:"ingestURL"`
-		IngestType  string    `db:"ingest_type" json:"ingestType"`
+		IngestType  string    `db:"ingest_type" json:"ingestType"` // rtmp / rtp / hls / srt
 		Start       time.Time `db:"scheduled_start" json:"start"`
 		End         time.Time `db:"scheduled_end" json:"end"`
+		HideLogo    bool      `db:"hide_logo" json:"hideLogo"`
+		Breaks      []Break   `db:"-" json:"breaks"`
 	}
 	// Playout the individual video stream that is played out as part of a channel
 	Playout struct {
@@ -56,6 +66,7 @@ type (
 		VODURL         string    `db:"vod_url" json:"vodURL"`
 		DVR            bool      `db:"dvr" json:"dvr"`
 		Archive        bool      `db:"archive" json:"archive"`
+		HideLogo       bool      `db:"hide_logo" json:"hideLogo"` // Channel's logo is taken off while on air
 	}
 )
 
@@ -65,6 +76,27 @@ func New(prog *programming.Programmer, db *sqlx.DB) *Playouter {
 
 var _ Repo = &Playouter{}
 
+// ErrUnknownIngestType is when a playout's ingest type isn't supported
+var ErrUnknownIngestType = errors.New("unknown ingest type")
+
+// validateIngest checks the ingest can be broadcast to
+func validateIngest(ingestURL, ingestType string) error {
+	switch strings.ToLower(ingestType) {
+	case "rtmp", "rtp", "hls":
+		return nil
+	case "srt":
+		// Connection options are part of the URL, i.e.
+		// srt://host:port?mode=caller&passphrase=...
+		u, err := url.Parse(ingestURL)
+		if err != nil || u.Scheme != "srt" {
+			return fmt.Errorf("srt ingest \"%s\" isn't an srt:// url", ingestURL)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: \"%s\"", ErrUnknownIngestType, ingestType)
+	}
+}
+
 // New adds a playout to the schedule
 func (p *Playouter) New(ctx context.Context, po NewPlayout) (int, error) {
 	/*
@@ -74,7 +106,15 @@ func (p *Playouter) New(ctx context.Context, po NewPlayout) (int, error) {
 		* Time isn't overlapping existing schedule
 	*/
 	playoutID := 0
-	_, err := p.prog.Get(ctx, po.ProgrammeID)
+	err := validateIngest(po.IngestURL, po.IngestType)
+	if err != nil {
+		return playoutID, err
+	}
+	err = validateBreaks(po)
+	if err != nil {
+		return playoutID, err
+	}
+	_, err = p.prog.Get(ctx, po.ProgrammeID)
 	if err != nil {
 		return playoutID, fmt.Errorf("failed to get programme: %w", err)
 	}
@@ -85,14 +125,20 @@ func (p *Playouter) New(ctx context.Context, po NewPlayout) (int, error) {
 	if len(playouts) != 0 {
 		return playoutID, errors.New("time already scheduled: %w")
 	}
-	err = p.db.GetContext(ctx, &playoutID, `
-		INSERT INTO schedule_playouts
-		(channel_id, programme_id, ingest_url, ingest_type, scheduled_start,
-		scheduled_end)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING playout_id;`, po.ChannelID, po.ProgrammeID, po.IngestURL, po.IngestType, po.Start, po.End)
+	err = utils.Transact(p.db, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &playoutID, `
+			INSERT INTO schedule_playouts
+			(channel_id, programme_id, ingest_url, ingest_type, scheduled_start,
+			scheduled_end, hide_logo)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING playout_id;`, po.ChannelID, po.ProgrammeID, po.IngestURL, po.IngestType, po.Start, po.End, po.HideLogo)
+		if err != nil {
+			return fmt.Errorf("failed to insert new playout")
+		}
+		return insertBreaks(ctx, tx, playoutID, po.Breaks)
+	})
 	if err != nil {
-		return playoutID, fmt.Errorf("failed to insert new playout")
+		return 0, err
 	}
 	return playoutID, nil
 }
@@ -101,6 +147,7 @@ func (p *Playouter) New(ctx context.Context, po NewPlayout) (int, error) {
 func (p *Playouter) Update(ctx context.Context, b Playout) error {
 	// TOOD: Validate this query. Are we allowing overlaps? FindIslands supports overlapping
 	// Ideally we need to validate each field
+	// Broadcast times which haven't happened are stored as NULL
 	res, err := p.db.ExecContext(ctx, `
 		UPDATE playout.schedule_playouts SET
 			channel_id = $1,
@@ -108,15 +155,16 @@ func (p *Playouter) Update(ctx context.Context, b Playout) error {
 			ingest_url = $3,
 			ingest_type = $4,
 			scheduled_start = $5,
-			broadcast_start = $6,
+			broadcast_start = NULLIF($6::timestamptz, '0001-01-01 00:00:00+00'),
 			scheduled_end = $7,
-			broadcast_end = $8
+			broadcast_end = NULLIF($8::timestamptz, '0001-01-01 00:00:00+00'),
 			vod_url = $9,
 			dvr = $10,
-			archive = $11
-		WHERE playout_id = $12;`, b.ChannelID, b.ProgrammeID, b.IngestURL, b.IngestType,
+			archive = $11,
+			hide_logo = $12
+		WHERE playout_id = $13;`, b.ChannelID, b.ProgrammeID, b.IngestURL, b.IngestType,
 		b.ScheduledStart, b.BroadcastStart, b.ScheduledEnd, b.BroadcastEnd,
-		b.VODURL, b.DVR, b.Archive, b.PlayoutID)
+		b.VODURL, b.DVR, b.Archive, b.HideLogo, b.PlayoutID)
 	if err != nil {
 		return fmt.Errorf("failed to update playout: %w", err)
 	}
@@ -130,13 +178,30 @@ func (p *Playouter) Update(ctx context.Context, b Playout) error {
 	return nil
 }
 
+// Get retrieves a playout by its playoutID
+func (p *Playouter) Get(ctx context.Context, playoutID int) (*Playout, error) {
+	po := Playout{}
+	err := p.db.GetContext(ctx, &po, `
+		SELECT playout_id, channel_id, programme_id, ingest_url, ingest_type,
+			scheduled_start, COALESCE(broadcast_start, '0001-01-01') AS broadcast_start,
+			scheduled_end, COALESCE(broadcast_end, '0001-01-01') AS broadcast_end,
+			vod_url, dvr, archive, hide_logo
+		FROM playout.schedule_playouts
+		WHERE playout_id = $1;`, playoutID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playout: %w", err)
+	}
+	return &po, nil
+}
+
 // GetRange gets a range of items from a time range
 func (p *Playouter) GetRange(ctx context.Context, start, end time.Time) ([]Playout, error) {
 	items := []Playout{}
 	err := p.db.SelectContext(ctx, &items, `
 	
 	SELECT playout_id, channel_id, programme_id, ingest_url,
-		scheduled_start, broadcast_start, scheduled_end, broadcast_end
+		scheduled_start, broadcast_start, scheduled_end, broadcast_end,
+		hide_logo
 		
 	FROM playout.schedule_playouts
 	
@@ -153,7 +218,8 @@ func (p *Playouter) GetAmount(ctx context.Context, amount int) ([]Playout, error
 	err := p.db.SelectContext(ctx, &playouts, `
 	
 	SELECT playout_id, channel_id, programme_id, ingest_url,
-		scheduled_start, broadcast_start, scheduled_end, broadcast_end
+		scheduled_start, broadcast_start, scheduled_end, broadcast_end,
+		hide_logo
 		
 	FROM playout.schedule_playouts
 	
@@ -165,13 +231,55 @@ func (p *Playouter) GetAmount(ctx context.Context, amount int) ([]Playout, error
 	return playouts, nil
 }
 
+// GetUpcoming gets a channel's playouts which haven't been broadcast
+// and are scheduled to start before a time, soonest first
+func (p *Playouter) GetUpcoming(ctx context.Context, channelID int, before time.Time) ([]Playout, error) {
+	playouts := []Playout{}
+	err := p.db.SelectContext(ctx, &playouts, `
+		SELECT playout_id, channel_id, programme_id, ingest_url, ingest_type,
+			scheduled_start, '0001-01-01'::timestamptz AS broadcast_start,
+			scheduled_end, '0001-01-01'::timestamptz AS broadcast_end,
+			vod_url, dvr, archive, hide_logo
+		FROM playout.schedule_playouts
+		WHERE channel_id = $1
+		AND broadcast_start IS NULL
+		AND scheduled_end > $2
+		AND scheduled_start < $3
+		ORDER BY scheduled_start;`, channelID, time.Now(), before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select upcoming playouts: %w", err)
+	}
+	return playouts, nil
+}
+
+// GetOnAir gets a channel's playouts which have started being broadcast
+// and haven't finished
+func (p *Playouter) GetOnAir(ctx context.Context, channelID int) ([]Playout, error) {
+	playouts := []Playout{}
+	err := p.db.SelectContext(ctx, &playouts, `
+		SELECT playout_id, channel_id, programme_id, ingest_url, ingest_type,
+			scheduled_start, broadcast_start,
+			scheduled_end, '0001-01-01'::timestamptz AS broadcast_end,
+			vod_url, dvr, archive, hide_logo
+		FROM playout.schedule_playouts
+		WHERE channel_id = $1
+		AND broadcast_start IS NOT NULL
+		AND broadcast_end IS NULL
+		ORDER BY scheduled_start;`, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select playouts on air: %w", err)
+	}
+	return playouts, nil
+}
+
 // GetCurrent gets the currently playing playout
 func (p *Playouter) GetCurrent(ctx context.Context) ([]Playout, error) {
 	playouts := []Playout{}
 	err := p.db.SelectContext(ctx, &playouts, `
 	
 	SELECT playout_id, channel_id, programme_id, ingest_url,
-		scheduled_start, broadcast_start, scheduled_end, broadcast_end
+		scheduled_start, broadcast_start, scheduled_end, broadcast_end,
+		hide_logo
 		
 	FROM playout.schedule_playouts
 	
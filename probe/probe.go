@@ -0,0 +1,240 @@
+// Package probe inspects media sources with ffprobe, so content can be
+// validated before it's needed. Results are cached since sources are
+// usually checked repeatedly in the run up to a playout.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// probeTimeout is how long a single probe can take
+	probeTimeout = 30 * time.Second
+	// failureTTL is how long an unreachable result is cached, short so
+	// a source which comes back is noticed
+	failureTTL = time.Minute
+)
+
+// ErrUnreachable is when a source couldn't be probed
+var ErrUnreachable = errors.New("source unreachable")
+
+type (
+	// Result is what was found about a source
+	Result struct {
+		URL           string        `json:"url"`
+		Reachable     bool          `json:"reachable"`
+		Error         string        `json:"error,omitempty"` // Why it's unreachable
+		Container     string        `json:"container,omitempty"`
+		VideoCodec    string        `json:"videoCodec,omitempty"`
+		Width         int           `json:"width,omitempty"`
+		Height        int           `json:"height,omitempty"`
+		FrameRate     float64       `json:"frameRate,omitempty"`
+		AudioCodec    string        `json:"audioCodec,omitempty"`
+		AudioChannels int           `json:"audioChannels,omitempty"`
+		ChannelLayout string        `json:"channelLayout,omitempty"`
+		SampleRate    int           `json:"sampleRate,omitempty"`
+		Duration      time.Duration `json:"duration"` // 0 for live sources
+		ProbedAt      time.Time     `json:"probedAt"`
+	}
+	// Prober probes sources, caching the results
+	Prober struct {
+		path string // ffprobe binary
+		ttl  time.Duration
+
+		lock  sync.Mutex
+		cache map[string]Result
+	}
+)
+
+// New creates a prober, results are reused for ttl
+func New(path string, ttl time.Duration) *Prober {
+	if path == "" {
+		path = "ffprobe"
+	}
+	return &Prober{
+		path:  path,
+		ttl:   ttl,
+		cache: make(map[string]Result),
+	}
+}
+
+// HasVideo is when the source has a video stream
+func (r Result) HasVideo() bool {
+	return r.VideoCodec != ""
+}
+
+// HasAudio is when the source has an audio stream
+func (r Result) HasAudio() bool {
+	return r.AudioCodec != ""
+}
+
+// Probe inspects a source, using a cached result if there is a recent
+// one. ingestType hints at the container of live sources, i.e. rtmp.
+//
+// Unreachable sources return their result alongside ErrUnreachable.
+func (p *Prober) Probe(ctx context.Context, url, ingestType string) (Result, error) {
+	if r, ok := p.Cached(url); ok {
+		if !r.Reachable {
+			return r, fmt.Errorf("%w: %s", ErrUnreachable, r.Error)
+		}
+		return r, nil
+	}
+	r, err := p.probe(ctx, url, ingestType)
+	if ctx.Err() != nil {
+		// Cancelled by the caller, not the source's fault
+		return r, ctx.Err()
+	}
+	p.lock.Lock()
+	p.cache[url] = r
+	p.lock.Unlock()
+	return r, err
+}
+
+// Cached returns the source's result if it hasn't expired
+func (p *Prober) Cached(url string) (Result, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	r, ok := p.cache[url]
+	if !ok {
+		return Result{}, false
+	}
+	ttl := p.ttl
+	if !r.Reachable && failureTTL < ttl {
+		ttl = failureTTL
+	}
+	if time.Since(r.ProbedAt) > ttl {
+		delete(p.cache, url)
+		return Result{}, false
+	}
+	return r, true
+}
+
+// Forget removes a source's result so it is probed again
+func (p *Prober) Forget(url string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.cache, url)
+}
+
+type (
+	// output is ffprobe's JSON
+	output struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []stream `json:"streams"`
+	}
+	stream struct {
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		RFrameRate    string `json:"r_frame_rate"`
+		Channels      int    `json:"channels"`
+		ChannelLayout string `json:"channel_layout"`
+		SampleRate    string `json:"sample_rate"`
+	}
+)
+
+// probe runs ffprobe against the source
+func (p *Prober) probe(ctx context.Context, url, ingestType string) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	r := Result{URL: url, ProbedAt: time.Now()}
+	args := []string{"-v", "error", "-show_format", "-show_streams", "-of", "json"}
+	switch strings.ToLower(ingestType) {
+	case "rtmp":
+		args = append(args, "-f", "flv")
+	case "srt":
+		args = append(args, "-f", "mpegts")
+	}
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, p.path, append(args, url)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		r.Error = "probe timed out"
+	case err != nil:
+		r.Error = strings.TrimSpace(stderr.String())
+		if r.Error == "" {
+			r.Error = err.Error()
+		}
+	}
+	if r.Error != "" {
+		return r, fmt.Errorf("%w: %s", ErrUnreachable, r.Error)
+	}
+	err = parse(stdout.Bytes(), &r)
+	if err != nil {
+		r.Error = err.Error()
+		return r, fmt.Errorf("%w: %s", ErrUnreachable, r.Error)
+	}
+	r.Reachable = true
+	return r, nil
+}
+
+// parse fills the result from ffprobe's output, using the first
+// video and audio streams
+func parse(b []byte, r *Result) error {
+	out := output{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	r.Container = out.Format.FormatName
+	if secs, err := strconv.ParseFloat(out.Format.Duration, 64); err == nil {
+		r.Duration = time.Duration(secs * float64(time.Second))
+	}
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			if r.VideoCodec != "" {
+				continue
+			}
+			r.VideoCodec = s.CodecName
+			r.Width, r.Height = s.Width, s.Height
+			r.FrameRate = rate(s.AvgFrameRate)
+			if r.FrameRate == 0 {
+				r.FrameRate = rate(s.RFrameRate)
+			}
+		case "audio":
+			if r.AudioCodec != "" {
+				continue
+			}
+			r.AudioCodec = s.CodecName
+			r.AudioChannels = s.Channels
+			r.ChannelLayout = s.ChannelLayout
+			r.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		}
+	}
+	return nil
+}
+
+// rate parses a frame rate fraction, i.e. 30000/1001
+func rate(s string) float64 {
+	parts := strings.SplitN(s, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
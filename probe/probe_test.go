@@ -0,0 +1,186 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ffprobeJSON is trimmed ffprobe output of a file with two audio streams
+const ffprobeJSON = `{
+	"streams": [
+		{
+			"index": 0,
+			"codec_name": "h264",
+			"codec_type": "video",
+			"width": 1920,
+			"height": 1080,
+			"r_frame_rate": "50/1",
+			"avg_frame_rate": "25/1"
+		},
+		{
+			"index": 1,
+			"codec_name": "aac",
+			"codec_type": "audio",
+			"sample_rate": "48000",
+			"channels": 2,
+			"channel_layout": "stereo"
+		},
+		{
+			"index": 2,
+			"codec_name": "ac3",
+			"codec_type": "audio",
+			"sample_rate": "44100",
+			"channels": 6,
+			"channel_layout": "5.1(side)"
+		}
+	],
+	"format": {
+		"filename": "programme.mp4",
+		"format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+		"duration": "1800.040000"
+	}
+}`
+
+func TestParse(t *testing.T) {
+	r := Result{}
+	err := parse([]byte(ffprobeJSON), &r)
+	if err != nil {
+		t.Fatalf("failed to parse: %+v", err)
+	}
+	want := Result{
+		Container:     "mov,mp4,m4a,3gp,3g2,mj2",
+		VideoCodec:    "h264",
+		Width:         1920,
+		Height:        1080,
+		FrameRate:     25,
+		AudioCodec:    "aac",
+		AudioChannels: 2,
+		ChannelLayout: "stereo",
+		SampleRate:    48000,
+		Duration:      1800*time.Second + 40*time.Millisecond,
+	}
+	if r != want {
+		t.Errorf("parsed %+v, want %+v", r, want)
+	}
+
+	// Live sources have no duration and often no average frame rate
+	r = Result{}
+	err = parse([]byte(`{"format": {"format_name": "flv", "duration": "N/A"}, "streams": [
+		{"codec_type": "video", "codec_name": "h264", "avg_frame_rate": "0/0", "r_frame_rate": "30000/1001"}
+	]}`), &r)
+	if err != nil {
+		t.Fatalf("failed to parse: %+v", err)
+	}
+	if r.Duration != 0 || r.FrameRate < 29.97 || r.FrameRate > 29.98 || r.HasAudio() || !r.HasVideo() {
+		t.Errorf("parsed live source as %+v", r)
+	}
+
+	err = parse([]byte("Invalid data found when processing input"), &Result{})
+	if err == nil {
+		t.Error("parsed output which isn't json")
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := map[string]float64{
+		"25/1":       25,
+		"30000/1001": 30000.0 / 1001,
+		"50":         50,
+		"0/0":        0,
+		"":           0,
+		"a/b":        0,
+	}
+	for s, want := range tests {
+		if got := rate(s); got != want {
+			t.Errorf("rate(%q) is %f, want %f", s, got, want)
+		}
+	}
+}
+
+// fakeFFprobe writes a script standing in for ffprobe, which records
+// its arguments to args
+func fakeFFprobe(t *testing.T, script string) (path, args string) {
+	dir := t.TempDir()
+	path = filepath.Join(dir, "ffprobe")
+	args = filepath.Join(dir, "args")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\necho \"$@\" >> "+args+"\n"+script), 0755)
+	if err != nil {
+		t.Fatalf("failed to write fake ffprobe: %+v", err)
+	}
+	return path, args
+}
+
+func TestProbe(t *testing.T) {
+	path, args := fakeFFprobe(t, "cat <<'EOF'\n"+ffprobeJSON+"\nEOF\n")
+	p := New(path, time.Hour)
+	ctx := context.Background()
+	url := "rtmp://ingest.example.com/live/test"
+	for i := 0; i < 2; i++ {
+		r, err := p.Probe(ctx, url, "RTMP")
+		if err != nil {
+			t.Fatalf("failed to probe: %+v", err)
+		}
+		if !r.Reachable || r.URL != url || r.VideoCodec != "h264" || r.ProbedAt.IsZero() {
+			t.Errorf("probed %+v", r)
+		}
+	}
+	b, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatalf("failed to read arguments: %+v", err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(calls) != 1 {
+		t.Errorf("ffprobe ran %d times, the second probe should be cached", len(calls))
+	}
+	if want := "-v error -show_format -show_streams -of json -f flv " + url; calls[0] != want {
+		t.Errorf("ffprobe ran with %q, want %q", calls[0], want)
+	}
+
+	p.Forget(url)
+	if _, ok := p.Cached(url); ok {
+		t.Error("forgotten result is still cached")
+	}
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	path, args := fakeFFprobe(t, "echo 'Connection refused' >&2\nexit 1\n")
+	p := New(path, time.Hour)
+	url := "srt://ingest.example.com:9000"
+	r, err := p.Probe(context.Background(), url, "srt")
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("probed an unreachable source, got %v", err)
+	}
+	if r.Reachable || r.Error != "Connection refused" {
+		t.Errorf("probed %+v", r)
+	}
+
+	// Failures are cached too, for less time than the ttl
+	_, err = p.Probe(context.Background(), url, "srt")
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("cached failure probed as %v", err)
+	}
+	b, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatalf("failed to read arguments: %+v", err)
+	}
+	if calls := strings.Count(string(b), "\n"); calls != 1 {
+		t.Errorf("ffprobe ran %d times, the failure should be cached", calls)
+	}
+	if !strings.Contains(string(b), "-f mpegts") {
+		t.Errorf("srt wasn't probed as mpegts: %s", b)
+	}
+
+	p.lock.Lock()
+	r = p.cache[url]
+	r.ProbedAt = time.Now().Add(-failureTTL - time.Second)
+	p.cache[url] = r
+	p.lock.Unlock()
+	if _, ok := p.Cached(url); ok {
+		t.Error("failure is cached for longer than the failure ttl")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 
 	"github.com/ystv/playout/utils"
 
@@ -12,10 +13,24 @@ import (
 
 var _ ProgrammeStore = &Programmer{}
 
+// ErrInvalidCaption is when a video's caption can't be used
+var ErrInvalidCaption = errors.New("invalid caption")
+
+// languageTag roughly matches a BCP 47 language tag, i.e. "en" or "en-GB"
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
 // New will create a new programme
 //
 // This requires at least one video in the Videos slice
 func (r *Programmer) New(ctx context.Context, p Programme) error {
+	for _, video := range p.Videos {
+		for _, caption := range video.Captions {
+			err := caption.validate()
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err := utils.Transact(r.db, func(tx *sqlx.Tx) error {
 		programmeID := 0
 		err := tx.QueryRowContext(ctx, `
@@ -32,12 +47,25 @@ func (r *Programmer) New(ctx context.Context, p Programme) error {
 		}
 		stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO playout.programme_videos(programme_id, url)
-		VALUES ($1, $2);`)
+		VALUES ($1, $2)
+		RETURNING programme_video_id;`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare videos: %w", err)
+		}
 		for _, video := range p.Videos {
-			_, err = stmt.ExecContext(ctx, programmeID, video.URL)
+			videoID := 0
+			err = stmt.QueryRowContext(ctx, programmeID, video.URL).Scan(&videoID)
 			if err != nil {
 				return fmt.Errorf("failed to link videos to programme: %w", err)
 			}
+			for _, caption := range video.Captions {
+				_, err = tx.ExecContext(ctx, `
+				INSERT INTO playout.programme_video_captions(programme_video_id, url, language, name)
+				VALUES ($1, $2, $3, $4);`, videoID, caption.URL, caption.Language, caption.Name)
+				if err != nil {
+					return fmt.Errorf("failed to link captions to video: %w", err)
+				}
+			}
 		}
 		return nil
 	})
@@ -51,8 +79,8 @@ func (r *Programmer) New(ctx context.Context, p Programme) error {
 func (r *Programmer) Get(ctx context.Context, programmeID int) (*Programme, error) {
 	p := Programme{}
 	err := utils.Transact(r.db, func(tx *sqlx.Tx) error {
-		err := tx.SelectContext(ctx, &p, `
-		SELECT title, description, thumbnail
+		err := tx.GetContext(ctx, &p, `
+		SELECT programme_id, title, description, thumbnail, type, vod_url
 		FROM playout.programmes
 		WHERE programme_id = $1;`, programmeID)
 		if err != nil {
@@ -66,6 +94,25 @@ func (r *Programmer) Get(ctx context.Context, programmeID int) (*Programme, erro
 		if err != nil {
 			return fmt.Errorf("failed to select videos: %w", err)
 		}
+		captions := []Caption{}
+		err = tx.SelectContext(ctx, &captions, `
+		SELECT caption.caption_id, caption.programme_video_id, caption.url,
+			caption.language, caption.name
+		FROM playout.programme_video_captions caption
+		INNER JOIN playout.programme_videos video
+			ON caption.programme_video_id = video.programme_video_id
+		WHERE video.programme_id = $1
+		ORDER BY caption.caption_id;`, programmeID)
+		if err != nil {
+			return fmt.Errorf("failed to select captions: %w", err)
+		}
+		for idx := range p.Videos {
+			for _, caption := range captions {
+				if caption.VideoID == p.Videos[idx].ID {
+					p.Videos[idx].Captions = append(p.Videos[idx].Captions, caption)
+				}
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -91,3 +138,14 @@ func (r *Programmer) Delete(ctx context.Context, programmeID int) error {
 	}
 	return nil
 }
+
+// validate checks a caption can be published
+func (c Caption) validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("%w: url is required", ErrInvalidCaption)
+	}
+	if !languageTag.MatchString(c.Language) {
+		return fmt.Errorf("%w: language \"%s\" isn't a language tag", ErrInvalidCaption, c.Language)
+	}
+	return nil
+}
@@ -38,8 +38,18 @@ type (
 	}
 	// Video is the individual video to be played out
 	Video struct {
-		ID  int    `db:"programme_video_id" json:"id"`
-		URL string `db:"url" json:"url"`
+		ID       int       `db:"programme_video_id" json:"id"`
+		URL      string    `db:"url" json:"url"`
+		Captions []Caption `json:"captions"`
+	}
+	// Caption is a sidecar subtitle file of a video, timed from
+	// the start of the video
+	Caption struct {
+		ID       int    `db:"caption_id" json:"id"`
+		VideoID  int    `db:"programme_video_id" json:"-"`
+		URL      string `db:"url" json:"url"` // SRT or WebVTT
+		Language string `db:"language" json:"language"`
+		Name     string `db:"name" json:"name"` // Shown to viewers, the language if empty
 	}
 )
 
@@ -3,7 +3,11 @@ package public
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/ystv/playout/channel"
 )
 
 type (
@@ -11,13 +15,16 @@ type (
 	//
 	// Linear or event video stream
 	Channel struct {
-		ShortName   string    `json:"shortName"`
-		Name        string    `json:"name"`
-		Description string    `json:"description"`
-		Thumbnail   string    `json:"thumbnail"`
-		Type        string    `json:"type"`
-		Outputs     []string  `json:"outputs"`
-		Schedule    []Playout `json:"schedule"`
+		ShortName      string    `json:"shortName"`
+		Name           string    `json:"name"`
+		Description    string    `json:"description"`
+		Thumbnail      string    `json:"thumbnail"`
+		ThumbnailAt    time.Time `json:"thumbnailAt"` // When it was grabbed live, zero if static
+		ThumbnailStale bool      `json:"thumbnailStale"`
+		Type           string    `json:"type"`
+		Status         string    `json:"status"`
+		Outputs        []string  `json:"outputs"`
+		Schedule       []Playout `json:"schedule"`
 	}
 	// Playout public representation
 	//
@@ -54,18 +61,7 @@ func (p *Publicer) GetAll(ctx context.Context) ([]Channel, error) {
 	}
 	tempChans := []Channel{}
 	for _, ch := range chs {
-		outputs := []string{}
-		for _, output := range ch.Outputs {
-			outputs = append(outputs, output.Destination)
-		}
-		tempChans = append(tempChans, Channel{
-			ShortName:   ch.ShortName,
-			Name:        ch.Name,
-			Description: ch.Description,
-			Thumbnail:   ch.Thumbnail,
-			Type:        ch.ChannelType,
-			Outputs:     outputs,
-		})
+		tempChans = append(tempChans, publicChannel(ch.Info()))
 	}
 
 	return tempChans, nil
@@ -78,19 +74,39 @@ func (p *Publicer) GetChannel(ctx context.Context, shortName string) (*Channel,
 		return nil, fmt.Errorf("failed to get public channel: %w", err)
 	}
 
+	chPublic := publicChannel(ch.Info())
+	return &chPublic, nil
+}
+
+// publicChannel converts a channel to its public representation
+func publicChannel(info channel.Info) Channel {
 	outputs := []string{}
-	for _, output := range ch.Outputs {
+	for _, output := range info.Outputs {
+		if strings.EqualFold(output.Type, "restream") {
+			// Platforms' ingests, not somewhere to watch
+			continue
+		}
 		outputs = append(outputs, output.Destination)
 	}
-
-	chPublic := &Channel{
-		ShortName:   ch.ShortName,
-		Name:        ch.Name,
-		Description: ch.Description,
-		Thumbnail:   ch.Thumbnail,
-		Type:        ch.ChannelType,
+	ch := Channel{
+		ShortName:   info.ShortName,
+		Name:        info.Name,
+		Description: info.Description,
+		Thumbnail:   info.Thumbnail,
+		Type:        info.ChannelType,
+		Status:      string(info.Status.State),
 		Outputs:     outputs,
 	}
+	if !info.Preview.CapturedAt.IsZero() {
+		ch.Thumbnail = ThumbnailURL(info.ShortName, info.Preview.CapturedAt)
+		ch.ThumbnailAt = info.Preview.CapturedAt
+		ch.ThumbnailStale = info.Preview.Stale
+	}
+	return ch
+}
 
-	return chPublic, nil
+// ThumbnailURL is where a channel's live thumbnail is served, the
+// capture time stops caches holding an old frame
+func ThumbnailURL(shortName string, capturedAt time.Time) string {
+	return fmt.Sprintf("/public/channel/%s/thumbnail.jpg?t=%d", url.PathEscape(shortName), capturedAt.Unix())
 }
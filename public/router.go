@@ -1,10 +1,17 @@
 package public
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ystv/playout/channel"
+	"github.com/ystv/playout/utils"
 )
 
 // Router provides HHTP endpoints to access the schedule
@@ -13,6 +20,8 @@ func (p *Publicer) Router() *mux.Router {
 	r.HandleFunc("/", index)
 	r.HandleFunc("/channels", p.GetChannelsHandler).Methods("GET")
 	r.HandleFunc("/channel/{name}", p.GetChannelHandler).Methods("GET")
+	r.HandleFunc("/channel/{name}/thumbnail.jpg", p.GetThumbnailHandler).Methods("GET")
+	r.HandleFunc("/events", p.EventsHandler).Methods("GET")
 	return r
 }
 
@@ -50,6 +59,106 @@ func (p *Publicer) GetChannelHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetThumbnailHandler serves a channel's latest frame, with the time
+// it was grabbed
+func (p *Publicer) GetThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	preview, err := p.mcr.GetPreview(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		if errors.Is(err, channel.ErrChannelNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(preview.Path)
+	if err != nil {
+		http.Error(w, "channel has no thumbnail", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Captured-At", preview.CapturedAt.UTC().Format(time.RFC3339))
+	w.Header().Set("X-Stale", strconv.FormatBool(preview.Stale))
+	http.ServeContent(w, r, "thumbnail.jpg", preview.CapturedAt, f)
+}
+
+// EventsHandler streams changes to the public channels as server-sent
+// events
+//
+// Output failures and why a channel changed state are internal so
+// aren't included.
+func (p *Publicer) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	stream, err := utils.NewEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Deleted channels can't be looked up, so which were public is kept
+	listed := map[string]bool{}
+	chs, err := p.mcr.GetChannels()
+	if err != nil {
+		return
+	}
+	for shortName, ch := range chs {
+		listed[shortName] = isListed(ch.Info())
+	}
+	msgs := make(chan channel.Message, 16)
+	unsubscribe := p.mcr.Subscribe(func(m channel.Message) {
+		select {
+		case msgs <- m:
+		default:
+			// Drop messages for a client which can't keep up
+		}
+	}, channel.TopicChannelCreated, channel.TopicChannelDeleted,
+		channel.TopicStateChanged, channel.TopicPlayoutStarted)
+	defer unsubscribe()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m := <-msgs:
+			m, ok := p.publicMessage(r.Context(), m, listed)
+			if !ok {
+				continue
+			}
+			err = stream.Send(string(m.Topic()), m)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publicMessage is a message as it can be shown publicly, or false if
+// its channel isn't listed publicly
+func (p *Publicer) publicMessage(ctx context.Context, m channel.Message, listed map[string]bool) (channel.Message, bool) {
+	if _, ok := m.(channel.ChannelDeleted); ok {
+		ok = listed[m.ChannelName()]
+		delete(listed, m.ChannelName())
+		return m, ok
+	}
+	ch, err := p.mcr.GetChannel(ctx, m.ChannelName())
+	if err != nil {
+		return nil, false
+	}
+	listed[m.ChannelName()] = isListed(ch.Info())
+	if !listed[m.ChannelName()] {
+		return nil, false
+	}
+	if s, ok := m.(channel.StateChanged); ok {
+		s.Reason = ""
+		return s, true
+	}
+	return m, true
+}
+
+// isListed is when a channel is shown on the public site
+func isListed(info channel.Info) bool {
+	return info.Visibility == "public"
+}
+
 func index(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("public"))
 }
@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ystv/playout/playout"
+)
+
+// scheduleBreaks publishes the start and end of each of a playout's
+// breaks, timed from when it went on air
+func (s *Scheduler) scheduleBreaks(ctx context.Context, po playout.Playout) {
+	breaks, err := s.po.GetBreaks(ctx, po.PlayoutID)
+	if err != nil {
+		log.Printf("scheduler %d: failed to get breaks of playout %d: %+v", s.channel, po.PlayoutID, err)
+		return
+	}
+	s.jobLock.Lock()
+	defer s.jobLock.Unlock()
+	for _, b := range breaks {
+		start := po.BroadcastStart.Add(b.Offset())
+		if start.Before(time.Now()) {
+			// Splicing in part of a break isn't worth it
+			continue
+		}
+		err = s.once(start, breakTag(po.PlayoutID), s.publish, Event{Type: EventBreakStarted, Playout: po, Break: b})
+		if err != nil {
+			log.Printf("scheduler %d: failed to schedule break %d: %+v", s.channel, b.ID, err)
+			continue
+		}
+		err = s.once(start.Add(b.Length()), breakTag(po.PlayoutID), s.publish, Event{Type: EventBreakEnded, Playout: po, Break: b})
+		if err != nil {
+			log.Printf("scheduler %d: failed to schedule end of break %d: %+v", s.channel, b.ID, err)
+		}
+	}
+}
+
+// unscheduleBreaks removes the jobs of a playout's breaks
+func (s *Scheduler) unscheduleBreaks(playoutID int) {
+	s.jobLock.Lock()
+	defer s.jobLock.Unlock()
+	s.unschedule(func(tag string) bool { return tag == breakTag(playoutID) })
+}
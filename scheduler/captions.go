@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/programming"
+)
+
+// captionTracks gathers a programme's sidecar captions by language,
+// each video's placed after the ones before it. Captions which can't
+// be placed, since a video before them has no known length, are left
+// out.
+func (s *Scheduler) captionTracks(ctx context.Context, prog *programming.Programme) []captions.Track {
+	tracks := []captions.Track{}
+	byLanguage := make(map[string]int)
+	offset := time.Duration(0)
+	placeable := true
+	for idx, video := range prog.Videos {
+		for _, caption := range video.Captions {
+			if !placeable {
+				log.Printf("scheduler %d: can't place captions %d of programme %d, an earlier video has no length",
+					s.channel, caption.ID, prog.ProgrammeID)
+				continue
+			}
+			cues, err := captions.Fetch(ctx, caption.URL)
+			if err != nil {
+				log.Printf("scheduler %d: failed to load captions %d of programme %d: %+v",
+					s.channel, caption.ID, prog.ProgrammeID, err)
+				continue
+			}
+			language := strings.ToLower(caption.Language)
+			track, ok := byLanguage[language]
+			if !ok {
+				track = len(tracks)
+				byLanguage[language] = track
+				tracks = append(tracks, captions.Track{Language: language})
+			}
+			if tracks[track].Name == "" {
+				tracks[track].Name = caption.Name
+			}
+			for _, cue := range cues {
+				cue.Start += offset
+				cue.End += offset
+				tracks[track].Cues = append(tracks[track].Cues, cue)
+			}
+		}
+		if idx == len(prog.Videos)-1 || !placeable {
+			continue
+		}
+		duration := s.videoDuration(ctx, video.URL)
+		if duration == 0 {
+			placeable = false
+		}
+		offset += duration
+	}
+	return tracks
+}
+
+// videoDuration is a video's length from the prober, 0 if it's unknown
+func (s *Scheduler) videoDuration(ctx context.Context, url string) time.Duration {
+	if s.prober == nil {
+		return 0
+	}
+	r, err := s.prober.Probe(ctx, url, "")
+	if err != nil {
+		return 0
+	}
+	return r.Duration
+}
@@ -60,10 +60,3 @@ func (s *Scheduler) FindIslands(ctx context.Context) ([]Island, error) {
 	}
 	return i, nil
 }
-
-// CheckSource will validate an IngestURL to see if
-// there is content available.
-func CheckSource() error {
-	// This will probably be something VT dependent where it uses ffprobe?
-	return nil
-}
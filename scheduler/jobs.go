@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// once runs fn with params a single time at t, which has to be in the
+// future. gocron builds a job on whichever job was added last, so jobs
+// are added under jobLock.
+func (s *Scheduler) once(t time.Time, tag string, fn interface{}, params ...interface{}) error {
+	_, err := s.sch.Every(1).Day().StartAt(t).LimitRunsTo(1).Tag(tag).Do(fn, params...)
+	return err
+}
+
+// unschedule removes the jobs with a tag matching, and any which have
+// already run. Caller holds jobLock.
+func (s *Scheduler) unschedule(match func(tag string) bool) {
+	for _, j := range s.sch.Jobs() {
+		tags := j.Tags()
+		if j.RunCount() > 0 || (len(tags) != 0 && match(tags[0])) {
+			s.sch.RemoveByReference(j)
+		}
+	}
+}
+
+// startTag is the tag of a playout's start job
+func startTag(playoutID int) string {
+	return fmt.Sprintf("start-%d", playoutID)
+}
+
+// endTag is the tag of a playout's end job
+func endTag(playoutID int) string {
+	return fmt.Sprintf("end-%d", playoutID)
+}
+
+// breakTag is the tag of a playout's break jobs
+func breakTag(playoutID int) string {
+	return fmt.Sprintf("break-%d", playoutID)
+}
+
+// isPlayoutTag is when a tag is of a playout's start or end job
+func isPlayoutTag(tag string) bool {
+	return strings.HasPrefix(tag, "start-") || strings.HasPrefix(tag, "end-")
+}
@@ -2,18 +2,29 @@ package scheduler
 
 import (
 	"context"
+	"log"
 	"time"
 )
 
-// MainLoop is the subroutine to manage the schedule
-func (s *Scheduler) MainLoop(ctx context.Context) error {
-	timer := time.Tick(5 * time.Second)
-	hasChanged := false
-	for time := range timer {
+const (
+	reloadInterval = time.Minute      // How often the schedule is reloaded to pick up changes
+	reloadHorizon  = 10 * time.Minute // How far ahead playouts are scheduled, past the next reload
+)
 
-		if hasChanged {
-			s.log.Printf("%s - something happened", time.String())
+// MainLoop is the subroutine to manage the schedule, reloading it until
+// the context is done
+func (s *Scheduler) MainLoop(ctx context.Context) error {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		err := s.Reload(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("scheduler %d: failed to reload: %+v", s.channel, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
-	return nil
 }
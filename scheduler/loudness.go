@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ystv/playout/loudness"
+	"github.com/ystv/playout/probe"
+	"github.com/ystv/playout/programming"
+)
+
+// SetLoudness sets what programmes are normalised to with measured
+// gains, nil leaves their audio to the channel
+func (s *Scheduler) SetLoudness(t *loudness.Target) {
+	s.loudnessLock.Lock()
+	defer s.loudnessLock.Unlock()
+	s.loudnessTarget = t
+}
+
+// target is what programmes are normalised to, nil if they aren't
+func (s *Scheduler) target() *loudness.Target {
+	s.loudnessLock.Lock()
+	defer s.loudnessLock.Unlock()
+	return s.loudnessTarget
+}
+
+// measure finds the loudness of a programme's videos, measuring any
+// which haven't been so it isn't left until air. sources are the
+// videos' probes, unreachable videos aren't measured.
+func (s *Scheduler) measure(ctx context.Context, prog *programming.Programme, sources []probe.Result) ([]*loudness.Measurement, error) {
+	ms, err := s.measured(ctx, prog)
+	if err != nil {
+		return nil, err
+	}
+	for idx, video := range prog.Videos {
+		if ms[idx] != nil || idx >= len(sources) || !sources[idx].Reachable {
+			continue
+		}
+		m := loudness.Silence()
+		if sources[idx].HasAudio() {
+			m, err = s.meter.Measure(ctx, video.URL)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Printf("scheduler %d: failed to measure video %d: %+v", s.channel, video.ID, err)
+				continue
+			}
+		}
+		m.VideoID = video.ID
+		m.Duration = sources[idx].Duration.Seconds()
+		err = s.measurements.Put(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store video %d: %w", video.ID, err)
+		}
+		ms[idx] = &m
+	}
+	return ms, nil
+}
+
+// audioFilter normalises a programme's audio with its videos' measured
+// gains, falling back to normalising live if any haven't been measured
+func (s *Scheduler) audioFilter(ctx context.Context, prog *programming.Programme) string {
+	t := s.target()
+	if t == nil {
+		return ""
+	}
+	ms, err := s.measured(ctx, prog)
+	if err != nil {
+		log.Printf("scheduler %d: failed to get measurements of programme %d: %+v", s.channel, prog.ProgrammeID, err)
+		return t.Live()
+	}
+	filter, ok := t.Measured(ms)
+	if !ok {
+		log.Printf("scheduler %d: programme %d isn't fully measured, normalising live", s.channel, prog.ProgrammeID)
+		return t.Live()
+	}
+	return filter
+}
+
+// measured are the stored measurements of a programme's videos in
+// order, nil where a video hasn't been measured
+func (s *Scheduler) measured(ctx context.Context, prog *programming.Programme) ([]*loudness.Measurement, error) {
+	ids := make([]int, len(prog.Videos))
+	for idx, video := range prog.Videos {
+		ids[idx] = video.ID
+	}
+	stored, err := s.measurements.Get(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*loudness.Measurement, len(prog.Videos))
+	for idx, video := range prog.Videos {
+		if m, ok := stored[video.ID]; ok {
+			ms[idx] = &m
+		}
+	}
+	return ms, nil
+}
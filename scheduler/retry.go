@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/ystv/playout/playout"
+)
+
+const (
+	startAttempts = 5           // How many times a playout is tried before it's given up on
+	startBackoff  = time.Minute // Wait after a playout's first failure, doubled after each one
+)
+
+// startFailure is how a playout has failed to start so far
+type startFailure struct {
+	attempts int
+	retry    time.Time // Zero once it's given up on
+}
+
+// startFailed records a playout failing to start and when it can be
+// tried again, publishing the failure
+func (s *Scheduler) startFailed(po playout.Playout, err error) {
+	s.lock.Lock()
+	f := s.failures[po.PlayoutID]
+	f.attempts++
+	f.retry = time.Time{}
+	if f.attempts < startAttempts {
+		f.retry = time.Now().Add(startBackoff << (f.attempts - 1))
+	}
+	s.failures[po.PlayoutID] = f
+	s.lock.Unlock()
+	if f.retry.IsZero() {
+		log.Printf("scheduler %d: giving up on playout %d after %d attempts", s.channel, po.PlayoutID, f.attempts)
+	}
+	s.publish(Event{Type: EventPlayoutFailed, Playout: po, Error: err.Error(), Retry: f.retry})
+}
+
+// canRetry is when a playout hasn't failed to start, or its wait
+// before trying again is over
+func (s *Scheduler) canRetry(playoutID int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, ok := s.failures[playoutID]
+	return !ok || !f.retry.IsZero() && !time.Now().Before(f.retry)
+}
+
+// clearFailure forgets a playout's failures once it has started or ended
+func (s *Scheduler) clearFailure(playoutID int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.failures, playoutID)
+}
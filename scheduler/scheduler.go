@@ -7,29 +7,95 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/go-co-op/gocron"
 	"github.com/jmoiron/sqlx"
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/loudness"
 	"github.com/ystv/playout/player"
-	"github.com/ystv/playout/player/vt"
+	vtplayer "github.com/ystv/playout/player/vt"
 	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/probe"
 	"github.com/ystv/playout/programming"
+	"github.com/ystv/playout/vt"
 )
 
 var _ Schedule = &Scheduler{}
 
 // Scheduler wrapper around key dependencies
 type Scheduler struct {
-	queueSize int
-	channel   int
+	channel int
 	// dependencies
 	db   *sqlx.DB
 	sch  *gocron.Scheduler
-	po   *playout.Playouter
-	prog *programming.Programmer
-	play *vt.Player
+	po   playout.Repo
+	prog programming.ProgrammeStore
+	play player.Player
 	log  *log.Logger
+
+	lock        sync.Mutex
+	subscribers []func(Event)
+
+	// jobs
+	jobLock    sync.Mutex           // Jobs are added and removed one at a time
+	onAir      map[int]bool         // Playouts which have been started and not ended
+	failures   map[int]startFailure // Playouts which have failed to start
+	loopLock   sync.Mutex
+	loopCancel context.CancelFunc
+	loopDone   chan struct{}
+
+	// source checks
+	prober        *probe.Prober
+	checkHorizon  time.Duration
+	checkInterval time.Duration
+	checkRun      sync.Mutex // One check of the upcoming playouts at a time
+	checkLock     sync.Mutex
+	checks        map[int]SourceCheck
+	checkCancel   context.CancelFunc
+	checkDone     chan struct{}
+
+	// loudness
+	meter          *loudness.Meter
+	measurements   *loudness.Store
+	loudnessLock   sync.Mutex
+	loudnessTarget *loudness.Target // Measured gains are applied when set
+}
+
+// Config is what a scheduler needs to play out
+type Config struct {
+	VTEndpoint string // VT instance programmes are played by
+	FFmpegPath string // Binary used to measure loudness
+
+	Prober        *probe.Prober // Sources aren't checked without one
+	CheckHorizon  time.Duration // How far ahead playouts are checked
+	CheckInterval time.Duration // How often upcoming playouts are checked
+}
+
+// EventType is a kind of schedule event
+type EventType string
+
+// Schedule event types
+const (
+	EventPlayoutStarted EventType = "playout-started"
+	EventPlayoutEnded   EventType = "playout-ended"
+	EventPlayoutFlagged EventType = "playout-flagged" // Its sources have problems
+	EventPlayoutCleared EventType = "playout-cleared" // Its sources no longer have problems
+	EventPlayoutFailed  EventType = "playout-failed"  // It couldn't be started
+	EventBreakStarted   EventType = "break-started"
+	EventBreakEnded     EventType = "break-ended"
+)
+
+// Event is something which has happened on the schedule
+type Event struct {
+	Type     EventType
+	Playout  playout.Playout
+	Problems []string         // Of a flagged playout
+	Captions []captions.Track // Of a started playout's programme, timed from its broadcast start
+	Break    playout.Break    // Of a break starting or ending
+	Error    string           // Why a playout failed to start
+	Retry    time.Time        // When a failed playout is tried again, zero once it's given up on
 }
 
 type (
@@ -44,43 +110,127 @@ type (
 	// and ingest_url's have data when required
 	Health interface {
 		FindIslands(ctx context.Context, channelID int) ([]Island, error)
+		CheckPlayout(ctx context.Context, po playout.Playout) (SourceCheck, error)
 	}
 )
 
 // New creates a new scheduler instance
 //
 // Scheduler handles assigning jobs to the player
-func New(db *sqlx.DB, channelID int) (*Scheduler, error) {
+func New(db *sqlx.DB, conf Config, channelID int) (*Scheduler, error) {
 	err := db.Ping()
 	if err != nil {
 		return nil, fmt.Errorf("failed to ping DB: %w", err)
 	}
-	p, err := vt.New("localhost:8080")
+	p, err := vtplayer.New(context.Background(), vt.NewTracker(vt.New(conf.VTEndpoint), db))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to vt: %w", err)
 	}
-	s := &Scheduler{
-		channel: channelID,
-		db:      db,
-		sch:     gocron.NewScheduler(time.Local),
-		prog:    programming.New(db),
-		play:    p,
-	}
+	prog := programming.New(db)
+	s := newScheduler(conf, channelID, playout.New(prog, db), prog, p)
+	s.db = db
+	s.measurements = loudness.NewStore(db)
+	s.startChecks()
 	return s, nil
 }
 
-// Reload will add a queueSize amount of playouts to the scheduler cache
+// newScheduler creates a scheduler around its dependencies, without
+// starting anything
+func newScheduler(conf Config, channelID int, po playout.Repo, prog programming.ProgrammeStore, play player.Player) *Scheduler {
+	return &Scheduler{
+		channel:       channelID,
+		sch:           gocron.NewScheduler(time.Local),
+		po:            po,
+		prog:          prog,
+		play:          play,
+		prober:        conf.Prober,
+		checkHorizon:  conf.CheckHorizon,
+		checkInterval: conf.CheckInterval,
+		checks:        make(map[int]SourceCheck),
+		onAir:         make(map[int]bool),
+		failures:      make(map[int]startFailure),
+		meter:         &loudness.Meter{Path: conf.FFmpegPath},
+	}
+}
+
+// Subscribe registers fn to be called on each schedule event
+func (s *Scheduler) Subscribe(fn func(Event)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// publish passes an event to each subscriber
+func (s *Scheduler) publish(e Event) {
+	s.lock.Lock()
+	subscribers := make([]func(Event), len(s.subscribers))
+	copy(subscribers, s.subscribers)
+	s.lock.Unlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// Start schedules the channel's playouts and keeps reloading them, so
+// changes to the schedule are picked up, until Stop
+func (s *Scheduler) Start() {
+	s.sch.StartAsync()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.loopLock.Lock()
+	s.loopCancel = cancel
+	s.loopDone = done
+	s.loopLock.Unlock()
+	go func() {
+		defer close(done)
+		s.MainLoop(ctx)
+	}()
+}
+
+// Stop removes all playouts from the scheduler cache and stops
+// executing them
+func (s *Scheduler) Stop() {
+	s.loopLock.Lock()
+	cancel, done := s.loopCancel, s.loopDone
+	s.loopCancel, s.loopDone = nil, nil
+	s.loopLock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	s.stopChecks()
+	s.jobLock.Lock()
+	s.unschedule(func(string) bool { return true })
+	s.jobLock.Unlock()
+	s.sch.Stop()
+}
+
+// Reload schedules the start and end of the channel's playouts which are
+// coming up and the end of those on air, replacing what was scheduled
 func (s *Scheduler) Reload(ctx context.Context) error {
-	// Get the playouts to be played next
-	playouts, err := s.po.GetAmount(ctx, s.queueSize)
+	onAir, err := s.po.GetOnAir(ctx, s.channel)
+	if err != nil {
+		return fmt.Errorf("failed to get playouts on air: %w", err)
+	}
+	upcoming, err := s.po.GetUpcoming(ctx, s.channel, time.Now().Add(reloadHorizon))
 	if err != nil {
-		return fmt.Errorf("Reload failed to get playouts: %w", err)
+		return fmt.Errorf("failed to get upcoming playouts: %w", err)
+	}
+	s.jobLock.Lock()
+	defer s.jobLock.Unlock()
+	s.unschedule(isPlayoutTag)
+	for _, po := range onAir {
+		s.setOnAir(po.PlayoutID, true)
+		err = s.scheduleEnd(po)
+		if err != nil {
+			return err
+		}
 	}
-	// Empty scheduler of old playouts
-	s.sch.Clear()
-	// Add new playouts
-	for _, playout := range playouts {
-		s.Schedule(ctx, playout)
+	for _, po := range upcoming {
+		err = s.schedule(po)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -88,15 +238,108 @@ func (s *Scheduler) Reload(ctx context.Context) error {
 // Schedule will add a schedule item to the internal jon scheduler
 // to be played out
 func (s *Scheduler) Schedule(ctx context.Context, b playout.Playout) error {
-	_, err := s.sch.StartAt(b.ScheduledStart).Tag(string(rune(b.PlayoutID))).Do(s.ExecEvent, b)
+	s.jobLock.Lock()
+	defer s.jobLock.Unlock()
+	return s.schedule(b)
+}
+
+// schedule adds the jobs to start and end a playout, one which should
+// already be on air is started now. Caller holds jobLock.
+func (s *Scheduler) schedule(b playout.Playout) error {
+	switch {
+	case b.ScheduledStart.After(time.Now()):
+		err := s.once(b.ScheduledStart, startTag(b.PlayoutID), s.startJob, b)
+		if err != nil {
+			return fmt.Errorf("failed to schedule event \"%d\": %w", b.PlayoutID, err)
+		}
+	case s.claim(b.PlayoutID):
+		go s.start(b)
+	}
+	return s.scheduleEnd(b)
+}
+
+// claim marks a playout which should be on air as starting, unless
+// it already is or is waiting to be retried. Caller holds jobLock, so
+// a reload and a start job can't both start it.
+func (s *Scheduler) claim(playoutID int) bool {
+	if s.isOnAir(playoutID) || !s.canRetry(playoutID) {
+		return false
+	}
+	s.setOnAir(playoutID, true)
+	return true
+}
+
+// scheduleEnd adds the job to end a playout, one which has overrun is
+// ended now. Caller holds jobLock.
+func (s *Scheduler) scheduleEnd(b playout.Playout) error {
+	if !b.ScheduledEnd.After(time.Now()) {
+		go s.end(b)
+		return nil
+	}
+	err := s.once(b.ScheduledEnd, endTag(b.PlayoutID), s.end, b)
 	if err != nil {
-		return fmt.Errorf("failed to schedule event \"%d\": %w", b.PlayoutID, err)
+		return fmt.Errorf("failed to schedule end of event \"%d\": %w", b.PlayoutID, err)
 	}
 	return nil
 }
 
+// startJob plays a playout at its start, unless a reload has already
+// started it
+func (s *Scheduler) startJob(po playout.Playout) {
+	s.jobLock.Lock()
+	claimed := s.claim(po.PlayoutID)
+	s.jobLock.Unlock()
+	if claimed {
+		s.start(po)
+	}
+}
+
+// start plays a playout from a job, which can't return errors
+func (s *Scheduler) start(po playout.Playout) {
+	err := s.ExecEvent(context.Background(), po)
+	if err != nil {
+		log.Printf("scheduler %d: failed to start playout %d: %+v", s.channel, po.PlayoutID, err)
+	}
+}
+
+// end finishes a playout from a job, which can't return errors
+func (s *Scheduler) end(po playout.Playout) {
+	err := s.EndEvent(context.Background(), po)
+	if err != nil {
+		log.Printf("scheduler %d: failed to end playout %d: %+v", s.channel, po.PlayoutID, err)
+	}
+}
+
+// isOnAir is when a playout has been started and not ended
+func (s *Scheduler) isOnAir(playoutID int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.onAir[playoutID]
+}
+
+// setOnAir records a playout being started or ended
+func (s *Scheduler) setOnAir(playoutID int, on bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !on {
+		delete(s.onAir, playoutID)
+		return
+	}
+	s.onAir[playoutID] = true
+}
+
 // ExecEvent trigger a Playout to be played out
-func (s *Scheduler) ExecEvent(ctx context.Context, po playout.Playout) error {
+func (s *Scheduler) ExecEvent(ctx context.Context, po playout.Playout) (err error) {
+	s.setOnAir(po.PlayoutID, true)
+	defer func() {
+		if err != nil {
+			// Let a later reload try again
+			s.setOnAir(po.PlayoutID, false)
+			s.startFailed(po, err)
+			return
+		}
+		s.clearFailure(po.PlayoutID)
+	}()
 	po.BroadcastStart = time.Now()
 	prog, err := s.prog.Get(ctx, po.ProgrammeID)
 	if err != nil {
@@ -107,22 +350,64 @@ func (s *Scheduler) ExecEvent(ctx context.Context, po playout.Playout) error {
 		videos = append(videos, video.URL)
 	}
 	c := player.Config{
-		DstURL:    po.IngestURL,
-		Width:     1920,
-		Height:    1080,
-		Bitrate:   8000,
-		VideoURLs: videos,
+		ChannelID:   po.ChannelID,
+		PlayoutID:   po.PlayoutID,
+		DstURL:      po.IngestURL,
+		Width:       1920,
+		Height:      1080,
+		Bitrate:     8000,
+		VideoURLs:   videos,
+		AudioFilter: s.audioFilter(ctx, prog),
 	}
 	err = s.play.Play(ctx, c)
 	if err != nil {
 		return fmt.Errorf("failed to play playout: %w", err)
 	}
+	err = s.po.Update(ctx, po)
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast start: %w", err)
+	}
+	s.publish(Event{Type: EventPlayoutStarted, Playout: po, Captions: s.captionTracks(ctx, prog)})
+	s.scheduleBreaks(ctx, po)
+	return nil
+}
+
+// EndEvent stops a Playout being played and marks it as finished
+func (s *Scheduler) EndEvent(ctx context.Context, b playout.Playout) error {
+	// Get a fresh copy since the broadcast start will have been set
+	po, err := s.po.Get(ctx, b.PlayoutID)
+	if err != nil {
+		return fmt.Errorf("failed to get playout: %w", err)
+	}
+	// So it doesn't overlap whatever is on next
+	err = s.play.Stop(ctx, po.PlayoutID)
+	if err != nil {
+		return fmt.Errorf("failed to stop playout: %w", err)
+	}
+	po.BroadcastEnd = time.Now()
+	s.setOnAir(po.PlayoutID, false)
+	s.clearFailure(po.PlayoutID)
+	// Breaks which hadn't come round yet won't now
+	s.unscheduleBreaks(po.PlayoutID)
+	err = s.po.Update(ctx, *po)
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast end: %w", err)
+	}
+	s.publish(Event{Type: EventPlayoutEnded, Playout: *po})
 	return nil
 }
 
 // Delete will remove an item from the schedule from the DB and in-memory store
 func (s *Scheduler) Delete(ctx context.Context, playoutID int) error {
-	err := s.po.Delete(ctx, playoutID)
+	// Cancel the playout if it is on air
+	err := s.play.Stop(ctx, playoutID)
+	if err != nil {
+		return fmt.Errorf("failed to stop playout: %w", err)
+	}
+	err = s.po.Delete(ctx, playoutID)
+	if err != nil {
+		return fmt.Errorf("failed to delete playout: %w", err)
+	}
 	err = s.deleteCron(ctx, playoutID)
 	if err != nil {
 		return fmt.Errorf("failed to delete playout: %w", err)
@@ -131,11 +416,13 @@ func (s *Scheduler) Delete(ctx context.Context, playoutID int) error {
 }
 
 func (s *Scheduler) deleteCron(ctx context.Context, playoutID int) error {
-	// TODO: if there are multiple playout instances, we can delete the DB record,
-	// but it will still exist in the other instances memory.
-	err := s.sch.RemoveByTag(string(rune(playoutID)))
-	if err != nil {
-		return fmt.Errorf("failed to delete playout from memory: %w", err)
-	}
+	// Other playout instances drop it on their next reload
+	s.jobLock.Lock()
+	defer s.jobLock.Unlock()
+	s.unschedule(func(tag string) bool {
+		return tag == startTag(playoutID) || tag == endTag(playoutID) || tag == breakTag(playoutID)
+	})
+	s.setOnAir(playoutID, false)
+	s.clearFailure(playoutID)
 	return nil
 }
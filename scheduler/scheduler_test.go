@@ -0,0 +1,372 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	vtplayer "github.com/ystv/playout/player/vt"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/programming"
+	"github.com/ystv/playout/vt"
+	"github.com/ystv/playout/vt/vttest"
+)
+
+// fakePlayouts is an in-memory playout.Repo, methods the scheduler
+// doesn't use panic
+type fakePlayouts struct {
+	playout.Repo
+
+	lock     sync.Mutex
+	playouts map[int]playout.Playout
+	breaks   map[int][]playout.Break
+}
+
+func (r *fakePlayouts) Get(ctx context.Context, playoutID int) (*playout.Playout, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	po, ok := r.playouts[playoutID]
+	if !ok {
+		return nil, errors.New("playout doesn't exist")
+	}
+	return &po, nil
+}
+
+func (r *fakePlayouts) Update(ctx context.Context, po playout.Playout) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.playouts[po.PlayoutID] = po
+	return nil
+}
+
+func (r *fakePlayouts) Delete(ctx context.Context, playoutID int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.playouts, playoutID)
+	return nil
+}
+
+func (r *fakePlayouts) GetBreaks(ctx context.Context, playoutID int) ([]playout.Break, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.breaks[playoutID], nil
+}
+
+// fakeProgrammes is an in-memory programming.ProgrammeStore
+type fakeProgrammes struct {
+	programming.ProgrammeStore
+	programmes map[int]programming.Programme
+}
+
+func (r *fakeProgrammes) Get(ctx context.Context, programmeID int) (*programming.Programme, error) {
+	p, ok := r.programmes[programmeID]
+	if !ok {
+		return nil, errors.New("programme doesn't exist")
+	}
+	return &p, nil
+}
+
+// events collects what a scheduler publishes
+type events struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (e *events) add(ev Event) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, ev)
+}
+
+func (e *events) get() []Event {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Event{}, e.events...)
+}
+
+// testPlayout is on air now on channel 1
+var testPlayout = playout.Playout{
+	PlayoutID:      10,
+	ChannelID:      1,
+	ProgrammeID:    20,
+	IngestURL:      "rtmp://ingest.example.com/live/test",
+	IngestType:     "rtmp",
+	ScheduledStart: time.Now(),
+	ScheduledEnd:   time.Now().Add(time.Hour),
+}
+
+// testScheduler is a scheduler playing out to a fake VT, with the
+// test playout and its programme stored
+func testScheduler(t *testing.T, srv *vttest.Server) (*Scheduler, *fakePlayouts, *events) {
+	t.Helper()
+	play, err := vtplayer.New(context.Background(), srv.Tracker())
+	if err != nil {
+		t.Fatalf("failed to create player: %+v", err)
+	}
+	po := &fakePlayouts{
+		playouts: map[int]playout.Playout{testPlayout.PlayoutID: testPlayout},
+		breaks:   make(map[int][]playout.Break),
+	}
+	prog := &fakeProgrammes{programmes: map[int]programming.Programme{
+		testPlayout.ProgrammeID: {ProgrammeID: testPlayout.ProgrammeID, Videos: []programming.Video{
+			{ID: 1, URL: "https://cdn.example.com/opening.mp4"},
+			{ID: 2, URL: "https://cdn.example.com/programme.mp4"},
+		}},
+	}}
+	s := newScheduler(Config{}, testPlayout.ChannelID, po, prog, play)
+	ev := &events{}
+	s.Subscribe(ev.add)
+	s.sch.StartAsync()
+	t.Cleanup(s.sch.Stop)
+	return s, po, ev
+}
+
+func TestExecEventPlaysAndEndEventEnds(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, po, ev := testScheduler(t, srv)
+	ctx := context.Background()
+
+	err := s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event: %+v", err)
+	}
+	running := srv.Running()
+	if len(running) != 1 || running[0].Kind != vttest.KindPlay {
+		t.Fatalf("vt is running %+v, want a play task", running)
+	}
+	task := running[0].Play
+	if task.EncodeArgs.DstURL != testPlayout.IngestURL || len(task.Videos) != 2 || task.Videos[1] != "https://cdn.example.com/programme.mp4" {
+		t.Errorf("play task is %+v", task)
+	}
+	stored, _ := po.Get(ctx, testPlayout.PlayoutID)
+	if stored.BroadcastStart.IsZero() {
+		t.Error("broadcast start wasn't stored")
+	}
+	if !s.isOnAir(testPlayout.PlayoutID) {
+		t.Error("playout isn't on air")
+	}
+	got := ev.get()
+	if len(got) != 1 || got[0].Type != EventPlayoutStarted || got[0].Playout.BroadcastStart.IsZero() {
+		t.Fatalf("published %+v, want the playout starting", got)
+	}
+
+	// A second start, i.e. after a restart, adopts the task
+	err = s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event again: %+v", err)
+	}
+	if tasks := srv.Tasks(); len(tasks) != 1 {
+		t.Errorf("vt has %d tasks, the running one should have been adopted", len(tasks))
+	}
+
+	err = s.EndEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to end event: %+v", err)
+	}
+	stored, _ = po.Get(ctx, testPlayout.PlayoutID)
+	if stored.BroadcastEnd.IsZero() {
+		t.Error("broadcast end wasn't stored")
+	}
+	if s.isOnAir(testPlayout.PlayoutID) {
+		t.Error("playout is still on air")
+	}
+	if running := srv.Running(); len(running) != 0 {
+		t.Errorf("vt is still running %+v after the playout ended", running)
+	}
+	got = ev.get()
+	if last := got[len(got)-1]; last.Type != EventPlayoutEnded || last.Playout.BroadcastEnd.IsZero() {
+		t.Errorf("published %+v last, want the playout ending", last)
+	}
+}
+
+func TestExecEventPlayFails(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, po, ev := testScheduler(t, srv)
+	ctx := context.Background()
+
+	srv.Fail(vttest.RoutePlay, http.StatusInternalServerError)
+	err := s.ExecEvent(ctx, testPlayout)
+	if err == nil {
+		t.Fatal("exec event succeeded while vt was failing")
+	}
+	if s.isOnAir(testPlayout.PlayoutID) {
+		t.Error("playout is on air after failing to play")
+	}
+	stored, _ := po.Get(ctx, testPlayout.PlayoutID)
+	if !stored.BroadcastStart.IsZero() {
+		t.Error("broadcast start was stored after failing to play")
+	}
+	got := ev.get()
+	if len(got) != 1 || got[0].Type != EventPlayoutFailed || got[0].Error == "" || got[0].Retry.IsZero() {
+		t.Errorf("published %+v after failing to play, want the failure and its retry", got)
+	}
+
+	// A later reload tries again
+	srv.Fail(vttest.RoutePlay, 0)
+	err = s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event once vt recovered: %+v", err)
+	}
+	if running := srv.Running(); len(running) != 1 {
+		t.Errorf("vt is running %d tasks, want 1", len(running))
+	}
+}
+
+// failures counts the failed starts published
+func (e *events) failures() int {
+	n := 0
+	for _, ev := range e.get() {
+		if ev.Type == EventPlayoutFailed {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFailedStartBacksOff(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, _, ev := testScheduler(t, srv)
+	srv.Fail(vttest.RoutePlay, http.StatusInternalServerError)
+	schedule := func() {
+		s.jobLock.Lock()
+		defer s.jobLock.Unlock()
+		err := s.schedule(testPlayout)
+		if err != nil {
+			t.Fatalf("failed to schedule: %+v", err)
+		}
+	}
+
+	schedule()
+	deadline := time.Now().Add(5 * time.Second)
+	for ev.failures() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ev.failures() != 1 {
+		t.Fatal("failed start wasn't published")
+	}
+
+	// Reloads while it's backing off leave it be
+	schedule()
+	time.Sleep(200 * time.Millisecond)
+	if n := ev.failures(); n != 1 {
+		t.Fatalf("tried %d times while backing off, want 1", n)
+	}
+
+	// The last attempt gives up
+	s.lock.Lock()
+	s.failures[testPlayout.PlayoutID] = startFailure{attempts: startAttempts - 1, retry: time.Now()}
+	s.lock.Unlock()
+	schedule()
+	for ev.failures() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := ev.get()
+	if last := got[len(got)-1]; last.Type != EventPlayoutFailed || !last.Retry.IsZero() {
+		t.Fatalf("published %+v last, want the playout given up on", last)
+	}
+	srv.Fail(vttest.RoutePlay, 0)
+	schedule()
+	time.Sleep(200 * time.Millisecond)
+	if running := srv.Running(); len(running) != 0 {
+		t.Errorf("vt is running %+v, a playout given up on shouldn't be started", running)
+	}
+}
+
+func TestExecEventBreaks(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, po, ev := testScheduler(t, srv)
+	ctx := context.Background()
+	po.breaks[testPlayout.PlayoutID] = []playout.Break{{ID: 1, Start: 1, Duration: 1}}
+
+	err := s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event: %+v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ev.get()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	got := ev.get()
+	if len(got) != 3 || got[1].Type != EventBreakStarted || got[2].Type != EventBreakEnded || got[1].Break.ID != 1 {
+		t.Fatalf("published %+v, want the playout starting then its break", got)
+	}
+}
+
+func TestEndEventUnschedulesBreaks(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, po, ev := testScheduler(t, srv)
+	ctx := context.Background()
+	po.breaks[testPlayout.PlayoutID] = []playout.Break{{ID: 1, Start: 1, Duration: 1}}
+
+	err := s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event: %+v", err)
+	}
+	err = s.EndEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to end event: %+v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	for _, e := range ev.get() {
+		if e.Type == EventBreakStarted || e.Type == EventBreakEnded {
+			t.Errorf("published %s after the playout ended", e.Type)
+		}
+	}
+}
+
+func TestDeleteCancelsPlayout(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	s, po, _ := testScheduler(t, srv)
+	ctx := context.Background()
+
+	err := s.ExecEvent(ctx, testPlayout)
+	if err != nil {
+		t.Fatalf("failed to exec event: %+v", err)
+	}
+
+	srv.Fail(vttest.RouteCancel, http.StatusBadGateway)
+	err = s.Delete(ctx, testPlayout.PlayoutID)
+	if err == nil {
+		t.Fatal("deleted a playout vt failed to cancel")
+	}
+	if _, err = po.Get(ctx, testPlayout.PlayoutID); err != nil {
+		t.Error("playout was deleted though it is still playing")
+	}
+
+	srv.Fail(vttest.RouteCancel, 0)
+	err = s.Delete(ctx, testPlayout.PlayoutID)
+	if err != nil {
+		t.Fatalf("failed to delete: %+v", err)
+	}
+	if running := srv.Running(); len(running) != 0 {
+		t.Errorf("vt is still running %+v", running)
+	}
+	if tasks := srv.Tasks(); len(tasks) != 1 || tasks[0].State != vt.StateCancelled {
+		t.Errorf("vt has %+v, want the play task cancelled", tasks)
+	}
+	if _, err = po.Get(ctx, testPlayout.PlayoutID); err == nil {
+		t.Error("playout wasn't deleted")
+	}
+	if s.isOnAir(testPlayout.PlayoutID) {
+		t.Error("deleted playout is still on air")
+	}
+}
+
+func TestPlayerNeedsVT(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	srv.Fail(vttest.RouteOK, http.StatusServiceUnavailable)
+	_, err := vtplayer.New(context.Background(), srv.Tracker())
+	if err == nil {
+		t.Error("created a player while vt wasn't ok")
+	}
+}
@@ -0,0 +1,251 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ystv/playout/captions"
+	"github.com/ystv/playout/loudness"
+	"github.com/ystv/playout/playout"
+	"github.com/ystv/playout/probe"
+)
+
+// liveCheckLead is how close to air a live playout's ingest is probed,
+// since live sources aren't usually up hours before
+const liveCheckLead = 15 * time.Minute
+
+var (
+	// playableVideo are the video codecs the player can decode
+	playableVideo = map[string]bool{
+		"h264": true, "hevc": true, "mpeg2video": true, "mpeg4": true,
+		"vp8": true, "vp9": true, "av1": true, "prores": true, "dnxhd": true,
+	}
+	// playableAudio are the audio codecs the player can decode, PCM
+	// is matched by prefix
+	playableAudio = map[string]bool{
+		"aac": true, "mp3": true, "mp2": true, "ac3": true, "eac3": true,
+		"opus": true, "vorbis": true, "flac": true,
+	}
+)
+
+// SourceCheck is the validation of a playout's content ahead of air
+type SourceCheck struct {
+	PlayoutID      int                     `json:"playoutID"`
+	ProgrammeID    int                     `json:"programmeID"`
+	ScheduledStart time.Time               `json:"scheduledStart"`
+	Live           bool                    `json:"live"`
+	Pending        bool                    `json:"pending"` // Live ingest not probed yet
+	Sources        []probe.Result          `json:"sources"`
+	Problems       []string                `json:"problems"`
+	Loudness       []*loudness.Measurement `json:"loudness,omitempty"` // Of each video, with measured gains only
+	CheckedAt      time.Time               `json:"checkedAt"`
+}
+
+// OK is when nothing is wrong with the playout's sources
+func (c SourceCheck) OK() bool {
+	return len(c.Problems) == 0
+}
+
+// CheckPlayout probes a playout's sources, its programme's videos or
+// the ingest of live programmes, finding anything which would stop it
+// airing
+func (s *Scheduler) CheckPlayout(ctx context.Context, po playout.Playout) (SourceCheck, error) {
+	c := SourceCheck{
+		PlayoutID:      po.PlayoutID,
+		ProgrammeID:    po.ProgrammeID,
+		ScheduledStart: po.ScheduledStart,
+		Sources:        []probe.Result{},
+		Problems:       []string{},
+	}
+	if s.prober == nil {
+		return c, errors.New("scheduler has no prober")
+	}
+	prog, err := s.prog.Get(ctx, po.ProgrammeID)
+	if err != nil {
+		return c, fmt.Errorf("failed to get programme: %w", err)
+	}
+	c.Live = len(prog.Videos) == 0
+	if c.Live {
+		if time.Until(po.ScheduledStart) > liveCheckLead {
+			c.Pending = true
+			c.CheckedAt = time.Now()
+			return c, nil
+		}
+		r, err := s.prober.Probe(ctx, po.IngestURL, po.IngestType)
+		if err != nil && !errors.Is(err, probe.ErrUnreachable) {
+			return c, fmt.Errorf("failed to probe ingest: %w", err)
+		}
+		c.Sources = append(c.Sources, r)
+		c.Problems = append(c.Problems, compatible("ingest", r, true)...)
+		c.CheckedAt = time.Now()
+		return c, nil
+	}
+	total := time.Duration(0)
+	for idx, video := range prog.Videos {
+		r, err := s.prober.Probe(ctx, video.URL, "")
+		if err != nil && !errors.Is(err, probe.ErrUnreachable) {
+			return c, fmt.Errorf("failed to probe video %d: %w", video.ID, err)
+		}
+		c.Sources = append(c.Sources, r)
+		c.Problems = append(c.Problems, compatible(fmt.Sprintf("video %d", idx+1), r, false)...)
+		for _, caption := range video.Captions {
+			_, err = captions.Fetch(ctx, caption.URL)
+			if err != nil {
+				c.Problems = append(c.Problems, fmt.Sprintf("video %d %s captions unusable: %s", idx+1, caption.Language, err))
+			}
+		}
+		total += r.Duration
+	}
+	if s.target() != nil {
+		c.Loudness, err = s.measure(ctx, prog, c.Sources)
+		if err != nil {
+			return c, fmt.Errorf("failed to measure loudness: %w", err)
+		}
+	}
+	slot := po.ScheduledEnd.Sub(po.ScheduledStart)
+	if total > slot {
+		c.Problems = append(c.Problems, fmt.Sprintf("content runs %s over its %s slot",
+			(total-slot).Round(time.Second), slot))
+	}
+	c.CheckedAt = time.Now()
+	return c, nil
+}
+
+// compatible lists why a source can't be played
+func compatible(name string, r probe.Result, live bool) []string {
+	if !r.Reachable {
+		return []string{fmt.Sprintf("%s unreachable: %s", name, r.Error)}
+	}
+	problems := []string{}
+	switch {
+	case !r.HasVideo():
+		problems = append(problems, name+" has no video")
+	case !playableVideo[r.VideoCodec]:
+		problems = append(problems, fmt.Sprintf("%s has unsupported video codec %s", name, r.VideoCodec))
+	case r.Width == 0 || r.Height == 0 || r.FrameRate == 0:
+		problems = append(problems, name+" has no resolution or frame rate")
+	}
+	switch {
+	case !r.HasAudio():
+		problems = append(problems, name+" has no audio")
+	case !playableAudio[r.AudioCodec] && !strings.HasPrefix(r.AudioCodec, "pcm_"):
+		problems = append(problems, fmt.Sprintf("%s has unsupported audio codec %s", name, r.AudioCodec))
+	}
+	if !live && r.Duration == 0 {
+		problems = append(problems, name+" has no duration")
+	}
+	return problems
+}
+
+// CheckUpcoming checks the playouts starting within the horizon,
+// flagging any with problems
+func (s *Scheduler) CheckUpcoming(ctx context.Context) ([]SourceCheck, error) {
+	s.checkRun.Lock()
+	defer s.checkRun.Unlock()
+	playouts, err := s.po.GetUpcoming(ctx, s.channel, time.Now().Add(s.horizon()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upcoming playouts: %w", err)
+	}
+	checks := make(map[int]SourceCheck, len(playouts))
+	for _, po := range playouts {
+		c, err := s.CheckPlayout(ctx, po)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check playout %d: %w", po.PlayoutID, err)
+		}
+		checks[po.PlayoutID] = c
+
+		s.checkLock.Lock()
+		prev, seen := s.checks[po.PlayoutID]
+		s.checkLock.Unlock()
+		switch {
+		case !c.OK() && strings.Join(c.Problems, "\n") != strings.Join(prev.Problems, "\n"):
+			s.publish(Event{Type: EventPlayoutFlagged, Playout: po, Problems: c.Problems})
+		case c.OK() && seen && !prev.OK():
+			s.publish(Event{Type: EventPlayoutCleared, Playout: po})
+		}
+	}
+	s.checkLock.Lock()
+	s.checks = checks
+	s.checkLock.Unlock()
+	return s.SourceChecks(), nil
+}
+
+// SourceChecks returns the latest checks of upcoming playouts,
+// soonest first
+func (s *Scheduler) SourceChecks() []SourceCheck {
+	s.checkLock.Lock()
+	checks := make([]SourceCheck, 0, len(s.checks))
+	for _, c := range s.checks {
+		checks = append(checks, c)
+	}
+	s.checkLock.Unlock()
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].ScheduledStart.Before(checks[j].ScheduledStart)
+	})
+	return checks
+}
+
+// horizon is how far ahead playouts are checked
+func (s *Scheduler) horizon() time.Duration {
+	if s.checkHorizon > 0 {
+		return s.checkHorizon
+	}
+	return 24 * time.Hour
+}
+
+// interval is how often upcoming playouts are checked
+func (s *Scheduler) interval() time.Duration {
+	if s.checkInterval > 0 {
+		return s.checkInterval
+	}
+	return 15 * time.Minute
+}
+
+// startChecks checks upcoming playouts in the background if the
+// scheduler has a prober
+func (s *Scheduler) startChecks() {
+	s.stopChecks()
+	if s.prober == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.checkLock.Lock()
+	s.checkCancel = cancel
+	s.checkDone = done
+	s.checkLock.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.interval())
+		defer ticker.Stop()
+		for {
+			_, err := s.CheckUpcoming(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("scheduler %d: failed to check sources: %+v", s.channel, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopChecks stops checking upcoming playouts and waits for it to finish
+func (s *Scheduler) stopChecks() {
+	s.checkLock.Lock()
+	cancel, done := s.checkCancel, s.checkDone
+	s.checkCancel, s.checkDone = nil, nil
+	s.checkLock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
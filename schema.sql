@@ -96,7 +96,21 @@ CREATE TABLE playout.channel(
     type text NOT NULL,
     ingest_url text NOT NULL,
     ingest_type text NOT NULL,
+    srt_mode text NOT NULL DEFAULT '',
+    srt_passphrase text NOT NULL DEFAULT '',
+    srt_latency int NOT NULL DEFAULT 0,
+    loudness_mode text NOT NULL DEFAULT 'off',
+    loudness_target real NOT NULL DEFAULT -23,
+    loudness_true_peak real NOT NULL DEFAULT -1,
+    logo_url text NOT NULL DEFAULT '',
+    logo_position text NOT NULL DEFAULT 'top-right',
+    logo_scale real NOT NULL DEFAULT 0.1,
+    logo_opacity real NOT NULL DEFAULT 1,
+    logo_margin real NOT NULL DEFAULT 0.05,
+    subtitle_languages text NOT NULL DEFAULT '',
+    closed_captions text NOT NULL DEFAULT '',
     slate_url text NOT NULL,
+    failback_delay int NOT NULL DEFAULT 0,
     visibility text NOT NULL,
     has_scheduler bool NOT NULL DEFAULT true,
     has_piper bool NOT NULL DEFAULT true,
@@ -113,11 +127,33 @@ At this level it is capable of accepting an ingest and producing an output.
 Producing DVR and handling an archive.
 It doesn''t care about things like schedules or programmes.
 
-Future plans include offering slate when ingest drops'; 
+Channels without a piper will offer slate when ingest drops'; 
 
 COMMENT ON COLUMN playout.channel.short_name IS
 'Public facing path';
 
+COMMENT ON COLUMN playout.channel.loudness_mode IS
+'off / live / measured. Live normalises the ingest as it is encoded, measured
+applies gains worked out ahead of air from programme_video_loudness and only
+limits peaks on the ingest';
+
+COMMENT ON COLUMN playout.channel.logo_url IS
+'PNG or JPEG station logo (DOG) burnt into outputs which enable it, empty for none.
+logo_scale is its width and logo_margin the safe area, both fractions of the frame';
+
+COMMENT ON COLUMN playout.channel.subtitle_languages IS
+'Comma separated language tags, each is a WebVTT rendition of HLS outputs which
+publish captions, filled from programme_video_captions while programmes are on air';
+
+COMMENT ON COLUMN playout.channel.closed_captions IS
+'Language of CEA-608/708 captions embedded in the ingest, empty if it has none';
+
+COMMENT ON COLUMN playout.channel.loudness_target IS
+'Integrated loudness in LUFS, -23 being EBU R128';
+
+COMMENT ON COLUMN playout.channel.loudness_true_peak IS
+'Ceiling in dBTP';
+
 COMMENT ON COLUMN playout.channel.type IS
 'linear / event. Linear for streams which do not end and event for temporary streams.';
 
@@ -130,8 +166,23 @@ schedule information and it''ll hit the ingest';
 COMMENT ON COLUMN playout.channel.ingest_url IS
 'rtmp/rtp/hls';
 
+COMMENT ON COLUMN playout.channel.srt_mode IS
+'caller / listener / rendezvous, srt ingests only. Empty is caller';
+
+COMMENT ON COLUMN playout.channel.srt_passphrase IS
+'Encrypts an srt ingest, 10 to 79 characters. Encrypted like the outputs''
+stream_key with the MCR''s stream key secret. Empty disables encryption';
+
+COMMENT ON COLUMN playout.channel.srt_latency IS
+'Milliseconds, 0 uses SRT''s default';
+
 COMMENT ON COLUMN playout.channel.slate_url IS
-'Fallback video if channel dies';
+'Fallback video if channel dies. Looped on the outputs of channels without
+a piper while their ingest and any backups are down';
+
+COMMENT ON COLUMN playout.channel.failback_delay IS
+'Seconds a preferred ingest source has to stay up before the outputs go back
+to it, 0 uses the MCR''s slate recovery';
 
 -- Might be depricating due to multiple outputs, and I think it could be compiled instead
 -- of defined here since each output has a unique url
@@ -145,7 +196,8 @@ internal - will be visible on the internal site,
 private - only visible by url so unlisted';
 
 COMMENT ON COLUMN playout.channel.archive IS
-'Backup the ingest';
+'Backup the ingest, archived playouts are cut from the recording
+once they end to produce their vod_url';
 
 COMMENT ON COLUMN playout.channel.dvr IS
 'Rewind support';
@@ -192,6 +244,20 @@ since it allows us to re-use the same player, probably makes things stabler when
 lot of very short videos playing. Let''s you have a programme like 2016 hits and will show
 that on the schedule and not each individual video making it look messy?';
 
+CREATE TABLE playout.programme_video_captions(
+    caption_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    programme_video_id int NOT NULL REFERENCES playout.programme_videos(programme_video_id) ON DELETE CASCADE,
+    url text NOT NULL,
+    language text NOT NULL,
+    name text NOT NULL DEFAULT ''
+);
+COMMENT ON TABLE playout.programme_video_captions IS
+'Sidecar subtitles of a video, SRT or WebVTT timed from the start of the video.
+Channels publish them as WebVTT renditions while the programme is on air.';
+
+COMMENT ON COLUMN playout.programme_video_captions.language IS
+'BCP 47 language tag i.e. en / en-GB, matched against the languages a channel publishes';
+
 --
 -- create playout.schedule_playouts
 --
@@ -213,7 +279,8 @@ CREATE TABLE playout.schedule_playouts(
     vod_url text NOT NULL DEFAULT '',
     -- properties optionally inherited from channel
     dvr bool NOT NULL DEFAULT TRUE,
-    archive bool NOT NULL DEFAULT TRUE
+    archive bool NOT NULL DEFAULT TRUE,
+    hide_logo bool NOT NULL DEFAULT FALSE
 );
 COMMENT ON TABLE playout.schedule_playouts IS
 'Playouts of video content used by the piper to playout to the ingest_url
@@ -248,8 +315,12 @@ COMMENT ON COLUMN playout.schedule_playouts.ingest_url IS
 * channel''s ingest_url (piper disabled)
 * piper''s ingest_url (piper enabled).';
 
+COMMENT ON COLUMN playout.schedule_playouts.hide_logo IS
+'Takes the channel''s logo off outputs which overlay it while the playout is on air,
+i.e. for content which carries its own branding';
+
 COMMENT ON COLUMN playout.schedule_playouts.ingest_type IS
-'rtmp/rtp/hls';
+'rtmp/rtp/hls/srt. srt connection options are query parameters of the ingest_url';
 
 -- Need to think about what triggers it the schedule_start or broadcast_start
 -- schedule_start should be what the user set. Broadcast_start is what the scheduler
@@ -264,6 +335,18 @@ COMMENT ON COLUMN playout.schedule_playouts.scheduled_start IS
 -- * float (will move when items overrun)
 -- * static (when scheduled_start, begin)
 
+CREATE TABLE playout.schedule_playout_breaks(
+    break_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    playout_id int NOT NULL REFERENCES playout.schedule_playouts(playout_id) ON DELETE CASCADE,
+    start_offset int NOT NULL CHECK (start_offset >= 0),
+    duration int NOT NULL CHECK (duration > 0)
+);
+COMMENT ON TABLE playout.schedule_playout_breaks IS
+'Ad breaks during a playout, signalled on outputs with markers as SCTE-35 splices
+so downstream platforms can replace them';
+COMMENT ON COLUMN playout.schedule_playout_breaks.start_offset IS
+'Seconds after the playout''s broadcast start';
+
 -- Will add in later iterations
 -- CREATE TABLE playout.idents(
 --     ident_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
@@ -280,18 +363,227 @@ COMMENT ON COLUMN playout.schedule_playouts.scheduled_start IS
 --     CONSTRAINT ident_group_items_pkey PRIMARY KEY (group_id, ident_id)
 -- );
 CREATE TABLE playout.outputs(
-    output_id int GENRATED BY DEFAULT AS IDENTITY ON UPDATE CASCADE ON DELETE CASCADE,
+    output_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
     channel_id int NOT NULL REFERENCES playout.channel(channel_id) ON UPDATE CASCADE ON DELETE CASCADE,
     name text NOT NULL,
     type text NOT NULL,
     passthrough bool NOT NULL,
     dvr bool NOT NULL,
+    dvr_window int NOT NULL DEFAULT 0,
+    segment_duration int NOT NULL DEFAULT 4,
     destination text NOT NULL,
+    profile text NOT NULL DEFAULT '',
+    profile_version int NOT NULL DEFAULT 0,
+    srt_mode text NOT NULL DEFAULT '',
+    srt_passphrase text NOT NULL DEFAULT '',
+    srt_latency int NOT NULL DEFAULT 0,
+    logo bool NOT NULL DEFAULT FALSE,
+    captions bool NOT NULL DEFAULT FALSE,
+    markers bool NOT NULL DEFAULT FALSE,
+    stream_key text NOT NULL DEFAULT '',
+    disabled bool NOT NULL DEFAULT FALSE,
+    scheduled bool NOT NULL DEFAULT FALSE,
+
+    args text NOT NULL DEFAULT ''
+);
+
+COMMENT ON TABLE playout.outputs IS
+'Outputs are the result of a channel. Channel''s can have multiple outputs of different types.';
+
+COMMENT ON COLUMN playout.outputs.type IS
+'rtp / rtmp / srt / hls / dash / cmaf / restream. cmaf shares fMP4 segments between a HLS and DASH manifest.
+restream simulcasts to a platform such as YouTube or Twitch over rtmp(s) or srt, decided by the destination';
+
+COMMENT ON COLUMN playout.outputs.dvr_window IS
+'Seconds of timeshift available when dvr is enabled, 0 keeps everything';
+
+COMMENT ON COLUMN playout.outputs.destination IS
+'http(s) URL segments are PUT to, or a local path';
+
+COMMENT ON COLUMN playout.outputs.srt_mode IS
+'caller / listener / rendezvous, srt outputs only. Shares the channel''s srt column formats';
+
+COMMENT ON COLUMN playout.outputs.srt_passphrase IS
+'Encrypted like stream_key with the MCR''s stream key secret. Empty disables encryption';
+
+COMMENT ON COLUMN playout.outputs.profile IS
+'Name of the encoding profile used instead of the output''s own renditions';
+
+COMMENT ON COLUMN playout.outputs.profile_version IS
+'Pinned version of the profile, 0 follows the latest';
+
+COMMENT ON COLUMN playout.outputs.captions IS
+'Keep the ingest''s embedded captions and, on hls outputs, publish the channel''s
+subtitle renditions';
+
+COMMENT ON COLUMN playout.outputs.markers IS
+'Mark programme changes and breaks in the manifests of hls / dash / cmaf outputs
+with local destinations';
+
+COMMENT ON COLUMN playout.outputs.stream_key IS
+'Restream only. AES-256-GCM encrypted with the MCR''s stream key secret, base64 of
+the nonce then the ciphertext. Appended to the path of rtmp destinations and the
+streamid of srt ones';
+
+COMMENT ON COLUMN playout.outputs.disabled IS
+'Restream only. Kept but not sent';
+
+COMMENT ON COLUMN playout.outputs.scheduled IS
+'Restream only. Only sent while one of the channel''s playouts is on air';
+
+CREATE TABLE playout.channel_audio_tracks(
+    audio_track_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    channel_id int NOT NULL REFERENCES playout.channel(channel_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    position int NOT NULL,
+    track int NOT NULL,
+    language text NOT NULL DEFAULT '',
+    name text NOT NULL,
+    CONSTRAINT channel_audio_tracks_position UNIQUE (channel_id, position),
+    CONSTRAINT channel_audio_tracks_name UNIQUE (channel_id, name)
+);
+
+COMMENT ON TABLE playout.channel_audio_tracks IS
+'Audio renditions of a channel''s outputs, i.e. commentary on and off or an
+English / Welsh pair. The first is the default, rtmp outputs only carry it.
+Channels without any take the ingest''s first audio stream.';
+
+COMMENT ON COLUMN playout.channel_audio_tracks.track IS
+'Index of the ingest''s audio stream the rendition is encoded from';
+
+COMMENT ON COLUMN playout.channel_audio_tracks.name IS
+'Shown to viewers and part of the rendition''s HLS playlist name';
+
+CREATE TABLE playout.channel_ingest_sources(
+    ingest_source_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    channel_id int NOT NULL REFERENCES playout.channel(channel_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    position int NOT NULL,
+    url text NOT NULL,
+    type text NOT NULL,
+    srt_mode text NOT NULL DEFAULT '',
+    srt_passphrase text NOT NULL DEFAULT '',
+    srt_latency int NOT NULL DEFAULT 0,
+    CONSTRAINT channel_ingest_sources_position UNIQUE (channel_id, position)
+);
+
+COMMENT ON TABLE playout.channel_ingest_sources IS
+'Backup ingests of a channel, failed over to in position order when the
+channel''s own ingest drops and before the slate. Position 0 is the
+channel''s ingest so backups start at 1.';
+
+COMMENT ON COLUMN playout.channel_ingest_sources.srt_mode IS
+'Only caller, listeners and rendezvous can''t be checked while they aren''t
+in use';
+
+COMMENT ON COLUMN playout.channel_ingest_sources.srt_passphrase IS
+'Encrypted like the channel''s srt_passphrase. Empty disables encryption';
+
+CREATE TABLE playout.output_renditions(
+    rendition_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    output_id int NOT NULL REFERENCES playout.outputs(output_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    position int NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    bitrate int NOT NULL,
+    fps int NOT NULL,
+    codec text NOT NULL,
+    CONSTRAINT output_renditions_position UNIQUE (output_id, position)
+);
+
+COMMENT ON TABLE playout.output_renditions IS
+'The video ladder of a transcoded output, passthrough outputs have none.';
+
+COMMENT ON COLUMN playout.output_renditions.position IS
+'Order of the rendition within the output, the first is the highest quality';
+
+COMMENT ON COLUMN playout.output_renditions.bitrate IS
+'Kb/s';
+
+CREATE TABLE playout.encoding_profiles(
+    profile_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name text NOT NULL,
+    version int NOT NULL,
+    description text NOT NULL DEFAULT '',
+    codec text NOT NULL,
+    video_profile text NOT NULL DEFAULT 'main',
+    preset text NOT NULL DEFAULT '',
+    gop int NOT NULL DEFAULT 0,
+    align_keyframes bool NOT NULL DEFAULT true,
+    audio_codec text NOT NULL DEFAULT 'aac',
+    audio_bitrate int NOT NULL DEFAULT 128,
+    audio_sample_rate int NOT NULL DEFAULT 48000,
+    audio_channels int NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT encoding_profiles_version UNIQUE (name, version)
+);
+
+COMMENT ON TABLE playout.encoding_profiles IS
+'Named encodings outputs can reference instead of defining renditions.
+Profiles are never edited, saving one inserts the next version.';
+
+COMMENT ON COLUMN playout.encoding_profiles.gop IS
+'Frames between keyframes, 0 aligns keyframes to the output''s segments';
+
+COMMENT ON COLUMN playout.encoding_profiles.audio_channels IS
+'0 keeps the ingest''s channel layout';
+
+CREATE TABLE playout.profile_renditions(
+    rendition_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    profile_id int NOT NULL REFERENCES playout.encoding_profiles(profile_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    position int NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    bitrate int NOT NULL,
+    fps int NOT NULL,
+    codec text NOT NULL DEFAULT '',
+    CONSTRAINT profile_renditions_position UNIQUE (profile_id, position)
+);
+
+COMMENT ON COLUMN playout.profile_renditions.codec IS
+'Empty uses the profile''s codec';
+
+CREATE TABLE playout.vt_tasks(
+    task_id text PRIMARY KEY,
+    channel_id int REFERENCES playout.channel(channel_id) ON UPDATE CASCADE ON DELETE SET NULL,
+    output text NOT NULL DEFAULT '',
+    playout_id int REFERENCES playout.schedule_playouts(playout_id) ON UPDATE CASCADE ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    finished_at timestamptz
+);
+
+COMMENT ON TABLE playout.vt_tasks IS
+'Tasks created in VT and what they were created for, so they can be cancelled
+and adopted again after a restart instead of being duplicated.';
+
+COMMENT ON COLUMN playout.vt_tasks.output IS
+'Key of the channel output a live task encodes, empty for playouts';
 
-    args text NOT NULL,
+COMMENT ON COLUMN playout.vt_tasks.finished_at IS
+'When the task was seen to have ended or was cancelled, null while it may be running';
 
-    CONSTRAINT outputs_pkey PRIMARY KEY (output_id, channel_id)
+CREATE TABLE playout.channel_events(
+    event_id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    channel_id int NOT NULL REFERENCES playout.channel(channel_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    type text NOT NULL,
+    message text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
 );
 
-COMMNENT ON TABLE playout.outputs IS
-'Outputs are the result of a channel. Channel''s can have multiple outputs of different types.';
\ No newline at end of file
+COMMENT ON TABLE playout.channel_events IS
+'A log of notable things happening to a channel such as swapping to slate.';
+
+CREATE TABLE playout.programme_video_loudness(
+    programme_video_id int PRIMARY KEY REFERENCES playout.programme_videos(programme_video_id) ON DELETE CASCADE,
+    integrated real NOT NULL,
+    true_peak real NOT NULL,
+    lra real NOT NULL,
+    threshold real NOT NULL,
+    duration double precision NOT NULL,
+    measured_at timestamptz NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE playout.programme_video_loudness IS
+'First pass loudness measurements of programme videos, taken ahead of air so
+channels normalising with measured gains don''t have to measure again.';
+
+COMMENT ON COLUMN playout.programme_video_loudness.duration IS
+'Seconds, used to place each video''s gain in a programme';
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrStreamingUnsupported is when a response can't be flushed as it is
+// written, so can't carry server-sent events
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// EventStream writes server-sent events to a client
+type EventStream struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// NewEventStream starts a stream of server-sent events on the response
+func NewEventStream(w http.ResponseWriter) (*EventStream, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &EventStream{w: w, f: f}, nil
+}
+
+// Send writes v as JSON under the event name
+func (s *EventStream) Send(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	s.f.Flush()
+	return nil
+}
@@ -0,0 +1,183 @@
+package vt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Tracker records the tasks created in VT against what they
+	// belong to, checking on them through the client
+	Tracker struct {
+		c     *Client
+		store Store
+	}
+	// Store records which tasks belong to what
+	Store interface {
+		// Add records a task as belonging to the owner
+		Add(ctx context.Context, taskID string, o Owner) error
+		// Unfinished retrieves the owner's tasks which haven't been
+		// finished, oldest first
+		Unfinished(ctx context.Context, o Owner) ([]Tracked, error)
+		// Finish marks a task as no longer running
+		Finish(ctx context.Context, taskID string) error
+	}
+	// Owner is what a task belongs to, zero values match any
+	// when searching
+	Owner struct {
+		ChannelID int    `db:"channel_id" json:"channelID"`
+		Output    string `db:"output" json:"output"` // Output key within the channel
+		PlayoutID int    `db:"playout_id" json:"playoutID"`
+		Outputs   bool   `db:"-" json:"-"` // Only match tasks of an output when searching
+	}
+	// Tracked is a task which has been recorded
+	Tracked struct {
+		TaskID    string    `db:"task_id" json:"taskID"`
+		Owner               // What created the task
+		CreatedAt time.Time `db:"created_at" json:"createdAt"`
+		Status    Status    `db:"-" json:"status"`
+	}
+)
+
+// NewTracker creates a tracker storing tasks in the DB
+func NewTracker(c *Client, db *sqlx.DB) *Tracker {
+	return NewStoreTracker(c, &dbStore{db: db})
+}
+
+// NewStoreTracker creates a tracker storing tasks in s
+func NewStoreTracker(c *Client, s Store) *Tracker {
+	return &Tracker{c: c, store: s}
+}
+
+// Client is the VT client the tracker uses
+func (t *Tracker) Client() *Client {
+	return t.c
+}
+
+// Track records a task as belonging to the owner
+func (t *Tracker) Track(ctx context.Context, taskID string, o Owner) error {
+	return t.store.Add(ctx, taskID, o)
+}
+
+// Active retrieves the owner's tasks which are still pending or
+// running in VT, with their status. Tasks VT has finished with are
+// marked as such and left out.
+func (t *Tracker) Active(ctx context.Context, o Owner) ([]Tracked, error) {
+	tasks, err := t.store.Unfinished(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	active := []Tracked{}
+	for _, task := range tasks {
+		status, err := t.c.Status(ctx, task.TaskID)
+		if err != nil && !errors.Is(err, ErrTaskNotFound) {
+			return nil, err
+		}
+		if err == nil && status.Active() {
+			task.Status = status
+			active = append(active, task)
+			continue
+		}
+		err = t.store.Finish(ctx, task.TaskID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+// Adopt finds a task of the owner which is still active, so it can be
+// taken over instead of creating a duplicate. Extra active tasks are
+// cancelled.
+func (t *Tracker) Adopt(ctx context.Context, o Owner) (string, bool, error) {
+	active, err := t.Active(ctx, o)
+	if err != nil {
+		return "", false, err
+	}
+	if len(active) == 0 {
+		return "", false, nil
+	}
+	// Keep the newest, anything older is a leftover duplicate
+	for _, task := range active[:len(active)-1] {
+		err = t.cancel(ctx, task.TaskID)
+		if err != nil {
+			log.Printf("vt: failed to cancel duplicate task \"%s\": %+v", task.TaskID, err)
+		}
+	}
+	return active[len(active)-1].TaskID, true, nil
+}
+
+// Cancel stops all of the owner's active tasks
+func (t *Tracker) Cancel(ctx context.Context, o Owner) error {
+	active, err := t.Active(ctx, o)
+	if err != nil {
+		return err
+	}
+	for _, task := range active {
+		err = t.cancel(ctx, task.TaskID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancel stops a task in VT and marks it as finished
+func (t *Tracker) cancel(ctx context.Context, taskID string) error {
+	err := t.c.Cancel(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	return t.store.Finish(ctx, taskID)
+}
+
+// dbStore records tasks in the DB
+type dbStore struct {
+	db *sqlx.DB
+}
+
+var _ Store = &dbStore{}
+
+func (s *dbStore) Add(ctx context.Context, taskID string, o Owner) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO playout.vt_tasks(task_id, channel_id, output, playout_id)
+		VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, 0));`,
+		taskID, o.ChannelID, o.Output, o.PlayoutID)
+	if err != nil {
+		return fmt.Errorf("failed to insert task: %w", err)
+	}
+	return nil
+}
+
+func (s *dbStore) Unfinished(ctx context.Context, o Owner) ([]Tracked, error) {
+	tasks := []Tracked{}
+	err := s.db.SelectContext(ctx, &tasks, `
+		SELECT task_id, COALESCE(channel_id, 0) AS channel_id, output,
+			COALESCE(playout_id, 0) AS playout_id, created_at
+		FROM playout.vt_tasks
+		WHERE finished_at IS NULL
+			AND ($1 = 0 OR channel_id = $1)
+			AND ($2 = '' OR output = $2)
+			AND ($3 = 0 OR playout_id = $3)
+			AND (NOT $4 OR output <> '')
+		ORDER BY created_at;`, o.ChannelID, o.Output, o.PlayoutID, o.Outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+func (s *dbStore) Finish(ctx context.Context, taskID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE playout.vt_tasks SET finished_at = now()
+		WHERE task_id = $1;`, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to finish task: %w", err)
+	}
+	return nil
+}
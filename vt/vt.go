@@ -0,0 +1,198 @@
+// Package vt is a client for VT, the video transcoding service which
+// runs live encodes for channels and plays out programmes.
+//
+// Every task VT creates is tracked against the channel, output or
+// playout it belongs to, so it can be queried, cancelled and adopted
+// again after a restart instead of being duplicated.
+package vt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrTaskNotFound is when VT doesn't know of a task
+	ErrTaskNotFound = errors.New("vt task not found")
+	// ErrNoTaskID is when VT accepts a task without returning its ID
+	ErrNoTaskID = errors.New("vt didn't return a task id")
+)
+
+// Task states
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateFinished  = "finished"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+type (
+	// Client talks to a VT instance
+	Client struct {
+		endpoint string
+		c        http.Client
+	}
+	// LiveTask is a long running encode of a live source
+	LiveTask struct {
+		ID      string `json:"id"`      // Task UUID
+		Args    string `json:"args"`    // Global arguments
+		SrcArgs string `json:"srcArgs"` // Input file options
+		SrcURL  string `json:"srcURL"`  // Location of source file on CDN
+		DstArgs string `json:"dstArgs"` // Output file options
+		DstURL  string `json:"dstURL"`  // Destination of finished encode on CDN
+	}
+	// EncodeArgs are the FFmpeg arguements on the playout encode
+	EncodeArgs struct {
+		Args    string `json:"args"`    // Global arguments
+		DstArgs string `json:"dstArgs"` // Output file options
+		DstURL  string `json:"dstURL"`  // Destination
+	}
+	// PlayTask plays a list of videos to a destination
+	PlayTask struct {
+		EncodeArgs EncodeArgs
+		Videos     []string
+	}
+	// Status is the state of a task in VT
+	Status struct {
+		ID       string  `json:"id"`
+		State    string  `json:"state"`    // pending / running / finished / failed / cancelled
+		Progress float64 `json:"progress"` // Percentage, live tasks stay at 0
+		Error    string  `json:"error"`
+	}
+)
+
+// New creates a client of the VT instance at endpoint
+func New(endpoint string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		c:        http.Client{},
+	}
+}
+
+// Active is when the task is still using VT's resources
+func (s Status) Active() bool {
+	return s.State == StatePending || s.State == StateRunning
+}
+
+// Ping checks VT is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	res, err := c.do(ctx, http.MethodGet, "/ok", nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VT: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("VT not OK: %s", res.Status)
+	}
+	return nil
+}
+
+// NewLive creates a live task, returning its ID
+func (c *Client) NewLive(ctx context.Context, t LiveTask) (string, error) {
+	return c.create(ctx, "/new_live", t)
+}
+
+// Play creates a task playing out videos, returning its ID
+func (c *Client) Play(ctx context.Context, t PlayTask) (string, error) {
+	return c.create(ctx, "/task/play", t)
+}
+
+// Status retrieves the state and progress of a task
+func (c *Client) Status(ctx context.Context, taskID string) (Status, error) {
+	res, err := c.do(ctx, http.MethodGet, "/task/"+taskID, nil)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to get task status: %w", err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Status{}, fmt.Errorf("%w: \"%s\"", ErrTaskNotFound, taskID)
+	default:
+		return Status{}, fmt.Errorf("VT failed to get task status: %s", readError(res))
+	}
+	s := Status{}
+	err = json.NewDecoder(res.Body).Decode(&s)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to decode task status: %w", err)
+	}
+	if s.ID == "" {
+		s.ID = taskID
+	}
+	return s, nil
+}
+
+// Cancel stops a task, a task which has already gone isn't an error
+func (c *Client) Cancel(ctx context.Context, taskID string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/task/"+taskID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to cancel task: %w", err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("VT failed to cancel task: %s", readError(res))
+	}
+}
+
+// create submits a task, returning the ID VT gave it
+func (c *Client) create(ctx context.Context, path string, task interface{}) (string, error) {
+	reqJSON, err := json.Marshal(task)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal VT task: %w", err)
+	}
+	res, err := c.do(ctx, http.MethodPost, path, reqJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit VT task: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("VT failed to create task: %s", readError(res))
+	}
+	created := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&created)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode created task: %w", err)
+	}
+	if created.ID == "" {
+		return "", ErrNoTaskID
+	}
+	return created.ID, nil
+}
+
+// do makes a request to VT
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.c.Do(req)
+}
+
+// readError describes an unsuccessful response
+func readError(res *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		return res.Status
+	}
+	return fmt.Sprintf("%s: %s", res.Status, msg)
+}
@@ -0,0 +1,146 @@
+package vt_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ystv/playout/vt"
+	"github.com/ystv/playout/vt/vttest"
+)
+
+func TestClientStatus(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	c := vt.New(srv.URL)
+	ctx := context.Background()
+
+	id := srv.AddTask(vttest.Task{Kind: vttest.KindPlay})
+	err := srv.SetState(id, vt.StateRunning, 42)
+	if err != nil {
+		t.Fatalf("failed to set task state: %+v", err)
+	}
+	status, err := c.Status(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get task status: %+v", err)
+	}
+	if status.ID != id || status.State != vt.StateRunning || status.Progress != 42 || !status.Active() {
+		t.Errorf("status is %+v", status)
+	}
+
+	_, err = c.Status(ctx, "unknown")
+	if !errors.Is(err, vt.ErrTaskNotFound) {
+		t.Errorf("got status of an unknown task, got %v", err)
+	}
+
+	srv.Fail(vttest.RouteTask, http.StatusInternalServerError)
+	_, err = c.Status(ctx, id)
+	if err == nil || errors.Is(err, vt.ErrTaskNotFound) {
+		t.Errorf("got status while VT is failing, got %v", err)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	c := vt.New(srv.URL)
+	ctx := context.Background()
+
+	id, err := c.NewLive(ctx, vt.LiveTask{SrcURL: "rtmp://ingest.example.com/live/test"})
+	if err != nil {
+		t.Fatalf("failed to create live task: %+v", err)
+	}
+	err = c.Cancel(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to cancel task: %+v", err)
+	}
+	task, _ := srv.Task(id)
+	if task.State != vt.StateCancelled {
+		t.Errorf("cancelled task is %s", task.State)
+	}
+
+	// VT forgetting a task leaves nothing to cancel
+	err = c.Cancel(ctx, "unknown")
+	if err != nil {
+		t.Errorf("failed to cancel an unknown task: %+v", err)
+	}
+
+	srv.Fail(vttest.RouteCancel, http.StatusBadGateway)
+	id = srv.AddTask(vttest.Task{Kind: vttest.KindLive})
+	err = c.Cancel(ctx, id)
+	if err == nil {
+		t.Error("cancelled a task while VT is failing")
+	}
+	task, _ = srv.Task(id)
+	if task.State != vt.StateRunning {
+		t.Errorf("task is %s after a failed cancel", task.State)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	c := vt.New(srv.URL)
+
+	err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("failed to ping VT: %+v", err)
+	}
+	srv.Fail(vttest.RouteOK, http.StatusServiceUnavailable)
+	err = c.Ping(context.Background())
+	if err == nil {
+		t.Error("pinged VT while it's failing")
+	}
+}
+
+// TestTrackerAdopt checks the newest active task is adopted, with older
+// duplicates cancelled and finished ones forgotten
+func TestTrackerAdopt(t *testing.T) {
+	srv := vttest.NewServer()
+	defer srv.Close()
+	tr := vt.NewStoreTracker(vt.New(srv.URL), vttest.NewStore())
+	ctx := context.Background()
+	o := vt.Owner{ChannelID: 1, Output: "main"}
+
+	finished := srv.AddTask(vttest.Task{Kind: vttest.KindLive, State: vt.StateFinished})
+	older := srv.AddTask(vttest.Task{Kind: vttest.KindLive})
+	newer := srv.AddTask(vttest.Task{Kind: vttest.KindLive})
+	other := srv.AddTask(vttest.Task{Kind: vttest.KindLive})
+	for _, id := range []string{finished, older, newer} {
+		err := tr.Track(ctx, id, o)
+		if err != nil {
+			t.Fatalf("failed to track task: %+v", err)
+		}
+	}
+	err := tr.Track(ctx, other, vt.Owner{ChannelID: 2, Output: "main"})
+	if err != nil {
+		t.Fatalf("failed to track task: %+v", err)
+	}
+
+	id, ok, err := tr.Adopt(ctx, o)
+	if err != nil {
+		t.Fatalf("failed to adopt task: %+v", err)
+	}
+	if !ok || id != newer {
+		t.Errorf("adopted %q (%t), want %q", id, ok, newer)
+	}
+	if task, _ := srv.Task(older); task.State != vt.StateCancelled {
+		t.Errorf("older duplicate is %s", task.State)
+	}
+	if task, _ := srv.Task(other); task.State != vt.StateRunning {
+		t.Errorf("another owner's task is %s", task.State)
+	}
+
+	err = tr.Cancel(ctx, o)
+	if err != nil {
+		t.Fatalf("failed to cancel tasks: %+v", err)
+	}
+	active, err := tr.Active(ctx, o)
+	if err != nil {
+		t.Fatalf("failed to get active tasks: %+v", err)
+	}
+	if len(active) != 0 {
+		t.Errorf("%d tasks are active after cancelling", len(active))
+	}
+}
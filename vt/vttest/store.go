@@ -0,0 +1,65 @@
+package vttest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ystv/playout/vt"
+)
+
+// Store is an in-memory vt.Store, standing in for the DB
+type Store struct {
+	lock     sync.Mutex
+	tasks    []vt.Tracked
+	finished map[string]bool
+}
+
+var _ vt.Store = &Store{}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{finished: make(map[string]bool)}
+}
+
+// Tracker creates a tracker using the fake with its own store
+func (s *Server) Tracker() *vt.Tracker {
+	return vt.NewStoreTracker(vt.New(s.URL), NewStore())
+}
+
+// Add records a task as belonging to the owner
+func (s *Store) Add(ctx context.Context, taskID string, o vt.Owner) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o.Outputs = false
+	s.tasks = append(s.tasks, vt.Tracked{TaskID: taskID, Owner: o, CreatedAt: time.Now()})
+	return nil
+}
+
+// Unfinished retrieves the owner's tasks which haven't been finished,
+// zero values match any the same as the DB
+func (s *Store) Unfinished(ctx context.Context, o vt.Owner) ([]vt.Tracked, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tasks := []vt.Tracked{}
+	for _, t := range s.tasks {
+		switch {
+		case s.finished[t.TaskID]:
+		case o.ChannelID != 0 && t.ChannelID != o.ChannelID:
+		case o.Output != "" && t.Output != o.Output:
+		case o.PlayoutID != 0 && t.PlayoutID != o.PlayoutID:
+		case o.Outputs && t.Output == "":
+		default:
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+// Finish marks a task as no longer running
+func (s *Store) Finish(ctx context.Context, taskID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.finished[taskID] = true
+	return nil
+}
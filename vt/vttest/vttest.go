@@ -0,0 +1,233 @@
+// Package vttest provides an in-process fake of VT for testing.
+//
+// Tasks created through the fake start running and stay that way
+// until they are scripted otherwise or cancelled. Any endpoint can be
+// made to fail to exercise error handling.
+package vttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ystv/playout/vt"
+)
+
+// Task kinds
+const (
+	KindLive = "live"
+	KindPlay = "play"
+)
+
+type (
+	// Server is a fake VT instance
+	Server struct {
+		*httptest.Server
+
+		lock   sync.Mutex
+		tasks  map[string]*Task
+		nextID int
+		fail   map[string]int // Status code by route
+	}
+	// Task is a task held by the fake
+	Task struct {
+		ID        string
+		Kind      string // live / play
+		State     string
+		Progress  float64
+		Live      vt.LiveTask // Set on live tasks
+		Play      vt.PlayTask // Set on play tasks
+		CreatedAt time.Time
+	}
+)
+
+// Routes of the fake, used to inject failures
+const (
+	RouteOK     = "/ok"
+	RoutePlay   = "/task/play"
+	RouteLive   = "/new_live"
+	RouteTask   = "/task/{id}"
+	RouteCancel = "DELETE /task/{id}"
+)
+
+// NewServer starts a fake VT, it should be closed when done with
+func NewServer() *Server {
+	s := &Server{
+		tasks: make(map[string]*Task),
+		fail:  make(map[string]int),
+	}
+	r := mux.NewRouter()
+	r.HandleFunc(RouteOK, s.guard(RouteOK, s.ok)).Methods(http.MethodGet)
+	r.HandleFunc(RoutePlay, s.guard(RoutePlay, s.newPlay)).Methods(http.MethodPost)
+	r.HandleFunc(RouteLive, s.guard(RouteLive, s.newLive)).Methods(http.MethodPost)
+	r.HandleFunc(RouteTask, s.guard(RouteTask, s.status)).Methods(http.MethodGet)
+	r.HandleFunc(RouteTask, s.guard(RouteCancel, s.cancel)).Methods(http.MethodDelete)
+	s.Server = httptest.NewServer(r)
+	return s
+}
+
+// Fail makes a route respond with the status code, 0 restores it
+func (s *Server) Fail(route string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == 0 {
+		delete(s.fail, route)
+		return
+	}
+	s.fail[route] = status
+}
+
+// AddTask seeds a task, as if it was created before a restart,
+// returning its ID. An empty state is running.
+func (s *Server) AddTask(t Task) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.add(t)
+}
+
+// Tasks lists every task in creation order
+func (s *Server) Tasks() []Task {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tasks := make([]Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, *t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+	return tasks
+}
+
+// Running lists the tasks which are pending or running
+func (s *Server) Running() []Task {
+	running := []Task{}
+	for _, t := range s.Tasks() {
+		if t.State == vt.StatePending || t.State == vt.StateRunning {
+			running = append(running, t)
+		}
+	}
+	return running
+}
+
+// Task retrieves a task by ID
+func (s *Server) Task(id string) (Task, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *t, true
+}
+
+// SetState scripts a task's state and progress
+func (s *Server) SetState(id, state string, progress float64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return fmt.Errorf("task \"%s\" doesn't exist", id)
+	}
+	t.State = state
+	t.Progress = progress
+	return nil
+}
+
+// add stores a task, the lock must be held
+func (s *Server) add(t Task) string {
+	s.nextID++
+	if t.ID == "" {
+		t.ID = fmt.Sprintf("task-%d", s.nextID)
+	}
+	if t.State == "" {
+		t.State = vt.StateRunning
+	}
+	if t.CreatedAt.IsZero() {
+		// Keep creation order stable even within the clock's resolution
+		t.CreatedAt = time.Now().Add(time.Duration(s.nextID))
+	}
+	s.tasks[t.ID] = &t
+	return t.ID
+}
+
+// guard responds with an injected failure instead of the handler
+func (s *Server) guard(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		status, failing := s.fail[route]
+		s.lock.Unlock()
+		if failing {
+			http.Error(w, "injected failure", status)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) ok(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) newPlay(w http.ResponseWriter, r *http.Request) {
+	t := vt.PlayTask{}
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	id := s.add(Task{Kind: KindPlay, Play: t})
+	s.lock.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+func (s *Server) newLive(w http.ResponseWriter, r *http.Request) {
+	t := vt.LiveTask{}
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	id := s.add(Task{Kind: KindLive, Live: t})
+	s.lock.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.Task(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, vt.Status{
+		ID:       t.ID,
+		State:    t.State,
+		Progress: t.Progress,
+	})
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[mux.Vars(r)["id"]]
+	if !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if t.State == vt.StatePending || t.State == vt.StateRunning {
+		t.State = vt.StateCancelled
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON responds with v
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -27,11 +27,29 @@ type (
 		SlateURL    string
 		Archive     bool
 		Status      string
+		StatusSince time.Time
+		Reason      string // Why the channel is in its status
 		Name        string // Display name
 		Description string
 		Thumbnail   string
 		CreatedAt   time.Time
 	}
+	Output struct {
+		Name     string
+		Type     string
+		Health   string
+		Reason   string
+		FPS      float64
+		Bitrate  float64 // Kb/s
+		Speed    float64
+		Dropped  int64
+		Restarts int
+	}
+	Event struct {
+		Type      string
+		Message   string
+		CreatedAt time.Time
+	}
 	PlainParams struct {
 		Base BaseParams
 	}
@@ -44,8 +62,10 @@ type (
 		Channels []Channel
 	}
 	ChannelParams struct {
-		Base BaseParams
-		Ch   Channel
+		Base    BaseParams
+		Ch      Channel
+		Outputs []Output
+		Events  []Event
 	}
 )
 
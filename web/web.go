@@ -1,12 +1,18 @@
 package web
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ystv/playout/channel"
+	"github.com/ystv/playout/public"
+	"github.com/ystv/playout/utils"
 	"github.com/ystv/playout/web/templates"
 )
 
@@ -21,6 +27,9 @@ func New(mcr *channel.MCR) *Web {
 
 	web.mux.HandleFunc("/", web.indexPage).Methods("GET")
 	web.mux.HandleFunc("/ch/{channel}", web.channelPage).Methods("GET")
+	web.mux.HandleFunc("/ch/{channel}/health", web.channelHealth).Methods("GET")
+	web.mux.HandleFunc("/ch/{channel}/tasks", web.channelTasks).Methods("GET")
+	web.mux.HandleFunc("/events", web.events).Methods("GET")
 	web.mux.HandleFunc("/channel/new", web.newChannelPage).Methods("GET")
 	web.mux.HandleFunc("/channel/new", web.newChannel).Methods("POST")
 	web.mux.HandleFunc("/settings", web.settingsPage).Methods("GET")
@@ -36,19 +45,7 @@ func (web *Web) indexPage(w http.ResponseWriter, r *http.Request) {
 	chs, err := web.mcr.GetChannels()
 	tempChans := []templates.Channel{}
 	for _, ch := range chs {
-		tempChans = append(tempChans, templates.Channel{
-			ShortName:   ch.ShortName,
-			ChannelType: ch.ChannelType,
-			IngestURL:   ch.IngestURL,
-			IngestType:  ch.IngestType,
-			SlateURL:    ch.SlateURL,
-			Archive:     ch.Archive,
-			Status:      ch.Status,
-			Name:        ch.Name,
-			Description: ch.Description,
-			Thumbnail:   ch.Thumbnail,
-			CreatedAt:   ch.CreatedAt,
-		})
+		tempChans = append(tempChans, templateChannel(ch.Info()))
 	}
 
 	if err != nil {
@@ -75,25 +72,38 @@ func (web *Web) channelPage(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		err = fmt.Errorf("failed to get channel: %w", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outputs := []templates.Output{}
+	for _, h := range ch.Health() {
+		outputs = append(outputs, templates.Output{
+			Name:     h.Name,
+			Type:     h.Type,
+			Health:   string(h.Health),
+			Reason:   h.Reason,
+			FPS:      h.Progress.FPS,
+			Bitrate:  h.Progress.Bitrate,
+			Speed:    h.Progress.Speed,
+			Dropped:  h.Progress.Dropped,
+			Restarts: h.Progress.Restarts,
+		})
+	}
+	events := []templates.Event{}
+	for _, e := range ch.Events() {
+		events = append(events, templates.Event{
+			Type:      e.Type,
+			Message:   e.Message,
+			CreatedAt: e.CreatedAt,
+		})
 	}
 	params := templates.ChannelParams{
 		Base: templates.BaseParams{
 			UserName:   "rhys",
 			SystemTime: time.Now(),
 		},
-		Ch: templates.Channel{
-			ShortName:   ch.ShortName,
-			ChannelType: ch.ChannelType,
-			IngestURL:   ch.IngestURL,
-			IngestType:  ch.IngestType,
-			SlateURL:    ch.SlateURL,
-			Archive:     ch.Archive,
-			Status:      ch.Status,
-			Name:        ch.Name,
-			Description: ch.Description,
-			Thumbnail:   ch.Thumbnail,
-			CreatedAt:   ch.CreatedAt,
-		},
+		Ch:      templateChannel(ch.Info()),
+		Outputs: outputs,
+		Events:  events,
 	}
 	err = web.t.Channel(w, params)
 	if err != nil {
@@ -102,6 +112,95 @@ func (web *Web) channelPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (web *Web) channelHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := web.mcr.GetOutputHealth(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, channel.ErrChannelNotFound) {
+			status = http.StatusNotFound
+		}
+		err = fmt.Errorf("failed to get output health: %w", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(health)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (web *Web) channelTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := web.mcr.GetVTTasks(r.Context(), mux.Vars(r)["channel"])
+	if err != nil {
+		err = fmt.Errorf("failed to get vt tasks: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(tasks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// events streams the MCR's messages as server-sent events, optionally
+// only those of a single channel
+func (web *Web) events(w http.ResponseWriter, r *http.Request) {
+	stream, err := utils.NewEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	shortName := r.URL.Query().Get("channel")
+	msgs := make(chan channel.Message, 16)
+	unsubscribe := web.mcr.Subscribe(func(m channel.Message) {
+		if shortName != "" && m.ChannelName() != shortName {
+			return
+		}
+		select {
+		case msgs <- m:
+		default:
+			// Drop messages for a client which can't keep up
+		}
+	})
+	defer unsubscribe()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m := <-msgs:
+			err = stream.Send(string(m.Topic()), m)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// templateChannel converts a channel for the templates
+func templateChannel(info channel.Info) templates.Channel {
+	thumbnail := info.Thumbnail
+	if !info.Preview.CapturedAt.IsZero() {
+		thumbnail = public.ThumbnailURL(info.ShortName, info.Preview.CapturedAt)
+	}
+	return templates.Channel{
+		ShortName:   info.ShortName,
+		ChannelType: info.ChannelType,
+		IngestURL:   info.IngestURL,
+		IngestType:  info.IngestType,
+		SlateURL:    info.SlateURL,
+		Archive:     info.Archive,
+		Status:      string(info.Status.State),
+		StatusSince: info.Status.Since,
+		Reason:      info.Status.Reason,
+		Name:        info.Name,
+		Description: info.Description,
+		Thumbnail:   thumbnail,
+		CreatedAt:   info.CreatedAt,
+	}
+}
+
 func (web *Web) newChannelPage(w http.ResponseWriter, r *http.Request) {
 	params := templates.PlainParams{
 		Base: templates.BaseParams{
@@ -131,15 +230,51 @@ func (web *Web) newChannel(w http.ResponseWriter, r *http.Request) {
 	if r.PostFormValue("vcr") != "" {
 		isDVR = true
 	}
+	ingestSRT := channel.SRTOptions{}
+	if strings.EqualFold(r.PostFormValue("ingest-type"), "srt") {
+		ingestSRT.SRTMode = r.PostFormValue("srt-mode")
+		ingestSRT.SRTPassphrase = r.PostFormValue("srt-passphrase")
+		if latency := r.PostFormValue("srt-latency"); latency != "" {
+			ingestSRT.SRTLatency, err = strconv.Atoi(latency)
+			if err != nil {
+				http.Error(w, "invalid srt latency", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	loudness := channel.LoudnessPolicy{LoudnessMode: r.PostFormValue("loudness-mode")}
+	for field, value := range map[*float64]string{
+		&loudness.LoudnessTarget:   r.PostFormValue("loudness-target"),
+		&loudness.LoudnessTruePeak: r.PostFormValue("loudness-true-peak"),
+	} {
+		if value == "" {
+			continue
+		}
+		*field, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "invalid loudness level", http.StatusBadRequest)
+			return
+		}
+	}
 	newCh := channel.NewChannelStruct{
 		Name:        r.PostFormValue("name"),
 		ShortName:   r.PostFormValue("short-name"),
 		Description: r.PostFormValue("description"),
 		ChannelType: r.PostFormValue("type"),
 		IngestType:  r.PostFormValue("ingest-type"),
-		SlateURL:    r.PostFormValue("slate-url"),
-		Archive:     isArchived,
-		DVR:         isDVR,
+		IngestSRT:   ingestSRT,
+		Loudness:    loudness,
+		Logo: channel.LogoOptions{
+			LogoURL:      r.PostFormValue("logo-url"),
+			LogoPosition: r.PostFormValue("logo-position"),
+		},
+		Captions: channel.CaptionOptions{
+			SubtitleLanguages: r.PostFormValue("subtitle-languages"),
+			ClosedCaptions:    r.PostFormValue("closed-captions"),
+		},
+		SlateURL: r.PostFormValue("slate-url"),
+		Archive:  isArchived,
+		DVR:      isDVR,
 	}
 	ch, err := web.mcr.NewChannel(r.Context(), newCh)
 	if err != nil {